@@ -88,6 +88,17 @@ func (buf *Buffer) flush() {
 	}
 }
 
+// discard drops the modifications to the page, so that they are never written to disk,
+// and detaches the buffer from its block.
+func (buf *Buffer) discard() {
+	buf.Lock()
+	defer buf.Unlock()
+
+	buf.txnum = storage.TxIDInvalid
+	buf.lsn = -1
+	buf.block = storage.Block{}
+}
+
 // assignToBlock associates a buffer with a disk block.
 // The buffer is first flushed so that any modifications to the
 // previous block are preserved.
@@ -100,6 +100,26 @@ func (man *BufferManager) FlushAll(txnum storage.TxID) {
 	})
 }
 
+// Discard returns to the free list the unpinned buffers assigned to the blocks of the given file,
+// without flushing them.
+// It is called when the file is removed, so that the buffers do not write
+// to a file that no longer exists, nor hold the contents of its blocks.
+func (man *BufferManager) Discard(fname string) {
+	man.blockMap.Range(func(key, value any) bool {
+		buf := value.(*Buffer)
+		if buf.Block().FileName() != fname || buf.isPinned() {
+			return true
+		}
+
+		buf.discard()
+		man.freeList.append(buf, func() {
+			man.blockMap.Delete(key)
+		})
+
+		return true
+	})
+}
+
 // Unpin unpins the specified buffer
 func (man *BufferManager) Unpin(buf *Buffer) {
 	buf.unpin()
@@ -100,7 +100,11 @@ func (db *DB) RunQuery(x tx.Transaction, q sql.Query) (fmt.Stringer, error) {
 
 		schema := plan.Schema()
 
-		for _, f := range q.Fields() {
+		// the output columns are named after their aliases
+		// and include the fields selected by *
+		fields := schema.Fields()
+
+		for _, f := range fields {
 			rows.cols = append(rows.cols, Col{
 				Name: f,
 				Type: schema.FieldInfo(f).Type,
@@ -118,7 +122,7 @@ func (db *DB) RunQuery(x tx.Transaction, q sql.Query) (fmt.Stringer, error) {
 			}
 
 			row := Row{}
-			for _, f := range q.Fields() {
+			for _, f := range fields {
 				v, err := scan.Val(f)
 				if err != nil {
 					return Rows{}, err
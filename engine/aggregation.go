@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+)
+
+// aggregationFn computes an aggregate over the records of a group.
+// NULLs are ignored by all the aggregates but COUNT(*).
+// The aggregate of a group without values is NULL, except
+// for counts, which are zero.
+type aggregationFn interface {
+	// reset clears the aggregate at the beginning of a new group.
+	reset()
+	// process adds the current record of the scan to the aggregate.
+	process(s Scan) error
+	// value returns the aggregate of the records processed since the last reset.
+	value() storage.Value
+}
+
+func newAggregationFn(agg sql.Expression, input Schema) (aggregationFn, error) {
+	operand, ok := agg.AggregateOperand()
+	if !ok {
+		return &countFn{}, nil
+	}
+
+	t, err := operand.Type(input)
+	if err != nil {
+		return nil, err
+	}
+
+	switch agg.AggregateFunc() {
+	case sql.AggregateCount:
+		return &countFn{operand: &operand}, nil
+	case sql.AggregateSum:
+		return &sumFn{operand: operand, typ: t}, nil
+	case sql.AggregateAvg:
+		return &avgFn{sumFn: sumFn{operand: operand, typ: t}}, nil
+	case sql.AggregateMin:
+		return &extremeFn{operand: operand, typ: t}, nil
+	}
+
+	return &extremeFn{operand: operand, typ: t, max: true}, nil
+}
+
+// countFn counts the records of the group, or
+// the non NULL values of its operand, if any.
+type countFn struct {
+	operand *sql.Expression
+	count   storage.Long
+}
+
+func (fn *countFn) reset() {
+	fn.count = 0
+}
+
+func (fn *countFn) process(s Scan) error {
+	if fn.operand != nil {
+		v, err := fn.operand.Evaluate(s)
+		if err != nil {
+			return err
+		}
+
+		if v.IsNull() {
+			return nil
+		}
+	}
+
+	fn.count++
+	return nil
+}
+
+func (fn *countFn) value() storage.Value {
+	return storage.ValueFromLong(storage.LONG, fn.count)
+}
+
+// sumFn sums the values of an integer operand.
+type sumFn struct {
+	operand sql.Expression
+	typ     storage.FieldType
+	sum     storage.Long
+	count   storage.Long
+}
+
+func (fn *sumFn) reset() {
+	fn.sum = 0
+	fn.count = 0
+}
+
+func (fn *sumFn) process(s Scan) error {
+	v, err := fn.operand.Evaluate(s)
+	if err != nil {
+		return err
+	}
+
+	if v.IsNull() {
+		return nil
+	}
+
+	fn.sum += storage.ValueAsLong(fn.typ, v)
+	fn.count++
+
+	return nil
+}
+
+func (fn *sumFn) value() storage.Value {
+	if fn.count == 0 {
+		return storage.Null
+	}
+
+	return storage.ValueFromLong(storage.LONG, fn.sum)
+}
+
+// avgFn averages the values of an integer operand.
+// Since there are no fractional types, the average is truncated.
+type avgFn struct {
+	sumFn
+}
+
+func (fn *avgFn) value() storage.Value {
+	if fn.count == 0 {
+		return storage.Null
+	}
+
+	return storage.ValueFromLong(storage.LONG, fn.sum/fn.count)
+}
+
+// extremeFn computes either the minimum or the maximum value of its operand.
+type extremeFn struct {
+	operand sql.Expression
+	typ     storage.FieldType
+	max     bool
+	val     storage.Value
+}
+
+func (fn *extremeFn) reset() {
+	fn.val = storage.Null
+}
+
+func (fn *extremeFn) process(s Scan) error {
+	v, err := fn.operand.Evaluate(s)
+	if err != nil {
+		return err
+	}
+
+	if v.IsNull() {
+		return nil
+	}
+
+	if fn.val.IsNull() || fn.max && v.More(fn.typ, fn.val) || !fn.max && v.Less(fn.typ, fn.val) {
+		fn.val = storage.Copy(v)
+	}
+
+	return nil
+}
+
+func (fn *extremeFn) value() storage.Value {
+	return fn.val
+}
@@ -0,0 +1,357 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+var (
+	ErrDuplicateField = errors.New("field already exists")
+	ErrTableExists    = errors.New("table already exists")
+	ErrDropLastField  = errors.New("cannot drop the only field of a table")
+)
+
+// alteredTable describes a table changed by ALTER TABLE.
+// sources maps each field of the new schema to the field of the old schema
+// it takes its values from. Fields without a source are NULL.
+// renamed maps the renamed fields to their new names,
+// and dropped is the name of the dropped field, if any.
+type alteredTable struct {
+	name    string
+	schema  Schema
+	sources map[string]string
+	renamed map[string]string
+	dropped string
+}
+
+// newAlteredTable returns the table resulting from applying the command to a table with the given schema.
+func newAlteredTable(data sql.AlterTableCommand, old Schema) (alteredTable, error) {
+	at := alteredTable{
+		name:    data.TableName,
+		schema:  newSchema(),
+		sources: map[string]string{},
+		renamed: map[string]string{},
+	}
+
+	switch data.Action {
+	case sql.AlterAddColumn:
+		if old.HasField(data.Field.Name) {
+			return alteredTable{}, ErrDuplicateField
+		}
+	case sql.AlterDropColumn:
+		if !old.HasField(data.Column) {
+			return alteredTable{}, ErrNoField
+		}
+
+		if len(old.fields) == 1 {
+			return alteredTable{}, ErrDropLastField
+		}
+
+		at.dropped = data.Column
+	case sql.AlterRenameColumn:
+		if !old.HasField(data.Column) {
+			return alteredTable{}, ErrNoField
+		}
+
+		if old.HasField(data.NewName) {
+			return alteredTable{}, ErrDuplicateField
+		}
+
+		at.renamed[data.Column] = data.NewName
+	case sql.AlterRenameTable:
+		at.name = data.NewName
+	}
+
+	for _, f := range old.fields {
+		if f == at.dropped {
+			continue
+		}
+
+		name := f
+		if newName, ok := at.renamed[f]; ok {
+			name = newName
+		}
+
+		at.schema.addField(name, old.ftype(f))
+		at.schema.setConstraints(name, old.info[f].fieldConstraints)
+		at.sources[name] = f
+	}
+
+	if data.Action == sql.AlterAddColumn {
+		at.schema.addField(data.Field.Name, data.Field.Type)
+		at.schema.setConstraints(data.Field.Name, newFieldConstraints(data.Field))
+	}
+
+	if err := at.alterChecks(); err != nil {
+		return alteredTable{}, err
+	}
+
+	return at, nil
+}
+
+// alterChecks makes the checks of the fields refer to the fields of the altered table:
+// renamed fields are renamed within the checks, and the checks that refer to the dropped field are dropped.
+// It returns ErrNoField if the check of an added field refers to a field that the table does not have.
+func (at alteredTable) alterChecks() error {
+	rename := func(field string) (string, error) {
+		if newName, ok := at.renamed[field]; ok {
+			return newName, nil
+		}
+
+		return field, nil
+	}
+
+	for _, f := range at.schema.fields {
+		pred, ok, err := parseCheck(at.schema, f)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		if _, isOld := at.sources[f]; !isOld {
+			if !pred.AppliesTo(at.schema) {
+				return ErrNoField
+			}
+
+			continue
+		}
+
+		pred, err = pred.Qualify(rename)
+		if err != nil {
+			return err
+		}
+
+		c := at.schema.info[f].fieldConstraints
+		c.Check = pred.String()
+		if !pred.AppliesTo(at.schema) {
+			c.Check = ""
+		}
+
+		at.schema.setConstraints(f, c)
+	}
+
+	return nil
+}
+
+// executeAlterTable changes the definition of the table in the catalogs
+// and rewrites its records, so that they are laid out as the new definition requires:
+// the records are copied into a temporary table and deleted, together with their index entries,
+// and are then inserted again with the new layout.
+// The keys that include a dropped field are dropped together with their indexes,
+// as are the indexes, the foreign keys and the sequence of the dropped field,
+// while a field referred to by a foreign key cannot be dropped.
+// Keys, foreign keys, indexes and sequences owned by a renamed field follow it.
+// An added auto increment field gets a sequence of its own, which numbers the existing records.
+// A renamed table moves to a new file, and the old one is removed when the transaction commits.
+// Added fields take their default value, and the records are checked against the constraints
+// of the altered table, so that a NOT NULL field without a default cannot be added to a table with records.
+// Since every change is made by the transaction, a rollback restores the table as it was.
+func (planner *IndexUpdatePlanner) executeAlterTable(data sql.AlterTableCommand, x tx.Transaction) (int, error) {
+	plan, err := newTablePlan(x, data.TableName, planner.mdm)
+	if err != nil {
+		return 0, err
+	}
+
+	at, err := newAlteredTable(data, plan.Schema())
+	if err != nil {
+		return 0, err
+	}
+
+	if at.name != data.TableName {
+		if err := planner.checkNameIsFree(at.name, x); err != nil {
+			return 0, err
+		}
+	}
+
+	tt, err := newMaterializePlan(x, plan).materialize()
+	if err != nil {
+		return 0, err
+	}
+
+	// the records are written back, so the actions of the foreign keys that refer to them do not apply.
+	if _, err := planner.deleteRecords(sql.NewDeleteCommand(data.TableName), x, nil); err != nil {
+		return 0, err
+	}
+
+	if at.dropped != "" {
+		if _, err := planner.mdm.dropForeignKeys(x, data.TableName, at.dropped); err != nil {
+			return 0, err
+		}
+
+		if err := planner.mdm.dropOwnedSequences(x, data.TableName, at.dropped); err != nil {
+			return 0, err
+		}
+
+		fks, err := planner.mdm.referencingKeys(x, data.TableName)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, fk := range fks {
+			if slices.Contains(fk.refFields, at.dropped) {
+				return 0, fmt.Errorf("%w: %q", ErrReferenced, fk.name)
+			}
+		}
+
+		keys, err := planner.mdm.dropKeys(x, data.TableName, at.dropped)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, key := range keys {
+			if err := planner.mdm.dropIndex(x, key.name); err != nil {
+				return 0, err
+			}
+
+			if err := removeIndexFiles(x, key.name); err != nil {
+				return 0, err
+			}
+		}
+
+		indexes, err := planner.mdm.dropIndexes(x, data.TableName, at.dropped)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, idx := range indexes {
+			if err := removeIndexFiles(x, idx); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err := planner.mdm.renameIndexes(x, data.TableName, at.name, at.renamed); err != nil {
+		return 0, err
+	}
+
+	if err := planner.mdm.renameKeys(x, data.TableName, at.name, at.renamed); err != nil {
+		return 0, err
+	}
+
+	if err := planner.mdm.renameForeignKeys(x, data.TableName, at.name, at.renamed); err != nil {
+		return 0, err
+	}
+
+	if err := planner.mdm.renameOwners(x, data.TableName, at.name, at.renamed); err != nil {
+		return 0, err
+	}
+
+	if data.Action == sql.AlterAddColumn && data.Field.AutoIncrement {
+		if err := planner.autoIncrement(x, &at.schema, at.name, data.Field.Name); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := planner.mdm.dropTable(data.TableName, x); err != nil {
+		return 0, err
+	}
+
+	if err := planner.mdm.createTable(at.name, at.schema, x); err != nil {
+		return 0, err
+	}
+
+	if at.name != data.TableName {
+		if err := x.RemoveFile(tableFileName(data.TableName)); err != nil {
+			return 0, err
+		}
+	}
+
+	planner.mdm.dropStats(data.TableName)
+	planner.mdm.dropStats(at.name)
+
+	return 0, planner.rewriteRecords(x, tt, at)
+}
+
+// checkNameIsFree returns ErrTableExists if a table or a view is named name.
+func (planner *IndexUpdatePlanner) checkNameIsFree(name string, x tx.Transaction) error {
+	_, err := planner.mdm.layout(name, x)
+	if err == nil {
+		return ErrTableExists
+	}
+
+	if !errors.Is(err, ErrViewNotFound) {
+		return err
+	}
+
+	_, err = planner.mdm.viewDefinition(name, x)
+	if err == nil {
+		return ErrTableExists
+	}
+
+	if !errors.Is(err, ErrViewNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// rewriteRecords inserts the records of the temporary table into the altered table,
+// and adds them to its indexes.
+func (planner *IndexUpdatePlanner) rewriteRecords(x tx.Transaction, tt *tmpTable, at alteredTable) error {
+	indexes, err := planner.openIndexes(x, at.name)
+	if err != nil {
+		return err
+	}
+
+	defer closeIndexes(indexes)
+
+	rc, err := newRowConstraints(at.schema, planner.sequences(x))
+	if err != nil {
+		return err
+	}
+
+	src := tt.Open()
+	defer src.Close()
+
+	us := newTableScan(x, at.name, NewLayout(at.schema))
+	defer us.Close()
+
+	vals := make([]storage.Value, len(at.schema.fields))
+	for {
+		err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for i, f := range at.schema.fields {
+			from, ok := at.sources[f]
+			if !ok {
+				v, err := rc.defaultValue(f)
+				if err != nil {
+					return err
+				}
+
+				vals[i] = v
+				continue
+			}
+
+			v, err := src.Val(from)
+			if err != nil {
+				return err
+			}
+
+			vals[i] = v
+		}
+
+		if err := rc.check(vals); err != nil {
+			return err
+		}
+
+		if err := insertRow(us, at.schema, vals, indexes); err != nil {
+			return err
+		}
+	}
+}
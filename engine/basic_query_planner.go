@@ -2,6 +2,7 @@ package engine
 
 import (
 	"errors"
+	"slices"
 
 	"github.com/luigitni/simpledb/sql"
 	"github.com/luigitni/simpledb/tx"
@@ -13,6 +14,8 @@ type QueryPlanner interface {
 
 type BasicQueryPlanner struct {
 	mdm *MetadataManager
+	// cteScope holds the common table expressions visible to the query being planned
+	cteScope *cteScope
 }
 
 func NewBasicQueryPlanner(mdm *MetadataManager) BasicQueryPlanner {
@@ -23,18 +26,66 @@ func NewBasicQueryPlanner(mdm *MetadataManager) BasicQueryPlanner {
 
 // CreatePlan creates a simple query plan using the following basic algorithm
 //  1. Construct a plan for each table T in the <FROM> clause
-//     a. If T is a stored table, the plan is a table plan for T
+//     a. If T is a stored table, the plan is a table plan for T, whose fields are qualified by the name or alias of T
 //     b. If T is a view, the plan is the result of calling this algorithm recursively on T's definition
-//  2. Take the product of these table plans, in the order given
-//  3. Select on the predicate in the <WHERE> clause
-//  4. Project on the fields in the <SELECT> clause
+//  2. Resolve the fields of the query against the schemas of the plans
+//  3. If the query has outer joins, join the table plans as the <FROM> clause specifies.
+//     Otherwise, take the product of the table plans, in the order given
+//  4. Select on the predicate in the <WHERE> clause, together with the <ON> clauses of inner joins
+//  5. Group the records and select on the predicate in the <HAVING> clause, if the query is grouped
+//  6. Project on the fields in the <SELECT> clause
+//  7. Remove duplicates if the query is DISTINCT, and sort on the fields in the <ORDER BY> clause
+//  8. Skip the records before the <OFFSET> and stop after the <LIMIT>
+//
+// The queries combined by a set operation are planned on their own with the same algorithm.
+// Tables named after a common table expression of the <WITH> clause refer to it,
+// and are planned as views unless they are materialized.
+// Subqueries are planned with the same algorithm once the fields of the query are resolved.
+// A subquery that refers to the fields of the outer query is evaluated again for each outer record,
+// by adding the current outer record to the product of its tables.
 func (bqp BasicQueryPlanner) CreatePlan(data sql.Query, x tx.Transaction) (Plan, error) {
+	return bqp.createPlan(data, x, nil)
+}
+
+func (bqp BasicQueryPlanner) ctes() *cteScope {
+	return bqp.cteScope
+}
+
+func (bqp BasicQueryPlanner) withCTEs(ctes *cteScope) subqueryPlanner {
+	bqp.cteScope = ctes
+	return bqp
+}
+
+func (bqp BasicQueryPlanner) createPlan(data sql.Query, x tx.Transaction, scope *subqueryScope) (Plan, error) {
+	if len(data.CTEs()) > 0 {
+		return planWith(x, bqp, data, scope)
+	}
+
+	if so, ok := data.SetOperation(); ok {
+		return planSetOperation(x, bqp, so, data, scope)
+	}
+
 	if len(data.Tables()) == 0 {
 		return nil, errors.New("invalid query data: empty table set")
 	}
-	var plans []Plan
-	for _, tName := range data.Tables() {
-		viewDef, err := bqp.mdm.viewDefinition(tName, x)
+
+	refs := data.TableRefs()
+	plans := make([]Plan, len(refs))
+	schemas := make([]Schema, len(refs))
+	for i, ref := range refs {
+		if ct, ok := bqp.cteScope.lookup(ref.Name); ok {
+			plan, err := ct.reference(ref.RangeName())
+			if err != nil {
+				return nil, err
+			}
+
+			plans[i] = plan
+			schemas[i] = plan.Schema()
+
+			continue
+		}
+
+		viewDef, err := bqp.mdm.viewDefinition(ref.Name, x)
 
 		// the table is a view, recurse on T
 		if err == nil {
@@ -44,38 +95,214 @@ func (bqp BasicQueryPlanner) CreatePlan(data sql.Query, x tx.Transaction) (Plan,
 				return nil, err
 			}
 
-			plan, err := bqp.CreatePlan(viewData, x)
+			// views do not see the common table expressions of the query
+			plan, err := NewBasicQueryPlanner(bqp.mdm).CreatePlan(viewData, x)
 			if err != nil {
 				return nil, err
 			}
 
-			plans = append(plans, plan)
+			plans[i] = plan
+		} else if errors.Is(err, ErrViewNotFound) {
+			plan, err := newQualifiedTablePlan(x, ref.Name, ref.RangeName(), bqp.mdm)
+			if err != nil {
+				return nil, err
+			}
+
+			plans[i] = plan
+		} else {
+			return nil, err
+		}
+
+		schemas[i] = plans[i].Schema()
+	}
+
+	resolve, err := newFieldResolver(refs, schemas)
+	if err != nil {
+		return nil, err
+	}
+
+	resolve = scope.resolver(resolve)
+
+	data, err = data.Qualify(resolve)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = bindSubqueries(x, bqp, data, resolve, scope.visibleSchema(schemas))
+	if err != nil {
+		return nil, err
+	}
+
+	var p Plan
+	pred := innerJoinPredicate(data)
+	if data.HasOuterJoin() {
+		p, err = joinPlan(plans, data.Joins())
+		if err != nil {
+			return nil, err
 		}
 
-		if errors.Is(err, ErrViewNotFound) {
-			plan, err := newTablePlan(x, tName, bqp.mdm)
+		pred = data.Predicate()
+	} else {
+		// Create the product of all plans
+		p = plans[0]
+		for _, next := range plans[1:] {
+			p = newProductPlan(p, next)
+		}
+	}
+
+	row := scope.rowPlan()
+	if row != nil {
+		p = newProductPlan(p, row)
+	}
+
+	return subqueryOutput(x, newSelectPlan(p, pred), data, resolve, row)
+}
+
+// innerJoinPredicate returns the predicate of the <WHERE> clause
+// together with the predicates of the <ON> clauses.
+// When the query has only inner joins, joining on a predicate
+// is the same as selecting on it after the product of the tables.
+func innerJoinPredicate(data sql.Query) sql.Predicate {
+	pred := data.Predicate()
+	for _, j := range data.Joins() {
+		pred.CojoinWith(j.On)
+	}
+
+	return pred
+}
+
+// joinPlan joins the plans of the tables in the <FROM> clause as the joins specify.
+// Each table is joined to the tables on its left, up to the previous comma;
+// the groups of tables separated by commas are then combined by a product.
+func joinPlan(plans []Plan, joins []sql.Join) (Plan, error) {
+	var groups []Plan
+	for i, p := range plans {
+		j := joins[i]
+		if j.Kind == sql.JoinNone {
+			groups = append(groups, p)
+			continue
+		}
+
+		left := groups[len(groups)-1]
+
+		var joined Plan
+		if j.Kind.IsOuter() {
+			ojp, err := newOuterJoinPlan(j.Kind, left, p, j.On)
 			if err != nil {
 				return nil, err
 			}
 
-			plans = append(plans, plan)
+			joined = ojp
+		} else {
+			product := newProductPlan(left, p)
+			if !j.On.AppliesTo(product.Schema()) {
+				return nil, ErrNoField
+			}
+
+			joined = newSelectPlan(product, j.On)
 		}
+
+		groups[len(groups)-1] = joined
 	}
 
-	// Create the product of all plans
-	p := plans[0]
-	for _, next := range plans[1:] {
+	p := groups[0]
+	for _, next := range groups[1:] {
 		p = newProductPlan(p, next)
 	}
 
-	p = newSelectPlan(p, data.Predicate())
+	return p, nil
+}
+
+// queryOutput adds on top of p the operators that shape the output of the query:
+// the grouping, the projection on the fields in the <SELECT> clause,
+// the removal of duplicates, the sorting and the <LIMIT> clause.
+// Since a distinct plan sorts its records, it takes care of the <ORDER BY> clause too.
+func queryOutput(x tx.Transaction, p Plan, data sql.Query, resolve sql.FieldResolver) (Plan, error) {
+	p, err := groupQuery(x, p, data)
+	if err != nil {
+		return nil, err
+	}
+
+	exprs, names := data.Expressions(), data.Fields()
+	if data.IsStar() {
+		exprs, names = starFields(p.Schema())
+	}
+
+	project, err := newProjectPlan(p, exprs, names)
+	if err != nil {
+		return nil, err
+	}
+
+	var out Plan = project
+
+	orderBy, err := outputSortKeys(data.OrderBy(), exprs, names, resolve)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.IsDistinct() {
+		out = newDistinctPlan(x, out, orderBy)
+	} else if len(orderBy) > 0 {
+		out = newSortPlan(x, out, orderBy)
+	}
+
+	if limit, ok := data.Limit(); ok {
+		out = newLimitPlan(out, limit, data.Offset())
+	}
+
+	return out, nil
+}
+
+// starFields returns the fields selected by *: all the fields of the schema, in index order.
+// Output columns are named after the unqualified name of their field,
+// unless more than one field has that name.
+func starFields(schema Schema) ([]sql.Expression, []string) {
+	fields := schema.Fields()
+
+	count := map[string]int{}
+	for _, f := range fields {
+		count[unqualified(f)]++
+	}
+
+	exprs := make([]sql.Expression, len(fields))
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		exprs[i] = sql.NewExpressionWithField(f)
+
+		names[i] = f
+		if count[unqualified(f)] == 1 {
+			names[i] = unqualified(f)
+		}
+	}
+
+	return exprs, names
+}
+
+// outputSortKeys maps the keys of the <ORDER BY> clause to the output columns of the query.
+// A key can name an output column, or a field selected by one of them.
+func outputSortKeys(keys []sql.SortKey, exprs []sql.Expression, names []string, resolve sql.FieldResolver) ([]sql.SortKey, error) {
+	out := make([]sql.SortKey, len(keys))
+	for i, k := range keys {
+		out[i] = k
+		if slices.Contains(names, k.Field) {
+			continue
+		}
+
+		f, err := resolve(k.Field)
+		if err != nil {
+			return nil, err
+		}
 
-	orderByFields := data.OrderByFields()
+		j := slices.IndexFunc(exprs, func(exp sql.Expression) bool {
+			return exp.IsFieldName() && exp.AsFieldName() == f
+		})
+
+		if j < 0 {
+			return nil, ErrNoField
+		}
 
-	project := newProjectPlan(p, data.Fields())
-	if len(orderByFields) == 0 {
-		return project, nil
+		out[i].Field = names[j]
 	}
 
-	return newSortPlan(x, project, orderByFields), nil
+	return out, nil
 }
@@ -0,0 +1,268 @@
+package engine
+
+import (
+	"io"
+
+	"github.com/luigitni/simpledb/pages"
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+var (
+	_ Plan = &blockProductPlan{}
+	_ Scan = &blockProductScan{}
+)
+
+// blockProductReservedBuffers is the number of available buffers
+// that a block product leaves to the scan of its second plan
+// and to the other scans of the query.
+const blockProductReservedBuffers = 2
+
+// blockProductPlan is the product of two plans computed as a block nested loop.
+// The first plan is materialized into a temporary table, which is read in chunks:
+// each chunk is a window of consecutive blocks pinned all at once,
+// as many as the available buffers allow.
+// The second plan is read once for each chunk, rather than once for each record of the first plan,
+// and each of its records is paired with all the records of the chunk.
+type blockProductPlan struct {
+	x      tx.Transaction
+	p1     Plan
+	p2     Plan
+	schema Schema
+}
+
+func newBlockProductPlan(x tx.Transaction, p1 Plan, p2 Plan) *blockProductPlan {
+	return &blockProductPlan{
+		x:      x,
+		p1:     p1,
+		p2:     p2,
+		schema: newJoinedSchema(p1.Schema(), p2.Schema()),
+	}
+}
+
+// chunkSize returns the number of blocks of a chunk of a table of the given size.
+// Chunks are as large as the available buffers allow,
+// but the blocks are split evenly among the fewest chunks.
+func chunkSize(available int, blocks int) int {
+	available -= blockProductReservedBuffers
+	if available <= 1 || blocks <= 1 {
+		return 1
+	}
+
+	chunks := (blocks + available - 1) / available
+	return (blocks + chunks - 1) / chunks
+}
+
+func (bp *blockProductPlan) Open() (Scan, error) {
+	tt, err := newMaterializePlan(bp.x, bp.p1).materialize()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, err := bp.x.Size(tt.fileName())
+	if err != nil {
+		return nil, err
+	}
+
+	rhs, err := bp.p2.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	size := chunkSize(bp.x.AvailableBuffers(), int(blocks))
+
+	return newBlockProductScan(bp.x, tt, int(blocks), size, rhs), nil
+}
+
+// BlocksAccessed is the cost of materializing the first plan and reading it back,
+// plus the cost of reading the second plan once for each chunk.
+func (bp *blockProductPlan) BlocksAccessed() int {
+	blocks := tmpTableBlocks(bp.x, bp.p1)
+	size := chunkSize(bp.x.AvailableBuffers(), blocks)
+	chunks := max(1, (blocks+size-1)/size)
+
+	return bp.p1.BlocksAccessed() + 2*blocks + chunks*bp.p2.BlocksAccessed()
+}
+
+func (bp *blockProductPlan) RecordsOutput() int {
+	return bp.p1.RecordsOutput() * bp.p2.RecordsOutput()
+}
+
+func (bp *blockProductPlan) DistinctValues(fieldName string) int {
+	if bp.p1.Schema().HasField(fieldName) {
+		return bp.p1.DistinctValues(fieldName)
+	}
+
+	return bp.p2.DistinctValues(fieldName)
+}
+
+func (bp *blockProductPlan) Schema() Schema {
+	return bp.schema
+}
+
+// blockProductScan pairs each record of the rhs scan
+// with each record of the current chunk of the lhs table.
+// Once the rhs scan is exhausted, the next chunk is pinned
+// and the rhs scan starts over.
+type blockProductScan struct {
+	x         tx.Transaction
+	lhs       *tmpTable
+	blocks    int
+	chunkSize int
+	// nextBlock is the first block of the chunk after the current one
+	nextBlock int
+	chunk     *chunkScan
+	rhs       Scan
+}
+
+func newBlockProductScan(x tx.Transaction, lhs *tmpTable, blocks int, chunkSize int, rhs Scan) *blockProductScan {
+	return &blockProductScan{
+		x:         x,
+		lhs:       lhs,
+		blocks:    blocks,
+		chunkSize: chunkSize,
+		rhs:       rhs,
+	}
+}
+
+func (bs *blockProductScan) BeforeFirst() error {
+	if bs.chunk != nil {
+		bs.chunk.Close()
+		bs.chunk = nil
+	}
+
+	bs.nextBlock = 0
+
+	return nil
+}
+
+func (bs *blockProductScan) Next() error {
+	for {
+		if bs.chunk != nil {
+			err := bs.chunk.Next()
+			if err != io.EOF {
+				return err
+			}
+
+			// the chunk is exhausted for the current rhs record
+			err = bs.rhs.Next()
+			if err == nil {
+				bs.chunk.BeforeFirst()
+				continue
+			}
+
+			if err != io.EOF {
+				return err
+			}
+
+			bs.chunk.Close()
+			bs.chunk = nil
+		}
+
+		if bs.nextBlock >= bs.blocks {
+			return io.EOF
+		}
+
+		last := min(bs.nextBlock+bs.chunkSize, bs.blocks) - 1
+		bs.chunk = newChunkScan(bs.x, bs.lhs, bs.nextBlock, last)
+		bs.nextBlock = last + 1
+
+		if err := bs.rhs.BeforeFirst(); err != nil {
+			return err
+		}
+
+		if err := bs.rhs.Next(); err != nil {
+			return err
+		}
+	}
+}
+
+func (bs *blockProductScan) Val(fieldName string) (storage.Value, error) {
+	if bs.chunk.HasField(fieldName) {
+		return bs.chunk.Val(fieldName)
+	}
+
+	return bs.rhs.Val(fieldName)
+}
+
+func (bs *blockProductScan) HasField(fieldName string) bool {
+	return bs.lhs.layout.schema.HasField(fieldName) || bs.rhs.HasField(fieldName)
+}
+
+func (bs *blockProductScan) Type(fieldName string) storage.FieldType {
+	if bs.lhs.layout.schema.HasField(fieldName) {
+		return bs.lhs.layout.schema.ftype(fieldName)
+	}
+
+	return bs.rhs.Type(fieldName)
+}
+
+func (bs *blockProductScan) Close() {
+	if bs.chunk != nil {
+		bs.chunk.Close()
+	}
+
+	bs.rhs.Close()
+}
+
+// chunkScan reads the records of a window of consecutive blocks of a table,
+// which stay pinned until the scan is closed.
+type chunkScan struct {
+	layout      Layout
+	pages       []*pages.SlottedPage
+	current     int
+	currentSlot storage.SmallInt
+}
+
+func newChunkScan(x tx.Transaction, tt *tmpTable, first int, last int) *chunkScan {
+	cs := &chunkScan{
+		layout: tt.layout,
+	}
+
+	for b := first; b <= last; b++ {
+		block := storage.NewBlock(tt.fileName(), storage.Long(b))
+		cs.pages = append(cs.pages, pages.NewSlottedPage(x, block, tt.layout))
+	}
+
+	cs.BeforeFirst()
+
+	return cs
+}
+
+func (cs *chunkScan) BeforeFirst() {
+	cs.current = 0
+	cs.currentSlot = pages.BeforeFirstSlot
+}
+
+func (cs *chunkScan) Next() error {
+	for cs.current < len(cs.pages) {
+		slot, err := cs.pages[cs.current].NextAfter(cs.currentSlot)
+		if err == nil {
+			cs.currentSlot = slot
+			return nil
+		}
+
+		if err != pages.ErrNoFreeSlot {
+			return err
+		}
+
+		cs.current++
+		cs.currentSlot = pages.BeforeFirstSlot
+	}
+
+	return io.EOF
+}
+
+func (cs *chunkScan) Val(fieldName string) (storage.Value, error) {
+	return recordVal(cs.pages[cs.current], cs.currentSlot, cs.layout, fieldName)
+}
+
+func (cs *chunkScan) HasField(fieldName string) bool {
+	return cs.layout.schema.HasField(fieldName)
+}
+
+func (cs *chunkScan) Close() {
+	for _, p := range cs.pages {
+		p.Close()
+	}
+}
@@ -23,8 +23,13 @@ func BTreeIndexSearchCost(numblocks int, recordsPerBucket int) int {
 	return 1 + int(math.Log(float64(numblocks))/math.Log(float64(recordsPerBucket)))
 }
 
+// bTreeIndexFiles returns the names of the files that hold the leaves and the directory of the index.
+func bTreeIndexFiles(idxName string) (string, string) {
+	return idxName + "_leaf", idxName + "_dir"
+}
+
 func NewBTreeIndex(x tx.Transaction, idxName string, leafLayout Layout) (*BTreeIndex, error) {
-	leafTable := idxName + "_leaf"
+	leafTable, dirTable := bTreeIndexFiles(idxName)
 
 	size, err := x.Size(leafTable)
 	if err != nil {
@@ -49,8 +54,6 @@ func NewBTreeIndex(x tx.Transaction, idxName string, leafLayout Layout) (*BTreeI
 	dirSchema.add(indexFieldDataVal, leafLayout.schema)
 	dirSchema.add(indexFieldBlockNumber, leafLayout.schema)
 
-	dirTable := idxName + "_dir"
-
 	dirLayout := NewLayout(dirSchema)
 	rootBlock := storage.NewBlock(dirTable, 0)
 
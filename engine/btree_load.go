@@ -0,0 +1,389 @@
+package engine
+
+import (
+	"io"
+
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+var (
+	_ Plan = indexEntryPlan{}
+	_ Scan = &indexEntryScan{}
+)
+
+// indexEntryPlan plans the entries of an index over a field of a table:
+// for each record whose field is not NULL, the value of the field
+// and the block and the slot of the record, as they are stored in the leaves of a B-tree.
+type indexEntryPlan struct {
+	tp     tablePlan
+	field  string
+	schema Schema
+}
+
+func newIndexEntryPlan(tp tablePlan, field string) indexEntryPlan {
+	schema := newSchema()
+	schema.addField(indexFieldDataVal, tp.layout.schema.ftype(field))
+	schema.addField(indexFieldBlockNumber, storage.LONG)
+	schema.addField(indexFieldRecordID, storage.SMALLINT)
+
+	return indexEntryPlan{
+		tp:     tp,
+		field:  field,
+		schema: schema,
+	}
+}
+
+func (p indexEntryPlan) Open() (Scan, error) {
+	return &indexEntryScan{
+		ts:     newTableScan(p.tp.tx, p.tp.tableName, p.tp.layout),
+		field:  p.field,
+		schema: p.schema,
+	}, nil
+}
+
+func (p indexEntryPlan) BlocksAccessed() int {
+	return p.tp.BlocksAccessed()
+}
+
+func (p indexEntryPlan) RecordsOutput() int {
+	return p.tp.RecordsOutput()
+}
+
+func (p indexEntryPlan) DistinctValues(fieldName string) int {
+	if fieldName == indexFieldDataVal {
+		return p.tp.DistinctValues(p.field)
+	}
+
+	return p.tp.RecordsOutput()
+}
+
+func (p indexEntryPlan) Schema() Schema {
+	return p.schema
+}
+
+// indexEntryScan reads the entries of an index from the records of the table.
+// Records whose field is NULL are skipped, since NULLs are not indexed.
+type indexEntryScan struct {
+	ts     *tableScan
+	field  string
+	schema Schema
+}
+
+func (s *indexEntryScan) BeforeFirst() error {
+	return s.ts.BeforeFirst()
+}
+
+func (s *indexEntryScan) Next() error {
+	for {
+		if err := s.ts.Next(); err != nil {
+			return err
+		}
+
+		v, err := s.ts.Val(s.field)
+		if err != nil {
+			return err
+		}
+
+		if !v.IsNull() {
+			return nil
+		}
+	}
+}
+
+func (s *indexEntryScan) Val(fname string) (storage.Value, error) {
+	switch fname {
+	case indexFieldDataVal:
+		return s.ts.Val(s.field)
+	case indexFieldBlockNumber:
+		return storage.ValueFromLong(storage.LONG, s.ts.GetRID().Blocknum), nil
+	case indexFieldRecordID:
+		return storage.ValueFromInteger[storage.SmallInt](storage.SizeOfSmallInt, s.ts.GetRID().Slot), nil
+	}
+
+	return nil, ErrNoField
+}
+
+func (s *indexEntryScan) HasField(fname string) bool {
+	return s.schema.HasField(fname)
+}
+
+func (s *indexEntryScan) Type(fname string) storage.FieldType {
+	return s.schema.ftype(fname)
+}
+
+func (s *indexEntryScan) Close() {
+	s.ts.Close()
+}
+
+// load builds the index from the bottom up, out of the entries read from the scan,
+// which must be sorted by value and have the fields of an indexEntryPlan.
+// The leaves are filled from left to right, and the directory is built above them one level at a time,
+// so that each page is written once instead of being split over and over by Insert.
+// A page is filled as long as it can still take a record of the largest key,
+// so that the index can keep growing through Insert once it is loaded.
+// The index must be empty.
+func (idx *BTreeIndex) load(entries Scan) error {
+	leaves, err := idx.loadLeaves(entries)
+	if err != nil {
+		return err
+	}
+
+	return idx.loadDirectory(leaves)
+}
+
+// loadLeaves writes the entries into the leaves and returns the directory entries that point to them.
+// As with Insert, the records of a value never span two leaves:
+// when a leaf fills up with the records of a single value,
+// the rest of them go to overflow blocks, each of which follows the previous one in the file.
+func (idx *BTreeIndex) loadLeaves(entries Scan) ([]dirEntry, error) {
+	t := idx.leafLayout.schema.ftype(indexFieldDataVal)
+
+	leaf := newBTreePage(idx.x, storage.NewBlock(idx.leafTable, 0), idx.leafLayout)
+	defer func() {
+		leaf.Close()
+	}()
+
+	// the first leaf holds the values that come before the first key of the directory,
+	// hence it is pointed to by the smallest value.
+	leaves := []dirEntry{{value: storage.MinValue(t), blockNum: 0}}
+
+	var (
+		slot storage.SmallInt
+		last storage.Value
+		// overflow is true if the current leaf is an overflow block,
+		// which can only hold records of the value that overflowed.
+		overflow bool
+	)
+
+	// appendLeaf appends a new leaf to the file and makes it the current leaf.
+	// If overflows is true, the new leaf is the overflow block of the current one,
+	// which records it in its flag.
+	// The current leaf is always the last block of the file,
+	// so that an overflow block is appended right after the leaf it belongs to.
+	appendLeaf := func(overflows bool) (storage.Block, error) {
+		block, err := idx.x.Append(idx.leafTable)
+		if err != nil {
+			return storage.Block{}, err
+		}
+
+		if overflows {
+			if err := leaf.setFlag(block.Number()); err != nil {
+				return storage.Block{}, err
+			}
+		}
+
+		leaf.Close()
+		leaf = newBTreePage(idx.x, block, idx.leafLayout)
+		slot = 0
+
+		return block, leaf.format(flagUnset)
+	}
+
+	for {
+		err := entries.Next()
+		if err == io.EOF {
+			return leaves, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := entries.Val(indexFieldDataVal)
+		if err != nil {
+			return nil, err
+		}
+
+		val := storage.Copy(v)
+
+		block, err := entries.Val(indexFieldBlockNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := entries.Val(indexFieldRecordID)
+		if err != nil {
+			return nil, err
+		}
+
+		rid := NewRID(storage.ValueAsLong(storage.LONG, block), storage.ValueAsInteger[storage.SmallInt](id))
+		same := slot > 0 && val.Equals(last)
+
+		for slot > 0 {
+			fits, err := leaf.fits(val.Size(t) + SizeOfRID)
+			if err != nil {
+				return nil, err
+			}
+
+			if fits && (same || !overflow) {
+				break
+			}
+
+			if !same {
+				// a new value goes to a new leaf when the current one is full,
+				// or when the current one is an overflow block.
+				b, err := appendLeaf(false)
+				if err != nil {
+					return nil, err
+				}
+
+				leaves = append(leaves, dirEntry{value: val, blockNum: b.Number()})
+				overflow = false
+				break
+			}
+
+			first, err := leaf.dataVal(0)
+			if err != nil {
+				return nil, err
+			}
+
+			if first.Equals(val) {
+				// the leaf is full of records of the value: they overflow into the next block.
+				if _, err := appendLeaf(true); err != nil {
+					return nil, err
+				}
+
+				overflow = true
+				break
+			}
+
+			// the records of the value are moved to a new leaf of their own,
+			// from which they can overflow.
+			pos, err := leaf.findSlotBefore(val)
+			if err != nil {
+				return nil, err
+			}
+
+			b, err := leaf.split(pos+1, flagUnset)
+			if err != nil {
+				return nil, err
+			}
+
+			leaf.Close()
+			leaf = newBTreePage(idx.x, b, idx.leafLayout)
+
+			slot, err = leaf.numRecords()
+			if err != nil {
+				return nil, err
+			}
+
+			leaves = append(leaves, dirEntry{value: val, blockNum: b.Number()})
+		}
+
+		if err := leaf.insertLeafRecord(slot, val, rid); err != nil {
+			return nil, err
+		}
+
+		slot++
+
+		last = val
+	}
+}
+
+// loadDirectory builds the directory above the leaves, from the bottom up.
+// The entries of each level are written into the root:
+// if they do not fit, they are written into new blocks of the directory instead,
+// and the entries pointing to those blocks make up the level above.
+func (idx *BTreeIndex) loadDirectory(entries []dirEntry) error {
+	for level := storage.Long(0); ; level++ {
+		upper, err := idx.loadDirLevel(entries, level)
+		if err != nil {
+			return err
+		}
+
+		if upper == nil {
+			return nil
+		}
+
+		entries = upper
+	}
+}
+
+// loadDirLevel writes the entries of a level of the directory, starting from the root.
+// It returns the entries of the level above, or nil if the entries fit into the root.
+func (idx *BTreeIndex) loadDirLevel(entries []dirEntry, level storage.Long) ([]dirEntry, error) {
+	t := idx.dirLayout.schema.ftype(indexFieldDataVal)
+
+	page := newBTreePage(idx.x, idx.rootBlock, idx.dirLayout)
+	defer func() {
+		page.Close()
+	}()
+
+	if err := page.format(level); err != nil {
+		return nil, err
+	}
+
+	// formatting does not reset the number of records of the root,
+	// which still holds those of the empty index or of the level below.
+	if err := page.setNumRecords(0); err != nil {
+		return nil, err
+	}
+
+	var (
+		upper []dirEntry
+		slot  storage.SmallInt
+	)
+
+	for _, e := range entries {
+		fits, err := page.fits(e.value.Size(t) + storage.SizeOfLong)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fits && slot > 0 {
+			if page.block == idx.rootBlock {
+				// the level does not fit into the root:
+				// its first page is moved out of the root, which becomes a level higher.
+				b, err := page.split(0, level)
+				if err != nil {
+					return nil, err
+				}
+
+				upper = append(upper, dirEntry{value: entries[0].value, blockNum: b.Number()})
+			}
+
+			b, err := idx.x.Append(idx.rootBlock.FileName())
+			if err != nil {
+				return nil, err
+			}
+
+			page.Close()
+			page = newBTreePage(idx.x, b, idx.dirLayout)
+			if err := page.format(level); err != nil {
+				return nil, err
+			}
+
+			upper = append(upper, dirEntry{value: e.value, blockNum: b.Number()})
+			slot = 0
+		}
+
+		if err := page.insertDirectoryRecord(slot, e.value, e.blockNum); err != nil {
+			return nil, err
+		}
+
+		slot++
+	}
+
+	return upper, nil
+}
+
+// buildIndex creates the index over the field of the table and loads into it the records of the table.
+// The entries of the index are sorted by value before they are loaded.
+func buildIndex(x tx.Transaction, idxName string, tp tablePlan, field string) error {
+	idx, err := NewBTreeIndex(x, idxName, idxLayout(tp.layout.schema, field))
+	if err != nil {
+		return err
+	}
+
+	defer idx.Close()
+
+	s, err := newSortPlan(x, newIndexEntryPlan(tp, field), ascending([]string{indexFieldDataVal})).Open()
+	if err != nil {
+		return err
+	}
+
+	defer s.Close()
+
+	return idx.load(s)
+}
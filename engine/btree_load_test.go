@@ -0,0 +1,176 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/test"
+	"github.com/luigitni/simpledb/tx"
+)
+
+func TestBTreeIndexLoad(t *testing.T) {
+	fm, lm, bm := test.MakeManagers(t)
+
+	x := tx.NewTx(fm, lm, bm)
+	defer x.Commit()
+
+	schema := newSchema()
+	schema.addField("v", storage.TEXT)
+	layout := NewLayout(schema)
+
+	tblName := test.RandomName()
+	tp := tablePlan{
+		tx:        x,
+		tableName: tblName,
+		layout:    layout,
+		schema:    schema,
+	}
+
+	padding := strings.Repeat("x", 150)
+	key := func(i int) string {
+		return fmt.Sprintf("%05d %s", i, padding)
+	}
+
+	// distinct keys fill enough leaves to need a directory level below the root.
+	// Key 10 has more records than fit next to the keys before it in a leaf,
+	// and key 1500 has enough records to overflow more than once.
+	var keys []string
+	for i := range 3000 {
+		keys = append(keys, key(i))
+	}
+
+	for range 30 {
+		keys = append(keys, key(10))
+	}
+
+	for range 300 {
+		keys = append(keys, key(1500))
+	}
+
+	// NULLs are not indexed
+	for range 20 {
+		keys = append(keys, "")
+	}
+
+	rand.Shuffle(len(keys), func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+
+	expected := map[string][]RID{}
+
+	ts := newTableScan(x, tblName, layout)
+	for _, k := range keys {
+		val := storage.Null
+		if k != "" {
+			val = storage.ValueFromGoString(k)
+		}
+
+		if err := ts.Insert(val.Size(storage.TEXT)); err != nil {
+			t.Fatalf("Error inserting record: %v", err)
+		}
+
+		if err := ts.SetVal("v", val); err != nil {
+			t.Fatalf("Error setting value: %v", err)
+		}
+
+		if k != "" {
+			expected[k] = append(expected[k], ts.GetRID())
+		}
+	}
+
+	ts.Close()
+
+	idxName := test.RandomName()
+	if err := buildIndex(x, idxName, tp, "v"); err != nil {
+		t.Fatalf("Error building index: %v", err)
+	}
+
+	index, err := NewBTreeIndex(x, idxName, idxLayout(schema, "v"))
+	if err != nil {
+		t.Fatalf("Error opening index: %v", err)
+	}
+
+	defer index.Close()
+
+	find := func(k string) []RID {
+		if err := index.BeforeFirst(storage.ValueFromGoString(k)); err != nil {
+			t.Fatalf("Error before first for key %q: %v", k, err)
+		}
+
+		var rids []RID
+		for {
+			err := index.Next()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				t.Fatalf("Error next for key %q: %v", k, err)
+			}
+
+			rid, err := index.DataRID()
+			if err != nil {
+				t.Fatalf("Error getting data RID for key %q: %v", k, err)
+			}
+
+			rids = append(rids, rid)
+		}
+
+		return rids
+	}
+
+	check := func(t *testing.T) {
+		for k, exp := range expected {
+			got := find(k)
+			if len(got) != len(exp) {
+				t.Fatalf("Expected %d records for key %.5s, got %d", len(exp), k, len(got))
+			}
+
+			for _, rid := range exp {
+				if !slices.Contains(got, rid) {
+					t.Fatalf("Expected record %v for key %.5s, got %v", rid, k, got)
+				}
+			}
+		}
+
+		if got := find(key(3000)); len(got) != 0 {
+			t.Fatalf("Expected no records for a missing key, got %v", got)
+		}
+	}
+
+	t.Run("finds the loaded records", func(t *testing.T) {
+		root := newBTreePage(x, index.rootBlock, index.dirLayout)
+		defer root.Close()
+
+		level, err := root.flag()
+		if err != nil {
+			t.Fatalf("Error getting level of the root: %v", err)
+		}
+
+		if level == 0 {
+			t.Fatalf("Expected the root to be above the first level of the directory")
+		}
+
+		check(t)
+	})
+
+	t.Run("inserts records into the loaded index", func(t *testing.T) {
+		for i := range 200 {
+			for _, k := range []string{key(5000 + i), key(1500), key(20)} {
+				rid := NewRID(storage.Long(10000+i), storage.SmallInt(len(expected[k])))
+				if err := index.Insert(storage.ValueFromGoString(k), rid); err != nil {
+					t.Fatalf("Error inserting key %.5s: %v", k, err)
+				}
+
+				expected[k] = append(expected[k], rid)
+			}
+		}
+
+		check(t)
+	})
+}
@@ -192,6 +192,12 @@ func (p bTreePage) isFull() (bool, error) {
 	return !fits, nil
 }
 
+// fits reports whether a record of the given size can be added to the page
+// without making it full, that is while leaving room for a record of the largest key.
+func (p bTreePage) fits(size storage.Offset) (bool, error) {
+	return p.slottedPage.RecordsFit(size, bTreeMaxSizeOfKey)
+}
+
 // findSlotBefore looks for the rank of the key in the page and returns the slot
 // of the predecessor of the key within the page.
 // It uses a binary search to find the slot that contains the key or the slot
@@ -294,6 +300,10 @@ func (page bTreePage) delete(slot storage.SmallInt) error {
 // It deletes the record from the current page once it's successfully copied over.
 // Because the transfer happens from slot to the right, and records are in increasing
 // key order, the records that are moved are those with the highest key value.
+// Records are copied through insertLeafRecord and insertDirectoryRecord,
+// so that they take the same size in the dst page as they did when they were first inserted.
+// In particular, the record id of a leaf is stored as the SmallInt slot of a RID,
+// even though the layout may give its field the size of an Int.
 func (page bTreePage) transferRecords(srcSlot storage.SmallInt, dst bTreePage) error {
 	var dstSlot storage.SmallInt
 
@@ -303,6 +313,8 @@ func (page bTreePage) transferRecords(srcSlot storage.SmallInt, dst bTreePage) e
 		return fmt.Errorf("transferRecords: getNumRecords: %w", err)
 	}
 
+	leaf := page.layout.schema.HasField(indexFieldRecordID)
+
 	for slot := srcSlot; slot < records; slot++ {
 		deleted, err := page.slottedPage.IsDeleted(slot)
 		if err != nil {
@@ -313,39 +325,36 @@ func (page bTreePage) transferRecords(srcSlot storage.SmallInt, dst bTreePage) e
 			continue
 		}
 
-		var recordSize storage.Offset
+		v, err := page.dataVal(slot)
+		if err != nil {
+			return fmt.Errorf("transferRecords: page.dataVal: %w", err)
+		}
+
+		// the value is copied, since writing to dst can pin a new buffer
+		// and take over the one the value was read from.
+		val := storage.Copy(v)
 
-		vals := make([]storage.Value, 0, len(page.layout.schema.fields))
-		for _, f := range page.layout.schema.fields {
-			v, err := page.val(slot, f)
+		if leaf {
+			rid, err := page.dataRID(slot)
 			if err != nil {
-				return fmt.Errorf("transferRecords: page.val: %w", err)
+				return fmt.Errorf("transferRecords: page.dataRID: %w", err)
 			}
 
-			recordSize += v.Size(page.layout.schema.ftype(f))
-			vals = append(vals, v)
-		}
-
-		if err := dst.slottedPage.InsertAt(dstSlot, recordSize); err != nil {
-			return fmt.Errorf(
-				"transferRecords: dst.InsertAfter: %w: dst.slot: %d, recordSize %d, block %d",
-				err,
-				dstSlot,
-				recordSize,
-				dst.block.Number(),
-			)
-		}
-
-		for i, f := range page.layout.schema.fields {
-			v := vals[i]
+			if err := dst.insertLeafRecord(dstSlot, val, rid); err != nil {
+				return fmt.Errorf("transferRecords: dst.insertLeafRecord: %w: dst.slot: %d, block %d", err, dstSlot, dst.block.Number())
+			}
+		} else {
+			blockNum, err := page.getBlockNumber(slot)
+			if err != nil {
+				return fmt.Errorf("transferRecords: page.getBlockNumber: %w", err)
+			}
 
-			if err := dst.setVal(dstSlot, f, v); err != nil {
-				return fmt.Errorf("transferRecords: dst.setVal: %w", err)
+			if err := dst.insertDirectoryRecord(dstSlot, val, blockNum); err != nil {
+				return fmt.Errorf("transferRecords: dst.insertDirectoryRecord: %w: dst.slot: %d, block %d", err, dstSlot, dst.block.Number())
 			}
 		}
 
 		dstSlot++
-		dst.setNumRecords(dstSlot)
 	}
 
 	if err := page.slottedPage.Truncate(srcSlot); err != nil {
@@ -566,7 +575,8 @@ func (leaf *bTreeLeaf) tryOverflow() (bool, error) {
 
 	leaf.Close()
 
-	nextBlock := storage.NewBlock(leaf.fileName, leaf.contents.slottedPage.Block().Number()+1)
+	// the flag holds the number of the overflow block
+	nextBlock := storage.NewBlock(leaf.fileName, flag)
 
 	leaf.contents = newBTreePage(leaf.x, nextBlock, leaf.layout)
 	leaf.currentSlot = 0
@@ -825,7 +835,11 @@ func (dir *bTreeDir) search(key storage.Value) (storage.Long, error) {
 		return 0, err
 	}
 
-	level := dir.contents.flag
+	// level reads the flag of the page the search has moved to,
+	// rather than the one of the root.
+	level := func() (storage.Long, error) {
+		return dir.contents.flag()
+	}
 
 	// traverse the directory tree until we reach the leaf level.
 	// if the flag is 0, we are at the leaf level.
@@ -5,6 +5,7 @@ import (
 	"io"
 	"math"
 	"slices"
+	"strings"
 	"testing"
 
 	"math/rand"
@@ -934,6 +935,379 @@ func TestBTreeDirInsert(t *testing.T) {
 	})
 }
 
+func TestBTreePageFits(t *testing.T) {
+	fm, lm, bm := test.MakeManagers(t)
+
+	schema := newSchema()
+	schema.addField(indexFieldDataVal, storage.INT)
+	schema.addField(indexFieldBlockNumber, storage.LONG)
+	schema.addField(indexFieldRecordID, storage.SMALLINT)
+
+	layout := NewLayout(schema)
+
+	x := tx.NewTx(fm, lm, bm)
+	defer x.Commit()
+
+	block := storage.NewBlock(test.RandomName(), 0)
+	x.Append(block.FileName())
+
+	page := newBTreePage(x, block, layout)
+	defer page.Close()
+
+	if err := page.format(flagUnset); err != nil {
+		t.Fatalf("unexpected error when formatting the page: %s", err)
+	}
+
+	size := storage.SizeOfInt + SizeOfRID
+
+	// a record that fits must not make the page full,
+	// and the first one that does not fit must.
+	var slot storage.SmallInt
+	for ; ; slot++ {
+		fits, err := page.fits(size)
+		if err != nil {
+			t.Fatalf("unexpected error when checking if the record fits: %s", err)
+		}
+
+		val := storage.ValueFromInteger[storage.Int](storage.SizeOfInt, storage.Int(slot))
+		if err := page.insertLeafRecord(slot, val, NewRID(0, slot)); err != nil {
+			t.Fatalf("unexpected error when inserting record %d: %s", slot, err)
+		}
+
+		full, err := page.isFull()
+		if err != nil {
+			t.Fatalf("unexpected error when checking if the page is full: %s", err)
+		}
+
+		if full == fits {
+			t.Fatalf("expected the page to be full only after a record that does not fit, got fits %t and full %t at record %d", fits, full, slot)
+		}
+
+		if full {
+			break
+		}
+	}
+
+	if slot == 0 {
+		t.Fatal("expected the page to take more than one record")
+	}
+}
+
+func TestBTreePageSplitVarlen(t *testing.T) {
+	fm, lm, bm := test.MakeManagers(t)
+
+	x := tx.NewTx(fm, lm, bm)
+	defer x.Commit()
+
+	schema := newSchema()
+	schema.addField(indexFieldDataVal, storage.TEXT)
+	schema.addField(indexFieldBlockNumber, storage.LONG)
+	schema.addField(indexFieldRecordID, storage.SMALLINT)
+
+	layout := NewLayout(schema)
+
+	block := storage.NewBlock(test.RandomName(), 0)
+	x.Append(block.FileName())
+
+	page := newBTreePage(x, block, layout)
+	defer page.Close()
+
+	if err := page.format(flagUnset); err != nil {
+		t.Fatalf("unexpected error when formatting the page: %s", err)
+	}
+
+	// the records have different sizes, so that a value read at the wrong offset
+	// or from a buffer that has been written over does not go unnoticed.
+	const records = 50
+	for i := range records {
+		slot := storage.SmallInt(i)
+		val := storage.ValueFromGoString(fmt.Sprintf("record %03d %s", i, strings.Repeat("x", i)))
+
+		if err := page.insertLeafRecord(slot, val, NewRID(storage.Long(i), slot)); err != nil {
+			t.Fatalf("unexpected error when inserting record: %s", err)
+		}
+	}
+
+	const splitpos = 20
+
+	newBlock, err := page.split(splitpos, flagUnset)
+	if err != nil {
+		t.Fatalf("unexpected error when splitting the page: %s", err)
+	}
+
+	splitted := newBTreePage(x, newBlock, layout)
+	defer splitted.Close()
+
+	test := func(page bTreePage, slot storage.SmallInt, i int) error {
+		v, err := page.dataVal(slot)
+		if err != nil {
+			return fmt.Errorf("unexpected error when getting the value at %d: %s", slot, err)
+		}
+
+		exp := fmt.Sprintf("record %03d %s", i, strings.Repeat("x", i))
+		if got := storage.ValueAsGoString(v); got != exp {
+			return fmt.Errorf("expected %q at %d, got %q", exp, slot, got)
+		}
+
+		rid, err := page.dataRID(slot)
+		if err != nil {
+			return fmt.Errorf("unexpected error when getting the rid at %d: %s", slot, err)
+		}
+
+		if exp := NewRID(storage.Long(i), storage.SmallInt(i)); rid != exp {
+			return fmt.Errorf("expected rid %v at %d, got %v", exp, slot, rid)
+		}
+
+		return nil
+	}
+
+	for i := 0; i < splitpos; i++ {
+		if err := test(page, storage.SmallInt(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := splitpos; i < records; i++ {
+		if err := test(splitted, storage.SmallInt(i-splitpos), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBTreePageSplitFullLeaf(t *testing.T) {
+	fm, lm, bm := test.MakeManagers(t)
+
+	x := tx.NewTx(fm, lm, bm)
+	defer x.Commit()
+
+	// the leaves of an index give the record id the size of an Int,
+	// while insertLeafRecord writes it as the SmallInt slot of a RID
+	tableSchema := newSchema()
+	tableSchema.addField("id", storage.INT)
+
+	layout := idxLayout(tableSchema, "id")
+
+	block := storage.NewBlock(test.RandomName(), 0)
+	x.Append(block.FileName())
+
+	page := newBTreePage(x, block, layout)
+	defer page.Close()
+
+	if err := page.format(flagUnset); err != nil {
+		t.Fatalf("unexpected error when formatting the page: %s", err)
+	}
+
+	val := storage.ValueFromInteger[storage.Int](storage.SizeOfInt, 7)
+
+	var records storage.SmallInt
+	for ; ; records++ {
+		full, err := page.isFull()
+		if err != nil {
+			t.Fatalf("unexpected error when checking if the page is full: %s", err)
+		}
+
+		if full {
+			break
+		}
+
+		if err := page.insertLeafRecord(records, val, NewRID(storage.Long(records), records)); err != nil {
+			t.Fatalf("unexpected error when inserting record: %s", err)
+		}
+	}
+
+	// all but the first record are moved, as when a leaf overflows,
+	// and must fit into the new page as they did into the full one
+	newBlock, err := page.split(1, flagUnset)
+	if err != nil {
+		t.Fatalf("unexpected error when splitting the page: %s", err)
+	}
+
+	splitted := newBTreePage(x, newBlock, layout)
+	defer splitted.Close()
+
+	n, err := splitted.numRecords()
+	if err != nil {
+		t.Fatalf("unexpected error when getting the number of records: %s", err)
+	}
+
+	if n != records-1 {
+		t.Fatalf("expected %d records in the new page, got %d", records-1, n)
+	}
+
+	for slot := storage.SmallInt(0); slot < n; slot++ {
+		rid, err := splitted.dataRID(slot)
+		if err != nil {
+			t.Fatalf("unexpected error when getting the rid at %d: %s", slot, err)
+		}
+
+		if exp := NewRID(storage.Long(slot+1), slot+1); rid != exp {
+			t.Fatalf("expected rid %v at %d, got %v", exp, slot, rid)
+		}
+	}
+}
+
+func TestBTreeLeafTryOverflow(t *testing.T) {
+	fm, lm, bm := test.MakeManagers(t)
+
+	schema := newSchema()
+	schema.addField(indexFieldDataVal, storage.INT)
+	schema.addField(indexFieldBlockNumber, storage.LONG)
+	schema.addField(indexFieldRecordID, storage.SMALLINT)
+
+	layout := NewLayout(schema)
+
+	x := tx.NewTx(fm, lm, bm)
+	defer x.Commit()
+
+	fileName := test.RandomName()
+
+	// the overflow block of the first leaf is not the one that follows it in the file:
+	// the leaf in between holds a different key.
+	blocks := []struct {
+		flag storage.Long
+		key  storage.Int
+		rids int
+	}{
+		{flag: 2, key: 5, rids: 3},
+		{flag: flagUnset, key: 7, rids: 2},
+		{flag: flagUnset, key: 5, rids: 2},
+	}
+
+	var exp []RID
+	for i, b := range blocks {
+		block, err := x.Append(fileName)
+		if err != nil {
+			t.Fatalf("unexpected error when appending block %d: %s", i, err)
+		}
+
+		page := newBTreePage(x, block, layout)
+		if err := page.format(b.flag); err != nil {
+			t.Fatalf("unexpected error when formatting block %d: %s", i, err)
+		}
+
+		for j := range b.rids {
+			slot := storage.SmallInt(j)
+			rid := NewRID(block.Number(), slot)
+			val := storage.ValueFromInteger[storage.Int](storage.SizeOfInt, b.key)
+
+			if err := page.insertLeafRecord(slot, val, rid); err != nil {
+				t.Fatalf("unexpected error when inserting record in block %d: %s", i, err)
+			}
+
+			if b.key == 5 {
+				exp = append(exp, rid)
+			}
+		}
+
+		page.Close()
+	}
+
+	leaf, err := newBTreeLeaf(
+		x,
+		storage.NewBlock(fileName, 0),
+		layout,
+		storage.ValueFromInteger[storage.Int](storage.SizeOfInt, 5),
+	)
+
+	if err != nil {
+		t.Fatalf("unexpected error when creating the leaf page: %s", err)
+	}
+
+	defer leaf.Close()
+
+	var got []RID
+	for {
+		found, err := leaf.next()
+		if err != nil {
+			t.Fatalf("unexpected error when finding next: %s", err)
+		}
+
+		if !found {
+			break
+		}
+
+		rid, err := leaf.dataRID()
+		if err != nil {
+			t.Fatalf("unexpected error when getting the rid: %s", err)
+		}
+
+		got = append(got, rid)
+	}
+
+	if !slices.Equal(got, exp) {
+		t.Fatalf("expected rids %v, got %v", exp, got)
+	}
+}
+
+func TestBTreeDirSearch(t *testing.T) {
+	fm, lm, bm := test.MakeManagers(t)
+
+	schema := newSchema()
+	schema.addField(indexFieldDataVal, storage.LONG)
+	schema.addField(indexFieldBlockNumber, storage.LONG)
+
+	layout := NewLayout(schema)
+
+	x := tx.NewTx(fm, lm, bm)
+	defer x.Commit()
+
+	block := storage.NewBlock(test.RandomName(), 0)
+	x.Append(block.FileName())
+
+	root := newBTreeDir(x, block, layout)
+	if err := root.contents.format(flagBTreeRoot); err != nil {
+		t.Fatalf("unexpected error when formatting the directory page: %s", err)
+	}
+
+	// each value points to the block of the same number,
+	// and there are enough of them for the root to move up a level.
+	const entries = 1000
+	for i := range storage.Long(entries) {
+		e, err := root.insert(dirEntry{
+			value:    storage.ValueFromInteger[storage.Long](storage.SizeOfLong, i),
+			blockNum: i,
+		})
+
+		if err != nil {
+			t.Fatalf("unexpected error when inserting entry %d: %s", i, err)
+		}
+
+		if e.empty() {
+			continue
+		}
+
+		if err := root.makeNewRoot(e); err != nil {
+			t.Fatalf("unexpected error when making a new root at entry %d: %s", i, err)
+		}
+	}
+
+	level, err := root.contents.flag()
+	if err != nil {
+		t.Fatalf("unexpected error when reading the level of the root: %s", err)
+	}
+
+	root.Close()
+
+	if level == flagBTreeRoot {
+		t.Fatal("expected the root to be above the first level of the directory")
+	}
+
+	for i := range storage.Long(entries) {
+		dir := newBTreeDir(x, block, layout)
+
+		got, err := dir.search(storage.ValueFromInteger[storage.Long](storage.SizeOfLong, i))
+		dir.Close()
+
+		if err != nil {
+			t.Fatalf("unexpected error when searching for %d: %s", i, err)
+		}
+
+		if got != i {
+			t.Fatalf("expected block %d for %d, got %d", i, i, got)
+		}
+	}
+}
+
 func BenchmarkDirectoryPageSplit(b *testing.B) {
 
 	b.StopTimer()
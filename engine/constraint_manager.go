@@ -0,0 +1,442 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+const (
+	constraintCatalogTableName     = "constraints"
+	constraintCatalogNameField     = "name"
+	constraintCatalogTableField    = "table_name"
+	constraintCatalogFieldField    = "field_name"
+	constraintCatalogPrimaryField  = "is_primary"
+	constraintCatalogPositionField = "position"
+
+	sizeOfConstraintCatalogRecord = storage.SizeOfName + // constraint name
+		storage.SizeOfName + // table name
+		storage.SizeOfName + // field name
+		storage.SizeOfSmallInt + // primary key flag
+		storage.SizeOfSmallInt // position of the field within the key
+)
+
+var (
+	ErrConstraintViolation = errors.New("constraint violation")
+	ErrMultiplePrimaryKeys = errors.New("a table can have only one primary key")
+	ErrConstraintIndex     = errors.New("index enforces a constraint")
+)
+
+// keyConstraint is a PRIMARY KEY or UNIQUE constraint over the fields of a table.
+// A key is enforced through the index named after it,
+// which is defined over the first of its fields.
+type keyConstraint struct {
+	name    string
+	table   string
+	primary bool
+	fields  []string
+}
+
+// keyName returns the name of a key constraint over the fields of the table.
+func keyName(table string, primary bool, fields []string) string {
+	if primary {
+		return table + "_pkey"
+	}
+
+	return table + "_" + strings.Join(fields, "_") + "_key"
+}
+
+// constraintManager stores the key constraints of the tables in the constraint catalog.
+// Each field of a key is stored as a record of the catalog,
+// together with its position within the key.
+type constraintManager struct {
+	l  Layout
+	tm *tableManager
+}
+
+func constraintCatalogSchema() Schema {
+	schema := newSchema()
+	schema.addField(constraintCatalogNameField, storage.NAME)
+	schema.addField(constraintCatalogTableField, storage.NAME)
+	schema.addField(constraintCatalogFieldField, storage.NAME)
+	schema.addField(constraintCatalogPrimaryField, storage.SMALLINT)
+	schema.addField(constraintCatalogPositionField, storage.SMALLINT)
+	return schema
+}
+
+func newConstraintManager(tm *tableManager) *constraintManager {
+	return &constraintManager{
+		l:  NewLayout(constraintCatalogSchema()),
+		tm: tm,
+	}
+}
+
+func (cm constraintManager) init(x tx.Transaction) error {
+	return cm.tm.createTable(constraintCatalogTableName, cm.l.schema, x)
+}
+
+// createKey stores the key constraint into the catalog.
+func (cm *constraintManager) createKey(x tx.Transaction, key keyConstraint) error {
+	ts := newTableScan(x, constraintCatalogTableName, cm.l)
+	defer ts.Close()
+
+	var primary storage.SmallInt
+	if key.primary {
+		primary = 1
+	}
+
+	for i, f := range key.fields {
+		if err := ts.Insert(sizeOfConstraintCatalogRecord); err != nil {
+			return err
+		}
+
+		nameBuf := storage.NewNameFromGoString(key.name)
+		if err := ts.SetVal(constraintCatalogNameField, storage.ValueFromName(nameBuf)); err != nil {
+			return err
+		}
+
+		nameBuf.WriteGoString(key.table)
+		if err := ts.SetVal(constraintCatalogTableField, storage.ValueFromName(nameBuf)); err != nil {
+			return err
+		}
+
+		nameBuf.WriteGoString(f)
+		if err := ts.SetVal(constraintCatalogFieldField, storage.ValueFromName(nameBuf)); err != nil {
+			return err
+		}
+
+		if err := ts.SetVal(
+			constraintCatalogPrimaryField,
+			storage.ValueFromInteger[storage.SmallInt](storage.SizeOfSmallInt, primary),
+		); err != nil {
+			return err
+		}
+
+		if err := ts.SetVal(
+			constraintCatalogPositionField,
+			storage.ValueFromInteger[storage.SmallInt](storage.SizeOfSmallInt, storage.SmallInt(i)),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keys returns the key constraints of the table, ordered by name.
+func (cm *constraintManager) keys(x tx.Transaction, tblName string) ([]keyConstraint, error) {
+	ts := newTableScan(x, constraintCatalogTableName, cm.l)
+	defer ts.Close()
+
+	byName := map[string]*keyConstraint{}
+	positions := map[string][]storage.SmallInt{}
+	for {
+		err := ts.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		table, err := ts.Val(constraintCatalogTableField)
+		if err != nil {
+			return nil, err
+		}
+
+		if table.AsName().AsGoString() != tblName {
+			continue
+		}
+
+		name, err := ts.Val(constraintCatalogNameField)
+		if err != nil {
+			return nil, err
+		}
+
+		field, err := ts.Val(constraintCatalogFieldField)
+		if err != nil {
+			return nil, err
+		}
+
+		primary, err := ts.Val(constraintCatalogPrimaryField)
+		if err != nil {
+			return nil, err
+		}
+
+		pos, err := ts.Val(constraintCatalogPositionField)
+		if err != nil {
+			return nil, err
+		}
+
+		// the names are kept in the constraints, so they are cloned out of the buffer of the catalog
+		n := strings.Clone(name.AsName().AsGoString())
+		key, ok := byName[n]
+		if !ok {
+			key = &keyConstraint{
+				name:    n,
+				table:   tblName,
+				primary: storage.ValueAsInteger[storage.SmallInt](primary) == 1,
+			}
+
+			byName[n] = key
+		}
+
+		// the fields are kept sorted by their position within the key
+		p := storage.ValueAsInteger[storage.SmallInt](pos)
+		i, _ := slices.BinarySearch(positions[n], p)
+		positions[n] = slices.Insert(positions[n], i, p)
+		key.fields = slices.Insert(key.fields, i, strings.Clone(field.AsName().AsGoString()))
+	}
+
+	keys := make([]keyConstraint, 0, len(byName))
+	for _, key := range byName {
+		keys = append(keys, *key)
+	}
+
+	slices.SortFunc(keys, func(a, b keyConstraint) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	return keys, nil
+}
+
+// dropKeys removes from the catalog the key constraints of the table,
+// or only those that include fldName if it is not empty, and returns them.
+func (cm *constraintManager) dropKeys(x tx.Transaction, tblName string, fldName string) ([]keyConstraint, error) {
+	keys, err := cm.keys(x, tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	var dropped []keyConstraint
+	for _, key := range keys {
+		if fldName != "" && !slices.Contains(key.fields, fldName) {
+			continue
+		}
+
+		if _, err := deleteCatalogRecords(x, constraintCatalogTableName, cm.l, constraintCatalogNameField, key.name, nil); err != nil {
+			return nil, err
+		}
+
+		dropped = append(dropped, key)
+	}
+
+	return dropped, nil
+}
+
+// renameKeys moves the key constraints of the table to the table newTblName.
+// fields maps the names of the renamed fields to their new names.
+func (cm *constraintManager) renameKeys(x tx.Transaction, tblName string, newTblName string, fields map[string]string) error {
+	ts := newTableScan(x, constraintCatalogTableName, cm.l)
+	defer ts.Close()
+
+	for {
+		err := ts.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		table, err := ts.Val(constraintCatalogTableField)
+		if err != nil {
+			return err
+		}
+
+		if table.AsName().AsGoString() != tblName {
+			continue
+		}
+
+		if err := ts.SetVal(constraintCatalogTableField, storage.ValueFromName(storage.NewNameFromGoString(newTblName))); err != nil {
+			return err
+		}
+
+		field, err := ts.Val(constraintCatalogFieldField)
+		if err != nil {
+			return err
+		}
+
+		newName, ok := fields[field.AsName().AsGoString()]
+		if !ok {
+			continue
+		}
+
+		if err := ts.SetVal(constraintCatalogFieldField, storage.ValueFromName(storage.NewNameFromGoString(newName))); err != nil {
+			return err
+		}
+	}
+}
+
+// isKey reports whether a key constraint is named name.
+func (cm *constraintManager) isKey(x tx.Transaction, name string) (bool, error) {
+	ts := newTableScan(x, constraintCatalogTableName, cm.l)
+	defer ts.Close()
+
+	for {
+		err := ts.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		v, err := ts.Val(constraintCatalogNameField)
+		if err != nil {
+			return false, err
+		}
+
+		if v.AsName().AsGoString() == name {
+			return true, nil
+		}
+	}
+}
+
+// checkKeys returns an ErrConstraintViolation if a record of the table with the values,
+// given in the order of the fields of the schema, would break one of the keys:
+// if a field of the primary key is NULL, or if another record has the same values
+// for all the fields of a key. Keys with a NULL field do not constrain the record.
+// The records with the same value for the first field of a key are found through its index.
+// self is the record being updated, or nil if the record is being inserted.
+func checkKeys(x tx.Transaction, layout Layout, keys []keyConstraint, vals []storage.Value, self *RID) error {
+	schema := layout.schema
+
+	for _, key := range keys {
+		keyVals := make([]storage.Value, len(key.fields))
+		hasNull := false
+		for i, f := range key.fields {
+			keyVals[i] = vals[schema.info[f].Index]
+			hasNull = hasNull || keyVals[i].IsNull()
+		}
+
+		if hasNull {
+			if key.primary {
+				return fmt.Errorf("%w: NULL value in primary key %q", ErrConstraintViolation, key.name)
+			}
+
+			continue
+		}
+
+		dup, err := hasDuplicateKey(x, layout, key, keyVals, self)
+		if err != nil {
+			return err
+		}
+
+		if dup {
+			return fmt.Errorf("%w: duplicate value for key %q", ErrConstraintViolation, key.name)
+		}
+	}
+
+	return nil
+}
+
+// hasDuplicateKey reports whether a record of the table other than self has the values of the key.
+func hasDuplicateKey(x tx.Transaction, layout Layout, key keyConstraint, keyVals []storage.Value, self *RID) (bool, error) {
+	idx, err := NewBTreeIndex(x, key.name, idxLayout(layout.schema, key.fields[0]))
+	if err != nil {
+		return false, err
+	}
+
+	defer idx.Close()
+
+	rids, err := findRecords(x, key.table, layout, idx, key.fields, keyVals)
+	if err != nil {
+		return false, err
+	}
+
+	for _, rid := range rids {
+		if self == nil || rid != *self {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// findRecords returns the records of the table whose fields have the values.
+// If idx is not nil, it is an index over the first of the fields,
+// through which the records are looked up. Otherwise the table is scanned.
+func findRecords(x tx.Transaction, table string, layout Layout, idx Index, fields []string, vals []storage.Value) ([]RID, error) {
+	ts := newTableScan(x, table, layout)
+	defer ts.Close()
+
+	// matches reports whether the current record of the table scan has the values,
+	// starting from the field at position from.
+	matches := func(from int) (bool, error) {
+		for i, f := range fields[from:] {
+			v, err := ts.Val(f)
+			if err != nil {
+				return false, err
+			}
+
+			if !v.Equals(vals[from+i]) {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	}
+
+	var rids []RID
+	if idx == nil {
+		for {
+			err := ts.Next()
+			if err == io.EOF {
+				return rids, nil
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			ok, err := matches(0)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				rids = append(rids, ts.GetRID())
+			}
+		}
+	}
+
+	if err := idx.BeforeFirst(vals[0]); err != nil {
+		return nil, err
+	}
+
+	for {
+		err := idx.Next()
+		if err == io.EOF {
+			return rids, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		rid, err := idx.DataRID()
+		if err != nil {
+			return nil, err
+		}
+
+		ts.MoveToRID(rid)
+
+		ok, err := matches(1)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			rids = append(rids, rid)
+		}
+	}
+}
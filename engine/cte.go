@@ -0,0 +1,476 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/tx"
+)
+
+var (
+	ErrCTEColumns   = errors.New("the columns of a common table expression must be distinct and as many as those of its query")
+	ErrRecursiveCTE = errors.New("a recursive common table expression must be the UNION of a query that does not refer to it and a query that does")
+)
+
+var (
+	_ Plan = &commonTablePlan{}
+	_ Plan = &recursivePlan{}
+	_ Plan = &workingTablePlan{}
+)
+
+// cteScope holds the common table expressions visible to a query:
+// those of its WITH clause and those of the queries it is nested in,
+// which are hidden by the former if they have the same name.
+type cteScope struct {
+	parent *cteScope
+	tables map[string]*commonTable
+}
+
+func newCTEScope(parent *cteScope) *cteScope {
+	return &cteScope{
+		parent: parent,
+		tables: map[string]*commonTable{},
+	}
+}
+
+// lookup returns the common table expression with the given name.
+// A nil scope is the scope of a query without common table expressions.
+func (cs *cteScope) lookup(name string) (*commonTable, bool) {
+	for s := cs; s != nil; s = s.parent {
+		if ct, ok := s.tables[name]; ok {
+			return ct, true
+		}
+	}
+
+	return nil, false
+}
+
+// define plans the common table expression and adds it to the scope.
+// refs is the number of times the rest of the query refers to it.
+// A recursive common table expression is visible to its own query,
+// whose references to it read the working table.
+func (cs *cteScope) define(x tx.Transaction, qp subqueryPlanner, def sql.CommonTableExpression, refs int) error {
+	if _, ok := cs.tables[def.Name]; ok {
+		return ErrDuplicateTable
+	}
+
+	qp = qp.withCTEs(cs)
+	ct := &commonTable{
+		x:    x,
+		refs: refs,
+	}
+
+	if !def.Recursive || cteReferences(def.Query, def.Name) == 0 {
+		p, err := qp.createPlan(def.Query, x, nil)
+		if err != nil {
+			return err
+		}
+
+		ct.plan, err = renameColumns(p, def.Columns)
+		if err != nil {
+			return err
+		}
+
+		cs.tables[def.Name] = ct
+
+		return nil
+	}
+
+	so, ok := def.Query.SetOperation()
+	_, limited := def.Query.Limit()
+	if !ok || so.Op != sql.SetUnion || cteReferences(so.Lhs, def.Name) > 0 ||
+		len(def.Query.CTEs()) > 0 || len(def.Query.OrderBy()) > 0 || limited {
+		return ErrRecursiveCTE
+	}
+
+	p, err := qp.createPlan(so.Lhs, x, nil)
+	if err != nil {
+		return err
+	}
+
+	anchor, err := renameColumns(p, def.Columns)
+	if err != nil {
+		return err
+	}
+
+	ct.working = newWorkingTablePlan(x, anchor)
+	cs.tables[def.Name] = ct
+
+	step, err := qp.createPlan(so.Rhs, x, nil)
+	if err != nil {
+		return err
+	}
+
+	rp, err := newRecursivePlan(x, anchor, step, ct.working, !so.All)
+	if err != nil {
+		return err
+	}
+
+	ct.plan = rp
+	ct.working = nil
+
+	return nil
+}
+
+// planWith plans a query with a WITH clause.
+// Common table expressions are visible to the query, to its subqueries
+// and to the common table expressions that follow them in the clause.
+// Their queries are planned on their own, so they cannot refer to the fields of the outer queries.
+func planWith(x tx.Transaction, qp subqueryPlanner, data sql.Query, scope *subqueryScope) (Plan, error) {
+	defs := data.CTEs()
+	body := data.WithCTEs(nil)
+
+	ctes := newCTEScope(qp.ctes())
+	for i, def := range defs {
+		refs := cteReferences(body, def.Name)
+		for _, next := range defs[i+1:] {
+			refs += cteReferences(next.Query, def.Name)
+		}
+
+		if err := ctes.define(x, qp, def, refs); err != nil {
+			return nil, err
+		}
+	}
+
+	return qp.withCTEs(ctes).createPlan(body, x, scope)
+}
+
+// cteReferences returns how many times the query refers to the table with the given name,
+// in its <FROM> clause and in those of its subqueries, set operations and common table expressions.
+// References to a common table expression of the query with the same name are not counted.
+func cteReferences(data sql.Query, name string) int {
+	var n int
+	for _, def := range data.CTEs() {
+		if def.Name == name {
+			if !def.Recursive {
+				n += cteReferences(def.Query, name)
+			}
+
+			return n
+		}
+
+		n += cteReferences(def.Query, name)
+	}
+
+	if so, ok := data.SetOperation(); ok {
+		return n + cteReferences(so.Lhs, name) + cteReferences(so.Rhs, name)
+	}
+
+	for _, t := range data.Tables() {
+		if t == name {
+			n++
+		}
+	}
+
+	// the binder only visits the subqueries, which are left as they are
+	data.BindSubqueries(func(sq sql.Subquery) (sql.Subquery, error) {
+		n += cteReferences(sq.Query(), name)
+		return sq, nil
+	})
+
+	return n
+}
+
+// renameColumns renames the fields of the plan of a common table expression after its columns.
+// If the common table expression does not name its columns,
+// they are named after the unqualified names of the fields.
+func renameColumns(p Plan, columns []string) (Plan, error) {
+	fields := p.Schema().Fields()
+	if len(columns) == 0 {
+		columns = make([]string, len(fields))
+		for i, f := range fields {
+			columns[i] = unqualified(f)
+		}
+	}
+
+	if len(columns) != len(fields) {
+		return nil, ErrCTEColumns
+	}
+
+	for i, c := range columns {
+		if slices.Contains(columns[:i], c) {
+			return nil, ErrCTEColumns
+		}
+	}
+
+	return newRenamePlan(p, columns)
+}
+
+// commonTable is a common table expression of the query being planned.
+// A common table expression that is referred to only once is inlined:
+// its plan takes the place of the reference, as the plan of a view would.
+// Otherwise, it is materialized into a temporary table the first time it is read,
+// and all the references read the temporary table.
+// Each reference qualifies the columns by its range name,
+// so that a query can refer to the same common table expression more than once.
+type commonTable struct {
+	x    tx.Transaction
+	plan Plan
+	refs int
+	// table holds the records of a materialized common table expression, once read
+	table *tmpTable
+	// working is the working table of a recursive common table expression
+	// while its own query is being planned, and nil otherwise
+	working *workingTablePlan
+}
+
+// reference returns the plan of a reference to the common table expression
+// whose fields are qualified by qualifier.
+func (ct *commonTable) reference(qualifier string) (Plan, error) {
+	var p Plan
+	switch {
+	case ct.working != nil:
+		p = ct.working
+	case ct.refs > 1:
+		p = &commonTablePlan{ct: ct}
+	default:
+		p = ct.plan
+	}
+
+	fields := p.Schema().Fields()
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = qualifier + "." + f
+	}
+
+	return newRenamePlan(p, names)
+}
+
+// materialize writes the records of the common table expression into a temporary table.
+func (ct *commonTable) materialize() (*tmpTable, error) {
+	if rp, ok := ct.plan.(*recursivePlan); ok {
+		return rp.materialize()
+	}
+
+	return newMaterializePlan(ct.x, ct.plan).materialize()
+}
+
+// commonTablePlan reads a materialized common table expression.
+// The common table expression is materialized by the first of its references to be opened.
+type commonTablePlan struct {
+	ct *commonTable
+}
+
+func (cp *commonTablePlan) Open() (Scan, error) {
+	if cp.ct.table == nil {
+		tt, err := cp.ct.materialize()
+		if err != nil {
+			return nil, err
+		}
+
+		cp.ct.table = tt
+	}
+
+	return cp.ct.table.Open(), nil
+}
+
+// BlocksAccessed is the cost of reading the temporary table.
+// Materializing it is a cost shared by all the references.
+func (cp *commonTablePlan) BlocksAccessed() int {
+	return tmpTableBlocks(cp.ct.x, cp.ct.plan)
+}
+
+func (cp *commonTablePlan) RecordsOutput() int {
+	return cp.ct.plan.RecordsOutput()
+}
+
+func (cp *commonTablePlan) DistinctValues(fieldName string) int {
+	return cp.ct.plan.DistinctValues(fieldName)
+}
+
+func (cp *commonTablePlan) Schema() Schema {
+	return cp.ct.plan.Schema()
+}
+
+// recursivePlan computes a recursive common table expression by fixpoint iteration.
+// The records of the anchor query are the first records of the result,
+// and fill the working table. The recursive query, which reads the working table
+// in place of the common table expression, then runs again and again:
+// each time, its records replace those of the working table and are added to the result,
+// until it returns no records.
+// Unless the queries are combined by UNION ALL, records already in the result are discarded,
+// so that the iteration stops once no new records are found.
+type recursivePlan struct {
+	x        tx.Transaction
+	anchor   Plan
+	step     Plan
+	working  *workingTablePlan
+	distinct bool
+}
+
+func newRecursivePlan(x tx.Transaction, anchor Plan, step Plan, working *workingTablePlan, distinct bool) (*recursivePlan, error) {
+	if !anchor.Schema().compatibleWith(step.Schema()) {
+		return nil, ErrIncompatibleSchemas
+	}
+
+	renamed, err := newRenamePlan(step, anchor.Schema().Fields())
+	if err != nil {
+		return nil, err
+	}
+
+	return &recursivePlan{
+		x:        x,
+		anchor:   anchor,
+		step:     renamed,
+		working:  working,
+		distinct: distinct,
+	}, nil
+}
+
+func (rp *recursivePlan) Open() (Scan, error) {
+	tt, err := rp.materialize()
+	if err != nil {
+		return nil, err
+	}
+
+	return tt.Open(), nil
+}
+
+// materialize runs the iteration and returns the temporary table that holds the result.
+func (rp *recursivePlan) materialize() (*tmpTable, error) {
+	result := newTmpTable(rp.x, rp.Schema())
+	dst := result.Open()
+	defer dst.Close()
+
+	seen := map[string]struct{}{}
+	p := rp.anchor
+	for {
+		wt, n, err := rp.iterate(p, dst, seen)
+		if err != nil {
+			return nil, err
+		}
+
+		if n == 0 {
+			return result, nil
+		}
+
+		rp.working.table = wt
+		p = rp.step
+	}
+}
+
+// iterate runs the plan once, and writes its new records into a new working table and into the result.
+// It returns the working table and the number of records written.
+func (rp *recursivePlan) iterate(p Plan, result UpdateScan, seen map[string]struct{}) (*tmpTable, int, error) {
+	schema := rp.Schema()
+
+	src, err := p.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer src.Close()
+
+	wt := newTmpTable(rp.x, schema)
+	dst := wt.Open()
+	defer dst.Close()
+
+	var n int
+	for {
+		err := src.Next()
+		if err == io.EOF {
+			return wt, n, nil
+		}
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		rec, err := copyRecord(&schema, src)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if rp.distinct {
+			key := recordKey(rec)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+
+			seen[key] = struct{}{}
+		}
+
+		if err := insertRecord(dst, rec); err != nil {
+			return nil, 0, err
+		}
+
+		if err := insertRecord(result, rec); err != nil {
+			return nil, 0, err
+		}
+
+		n++
+	}
+}
+
+// recordKey returns a key that is equal for records with equal values.
+// Each value is prefixed by its length, and NULLs by a length that no value has.
+func recordKey(rec memRecord) string {
+	var key []byte
+	for _, v := range rec.vals {
+		if v.IsNull() {
+			key = binary.BigEndian.AppendUint32(key, ^uint32(0))
+			continue
+		}
+
+		key = binary.BigEndian.AppendUint32(key, uint32(len(v)))
+		key = append(key, v...)
+	}
+
+	return string(key)
+}
+
+// BlocksAccessed assumes that the recursive query runs once.
+func (rp *recursivePlan) BlocksAccessed() int {
+	return rp.anchor.BlocksAccessed() + rp.step.BlocksAccessed()
+}
+
+func (rp *recursivePlan) RecordsOutput() int {
+	return rp.anchor.RecordsOutput() + rp.step.RecordsOutput()
+}
+
+func (rp *recursivePlan) DistinctValues(fieldName string) int {
+	return rp.anchor.DistinctValues(fieldName)
+}
+
+func (rp *recursivePlan) Schema() Schema {
+	return rp.anchor.Schema()
+}
+
+// workingTablePlan reads the working table of a recursive common table expression,
+// which holds the records found by the previous iteration.
+// Its estimates are those of the anchor query.
+type workingTablePlan struct {
+	x      tx.Transaction
+	anchor Plan
+	table  *tmpTable
+}
+
+func newWorkingTablePlan(x tx.Transaction, anchor Plan) *workingTablePlan {
+	return &workingTablePlan{
+		x:      x,
+		anchor: anchor,
+	}
+}
+
+func (wp *workingTablePlan) Open() (Scan, error) {
+	return wp.table.Open(), nil
+}
+
+func (wp *workingTablePlan) BlocksAccessed() int {
+	return tmpTableBlocks(wp.x, wp.anchor)
+}
+
+func (wp *workingTablePlan) RecordsOutput() int {
+	return wp.anchor.RecordsOutput()
+}
+
+func (wp *workingTablePlan) DistinctValues(fieldName string) int {
+	return wp.anchor.DistinctValues(fieldName)
+}
+
+func (wp *workingTablePlan) Schema() Schema {
+	return wp.anchor.Schema()
+}
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"slices"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+var (
+	_ Plan = &distinctPlan{}
+	_ Scan = &distinctScan{}
+)
+
+// distinctPlan removes duplicate records from the underlying plan.
+// The records are sorted on all their fields, so that duplicates
+// are next to each other, and only the first record of each run of duplicates is output.
+// Sorting starts from the leading fields, if any, so that the output
+// is also ordered by them. NULLs are not distinct from each other.
+type distinctPlan struct {
+	p      Plan
+	src    Plan
+	fields []string
+}
+
+func newDistinctPlan(x tx.Transaction, p Plan, leading []sql.SortKey) *distinctPlan {
+	keys := slices.Clone(leading)
+	var fields []string
+	for _, k := range leading {
+		fields = append(fields, k.Field)
+	}
+
+	for _, f := range p.Schema().fields {
+		if !slices.Contains(fields, f) {
+			fields = append(fields, f)
+			keys = append(keys, sql.SortKey{Field: f})
+		}
+	}
+
+	return &distinctPlan{
+		p:      newSortPlan(x, p, keys),
+		src:    p,
+		fields: fields,
+	}
+}
+
+func (dp *distinctPlan) Open() (Scan, error) {
+	s, err := dp.p.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	return newDistinctScan(s, dp.fields), nil
+}
+
+func (dp *distinctPlan) BlocksAccessed() int {
+	return dp.p.BlocksAccessed()
+}
+
+// RecordsOutput estimates the number of distinct records
+// as the product of the distinct values of each field.
+func (dp *distinctPlan) RecordsOutput() int {
+	distinct := 1
+	for _, f := range dp.fields {
+		distinct *= dp.src.DistinctValues(f)
+		if distinct >= dp.src.RecordsOutput() {
+			return dp.src.RecordsOutput()
+		}
+	}
+
+	return distinct
+}
+
+func (dp *distinctPlan) DistinctValues(fieldName string) int {
+	return dp.src.DistinctValues(fieldName)
+}
+
+func (dp *distinctPlan) Schema() Schema {
+	return dp.src.Schema()
+}
+
+// distinctScan skips the records of a sorted scan
+// that are equal to the previous one.
+type distinctScan struct {
+	scan   Scan
+	fields []string
+	prev   []storage.Value
+}
+
+func newDistinctScan(scan Scan, fields []string) *distinctScan {
+	return &distinctScan{
+		scan:   scan,
+		fields: fields,
+	}
+}
+
+func (ds *distinctScan) BeforeFirst() error {
+	ds.prev = nil
+	return ds.scan.BeforeFirst()
+}
+
+func (ds *distinctScan) Next() error {
+	for {
+		if err := ds.scan.Next(); err != nil {
+			return err
+		}
+
+		vals := make([]storage.Value, len(ds.fields))
+		for i, f := range ds.fields {
+			v, err := ds.scan.Val(f)
+			if err != nil {
+				return err
+			}
+
+			vals[i] = storage.Copy(v)
+		}
+
+		if ds.prev == nil || !slices.EqualFunc(vals, ds.prev, storage.Value.Equals) {
+			ds.prev = vals
+			return nil
+		}
+	}
+}
+
+func (ds *distinctScan) Val(fieldName string) (storage.Value, error) {
+	return ds.scan.Val(fieldName)
+}
+
+func (ds *distinctScan) HasField(fieldName string) bool {
+	return ds.scan.HasField(fieldName)
+}
+
+func (ds *distinctScan) Type(fieldName string) storage.FieldType {
+	return ds.scan.Type(fieldName)
+}
+
+func (ds *distinctScan) Close() {
+	ds.scan.Close()
+}
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+)
+
+var _ sql.Scan = valuesScan{}
+
+// newFieldConstraints returns the constraints declared by the definition of the field.
+func newFieldConstraints(def sql.FieldDef) fieldConstraints {
+	c := fieldConstraints{
+		NotNull: def.NotNull,
+	}
+
+	if def.Default != nil {
+		c.Default = def.Default.String()
+	}
+
+	if def.Check != nil {
+		c.Check = def.Check.String()
+	}
+
+	return c
+}
+
+// parseCheck returns the check of the field, and false if the field has none.
+func parseCheck(schema Schema, field string) (sql.Predicate, bool, error) {
+	check := schema.info[field].Check
+	if check == "" {
+		return sql.Predicate{}, false, nil
+	}
+
+	pred, err := sql.NewParser(check).Predicate()
+	if err != nil {
+		return sql.Predicate{}, false, err
+	}
+
+	return pred, true, nil
+}
+
+// checkApplies returns ErrNoField if the check of the field
+// refers to a field that the schema does not have.
+func checkApplies(schema Schema, field string) error {
+	pred, ok, err := parseCheck(schema, field)
+	if err != nil || !ok {
+		return err
+	}
+
+	if !pred.AppliesTo(schema) {
+		return ErrNoField
+	}
+
+	return nil
+}
+
+// rowConstraints holds the NOT NULL, DEFAULT and CHECK constraints of the fields of a table,
+// parsed once for all the records written by a statement.
+// Defaults draw the values of sequences from the generator of the statement.
+type rowConstraints struct {
+	schema   Schema
+	defaults map[string]sql.Expression
+	checks   map[string]sql.Predicate
+}
+
+func newRowConstraints(schema Schema, gen sql.SequenceGenerator) (rowConstraints, error) {
+	rc := rowConstraints{
+		schema:   schema,
+		defaults: map[string]sql.Expression{},
+		checks:   map[string]sql.Predicate{},
+	}
+
+	for _, f := range schema.fields {
+		if def := schema.info[f].Default; def != "" {
+			exp, err := sql.NewParser(def).Expression()
+			if err != nil {
+				return rowConstraints{}, err
+			}
+
+			rc.defaults[f] = exp.BindSequences(gen)
+		}
+
+		pred, ok, err := parseCheck(schema, f)
+		if err != nil {
+			return rowConstraints{}, err
+		}
+
+		if ok {
+			rc.checks[f] = pred
+		}
+	}
+
+	return rc, nil
+}
+
+// defaultValue returns the value of the field when an INSERT omits it.
+// Defaults are evaluated for each record, and a field without one is NULL.
+func (rc rowConstraints) defaultValue(field string) (storage.Value, error) {
+	exp, ok := rc.defaults[field]
+	if !ok {
+		return storage.Null, nil
+	}
+
+	return exp.EvaluateAs(nil, rc.schema.ftype(field))
+}
+
+// check returns an ErrConstraintViolation if a record with the values,
+// given in the order of the fields of the schema, has a NULL in a NOT NULL field
+// or makes the check of a field false.
+// As in the <WHERE> clause, a check involving NULLs can be unknown,
+// and only a false check rejects the record.
+func (rc rowConstraints) check(vals []storage.Value) error {
+	row := valuesScan{schema: rc.schema, vals: vals}
+	for i, f := range rc.schema.fields {
+		if rc.schema.info[f].NotNull && vals[i].IsNull() {
+			return fmt.Errorf("%w: NULL value in NOT NULL field %q", ErrConstraintViolation, f)
+		}
+
+		pred, ok := rc.checks[f]
+		if !ok {
+			continue
+		}
+
+		failed, err := pred.IsFalse(row)
+		if err != nil {
+			return err
+		}
+
+		if failed {
+			return fmt.Errorf("%w: check of field %q failed: %s", ErrConstraintViolation, f, pred)
+		}
+	}
+
+	return nil
+}
+
+// valuesScan is a scan positioned on a single record whose values are held in memory,
+// given in the order of the fields of the schema.
+// It lets the constraints of a table be evaluated on a record before it is written.
+type valuesScan struct {
+	schema Schema
+	vals   []storage.Value
+}
+
+func (vs valuesScan) Val(fieldName string) (storage.Value, error) {
+	if !vs.schema.HasField(fieldName) {
+		return nil, ErrNoField
+	}
+
+	return vs.vals[vs.schema.info[fieldName].Index], nil
+}
+
+func (vs valuesScan) Type(fieldName string) storage.FieldType {
+	return vs.schema.ftype(fieldName)
+}
@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/luigitni/simpledb/sql"
+)
+
+var (
+	ErrAmbiguousField = errors.New("ambiguous field")
+	ErrDuplicateTable = errors.New("table name specified more than once")
+)
+
+// newFieldResolver returns a resolver for the fields of a query over the tables in refs,
+// whose plans have the given schemas.
+// A qualified field, as in table.field, refers to the field of the table
+// with that name or alias. An unqualified field refers to the only table that has it:
+// if more than one table has a field with that name, the field is ambiguous.
+// Fields are resolved to their names in the schemas: the fields of stored tables
+// are qualified, while the fields of views are not.
+func newFieldResolver(refs []sql.TableRef, schemas []Schema) (sql.FieldResolver, error) {
+	seen := map[string]struct{}{}
+	for _, ref := range refs {
+		if _, ok := seen[ref.RangeName()]; ok {
+			return nil, ErrDuplicateTable
+		}
+
+		seen[ref.RangeName()] = struct{}{}
+	}
+
+	// lookup returns the name of the field in the schema of the i-th table
+	lookup := func(i int, field string) (string, bool) {
+		if qf := refs[i].RangeName() + "." + field; schemas[i].HasField(qf) {
+			return qf, true
+		}
+
+		return field, schemas[i].HasField(field)
+	}
+
+	return func(field string) (string, error) {
+		if qualifier, f, ok := strings.Cut(field, "."); ok {
+			for i, ref := range refs {
+				if ref.RangeName() != qualifier {
+					continue
+				}
+
+				if name, ok := lookup(i, f); ok {
+					return name, nil
+				}
+			}
+
+			return "", ErrNoField
+		}
+
+		var resolved []string
+		for i := range refs {
+			if name, ok := lookup(i, field); ok {
+				resolved = append(resolved, name)
+			}
+		}
+
+		switch len(resolved) {
+		case 0:
+			return "", ErrNoField
+		case 1:
+			return resolved[0], nil
+		}
+
+		return "", ErrAmbiguousField
+	}, nil
+}
@@ -0,0 +1,293 @@
+package engine
+
+import (
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+const (
+	fkCatalogTableName     = "foreign_keys"
+	fkCatalogNameField     = "name"
+	fkCatalogTableField    = "table_name"
+	fkCatalogFieldField    = "field_name"
+	fkCatalogRefTableField = "ref_table"
+	fkCatalogRefFieldField = "ref_field"
+	fkCatalogOnDeleteField = "on_delete"
+	fkCatalogPositionField = "position"
+
+	sizeOfFKCatalogRecord = storage.SizeOfName + // foreign key name
+		storage.SizeOfName + // table name
+		storage.SizeOfName + // field name
+		storage.SizeOfName + // referenced table name
+		storage.SizeOfName + // referenced field name
+		storage.SizeOfSmallInt + // action on delete
+		storage.SizeOfSmallInt // position of the field within the foreign key
+)
+
+// foreignKey is a FOREIGN KEY constraint, which makes the fields of a table
+// refer to the fields of a key of the referenced table.
+// The field at each position refers to the referenced field at the same position.
+type foreignKey struct {
+	name      string
+	table     string
+	fields    []string
+	refTable  string
+	refFields []string
+	onDelete  sql.ReferentialAction
+}
+
+// foreignKeyName returns the name of a foreign key over the fields of the table.
+func foreignKeyName(table string, fields []string) string {
+	return table + "_" + strings.Join(fields, "_") + "_fkey"
+}
+
+// foreignKeyManager stores the foreign keys of the tables in the foreign key catalog.
+// Each field of a foreign key is stored as a record of the catalog,
+// together with the field it refers to and its position within the foreign key.
+type foreignKeyManager struct {
+	l  Layout
+	tm *tableManager
+}
+
+func fkCatalogSchema() Schema {
+	schema := newSchema()
+	schema.addField(fkCatalogNameField, storage.NAME)
+	schema.addField(fkCatalogTableField, storage.NAME)
+	schema.addField(fkCatalogFieldField, storage.NAME)
+	schema.addField(fkCatalogRefTableField, storage.NAME)
+	schema.addField(fkCatalogRefFieldField, storage.NAME)
+	schema.addField(fkCatalogOnDeleteField, storage.SMALLINT)
+	schema.addField(fkCatalogPositionField, storage.SMALLINT)
+	return schema
+}
+
+func newForeignKeyManager(tm *tableManager) *foreignKeyManager {
+	return &foreignKeyManager{
+		l:  NewLayout(fkCatalogSchema()),
+		tm: tm,
+	}
+}
+
+func (fm foreignKeyManager) init(x tx.Transaction) error {
+	return fm.tm.createTable(fkCatalogTableName, fm.l.schema, x)
+}
+
+// createForeignKey stores the foreign key into the catalog.
+func (fm *foreignKeyManager) createForeignKey(x tx.Transaction, fk foreignKey) error {
+	ts := newTableScan(x, fkCatalogTableName, fm.l)
+	defer ts.Close()
+
+	for i, f := range fk.fields {
+		if err := ts.Insert(sizeOfFKCatalogRecord); err != nil {
+			return err
+		}
+
+		for field, name := range map[string]string{
+			fkCatalogNameField:     fk.name,
+			fkCatalogTableField:    fk.table,
+			fkCatalogFieldField:    f,
+			fkCatalogRefTableField: fk.refTable,
+			fkCatalogRefFieldField: fk.refFields[i],
+		} {
+			if err := ts.SetVal(field, storage.ValueFromName(storage.NewNameFromGoString(name))); err != nil {
+				return err
+			}
+		}
+
+		if err := ts.SetVal(
+			fkCatalogOnDeleteField,
+			storage.ValueFromInteger[storage.SmallInt](storage.SizeOfSmallInt, storage.SmallInt(fk.onDelete)),
+		); err != nil {
+			return err
+		}
+
+		if err := ts.SetVal(
+			fkCatalogPositionField,
+			storage.ValueFromInteger[storage.SmallInt](storage.SizeOfSmallInt, storage.SmallInt(i)),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// foreignKeys returns the foreign keys of the table, ordered by name.
+func (fm *foreignKeyManager) foreignKeys(x tx.Transaction, tblName string) ([]foreignKey, error) {
+	return fm.loadForeignKeys(x, fkCatalogTableField, tblName)
+}
+
+// referencingKeys returns the foreign keys that refer to the table, ordered by name.
+func (fm *foreignKeyManager) referencingKeys(x tx.Transaction, tblName string) ([]foreignKey, error) {
+	return fm.loadForeignKeys(x, fkCatalogRefTableField, tblName)
+}
+
+// foreignKeyExists reports whether a foreign key is named name.
+func (fm *foreignKeyManager) foreignKeyExists(x tx.Transaction, name string) (bool, error) {
+	fks, err := fm.loadForeignKeys(x, fkCatalogNameField, name)
+	return len(fks) > 0, err
+}
+
+// loadForeignKeys returns the foreign keys whose catalog records hold the given name in field.
+func (fm *foreignKeyManager) loadForeignKeys(x tx.Transaction, field string, name string) ([]foreignKey, error) {
+	ts := newTableScan(x, fkCatalogTableName, fm.l)
+	defer ts.Close()
+
+	byName := map[string]*foreignKey{}
+	positions := map[string][]storage.SmallInt{}
+	for {
+		err := ts.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := ts.Val(field)
+		if err != nil {
+			return nil, err
+		}
+
+		if v.AsName().AsGoString() != name {
+			continue
+		}
+
+		names := map[string]string{}
+		for _, f := range []string{fkCatalogNameField, fkCatalogTableField, fkCatalogFieldField, fkCatalogRefTableField, fkCatalogRefFieldField} {
+			v, err := ts.Val(f)
+			if err != nil {
+				return nil, err
+			}
+
+			// the names are kept in the foreign key, so they are cloned out of the buffer of the catalog
+			names[f] = strings.Clone(v.AsName().AsGoString())
+		}
+
+		onDelete, err := ts.Val(fkCatalogOnDeleteField)
+		if err != nil {
+			return nil, err
+		}
+
+		pos, err := ts.Val(fkCatalogPositionField)
+		if err != nil {
+			return nil, err
+		}
+
+		n := names[fkCatalogNameField]
+		fk, ok := byName[n]
+		if !ok {
+			fk = &foreignKey{
+				name:     n,
+				table:    names[fkCatalogTableField],
+				refTable: names[fkCatalogRefTableField],
+				onDelete: sql.ReferentialAction(storage.ValueAsInteger[storage.SmallInt](onDelete)),
+			}
+
+			byName[n] = fk
+		}
+
+		// the fields are kept sorted by their position within the foreign key
+		p := storage.ValueAsInteger[storage.SmallInt](pos)
+		i, _ := slices.BinarySearch(positions[n], p)
+		positions[n] = slices.Insert(positions[n], i, p)
+		fk.fields = slices.Insert(fk.fields, i, names[fkCatalogFieldField])
+		fk.refFields = slices.Insert(fk.refFields, i, names[fkCatalogRefFieldField])
+	}
+
+	fks := make([]foreignKey, 0, len(byName))
+	for _, fk := range byName {
+		fks = append(fks, *fk)
+	}
+
+	slices.SortFunc(fks, func(a, b foreignKey) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	return fks, nil
+}
+
+// dropForeignKeys removes from the catalog the foreign keys of the table,
+// or only those that include fldName if it is not empty, and returns them.
+func (fm *foreignKeyManager) dropForeignKeys(x tx.Transaction, tblName string, fldName string) ([]foreignKey, error) {
+	fks, err := fm.foreignKeys(x, tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	var dropped []foreignKey
+	for _, fk := range fks {
+		if fldName != "" && !slices.Contains(fk.fields, fldName) {
+			continue
+		}
+
+		if _, err := deleteCatalogRecords(x, fkCatalogTableName, fm.l, fkCatalogNameField, fk.name, nil); err != nil {
+			return nil, err
+		}
+
+		dropped = append(dropped, fk)
+	}
+
+	return dropped, nil
+}
+
+// renameForeignKeys moves the foreign keys of the table, and those that refer to it, to the table newTblName.
+// fields maps the names of the renamed fields to their new names.
+func (fm *foreignKeyManager) renameForeignKeys(x tx.Transaction, tblName string, newTblName string, fields map[string]string) error {
+	ts := newTableScan(x, fkCatalogTableName, fm.l)
+	defer ts.Close()
+
+	// rename sets the table held by tableField to newTblName,
+	// and renames the field held by fieldField, if the record is about the table.
+	rename := func(tableField string, fieldField string) error {
+		table, err := ts.Val(tableField)
+		if err != nil {
+			return err
+		}
+
+		if table.AsName().AsGoString() != tblName {
+			return nil
+		}
+
+		if err := ts.SetVal(tableField, storage.ValueFromName(storage.NewNameFromGoString(newTblName))); err != nil {
+			return err
+		}
+
+		field, err := ts.Val(fieldField)
+		if err != nil {
+			return err
+		}
+
+		newName, ok := fields[field.AsName().AsGoString()]
+		if !ok {
+			return nil
+		}
+
+		return ts.SetVal(fieldField, storage.ValueFromName(storage.NewNameFromGoString(newName)))
+	}
+
+	for {
+		err := ts.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := rename(fkCatalogTableField, fkCatalogFieldField); err != nil {
+			return err
+		}
+
+		if err := rename(fkCatalogRefTableField, fkCatalogRefFieldField); err != nil {
+			return err
+		}
+	}
+}
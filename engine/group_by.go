@@ -0,0 +1,295 @@
+package engine
+
+import (
+	"io"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+var (
+	_ Plan = &groupByPlan{}
+	_ Scan = &groupByScan{}
+)
+
+// groupByPlan groups the records of the underlying plan by the values
+// of the group fields and computes the aggregates over each group.
+// The underlying plan is sorted on the group fields first, so that
+// the records of a group are next to each other and each group
+// can be aggregated in a single pass.
+// If there are no group fields, all the records form a single group.
+//
+// The output of the plan has a field for each group field,
+// and a field for each aggregate, named after the aggregate expression.
+type groupByPlan struct {
+	p           Plan
+	groupFields []string
+	aggregates  []sql.Expression
+	schema      Schema
+}
+
+func newGroupByPlan(x tx.Transaction, p Plan, groupFields []string, aggregates []sql.Expression) (*groupByPlan, error) {
+	input := p.Schema()
+	schema := newSchema()
+
+	for _, f := range groupFields {
+		if !input.HasField(f) {
+			return nil, ErrNoField
+		}
+
+		schema.add(f, input)
+	}
+
+	for _, agg := range aggregates {
+		if operand, ok := agg.AggregateOperand(); ok && !operand.AppliesTo(input) {
+			return nil, ErrNoField
+		}
+
+		t, err := agg.AggregateType(input)
+		if err != nil {
+			return nil, err
+		}
+
+		schema.addField(agg.String(), t)
+	}
+
+	if len(groupFields) > 0 {
+		p = newSortPlan(x, p, ascending(groupFields))
+	}
+
+	return &groupByPlan{
+		p:           p,
+		groupFields: groupFields,
+		aggregates:  aggregates,
+		schema:      schema,
+	}, nil
+}
+
+func (gp *groupByPlan) Open() (Scan, error) {
+	input := gp.p.Schema()
+
+	fns := make([]aggregationFn, len(gp.aggregates))
+	for i, agg := range gp.aggregates {
+		fn, err := newAggregationFn(agg, input)
+		if err != nil {
+			return nil, err
+		}
+
+		fns[i] = fn
+	}
+
+	s, err := gp.p.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	return newGroupByScan(s, gp.groupFields, gp.aggregates, fns, gp.schema)
+}
+
+func (gp *groupByPlan) BlocksAccessed() int {
+	return gp.p.BlocksAccessed()
+}
+
+// RecordsOutput estimates the number of groups as the product
+// of the distinct values of the group fields.
+func (gp *groupByPlan) RecordsOutput() int {
+	groups := 1
+	for _, f := range gp.groupFields {
+		groups *= gp.p.DistinctValues(f)
+	}
+
+	return max(1, min(groups, gp.p.RecordsOutput()))
+}
+
+func (gp *groupByPlan) DistinctValues(fieldName string) int {
+	for _, f := range gp.groupFields {
+		if f == fieldName {
+			return gp.p.DistinctValues(fieldName)
+		}
+	}
+
+	return gp.RecordsOutput()
+}
+
+func (gp *groupByPlan) Schema() Schema {
+	return gp.schema
+}
+
+// groupByScan iterates over the groups of a scan sorted on the group fields.
+// Each call to Next reads the records of the next group
+// and computes its aggregates.
+type groupByScan struct {
+	scan        Scan
+	groupFields []string
+	groupVals   map[string]storage.Value
+	aggregates  map[string]aggregationFn
+	schema      Schema
+	// moreGroups is true if the underlying scan
+	// is positioned on the first record of the next group.
+	moreGroups bool
+	// emitted is true if at least a group has been read.
+	emitted bool
+}
+
+func newGroupByScan(
+	scan Scan,
+	groupFields []string,
+	aggregates []sql.Expression,
+	fns []aggregationFn,
+	schema Schema,
+) (*groupByScan, error) {
+	gs := &groupByScan{
+		scan:        scan,
+		groupFields: groupFields,
+		groupVals:   make(map[string]storage.Value, len(groupFields)),
+		aggregates:  make(map[string]aggregationFn, len(aggregates)),
+		schema:      schema,
+	}
+
+	for i, agg := range aggregates {
+		gs.aggregates[agg.String()] = fns[i]
+	}
+
+	if err := gs.BeforeFirst(); err != nil {
+		return nil, err
+	}
+
+	return gs, nil
+}
+
+func (gs *groupByScan) BeforeFirst() error {
+	if err := gs.scan.BeforeFirst(); err != nil {
+		return err
+	}
+
+	more, err := hasNextOrError(gs.scan)
+	if err != nil {
+		return err
+	}
+
+	gs.moreGroups = more
+	gs.emitted = false
+
+	return nil
+}
+
+// Next reads the next group of records.
+// A scan without group fields always outputs exactly one group,
+// even if the underlying scan is empty.
+func (gs *groupByScan) Next() error {
+	for _, fn := range gs.aggregates {
+		fn.reset()
+	}
+
+	if !gs.moreGroups {
+		if len(gs.groupFields) == 0 && !gs.emitted {
+			gs.emitted = true
+			return nil
+		}
+
+		return io.EOF
+	}
+
+	gs.emitted = true
+
+	for _, f := range gs.groupFields {
+		v, err := gs.scan.Val(f)
+		if err != nil {
+			return err
+		}
+
+		gs.groupVals[f] = storage.Copy(v)
+	}
+
+	for {
+		for _, fn := range gs.aggregates {
+			if err := fn.process(gs.scan); err != nil {
+				return err
+			}
+		}
+
+		more, err := hasNextOrError(gs.scan)
+		if err != nil {
+			return err
+		}
+
+		if !more {
+			gs.moreGroups = false
+			return nil
+		}
+
+		same, err := gs.isSameGroup()
+		if err != nil {
+			return err
+		}
+
+		if !same {
+			return nil
+		}
+	}
+}
+
+// isSameGroup returns true if the current record of the underlying scan
+// belongs to the current group.
+// NULLs are grouped together.
+func (gs *groupByScan) isSameGroup() (bool, error) {
+	for _, f := range gs.groupFields {
+		v, err := gs.scan.Val(f)
+		if err != nil {
+			return false, err
+		}
+
+		if !v.Equals(gs.groupVals[f]) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (gs *groupByScan) Val(fieldName string) (storage.Value, error) {
+	if v, ok := gs.groupVals[fieldName]; ok {
+		return v, nil
+	}
+
+	fn, ok := gs.aggregates[fieldName]
+	if !ok {
+		return storage.Value{}, ErrNoField
+	}
+
+	return fn.value(), nil
+}
+
+func (gs *groupByScan) HasField(fieldName string) bool {
+	return gs.schema.HasField(fieldName)
+}
+
+func (gs *groupByScan) Type(fieldName string) storage.FieldType {
+	return gs.schema.Type(fieldName)
+}
+
+func (gs *groupByScan) Close() {
+	gs.scan.Close()
+}
+
+// groupQuery adds the grouping of the query on top of p,
+// followed by the selection of the HAVING clause.
+// If the query is not grouped, p is returned as is.
+func groupQuery(x tx.Transaction, p Plan, data sql.Query) (Plan, error) {
+	if !data.IsGrouped() {
+		return p, nil
+	}
+
+	gp, err := newGroupByPlan(x, p, data.GroupByFields(), data.Aggregates())
+	if err != nil {
+		return nil, err
+	}
+
+	having := data.Having()
+	if !having.AppliesTo(gp.Schema()) {
+		return nil, ErrNoField
+	}
+
+	return newSelectPlan(gp, having), nil
+}
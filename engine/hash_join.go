@@ -0,0 +1,609 @@
+package engine
+
+import (
+	"hash/fnv"
+	"io"
+	"slices"
+
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+var (
+	_ Plan = &hashJoinPlan{}
+	_ Scan = &hashJoinScan{}
+)
+
+// hashJoinMemoryBudget is the number of bytes of the build input
+// that a hash join keeps in memory before spilling to temporary tables.
+const hashJoinMemoryBudget = 16 * storage.PageSize
+
+// hashJoinMaxLevel is the number of times a partition is split again
+// when it does not fit in the memory budget.
+// Past it, the records of the partition share too few join values to be split,
+// and the partition is joined one budget of records at a time.
+const hashJoinMaxLevel = 3
+
+// hashJoinPlan joins two plans on the equality of a field of each.
+// The smaller input, the build side, is loaded into a hash table
+// keyed by its join field, and the records of the other input,
+// the probe side, are matched by looking up their join field in the table.
+// If the build side does not fit in the memory budget,
+// both inputs are partitioned by the hash of their join field into temporary tables,
+// so that matching records end up in partitions with the same number,
+// and each pair of partitions is joined in turn.
+// A build partition that still does not fit is partitioned again, together with its probe partition.
+// NULL join fields equal no value, so their records are discarded.
+type hashJoinPlan struct {
+	x      tx.Transaction
+	p1     Plan
+	p2     Plan
+	field1 string
+	field2 string
+	budget int
+	schema Schema
+}
+
+func newHashJoinPlan(x tx.Transaction, p1 Plan, p2 Plan, field1 string, field2 string) *hashJoinPlan {
+	return &hashJoinPlan{
+		x:      x,
+		p1:     p1,
+		p2:     p2,
+		field1: field1,
+		field2: field2,
+		budget: hashJoinMemoryBudget,
+		schema: newJoinedSchema(p1.Schema(), p2.Schema()),
+	}
+}
+
+// build returns the build side, with its join field,
+// followed by the probe side and its join field.
+func (hp *hashJoinPlan) build() (Plan, string, Plan, string) {
+	if hp.p2.RecordsOutput() < hp.p1.RecordsOutput() {
+		return hp.p2, hp.field2, hp.p1, hp.field1
+	}
+
+	return hp.p1, hp.field1, hp.p2, hp.field2
+}
+
+func (hp *hashJoinPlan) Open() (Scan, error) {
+	build, buildField, probe, probeField := hp.build()
+
+	src, err := build.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer src.Close()
+
+	schema := build.Schema()
+	table := hashTable{}
+	var records, size int
+	for size <= hp.budget {
+		err := src.Next()
+		if err == io.EOF {
+			ps, err := probe.Open()
+			if err != nil {
+				return nil, err
+			}
+
+			return newHashJoinScan(schema, table, ps, probeField), nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		rec, err := copyRecord(&schema, src)
+		if err != nil {
+			return nil, err
+		}
+
+		records++
+		size += rec.size()
+		table.add(rec, buildField)
+	}
+
+	// estimate how many partitions of the build side fit in the budget
+	// from the average size of the records read so far
+	n := partitions(build.RecordsOutput()*size/records, hp.budget)
+
+	buildParts, err := partitionRecords(hp.x, schema, table, src, buildField, n, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ps, err := probe.Open()
+	if err != nil {
+		dropAll(buildParts)
+		return nil, err
+	}
+
+	defer ps.Close()
+
+	probeSchema := probe.Schema()
+	probeParts, err := partitionRecords(hp.x, probeSchema, nil, ps, probeField, n, 0)
+	if err != nil {
+		dropAll(buildParts)
+		return nil, err
+	}
+
+	scan := &hashJoinScan{
+		x:           hp.x,
+		budget:      hp.budget,
+		buildSchema: schema,
+		buildField:  buildField,
+		probeSchema: probeSchema,
+		probeField:  probeField,
+	}
+
+	for i := range buildParts {
+		scan.parts = append(scan.parts, hashPartition{build: buildParts[i], probe: probeParts[i]})
+	}
+
+	if err := scan.BeforeFirst(); err != nil {
+		scan.Close()
+		return nil, err
+	}
+
+	return scan, nil
+}
+
+// partitions returns the number of partitions that records taking size bytes are written to,
+// so that each of them is expected to fit in the budget.
+func partitions(size int, budget int) int {
+	return max(2, 2*(size/budget+1))
+}
+
+// partitionRecords writes the records of the hash table, if any,
+// followed by the remaining records of the scan, into n new partitions.
+// The level is the number of times the records have already been partitioned,
+// and changes the hash the partition of a record is picked by,
+// so that the records of a partition are spread when it is partitioned again.
+func partitionRecords(x tx.Transaction, schema Schema, table hashTable, src Scan, field string, n int, level int) ([]*tmpTable, error) {
+	parts := make([]*tmpTable, n)
+	dsts := make([]UpdateScan, n)
+	for i := range parts {
+		parts[i] = newTmpTable(x, schema)
+		dsts[i] = parts[i].Open()
+	}
+
+	defer closeAll(dsts)
+
+	for _, recs := range table {
+		for _, rec := range recs {
+			if err := writePartition(dsts, rec, field, level); err != nil {
+				dropAll(parts)
+				return nil, err
+			}
+		}
+	}
+
+	if err := partitionScan(dsts, &schema, src, field, level); err != nil {
+		dropAll(parts)
+		return nil, err
+	}
+
+	return parts, nil
+}
+
+// fitsInMemory returns true if the build side is estimated
+// to fit in the memory budget.
+func (hp *hashJoinPlan) fitsInMemory() bool {
+	build, _, _, _ := hp.build()
+	return build.RecordsOutput()*estimatedRecordSize(build.Schema()) <= hp.budget
+}
+
+// BlocksAccessed is the cost of reading both inputs.
+// If the build side does not fit in memory,
+// the partitions are also written and read back.
+func (hp *hashJoinPlan) BlocksAccessed() int {
+	blocks := hp.p1.BlocksAccessed() + hp.p2.BlocksAccessed()
+	if hp.fitsInMemory() {
+		return blocks
+	}
+
+	return blocks + 2*(tmpTableBlocks(hp.x, hp.p1)+tmpTableBlocks(hp.x, hp.p2))
+}
+
+func (hp *hashJoinPlan) RecordsOutput() int {
+	d := max(hp.p1.DistinctValues(hp.field1), hp.p2.DistinctValues(hp.field2))
+	return hp.p1.RecordsOutput() * hp.p2.RecordsOutput() / max(1, d)
+}
+
+func (hp *hashJoinPlan) DistinctValues(fieldName string) int {
+	if hp.p1.Schema().HasField(fieldName) {
+		return hp.p1.DistinctValues(fieldName)
+	}
+
+	return hp.p2.DistinctValues(fieldName)
+}
+
+func (hp *hashJoinPlan) Schema() Schema {
+	return hp.schema
+}
+
+// hashTable holds the records of the build side of a hash join,
+// keyed by the value of their join field.
+type hashTable map[string][]memRecord
+
+func (t hashTable) add(rec memRecord, field string) {
+	key, _ := rec.Val(field)
+	if key.IsNull() {
+		return
+	}
+
+	t[string(key)] = append(t[string(key)], rec)
+}
+
+// copyRecord copies the current record of the scan into memory.
+func copyRecord(schema *Schema, src Scan) (memRecord, error) {
+	rec := memRecord{
+		schema: schema,
+		vals:   make([]storage.Value, len(schema.fields)),
+	}
+
+	for _, f := range schema.fields {
+		v, err := src.Val(f)
+		if err != nil {
+			return memRecord{}, err
+		}
+
+		rec.vals[schema.info[f].Index] = storage.Copy(v)
+	}
+
+	return rec, nil
+}
+
+// size returns the number of bytes of the values of the record.
+func (r memRecord) size() int {
+	var size int
+	for _, v := range r.vals {
+		size += len(v)
+	}
+
+	return size
+}
+
+// partitionScan writes the records of the scan into the partitions.
+func partitionScan(dsts []UpdateScan, schema *Schema, src Scan, field string, level int) error {
+	for {
+		err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		rec, err := copyRecord(schema, src)
+		if err != nil {
+			return err
+		}
+
+		if err := writePartition(dsts, rec, field, level); err != nil {
+			return err
+		}
+	}
+}
+
+// writePartition inserts the record into the partition
+// picked by the hash of its join field and of the level of the partitions.
+// Records with a NULL join field match no record, so they are not written.
+func writePartition(dsts []UpdateScan, rec memRecord, field string, level int) error {
+	key, err := rec.Val(field)
+	if err != nil {
+		return err
+	}
+
+	if key.IsNull() {
+		return nil
+	}
+
+	h := fnv.New32a()
+	h.Write(key)
+	h.Write([]byte{byte(level)})
+	dst := dsts[h.Sum32()%uint32(len(dsts))]
+
+	return insertRecord(dst, rec)
+}
+
+// insertRecord inserts a copy of the record into the scan.
+func insertRecord(dst UpdateScan, rec memRecord) error {
+	schema := rec.schema
+	var size storage.Offset
+	for _, f := range schema.fields {
+		size += rec.vals[schema.info[f].Index].Size(schema.ftype(f))
+	}
+
+	if err := dst.Insert(size); err != nil {
+		return err
+	}
+
+	for _, f := range schema.fields {
+		if err := dst.SetVal(f, rec.vals[schema.info[f].Index]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func closeAll(scans []UpdateScan) {
+	for _, s := range scans {
+		s.Close()
+	}
+}
+
+// dropAll removes the files of the tables when the transaction commits.
+// The tables are temporary and used by this transaction only,
+// so the lock on their files is always granted.
+func dropAll(tables []*tmpTable) {
+	for _, t := range tables {
+		t.drop()
+	}
+}
+
+// hashPartition is a pair of partitions of the inputs of a hash join
+// with the same number, whose records are joined with each other only.
+type hashPartition struct {
+	build *tmpTable
+	probe *tmpTable
+	// level is the number of times the records of the partition
+	// were partitioned before being written to it.
+	level int
+}
+
+// hashJoinScan outputs each record of the probe scan
+// once for every record of the hash table with the same join field.
+// If the inputs were partitioned, the scan joins
+// each build partition with the probe partition of the same number, in turn.
+type hashJoinScan struct {
+	x           tx.Transaction
+	budget      int
+	buildSchema Schema
+	buildField  string
+	table       hashTable
+	probe       Scan
+	probeSchema Schema
+	probeField  string
+	// matches are the build records that match the current probe record
+	matches []memRecord
+	current int
+	// parts are the partitions of the inputs,
+	// empty if the build side fits in memory.
+	parts []hashPartition
+	part  int
+	// build is the scan of the current build partition if it does not fit in memory
+	// even after being partitioned again: its records are loaded a budget at a time,
+	// and each time the probe partition is joined with them from its first record.
+	// It is nil once the last records of the partition are loaded.
+	build UpdateScan
+}
+
+func newHashJoinScan(buildSchema Schema, table hashTable, probe Scan, probeField string) *hashJoinScan {
+	return &hashJoinScan{
+		buildSchema: buildSchema,
+		table:       table,
+		probe:       probe,
+		probeField:  probeField,
+	}
+}
+
+func (hs *hashJoinScan) BeforeFirst() error {
+	hs.matches = nil
+	hs.current = 0
+
+	if len(hs.parts) == 0 {
+		return hs.probe.BeforeFirst()
+	}
+
+	return hs.loadPartition(0)
+}
+
+// loadPartition loads the i-th build partition into the hash table
+// and opens the i-th probe partition.
+// If the build partition does not fit in the memory budget, both partitions are replaced
+// by the partitions their records are written to once more.
+// Past hashJoinMaxLevel, the build partition is loaded a budget at a time instead.
+func (hs *hashJoinScan) loadPartition(i int) error {
+	hs.closeInputs()
+
+	hs.part = i
+	part := hs.parts[i]
+
+	src := part.build.Open()
+
+	fits, err := hs.loadTable(src)
+	if err != nil {
+		src.Close()
+		return err
+	}
+
+	if fits {
+		src.Close()
+	} else if part.level < hashJoinMaxLevel {
+		err := hs.repartition(i, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+
+		return hs.loadPartition(i)
+	} else {
+		hs.build = src
+	}
+
+	hs.probe = part.probe.Open()
+
+	return nil
+}
+
+// loadTable loads the records of the scan into the hash table,
+// until the end of the scan or until they take more than the memory budget.
+// It returns true if the end of the scan was reached.
+func (hs *hashJoinScan) loadTable(src Scan) (bool, error) {
+	hs.table = hashTable{}
+
+	var size int
+	for size <= hs.budget {
+		err := src.Next()
+		if err == io.EOF {
+			return true, nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		rec, err := copyRecord(&hs.buildSchema, src)
+		if err != nil {
+			return false, err
+		}
+
+		size += rec.size()
+		hs.table.add(rec, hs.buildField)
+	}
+
+	return false, nil
+}
+
+// repartition writes the records of the i-th build partition,
+// of which those in the hash table have already been read from src,
+// and those of the i-th probe partition into new partitions, which replace them.
+func (hs *hashJoinScan) repartition(i int, src Scan) error {
+	part := hs.parts[i]
+
+	blocks, err := hs.x.Size(part.build.fileName())
+	if err != nil {
+		return err
+	}
+
+	n := partitions(int(blocks)*int(hs.x.BlockSize()), hs.budget)
+	level := part.level + 1
+
+	buildParts, err := partitionRecords(hs.x, hs.buildSchema, hs.table, src, hs.buildField, n, level)
+	if err != nil {
+		return err
+	}
+
+	hs.table = nil
+
+	ps := part.probe.Open()
+	defer ps.Close()
+
+	probeParts, err := partitionRecords(hs.x, hs.probeSchema, nil, ps, hs.probeField, n, level)
+	if err != nil {
+		dropAll(buildParts)
+		return err
+	}
+
+	parts := make([]hashPartition, n)
+	for j := range parts {
+		parts[j] = hashPartition{build: buildParts[j], probe: probeParts[j], level: level}
+	}
+
+	hs.parts = slices.Insert(slices.Delete(hs.parts, i, i+1), i, parts...)
+	dropAll([]*tmpTable{part.build, part.probe})
+
+	return nil
+}
+
+// closeInputs closes the scans of the current partitions, if any.
+func (hs *hashJoinScan) closeInputs() {
+	if hs.probe != nil {
+		hs.probe.Close()
+		hs.probe = nil
+	}
+
+	if hs.build != nil {
+		hs.build.Close()
+		hs.build = nil
+	}
+}
+
+func (hs *hashJoinScan) Next() error {
+	for {
+		if hs.current+1 < len(hs.matches) {
+			hs.current++
+			return nil
+		}
+
+		err := hs.probe.Next()
+		if err == io.EOF && hs.build != nil {
+			// the probe partition is joined again with the next records of the build partition
+			fits, err := hs.loadTable(hs.build)
+			if err != nil {
+				return err
+			}
+
+			if fits {
+				hs.build.Close()
+				hs.build = nil
+			}
+
+			if err := hs.probe.BeforeFirst(); err != nil {
+				return err
+			}
+
+			hs.matches = nil
+			continue
+		}
+
+		if err == io.EOF && hs.part+1 < len(hs.parts) {
+			if err := hs.loadPartition(hs.part + 1); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		key, err := hs.probe.Val(hs.probeField)
+		if err != nil {
+			return err
+		}
+
+		hs.matches = nil
+		if !key.IsNull() {
+			hs.matches = hs.table[string(key)]
+		}
+
+		hs.current = -1
+	}
+}
+
+func (hs *hashJoinScan) Val(fieldName string) (storage.Value, error) {
+	if hs.buildSchema.HasField(fieldName) {
+		return hs.matches[hs.current].Val(fieldName)
+	}
+
+	return hs.probe.Val(fieldName)
+}
+
+func (hs *hashJoinScan) HasField(fieldName string) bool {
+	return hs.buildSchema.HasField(fieldName) || hs.probe.HasField(fieldName)
+}
+
+func (hs *hashJoinScan) Type(fieldName string) storage.FieldType {
+	if hs.buildSchema.HasField(fieldName) {
+		return hs.buildSchema.ftype(fieldName)
+	}
+
+	return hs.probe.Type(fieldName)
+}
+
+// Close closes the scans of the inputs and drops the partitions, if any.
+func (hs *hashJoinScan) Close() {
+	hs.closeInputs()
+
+	for _, part := range hs.parts {
+		dropAll([]*tmpTable{part.build, part.probe})
+	}
+
+	hs.parts = nil
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"io"
+	"strings"
 
 	"github.com/luigitni/simpledb/pages"
 	"github.com/luigitni/simpledb/storage"
@@ -28,10 +29,17 @@ type tableScan struct {
 	recordPage  *pages.SlottedPage
 	fileName    string
 	currentSlot storage.SmallInt
+	// qualifier, if set, must prefix the names of the fields, as in qualifier.field
+	qualifier string
+}
+
+// tableFileName returns the name of the file that holds the records of the table.
+func tableFileName(tablename string) string {
+	return tablename + ".tbl"
 }
 
 func newTableScan(tx tx.Transaction, tablename string, layout Layout) *tableScan {
-	fname := tablename + ".tbl"
+	fname := tableFileName(tablename)
 
 	ts := &tableScan{
 		x:        tx,
@@ -53,6 +61,16 @@ func newTableScan(tx tx.Transaction, tablename string, layout Layout) *tableScan
 	return ts
 }
 
+// field returns the name of the field in the layout of the table.
+// The second return value is false if the name is not qualified by the qualifier of the scan.
+func (ts *tableScan) field(name string) (string, bool) {
+	if ts.qualifier == "" {
+		return name, true
+	}
+
+	return strings.CutPrefix(name, ts.qualifier+".")
+}
+
 func (ts *tableScan) BeforeFirst() error {
 	ts.moveToBlock(0)
 	return nil
@@ -106,9 +124,28 @@ func (ts *tableScan) Varlen(fieldname string) (storage.Varlen, error) {
 	return ts.recordPage.VarLen(ts.currentSlot, fieldname)
 }
 
+// Val returns the value of the field for the current record,
+// or storage.Null if the field is NULL.
 func (ts *tableScan) Val(fieldname string) (storage.Value, error) {
-	if size := ts.layout.schema.ftype(fieldname).Size(); size != storage.SizeOfVarlen {
-		v, err := ts.FixedLen(fieldname)
+	fieldname, _ = ts.field(fieldname)
+
+	return recordVal(ts.recordPage, ts.currentSlot, ts.layout, fieldname)
+}
+
+// recordVal returns the value of the field for the record at the slot of the page,
+// or storage.Null if the field is NULL.
+func recordVal(page *pages.SlottedPage, slot storage.SmallInt, layout Layout, fieldname string) (storage.Value, error) {
+	null, err := page.IsNull(slot, fieldname)
+	if err != nil {
+		return storage.Value{}, err
+	}
+
+	if null {
+		return storage.Null, nil
+	}
+
+	if size := layout.schema.ftype(fieldname).Size(); size != storage.SizeOfVarlen {
+		v, err := page.FixedLen(slot, fieldname)
 		if err != nil {
 			return storage.Value{}, err
 		}
@@ -116,7 +153,7 @@ func (ts *tableScan) Val(fieldname string) (storage.Value, error) {
 		return storage.ValueFromFixedLen(v), nil
 	}
 
-	v, err := ts.Varlen(fieldname)
+	v, err := page.VarLen(slot, fieldname)
 	if err != nil {
 		return storage.Value{}, err
 	}
@@ -125,10 +162,24 @@ func (ts *tableScan) Val(fieldname string) (storage.Value, error) {
 }
 
 func (ts *tableScan) HasField(fieldname string) bool {
-	return ts.layout.schema.HasField(fieldname)
+	f, ok := ts.field(fieldname)
+	return ok && ts.layout.schema.HasField(f)
 }
 
+func (ts *tableScan) Type(fieldname string) storage.FieldType {
+	f, _ := ts.field(fieldname)
+	return ts.layout.schema.ftype(f)
+}
+
+// SetVal sets the value of the field for the current record.
+// A NULL value sets the field to NULL.
 func (ts *tableScan) SetVal(fieldname string, val storage.Value) error {
+	fieldname, _ = ts.field(fieldname)
+
+	if val.IsNull() {
+		return ts.recordPage.SetNull(ts.currentSlot, fieldname)
+	}
+
 	if size := ts.layout.schema.ftype(fieldname).Size(); size != storage.SizeOfVarlen {
 		return ts.recordPage.SetFixedLen(ts.currentSlot, fieldname, val.AsFixedLen())
 	}
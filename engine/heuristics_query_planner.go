@@ -11,8 +11,11 @@ var _ QueryPlanner = HeuristicsQueryPlanner{}
 
 // HeuristicsQueryPlanner uses heuristics to pick a good enough query plan.
 // Join order is chosen according to the table that produces the smallest output.
+// Queries with outer joins are joined in the order of the <FROM> clause.
 type HeuristicsQueryPlanner struct {
 	mdm *MetadataManager
+	// cteScope holds the common table expressions visible to the query being planned
+	cteScope *cteScope
 }
 
 func NewHeuristicsQueryPlanner(mdm *MetadataManager) HeuristicsQueryPlanner {
@@ -22,10 +25,95 @@ func NewHeuristicsQueryPlanner(mdm *MetadataManager) HeuristicsQueryPlanner {
 }
 
 func (hqp HeuristicsQueryPlanner) CreatePlan(data sql.Query, x tx.Transaction) (Plan, error) {
+	return hqp.createPlan(data, x, nil)
+}
+
+func (hqp HeuristicsQueryPlanner) ctes() *cteScope {
+	return hqp.cteScope
+}
+
+func (hqp HeuristicsQueryPlanner) withCTEs(ctes *cteScope) subqueryPlanner {
+	hqp.cteScope = ctes
+	return hqp
+}
+
+// createPlan plans the query, which is a subquery if scope is not nil.
+// The queries combined by a set operation are planned on their own.
+// Common table expressions are joined like tables, but have no indexes.
+// A correlated subquery begins its join order with the current record of the outer query,
+// so that its tables can be joined to the outer references through their indexes.
+// Uncorrelated IN and NOT IN conditions of the <WHERE> clause
+// are evaluated by semi-joins and anti-joins on top of the joined tables.
+func (hqp HeuristicsQueryPlanner) createPlan(data sql.Query, x tx.Transaction, scope *subqueryScope) (Plan, error) {
+	if len(data.CTEs()) > 0 {
+		return planWith(x, hqp, data, scope)
+	}
+
+	if so, ok := data.SetOperation(); ok {
+		return planSetOperation(x, hqp, so, data, scope)
+	}
+
+	refs := data.TableRefs()
+	plans := make([]Plan, len(refs))
+	schemas := make([]Schema, len(refs))
+	for i, ref := range refs {
+		var plan Plan
+		var err error
+		if ct, ok := hqp.cteScope.lookup(ref.Name); ok {
+			plan, err = ct.reference(ref.RangeName())
+		} else {
+			plan, err = newQualifiedTablePlan(x, ref.Name, ref.RangeName(), hqp.mdm)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		plans[i] = plan
+		schemas[i] = plan.Schema()
+	}
+
+	resolve, err := newFieldResolver(refs, schemas)
+	if err != nil {
+		return nil, err
+	}
+
+	resolve = scope.resolver(resolve)
+
+	data, err = data.Qualify(resolve)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = bindSubqueries(x, hqp, data, resolve, scope.visibleSchema(schemas))
+	if err != nil {
+		return nil, err
+	}
+
+	row := scope.rowPlan()
+
+	// outer joins cannot be reordered, so tables are joined as the query specifies
+	if data.HasOuterJoin() {
+		p, err := joinPlan(plans, data.Joins())
+		if err != nil {
+			return nil, err
+		}
+
+		if row != nil {
+			p = newProductPlan(row, p)
+		}
+
+		return subqueryOutput(x, newSelectPlan(p, data.Predicate()), data, resolve, row)
+	}
+
+	data, semis := semiJoins(data)
+
+	pred := innerJoinPredicate(data)
+
 	var planners []*tablePlanner
 	// create a table planner for each table contained in the query
-	for _, tname := range data.Tables() {
-		planner, err := newTablePlanner(x, tname, data.Predicate(), hqp.mdm)
+	for _, plan := range plans {
+		planner, err := newTablePlanner(x, plan, pred, hqp.mdm)
 		if err != nil {
 			return nil, err
 		}
@@ -33,8 +121,17 @@ func (hqp HeuristicsQueryPlanner) CreatePlan(data sql.Query, x tx.Transaction) (
 		planners = append(planners, planner)
 	}
 
-	// choose the lowest-size plan to begin the join order
-	plan, planners := lowestSelectPlan(planners)
+	// choose the lowest-size plan to begin the join order,
+	// unless the outer record of a correlated subquery begins it
+	var plan Plan
+	if row != nil {
+		plan = row
+		if rowPredicate, ok := pred.SelectSubPredicate(row.Schema()); ok {
+			plan = newSelectPlan(row, rowPredicate)
+		}
+	} else {
+		plan, planners = lowestSelectPlan(planners)
+	}
 
 	for {
 		if len(planners) == 0 {
@@ -50,12 +147,12 @@ func (hqp HeuristicsQueryPlanner) CreatePlan(data sql.Query, x tx.Transaction) (
 		}
 	}
 
-	proj := newProjectPlan(plan, data.Fields())
-	if data.OrderByFields() == nil {
-		return proj, nil
+	plan, err = addSemiJoins(plan, semis)
+	if err != nil {
+		return nil, err
 	}
 
-	return newSortPlan(x, proj, data.OrderByFields()), nil
+	return subqueryOutput(x, plan, data, resolve, row)
 }
 
 // lowestSelectPlan picks the table that
@@ -118,29 +215,35 @@ func lowestProductPlan(planners []*tablePlanner, current Plan) (Plan, []*tablePl
 // an efficient query plan for the table, based on the given predicate and available indexes.
 // tablePlanner uses heuristics to determine the lowest cost query exectuion plan
 type tablePlanner struct {
-	plan      tablePlan
+	plan      Plan
 	predicate Predicate
 	schema    Schema
 	indexes   map[string]*indexInfo
 	x         tx.Transaction
 }
 
-func newTablePlanner(x tx.Transaction, tableName string, pred Predicate, mdm *MetadataManager) (*tablePlanner, error) {
-	plan, err := newTablePlan(x, tableName, mdm)
-	if err != nil {
-		return nil, err
-	}
+// newTablePlanner returns a planner for the table of the plan.
+// Indexes are keyed by the qualified name of their field,
+// so that they match the fields of the predicate.
+// Only stored tables have indexes.
+func newTablePlanner(x tx.Transaction, plan Plan, pred Predicate, mdm *MetadataManager) (*tablePlanner, error) {
+	indexes := map[string]*indexInfo{}
+	if tp, ok := plan.(tablePlan); ok {
+		iinfo, err := mdm.indexInfo(x, tp.tableName)
+		if err != nil {
+			return nil, err
+		}
 
-	iinfo, err := mdm.indexInfo(x, tableName)
-	if err != nil {
-		return nil, err
+		for f, ii := range iinfo {
+			indexes[tp.qualifier+"."+f] = ii
+		}
 	}
 
 	return &tablePlanner{
 		plan:      plan,
 		predicate: pred,
 		schema:    plan.Schema(),
-		indexes:   iinfo,
+		indexes:   indexes,
 		x:         x,
 	}, nil
 }
@@ -194,9 +297,10 @@ func (tp tablePlanner) makeIndexSelectPlan() Plan {
 }
 
 // makeJoinPlan checks if a join exists between the specified Plan and this plan.
-// If a join predicate exists, the heuristics will attempt to create an IndexJoin.
-// If that's not possible, the planner will select a product join plan
-// (A product followed by a select).
+// If a join predicate exists, the heuristics will attempt to create an IndexJoin
+// and, if the predicate equates a field of each plan, a hash or a merge join.
+// The planner also considers a product join plan
+// (A product followed by a select), and picks the plan that accesses the fewest blocks.
 func (tp tablePlanner) makeJoinPlan(current Plan) Plan {
 	schema := current.Schema()
 	joinedSchema := newJoinedSchema(tp.schema, schema)
@@ -205,18 +309,33 @@ func (tp tablePlanner) makeJoinPlan(current Plan) Plan {
 		return nil
 	}
 
-	plan := tp.makeIndexJoinPlan(current, schema)
-	if plan == nil {
-		plan = tp.makeProductJoinPlan(current, schema)
+	// on ties, join plans are preferred to the product,
+	// which evaluates the predicate on every pair of records
+	var plan Plan
+	for _, p := range []Plan{
+		tp.makeIndexJoinPlan(current, schema),
+		tp.makeEquiJoinPlan(current, schema),
+		tp.makeProductJoinPlan(current, schema),
+	} {
+		if p != nil && (plan == nil || p.BlocksAccessed() < plan.BlocksAccessed()) {
+			plan = p
+		}
 	}
 
 	return plan
 }
 
+// makeProductPlan creates the product of the specified plan and the table,
+// either as a nested loop or as a block nested loop, whichever accesses fewer blocks.
 func (tp tablePlanner) makeProductPlan(current Plan) Plan {
 	plan := tp.addSelectPredicate(tp.plan)
 
-	return newProductPlan(tp.plan, plan)
+	var p Plan = newProductPlan(current, plan)
+	if bp := newBlockProductPlan(tp.x, current, plan); bp.BlocksAccessed() < p.BlocksAccessed() {
+		p = bp
+	}
+
+	return p
 }
 
 func (tp tablePlanner) makeIndexJoinPlan(current Plan, schema Schema) Plan {
@@ -232,6 +351,33 @@ func (tp tablePlanner) makeIndexJoinPlan(current Plan, schema Schema) Plan {
 	return nil
 }
 
+// makeEquiJoinPlan attempts to create a hash join or a merge join
+// between the specified plan and the table.
+// The predicate must equate a field of the table with a field of the plan of the same type,
+// since values of different types are never equal byte by byte.
+// Both joins are planned and the one that accesses fewer blocks is kept.
+// On ties, which happen when the build side of the hash join does not fit in memory
+// and must be partitioned, the merge join is preferred.
+func (tp tablePlanner) makeEquiJoinPlan(current Plan, schema Schema) Plan {
+	for _, f := range tp.schema.fields {
+		g, ok := tp.predicate.EquatesWithField(f)
+		if !ok || !schema.HasField(g) || schema.ftype(g) != tp.schema.ftype(f) {
+			continue
+		}
+
+		p := tp.addSelectPredicate(tp.plan)
+
+		var plan Plan = newMergeJoinPlan(tp.x, current, p, g, f)
+		if hp := newHashJoinPlan(tp.x, current, p, g, f); hp.BlocksAccessed() < plan.BlocksAccessed() {
+			plan = hp
+		}
+
+		return tp.addJoinPredicate(plan, schema)
+	}
+
+	return nil
+}
+
 func (tp tablePlanner) makeProductJoinPlan(current Plan, schema Schema) Plan {
 	p := tp.makeProductPlan(current)
 
@@ -76,6 +76,9 @@ type IndexJoinScan struct {
 	idx       Index
 	joinField string
 	rhs       *tableScan
+	// nullKey is true if the join field of the current lhs record is NULL.
+	// NULL does not equal any value, so the record has no matches.
+	nullKey bool
 }
 
 func newIndexJoinScan(lhs Scan, idx Index, joinField string, rhs *tableScan) *IndexJoinScan {
@@ -110,7 +113,10 @@ func (ijs *IndexJoinScan) BeforeFirst() error {
 
 func (ijs *IndexJoinScan) Next() error {
 	for {
-		err := ijs.idx.Next()
+		err := io.EOF
+		if !ijs.nullKey {
+			err = ijs.idx.Next()
+		}
 
 		if err == nil {
 
@@ -131,7 +137,9 @@ func (ijs *IndexJoinScan) Next() error {
 			return err
 		}
 
-		ijs.resetIndex()
+		if err := ijs.resetIndex(); err != nil {
+			return err
+		}
 	}
 }
 
@@ -141,6 +149,11 @@ func (ijs *IndexJoinScan) resetIndex() error {
 		return err
 	}
 
+	ijs.nullKey = key.IsNull()
+	if ijs.nullKey {
+		return nil
+	}
+
 	return ijs.idx.BeforeFirst(key)
 }
 
@@ -148,6 +161,14 @@ func (ijs *IndexJoinScan) HasField(field string) bool {
 	return ijs.rhs.HasField(field) || ijs.lhs.HasField(field)
 }
 
+func (ijs *IndexJoinScan) Type(field string) storage.FieldType {
+	if ijs.rhs.HasField(field) {
+		return ijs.rhs.Type(field)
+	}
+
+	return ijs.lhs.Type(field)
+}
+
 func (ijs *IndexJoinScan) Val(field string) (storage.Value, error) {
 	if ijs.rhs.HasField(field) {
 		return ijs.rhs.Val(field)
@@ -1,7 +1,10 @@
 package engine
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 
 	"github.com/luigitni/simpledb/storage"
 	"github.com/luigitni/simpledb/tx"
@@ -14,6 +17,8 @@ const (
 	idxCatalogFieldField = "field_name"
 )
 
+var ErrIndexNotFound = errors.New("cannot find index in catalog")
+
 type Index interface {
 	BeforeFirst(searchKey storage.Value) error
 	Next() error
@@ -142,13 +147,101 @@ func (im *indexManager) createIndex(x tx.Transaction, idxName string, tblName st
 	return nil
 }
 
-// indexInfo returns a map of indexInfo defined over the fields of the provided table.
-func (im *indexManager) indexInfo(x tx.Transaction, tblName string) (map[string]*indexInfo, error) {
-	m := map[string]*indexInfo{}
+// dropIndex removes the index from the catalog.
+// If the index cannot be found returns an ErrIndexNotFound
+func (im *indexManager) dropIndex(x tx.Transaction, idxName string) error {
+	n, err := deleteCatalogRecords(x, idxCatalogTableName, im.l, idxCatalogNameField, idxName, nil)
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%w: %q", ErrIndexNotFound, idxName)
+	}
+
+	return nil
+}
+
+// dropIndexes removes from the catalog the indexes defined over the fields of the table,
+// or only those defined over fldName if it is not empty, and returns their names.
+func (im *indexManager) dropIndexes(x tx.Transaction, tblName string, fldName string) ([]string, error) {
+	var names []string
+	_, err := deleteCatalogRecords(x, idxCatalogTableName, im.l, idxCatalogTableField, tblName, func(ts *tableScan) (bool, error) {
+		if fldName != "" {
+			v, err := ts.Val(idxCatalogFieldField)
+			if err != nil || v.AsName().AsGoString() != fldName {
+				return false, err
+			}
+		}
+
+		v, err := ts.Val(idxCatalogNameField)
+		if err != nil {
+			return false, err
+		}
+
+		// the names are returned after the scan of the catalog is closed
+		names = append(names, strings.Clone(v.AsName().AsGoString()))
+
+		return true, nil
+	})
+
+	return names, err
+}
+
+// renameIndexes moves the indexes defined over the fields of the table to the table newTblName.
+// fields maps the names of the renamed fields to their new names.
+func (im *indexManager) renameIndexes(x tx.Transaction, tblName string, newTblName string, fields map[string]string) error {
+	ts := newTableScan(x, idxCatalogTableName, im.l)
+	defer ts.Close()
+
+	for {
+		err := ts.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		table, err := ts.Val(idxCatalogTableField)
+		if err != nil {
+			return err
+		}
+
+		if table.AsName().AsGoString() != tblName {
+			continue
+		}
+
+		if err := ts.SetVal(idxCatalogTableField, storage.ValueFromName(storage.NewNameFromGoString(newTblName))); err != nil {
+			return err
+		}
+
+		field, err := ts.Val(idxCatalogFieldField)
+		if err != nil {
+			return err
+		}
+
+		newName, ok := fields[field.AsName().AsGoString()]
+		if !ok {
+			continue
+		}
+
+		if err := ts.SetVal(idxCatalogFieldField, storage.ValueFromName(storage.NewNameFromGoString(newName))); err != nil {
+			return err
+		}
+	}
+}
+
+// indexes returns the indexInfo of every index defined over the fields of the provided table.
+func (im *indexManager) indexes(x tx.Transaction, tblName string) ([]*indexInfo, error) {
+	var infos []*indexInfo
 
 	scan := newTableScan(x, idxCatalogTableName, im.l)
 	defer scan.Close()
 
+	var layout Layout
+	var stat statInfo
 	for {
 		err := scan.Next()
 		if err == io.EOF {
@@ -178,22 +271,68 @@ func (im *indexManager) indexInfo(x tx.Transaction, tblName string) (map[string]
 			return nil, err
 		}
 
-		layout, err := im.tm.layout(tblName, x)
-		if err != nil {
-			return nil, err
+		// the names outlive the scan of the catalog, and the buffer it reads from
+		// can be reused while the layout and the statistics of the table are read,
+		// so they are cloned out of it first
+		idxn := strings.Clone(idxName.AsName().AsGoString())
+		fn := strings.Clone(fldName.AsName().AsGoString())
+
+		if infos == nil {
+			layout, err = im.tm.layout(tblName, x)
+			if err != nil {
+				return nil, err
+			}
+
+			stat, err = im.sm.statInfo(tblName, layout, x)
+			if err != nil {
+				return nil, err
+			}
 		}
 
-		stat, err := im.sm.statInfo(tblName, layout, x)
-		if err != nil {
-			return nil, err
-		}
+		infos = append(infos, newIndexInfo(x, idxn, fn, *layout.Schema(), stat))
+	}
+
+	return infos, nil
+}
 
-		idxn := idxName.AsName().AsGoString()
-		fn := fldName.AsName().AsGoString()
+// indexInfo returns a map of indexInfo defined over the fields of the provided table.
+// If a field has more than one index, only one of them is returned.
+func (im *indexManager) indexInfo(x tx.Transaction, tblName string) (map[string]*indexInfo, error) {
+	infos, err := im.indexes(x, tblName)
+	if err != nil {
+		return nil, err
+	}
 
-		ii := newIndexInfo(x, idxn, fn, *layout.Schema(), stat)
-		m[fn] = ii
+	m := make(map[string]*indexInfo, len(infos))
+	for _, ii := range infos {
+		m[ii.fieldName] = ii
 	}
 
 	return m, nil
 }
+
+// indexExists reports whether an index is named idxName.
+func (im *indexManager) indexExists(x tx.Transaction, idxName string) (bool, error) {
+	scan := newTableScan(x, idxCatalogTableName, im.l)
+	defer scan.Close()
+
+	for {
+		err := scan.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		v, err := scan.Val(idxCatalogNameField)
+		if err != nil {
+			return false, err
+		}
+
+		if v.AsName().AsGoString() == idxName {
+			return true, nil
+		}
+	}
+}
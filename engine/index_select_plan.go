@@ -42,7 +42,7 @@ func (plan *IndexSelectPlan) RecordsOutput() int {
 }
 
 func (plan *IndexSelectPlan) DistinctValues(fieldName string) int {
-	return plan.indexInfo.DistinctValues(fieldName)
+	return plan.indexInfo.DistinctValues(unqualified(fieldName))
 }
 
 func (plan *IndexSelectPlan) Schema() Schema {
@@ -97,6 +97,10 @@ func (scan *indexSelectScan) HasField(fname string) bool {
 	return scan.tableScan.HasField(fname)
 }
 
+func (scan *indexSelectScan) Type(fname string) storage.FieldType {
+	return scan.tableScan.Type(fname)
+}
+
 func (scan *indexSelectScan) Close() {
 	scan.idx.Close()
 	scan.tableScan.Close()
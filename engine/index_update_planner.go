@@ -1,7 +1,10 @@
 package engine
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"slices"
 
 	"github.com/luigitni/simpledb/sql"
 	"github.com/luigitni/simpledb/storage"
@@ -10,6 +13,8 @@ import (
 
 var _ UpdatePlanner = &IndexUpdatePlanner{}
 
+var ErrInsertValues = errors.New("the number of values to insert does not match the number of fields")
+
 type IndexUpdatePlanner struct {
 	mdm *MetadataManager
 }
@@ -20,6 +25,19 @@ func newIndexUpdatePlanner(mdm *MetadataManager) *IndexUpdatePlanner {
 	}
 }
 
+// sequences returns the generator that draws the values of the sequences for the transaction.
+func (planner *IndexUpdatePlanner) sequences(x tx.Transaction) sql.SequenceGenerator {
+	return sequenceGenerator{x: x, sm: planner.mdm.sequenceManager}
+}
+
+// executeInsert inserts the rows of the VALUES clause, or the records returned by the query, into the table.
+// The records of the query are materialized before the first one is inserted,
+// so that a query that reads the table does not see the records being inserted.
+// Fields that are not listed in the command take their default value.
+// Each record is added to all the indexes of the table,
+// once it has been checked against the constraints of the table
+// and the records its foreign keys refer to have been found.
+// A record can refer to itself, in which case it is its own parent.
 func (planner *IndexUpdatePlanner) executeInsert(data sql.InsertCommand, x tx.Transaction) (int, error) {
 	plan, err := newTablePlan(x, data.TableName, planner.mdm)
 	if err != nil {
@@ -28,6 +46,126 @@ func (planner *IndexUpdatePlanner) executeInsert(data sql.InsertCommand, x tx.Tr
 
 	schema := plan.Schema()
 
+	fields := data.Fields
+	if len(fields) == 0 {
+		fields = schema.fields
+	}
+
+	for _, f := range fields {
+		if !schema.HasField(f) {
+			return 0, ErrNoField
+		}
+	}
+
+	var p Plan
+	if data.Query != nil {
+		p, err = NewHeuristicsQueryPlanner(planner.mdm).CreatePlan(*data.Query, x)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(p.Schema().Fields()) != len(fields) {
+			return 0, ErrInsertValues
+		}
+	}
+
+	for _, row := range data.Rows {
+		if len(row) != len(fields) {
+			return 0, ErrInsertValues
+		}
+	}
+
+	indexes, err := planner.openIndexes(x, data.TableName)
+	if err != nil {
+		return 0, err
+	}
+
+	defer closeIndexes(indexes)
+
+	keys, err := planner.mdm.keys(x, data.TableName)
+	if err != nil {
+		return 0, err
+	}
+
+	gen := planner.sequences(x)
+
+	rc, err := newRowConstraints(schema, gen)
+	if err != nil {
+		return 0, err
+	}
+
+	fkRefs, err := planner.foreignKeyRefs(x, data.TableName, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// insert evaluates the expressions of the row over the scan src,
+	// which is nil for the rows of the VALUES clause.
+	insert := func(us UpdateScan, row []sql.Expression, src Scan) error {
+		vals := make([]storage.Value, len(schema.fields))
+		for i, f := range schema.fields {
+			if slices.Contains(fields, f) {
+				continue
+			}
+
+			v, err := rc.defaultValue(f)
+			if err != nil {
+				return err
+			}
+
+			vals[i] = v
+		}
+
+		for i, f := range fields {
+			v, err := row[i].BindSequences(gen).EvaluateAs(src, schema.ftype(f))
+			if err != nil {
+				return err
+			}
+
+			vals[schema.info[f].Index] = v
+		}
+
+		if err := rc.check(vals); err != nil {
+			return err
+		}
+
+		if err := checkKeys(x, plan.layout, keys, vals, nil); err != nil {
+			return err
+		}
+
+		if err := checkForeignKeys(x, schema, fkRefs, vals, nil); err != nil {
+			return err
+		}
+
+		return insertRow(us, schema, vals, indexes)
+	}
+
+	if p == nil {
+		scan, err := plan.Open()
+		if err != nil {
+			return 0, err
+		}
+
+		us := scan.(UpdateScan)
+		defer us.Close()
+
+		for _, row := range data.Rows {
+			if err := insert(us, row, nil); err != nil {
+				return 0, err
+			}
+		}
+
+		return len(data.Rows), nil
+	}
+
+	tt, err := newMaterializePlan(x, p).materialize()
+	if err != nil {
+		return 0, err
+	}
+
+	src := tt.Open()
+	defer src.Close()
+
 	scan, err := plan.Open()
 	if err != nil {
 		return 0, err
@@ -36,48 +174,99 @@ func (planner *IndexUpdatePlanner) executeInsert(data sql.InsertCommand, x tx.Tr
 	us := scan.(UpdateScan)
 	defer us.Close()
 
+	columns := p.Schema().Fields()
+	row := make([]sql.Expression, len(columns))
+	for i, c := range columns {
+		row[i] = sql.NewExpressionWithField(c)
+	}
+
+	var count int
+	for {
+		err := src.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		if err := insert(us, row, src); err != nil {
+			return 0, err
+		}
+
+		count++
+	}
+}
+
+// openIndexes opens all the indexes defined over the fields of the table,
+// grouped by the field they index. They must be closed with closeIndexes.
+func (planner *IndexUpdatePlanner) openIndexes(x tx.Transaction, tblName string) (map[string][]Index, error) {
+	infos, err := planner.mdm.indexes(x, tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[string][]Index, len(infos))
+	for _, info := range infos {
+		indexes[info.fieldName] = append(indexes[info.fieldName], info.Open())
+	}
+
+	return indexes, nil
+}
+
+func closeIndexes(indexes map[string][]Index) {
+	for _, idxs := range indexes {
+		for _, idx := range idxs {
+			idx.Close()
+		}
+	}
+}
+
+// insertRow inserts a record with the values, given in the order of the fields of the schema,
+// and adds it to the indexes of its fields.
+func insertRow(us UpdateScan, schema Schema, vals []storage.Value, indexes map[string][]Index) error {
 	var size storage.Offset = 0
-	for i, v := range data.Values {
+	for i, f := range schema.fields {
 		// todo: check if the value of type varlena needs to be toasted.
-		f := data.Fields[i]
-
-		t := schema.ftype(f)
-		size += v.Size(t)
+		size += vals[i].Size(schema.ftype(f))
 	}
 
 	if err := us.Insert(size); err != nil {
-		return 0, err
+		return err
 	}
 
 	rid := us.GetRID()
 
-	ii, err := planner.mdm.indexInfo(x, data.TableName)
-	if err != nil {
-		return 0, err
-	}
-
-	for i, field := range data.Fields {
-		val := data.Values[i]
+	// fields are written in the order of the layout,
+	// since the offset of a field depends on the size of the variable length fields before it.
+	for i, field := range schema.fields {
+		val := vals[i]
 		if err := us.SetVal(field, val); err != nil {
-			return 0, err
+			return err
 		}
 
-		// check if the field is indexed. If it is, save it
-		info, ok := ii[field]
-		if !ok {
+		// NULLs are not indexed.
+		if val.IsNull() {
 			continue
 		}
 
-		idx := info.Open()
-		defer idx.Close()
-		if err := idx.Insert(val, rid); err != nil {
-			return 0, err
+		for _, idx := range indexes[field] {
+			if err := idx.Insert(val, rid); err != nil {
+				return err
+			}
 		}
 	}
 
-	return 1, nil
+	return nil
 }
 
+// executeUpdate sets the new values of the fields of the records that satisfy the predicate,
+// and updates the indexes of the table.
+// Each record is checked before it is updated: against the NOT NULL and CHECK constraints of the table,
+// against its keys if the command sets one of their fields,
+// the records referred to by the foreign keys whose fields are set must exist,
+// and the old values of its fields that change must not be referred to by other records.
 func (planner *IndexUpdatePlanner) executeUpdate(data sql.UpdateCommand, x tx.Transaction) (int, error) {
 	plan, err := newTablePlan(x, data.TableName, planner.mdm)
 	if err != nil {
@@ -94,7 +283,31 @@ func (planner *IndexUpdatePlanner) executeUpdate(data sql.UpdateCommand, x tx.Tr
 	updateScan := s.(UpdateScan)
 	defer updateScan.Close()
 
-	ii, err := planner.mdm.indexInfo(x, data.TableName)
+	indexes, err := planner.openIndexes(x, data.TableName)
+	if err != nil {
+		return 0, err
+	}
+
+	defer closeIndexes(indexes)
+
+	keys, err := planner.updatedKeys(x, data)
+	if err != nil {
+		return 0, err
+	}
+
+	gen := planner.sequences(x)
+
+	rc, err := newRowConstraints(plan.schema, gen)
+	if err != nil {
+		return 0, err
+	}
+
+	fkRefs, err := planner.foreignKeyRefs(x, data.TableName, data.Fields)
+	if err != nil {
+		return 0, err
+	}
+
+	referencing, err := planner.referencingKeys(x, data.TableName, data.Fields)
 	if err != nil {
 		return 0, err
 	}
@@ -123,11 +336,15 @@ func (planner *IndexUpdatePlanner) executeUpdate(data sql.UpdateCommand, x tx.Tr
 		var size storage.Offset = 0
 
 		for _, fieldName := range schema.fields {
-			val, err := updateScan.Val(fieldName)
+			v, err := updateScan.Val(fieldName)
 			if err != nil {
 				return updatedRows, err
 			}
 
+			// the record is checked against the constraints before it is written,
+			// which reads other blocks, so its values must outlive the buffer of the record.
+			val := storage.Copy(v)
+
 			idx := schema.info[fieldName].Index
 			entryFields[idx] = fieldValue{
 				field:    fieldName,
@@ -141,7 +358,9 @@ func (planner *IndexUpdatePlanner) executeUpdate(data sql.UpdateCommand, x tx.Tr
 		}
 
 		for _, f := range data.Fields {
-			newValue, err := f.NewValue.Evaluate(updateScan)
+			t := schema.ftype(f.Field)
+
+			newValue, err := f.NewValue.BindSequences(gen).EvaluateAs(updateScan, t)
 			if err != nil {
 				return updatedRows, err
 			}
@@ -150,45 +369,83 @@ func (planner *IndexUpdatePlanner) executeUpdate(data sql.UpdateCommand, x tx.Tr
 
 			oldValue := entryFields[idx].oldValue
 
-			t := schema.ftype(f.Field)
 			size -= oldValue.Size(t)
 
-			entryFields[idx].newValue = newValue
+			entryFields[idx].newValue = storage.Copy(newValue)
 
 			size += newValue.Size(t)
 		}
 
 		oldRid := updateScan.GetRID()
 
-		if err := updateScan.Update(size); err != nil {
+		vals := make([]storage.Value, len(entryFields))
+		for i, fv := range entryFields {
+			vals[i] = fv.newValue
+		}
+
+		if err := rc.check(vals); err != nil {
 			return updatedRows, err
 		}
 
-		newRid := updateScan.GetRID()
+		if err := checkKeys(x, plan.layout, keys, vals, &oldRid); err != nil {
+			return updatedRows, err
+		}
 
-		for _, fv := range entryFields {
-			if err := updateScan.SetVal(fv.field, fv.newValue); err != nil {
+		if err := checkForeignKeys(x, schema, fkRefs, vals, &oldRid); err != nil {
+			return updatedRows, err
+		}
+
+		// the referenced values that change must not be referred to by any other record.
+		for _, ref := range referencing {
+			old, ok, err := ref.referencedValues(updateScan)
+			if err != nil {
 				return updatedRows, err
 			}
 
-			// check if the field is indexed. If it is, save it
-			// we need to do this even if the value that has changed is not indexed
-			// because the new record will have a new rid.
-			info, ok := ii[fv.field]
 			if !ok {
 				continue
 			}
 
-			idx := info.Open()
-			defer idx.Close()
+			for j, f := range ref.refFields {
+				if old[j].Equals(vals[schema.info[f].Index]) {
+					continue
+				}
 
-			if err := idx.Delete(fv.oldValue, oldRid); err != nil {
-				return updatedRows, err
+				if err := ref.restrict(x, old, oldRid); err != nil {
+					return updatedRows, err
+				}
+
+				break
 			}
+		}
 
-			if err := idx.Insert(fv.newValue, newRid); err != nil {
+		if err := updateScan.Update(size); err != nil {
+			return updatedRows, err
+		}
+
+		newRid := updateScan.GetRID()
+
+		for _, fv := range entryFields {
+			if err := updateScan.SetVal(fv.field, fv.newValue); err != nil {
 				return updatedRows, err
 			}
+
+			// update the indexes of the field.
+			// we need to do this even if the value that has changed is not indexed
+			// because the new record will have a new rid.
+			for _, idx := range indexes[fv.field] {
+				if !fv.oldValue.IsNull() {
+					if err := idx.Delete(fv.oldValue, oldRid); err != nil {
+						return updatedRows, err
+					}
+				}
+
+				if !fv.newValue.IsNull() {
+					if err := idx.Insert(fv.newValue, newRid); err != nil {
+						return updatedRows, err
+					}
+				}
+			}
 		}
 
 		updateScan.MoveToRID(oldRid)
@@ -199,7 +456,42 @@ func (planner *IndexUpdatePlanner) executeUpdate(data sql.UpdateCommand, x tx.Tr
 	return updatedRows, nil
 }
 
+// updatedKeys returns the keys of the table that include a field set by the command.
+func (planner *IndexUpdatePlanner) updatedKeys(x tx.Transaction, data sql.UpdateCommand) ([]keyConstraint, error) {
+	keys, err := planner.mdm.keys(x, data.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated []keyConstraint
+	for _, key := range keys {
+		for _, f := range data.Fields {
+			if slices.Contains(key.fields, f.Field) {
+				updated = append(updated, key)
+				break
+			}
+		}
+	}
+
+	return updated, nil
+}
+
+// executeDelete deletes the records that satisfy the predicate, together with their index entries,
+// and applies the ON DELETE action of the foreign keys that refer to each of them.
 func (planner *IndexUpdatePlanner) executeDelete(data sql.DeleteCommand, x tx.Transaction) (int, error) {
+	refs, err := planner.referencingKeys(x, data.TableName, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return planner.deleteRecords(data, x, refs)
+}
+
+// deleteRecords deletes the records that satisfy the predicate, together with their index entries.
+// Before each record is deleted, the referencing foreign keys whose action is RESTRICT or NO ACTION
+// must not refer to it. After it is deleted, the action of each of the other referencing foreign keys
+// is applied to the records that referred to it.
+func (planner *IndexUpdatePlanner) deleteRecords(data sql.DeleteCommand, x tx.Transaction, refs []referencingKey) (int, error) {
 	plan, err := newTablePlan(x, data.TableName, planner.mdm)
 	if err != nil {
 		return 0, err
@@ -207,11 +499,13 @@ func (planner *IndexUpdatePlanner) executeDelete(data sql.DeleteCommand, x tx.Tr
 
 	selectPlan := newSelectPlan(plan, data.Predicate)
 
-	ii, err := planner.mdm.indexInfo(x, data.TableName)
+	indexes, err := planner.openIndexes(x, data.TableName)
 	if err != nil {
 		return 0, err
 	}
 
+	defer closeIndexes(indexes)
+
 	s, err := selectPlan.Open()
 	if err != nil {
 		return 0, err
@@ -222,16 +516,20 @@ func (planner *IndexUpdatePlanner) executeDelete(data sql.DeleteCommand, x tx.Tr
 
 	delFromIdx := func() error {
 		rid := updateScan.GetRID()
-		for field, info := range ii {
+		for field, idxs := range indexes {
 			val, err := updateScan.Val(field)
 			if err != nil {
 				return err
 			}
 
-			idx := info.Open()
-			defer idx.Close()
-			if err := idx.Delete(val, rid); err != nil {
-				return err
+			if val.IsNull() {
+				continue
+			}
+
+			for _, idx := range idxs {
+				if err := idx.Delete(val, rid); err != nil {
+					return err
+				}
 			}
 		}
 		return nil
@@ -249,6 +547,28 @@ func (planner *IndexUpdatePlanner) executeDelete(data sql.DeleteCommand, x tx.Tr
 			return c, err
 		}
 
+		refVals := make([][]storage.Value, len(refs))
+		for i, ref := range refs {
+			vals, ok, err := ref.referencedValues(updateScan)
+			if err != nil {
+				return c, err
+			}
+
+			if !ok {
+				continue
+			}
+
+			refVals[i] = vals
+
+			if ref.cascades() {
+				continue
+			}
+
+			if err := ref.restrict(x, vals, updateScan.GetRID()); err != nil {
+				return c, err
+			}
+		}
+
 		if err := delFromIdx(); err != nil {
 			return c, err
 		}
@@ -257,28 +577,289 @@ func (planner *IndexUpdatePlanner) executeDelete(data sql.DeleteCommand, x tx.Tr
 			return c, err
 		}
 
+		for i, ref := range refs {
+			if refVals[i] == nil || !ref.cascades() {
+				continue
+			}
+
+			if err := planner.onDelete(x, ref, refVals[i]); err != nil {
+				return c, err
+			}
+		}
+
 		c++
 	}
 
 	return c, nil
 }
 
+// executeCreateIndex stores the index into the catalog and builds it out of the records already in the table,
+// whose values of the field are sorted and loaded into the index from the bottom up.
 func (planner *IndexUpdatePlanner) executeCreateIndex(data sql.CreateIndexCommand, x tx.Transaction) (int, error) {
+	tp, err := newTablePlan(x, data.TableName, planner.mdm)
+	if err != nil {
+		return 0, err
+	}
+
+	if !tp.layout.schema.HasField(data.TargetField) {
+		return 0, ErrNoField
+	}
+
 	if err := planner.mdm.createIndex(x, data.IndexName, data.TableName, data.TargetField); err != nil {
 		return 0, err
 	}
 
+	if err := buildIndex(x, data.IndexName, tp, data.TargetField); err != nil {
+		return 0, err
+	}
+
 	return 0, nil
 }
 
+// executeCreateTable stores the table, the constraints of its fields, its keys and its foreign keys
+// into the catalogs, and creates the index that enforces each key
+// and the sequence of each auto increment field.
 func (planner *IndexUpdatePlanner) executeCreateTable(data sql.CreateTableCommand, x tx.Transaction) (int, error) {
 	schema := newSchema()
 	for _, f := range data.Fields {
 		schema.addField(f.Name, f.Type)
+		schema.setConstraints(f.Name, newFieldConstraints(f))
+	}
+
+	for _, f := range data.Fields {
+		if err := checkApplies(schema, f.Name); err != nil {
+			return 0, err
+		}
+	}
+
+	keys, err := planner.tableKeys(data, schema, x)
+	if err != nil {
+		return 0, err
+	}
+
+	fks, err := planner.tableForeignKeys(data, schema, keys, x)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, f := range data.Fields {
+		if !f.AutoIncrement {
+			continue
+		}
+
+		if err := planner.autoIncrement(x, &schema, data.TableName, f.Name); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := planner.mdm.createTable(data.TableName, schema, x); err != nil {
+		return 0, err
 	}
-	return 0, planner.mdm.createTable(data.TableName, schema, x)
+
+	for _, key := range keys {
+		if err := planner.mdm.createKey(x, key); err != nil {
+			return 0, err
+		}
+
+		if err := planner.mdm.createIndex(x, key.name, key.table, key.fields[0]); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, fk := range fks {
+		if err := planner.mdm.createForeignKey(x, fk); err != nil {
+			return 0, err
+		}
+	}
+
+	return 0, nil
+}
+
+// tableKeys returns the keys declared by the command, named after the table and their fields.
+// A key whose name is already taken by an index gets a numeric suffix.
+func (planner *IndexUpdatePlanner) tableKeys(data sql.CreateTableCommand, schema Schema, x tx.Transaction) ([]keyConstraint, error) {
+	var keys []keyConstraint
+	hasPrimary := false
+	for _, k := range data.Keys {
+		if k.Primary {
+			if hasPrimary {
+				return nil, ErrMultiplePrimaryKeys
+			}
+
+			hasPrimary = true
+		}
+
+		for i, f := range k.Fields {
+			if !schema.HasField(f) {
+				return nil, ErrNoField
+			}
+
+			if slices.Contains(k.Fields[:i], f) {
+				return nil, ErrDuplicateField
+			}
+		}
+
+		base := keyName(data.TableName, k.Primary, k.Fields)
+		name := base
+		for n := 1; ; n++ {
+			exists, err := planner.mdm.indexExists(x, name)
+			if err != nil {
+				return nil, err
+			}
+
+			taken := slices.ContainsFunc(keys, func(key keyConstraint) bool {
+				return key.name == name
+			})
+
+			if !exists && !taken {
+				break
+			}
+
+			name = fmt.Sprintf("%s%d", base, n)
+		}
+
+		keys = append(keys, keyConstraint{
+			name:    name,
+			table:   data.TableName,
+			primary: k.Primary,
+			fields:  k.Fields,
+		})
+	}
+
+	return keys, nil
+}
+
+// autoIncrement creates the sequence owned by the field of the table, named after them,
+// and makes it the default of the field, which cannot be NULL.
+// A sequence whose name is already taken gets a numeric suffix.
+func (planner *IndexUpdatePlanner) autoIncrement(x tx.Transaction, schema *Schema, table string, field string) error {
+	base := sequenceName(table, field)
+	name := base
+	for n := 1; ; n++ {
+		exists, err := planner.mdm.sequenceExists(x, name)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			break
+		}
+
+		name = fmt.Sprintf("%s%d", base, n)
+	}
+
+	c := schema.info[field].fieldConstraints
+	c.NotNull = true
+	c.Default = sql.NewNextvalExpression(name).String()
+	schema.setConstraints(field, c)
+
+	return planner.mdm.createSequence(x, sequence{
+		name:       name,
+		next:       1,
+		increment:  1,
+		ownerTable: table,
+		ownerField: field,
+	})
+}
+
+func (planner *IndexUpdatePlanner) executeCreateSequence(data sql.CreateSequenceCommand, x tx.Transaction) (int, error) {
+	return 0, planner.mdm.createSequence(x, sequence{
+		name:      data.SequenceName,
+		next:      storage.Long(data.Start),
+		increment: storage.Long(data.Increment),
+	})
+}
+
+func (planner *IndexUpdatePlanner) executeDropSequence(data sql.DropSequenceCommand, x tx.Transaction) (int, error) {
+	return 0, planner.mdm.dropSequence(x, data.SequenceName)
 }
 
 func (planner *IndexUpdatePlanner) executeCreateView(data sql.CreateViewCommand, x tx.Transaction) (int, error) {
 	return 0, planner.mdm.createView(data.ViewName, data.Definition(), x)
 }
+
+// executeDropTable removes the table, its keys, its foreign keys, its indexes
+// and the sequences owned by its fields from the catalogs, and discards its statistics.
+// Their files are removed when the transaction commits.
+// A table referred to by the foreign key of another table cannot be dropped.
+func (planner *IndexUpdatePlanner) executeDropTable(data sql.DropTableCommand, x tx.Transaction) (int, error) {
+	fks, err := planner.mdm.referencingKeys(x, data.TableName)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, fk := range fks {
+		if fk.table != data.TableName {
+			return 0, fmt.Errorf("%w: %q", ErrReferenced, fk.name)
+		}
+	}
+
+	if err := planner.mdm.dropTable(data.TableName, x); err != nil {
+		return 0, err
+	}
+
+	if _, err := planner.mdm.dropKeys(x, data.TableName, ""); err != nil {
+		return 0, err
+	}
+
+	if _, err := planner.mdm.dropForeignKeys(x, data.TableName, ""); err != nil {
+		return 0, err
+	}
+
+	if err := planner.mdm.dropOwnedSequences(x, data.TableName, ""); err != nil {
+		return 0, err
+	}
+
+	indexes, err := planner.mdm.dropIndexes(x, data.TableName, "")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, idx := range indexes {
+		if err := removeIndexFiles(x, idx); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := x.RemoveFile(tableFileName(data.TableName)); err != nil {
+		return 0, err
+	}
+
+	planner.mdm.dropStats(data.TableName)
+
+	return 0, nil
+}
+
+func (planner *IndexUpdatePlanner) executeDropView(data sql.DropViewCommand, x tx.Transaction) (int, error) {
+	return 0, planner.mdm.dropView(data.ViewName, x)
+}
+
+// executeDropIndex removes the index from the catalog.
+// Its files are removed when the transaction commits.
+// The index of a key cannot be dropped, since it enforces the key.
+func (planner *IndexUpdatePlanner) executeDropIndex(data sql.DropIndexCommand, x tx.Transaction) (int, error) {
+	isKey, err := planner.mdm.isKey(x, data.IndexName)
+	if err != nil {
+		return 0, err
+	}
+
+	if isKey {
+		return 0, fmt.Errorf("%w: %q", ErrConstraintIndex, data.IndexName)
+	}
+
+	if err := planner.mdm.dropIndex(x, data.IndexName); err != nil {
+		return 0, err
+	}
+
+	return 0, removeIndexFiles(x, data.IndexName)
+}
+
+// removeIndexFiles removes the files of the index when the transaction commits.
+func removeIndexFiles(x tx.Transaction, idxName string) error {
+	leaf, dir := bTreeIndexFiles(idxName)
+	if err := x.RemoveFile(leaf); err != nil {
+		return err
+	}
+
+	return x.RemoveFile(dir)
+}
@@ -0,0 +1,322 @@
+package engine
+
+import (
+	"container/heap"
+	"io"
+
+	"github.com/luigitni/simpledb/storage"
+)
+
+var (
+	_ Plan = &limitPlan{}
+	_ Scan = &limitScan{}
+	_ Plan = &topNPlan{}
+	_ Scan = &topNScan{}
+)
+
+// topNMaxRecords is the highest number of records a topNPlan keeps in memory.
+// Above it, records are sorted by the external merge sort of the sortPlan.
+const topNMaxRecords = 1024
+
+// limitPlan skips the first offset records of the underlying plan
+// and outputs at most limit of the following ones.
+// The limit scan stops reading from the underlying scan as soon as
+// enough records have been output.
+// When the underlying plan is a sort, only the first limit + offset records
+// of the sorted output are needed: the sort is replaced by a topNPlan,
+// which keeps them in memory instead of sorting the whole input.
+type limitPlan struct {
+	p      Plan
+	limit  int
+	offset int
+}
+
+func newLimitPlan(p Plan, limit int, offset int) *limitPlan {
+	if sp, ok := p.(*sortPlan); ok && limit+offset <= topNMaxRecords {
+		p = newTopNPlan(sp, limit+offset)
+	}
+
+	return &limitPlan{
+		p:      p,
+		limit:  limit,
+		offset: offset,
+	}
+}
+
+func (lp *limitPlan) Open() (Scan, error) {
+	s, err := lp.p.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	return newLimitScan(s, lp.limit, lp.offset), nil
+}
+
+func (lp *limitPlan) BlocksAccessed() int {
+	return lp.p.BlocksAccessed()
+}
+
+func (lp *limitPlan) RecordsOutput() int {
+	return max(0, min(lp.limit, lp.p.RecordsOutput()-lp.offset))
+}
+
+func (lp *limitPlan) DistinctValues(fieldName string) int {
+	return max(1, min(lp.RecordsOutput(), lp.p.DistinctValues(fieldName)))
+}
+
+func (lp *limitPlan) Schema() Schema {
+	return lp.p.Schema()
+}
+
+type limitScan struct {
+	scan   Scan
+	limit  int
+	offset int
+	// read is the number of records read from the underlying scan
+	read int
+}
+
+func newLimitScan(scan Scan, limit int, offset int) *limitScan {
+	return &limitScan{
+		scan:   scan,
+		limit:  limit,
+		offset: offset,
+	}
+}
+
+func (ls *limitScan) BeforeFirst() error {
+	ls.read = 0
+	return ls.scan.BeforeFirst()
+}
+
+func (ls *limitScan) Next() error {
+	for ls.read < ls.offset {
+		if err := ls.scan.Next(); err != nil {
+			return err
+		}
+
+		ls.read++
+	}
+
+	if ls.read >= ls.offset+ls.limit {
+		return io.EOF
+	}
+
+	if err := ls.scan.Next(); err != nil {
+		return err
+	}
+
+	ls.read++
+
+	return nil
+}
+
+func (ls *limitScan) Val(fieldName string) (storage.Value, error) {
+	return ls.scan.Val(fieldName)
+}
+
+func (ls *limitScan) HasField(fieldName string) bool {
+	return ls.scan.HasField(fieldName)
+}
+
+func (ls *limitScan) Type(fieldName string) storage.FieldType {
+	return ls.scan.Type(fieldName)
+}
+
+func (ls *limitScan) Close() {
+	ls.scan.Close()
+}
+
+// memRecord is a copy of a record held in memory.
+type memRecord struct {
+	schema *Schema
+	vals   []storage.Value
+}
+
+func (r memRecord) Val(fieldName string) (storage.Value, error) {
+	info, ok := r.schema.info[fieldName]
+	if !ok {
+		return storage.Value{}, ErrNoField
+	}
+
+	return r.vals[info.Index], nil
+}
+
+// topNPlan outputs the first n records of a sortPlan.
+// It reads the input once and keeps the n records that sort first
+// in a bounded heap, whose root is the one that sorts last among them.
+// Each record that sorts before the root replaces it.
+// Since the records never leave memory, no temporary table is needed.
+type topNPlan struct {
+	sp *sortPlan
+	n  int
+}
+
+func newTopNPlan(sp *sortPlan, n int) *topNPlan {
+	return &topNPlan{
+		sp: sp,
+		n:  n,
+	}
+}
+
+func (tp *topNPlan) Open() (Scan, error) {
+	src, err := tp.sp.p.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer src.Close()
+
+	schema := tp.sp.schema
+	h := &recordHeap{recordComparator: tp.sp.recordComparator}
+
+	for tp.n > 0 {
+		err := src.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		rec := memRecord{
+			schema: &schema,
+			vals:   make([]storage.Value, len(schema.fields)),
+		}
+
+		for _, f := range schema.fields {
+			v, err := src.Val(f)
+			if err != nil {
+				return nil, err
+			}
+
+			rec.vals[schema.info[f].Index] = storage.Copy(v)
+		}
+
+		if h.Len() < tp.n {
+			heap.Push(h, rec)
+		} else {
+			less, err := tp.sp.Less(rec, h.records[0])
+			if err != nil {
+				return nil, err
+			}
+
+			if less {
+				h.records[0] = rec
+				heap.Fix(h, 0)
+			}
+		}
+
+		if h.err != nil {
+			return nil, h.err
+		}
+	}
+
+	// popping the heap returns the records from the last to the first
+	records := make([]memRecord, h.Len())
+	for i := len(records) - 1; i >= 0; i-- {
+		records[i] = heap.Pop(h).(memRecord)
+	}
+
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	return newTopNScan(schema, records), nil
+}
+
+func (tp *topNPlan) BlocksAccessed() int {
+	return tp.sp.p.BlocksAccessed()
+}
+
+func (tp *topNPlan) RecordsOutput() int {
+	return min(tp.n, tp.sp.RecordsOutput())
+}
+
+func (tp *topNPlan) DistinctValues(fieldName string) int {
+	return max(1, min(tp.RecordsOutput(), tp.sp.DistinctValues(fieldName)))
+}
+
+func (tp *topNPlan) Schema() Schema {
+	return tp.sp.Schema()
+}
+
+// recordHeap is a max-heap of records, ordered by the comparator.
+// The first error returned by the comparator is saved in err.
+type recordHeap struct {
+	recordComparator
+	records []memRecord
+	err     error
+}
+
+func (h *recordHeap) Len() int {
+	return len(h.records)
+}
+
+func (h *recordHeap) Less(i, j int) bool {
+	less, err := h.recordComparator.Less(h.records[j], h.records[i])
+	if err != nil && h.err == nil {
+		h.err = err
+	}
+
+	return less
+}
+
+func (h *recordHeap) Swap(i, j int) {
+	h.records[i], h.records[j] = h.records[j], h.records[i]
+}
+
+func (h *recordHeap) Push(x any) {
+	h.records = append(h.records, x.(memRecord))
+}
+
+func (h *recordHeap) Pop() any {
+	last := h.records[len(h.records)-1]
+	h.records = h.records[:len(h.records)-1]
+	return last
+}
+
+// topNScan scans the records sorted by a topNPlan.
+type topNScan struct {
+	schema  Schema
+	records []memRecord
+	current int
+}
+
+func newTopNScan(schema Schema, records []memRecord) *topNScan {
+	return &topNScan{
+		schema:  schema,
+		records: records,
+		current: -1,
+	}
+}
+
+func (ts *topNScan) BeforeFirst() error {
+	ts.current = -1
+	return nil
+}
+
+func (ts *topNScan) Next() error {
+	if ts.current+1 >= len(ts.records) {
+		return io.EOF
+	}
+
+	ts.current++
+
+	return nil
+}
+
+func (ts *topNScan) Val(fieldName string) (storage.Value, error) {
+	return ts.records[ts.current].Val(fieldName)
+}
+
+func (ts *topNScan) HasField(fieldName string) bool {
+	return ts.schema.HasField(fieldName)
+}
+
+func (ts *topNScan) Type(fieldName string) storage.FieldType {
+	return ts.schema.ftype(fieldName)
+}
+
+func (ts *topNScan) Close() {}
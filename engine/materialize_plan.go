@@ -24,6 +24,16 @@ func newMaterializePlan(x tx.Transaction, srcPlan Plan) *materializePlan {
 }
 
 func (mp *materializePlan) Open() (Scan, error) {
+	tmpTable, err := mp.materialize()
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpTable.Open(), nil
+}
+
+// materialize copies the records of the source plan into a new temporary table.
+func (mp *materializePlan) materialize() (*tmpTable, error) {
 	schema := mp.srcPlan.Schema()
 	tmpTable := newTmpTable(mp.x, schema)
 
@@ -35,6 +45,7 @@ func (mp *materializePlan) Open() (Scan, error) {
 	defer src.Close()
 
 	dst := tmpTable.Open()
+	defer dst.Close()
 
 	for {
 		err := src.Next()
@@ -47,13 +58,13 @@ func (mp *materializePlan) Open() (Scan, error) {
 		}
 
 		var size storage.Offset
-		vals := make(map[string]storage.Value)
-		for _, fname := range schema.fields {
+		vals := make([]storage.Value, len(schema.fields))
+		for i, fname := range schema.fields {
 			v, err := src.Val(fname)
 			if err != nil {
 				return nil, err
 			}
-			vals[fname] = v
+			vals[i] = v
 
 			// the schema holds the type of the field
 			// this is retrieved from the catalog
@@ -66,16 +77,14 @@ func (mp *materializePlan) Open() (Scan, error) {
 			return nil, err
 		}
 
-		for fname, v := range vals {
-			dst.SetVal(fname, v)
+		for i, fname := range schema.fields {
+			if err := dst.SetVal(fname, vals[i]); err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	if err := dst.BeforeFirst(); err != nil {
-		return nil, err
-	}
-
-	return dst, nil
+	return tmpTable, nil
 }
 
 func (mp *materializePlan) Schema() Schema {
@@ -0,0 +1,266 @@
+package engine
+
+import (
+	"io"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+var (
+	_ Plan = &mergeJoinPlan{}
+	_ Scan = &mergeJoinScan{}
+)
+
+// mergeJoinPlan joins two plans on the equality of a field of each,
+// by sorting both plans on their join field and merging them.
+// Records of the second plan with the same join field are joined
+// with every record of the first plan with that value:
+// the second scan saves the position of the first record of the group
+// and rewinds to it when the first scan moves to a record with the same value.
+// For this reason, the second plan is always a sortPlan,
+// while the first plan is not sorted again if its output is already sorted.
+// Since NULLs sort last and equal no value, the merge stops at the first NULL.
+type mergeJoinPlan struct {
+	x      tx.Transaction
+	p1     Plan
+	p2     Plan
+	field1 string
+	field2 string
+	// sorted1 is true if the first plan is already sorted on its join field
+	sorted1 bool
+	// sorted2 is true if the second plan is already a sortPlan on its join field
+	sorted2 bool
+	schema  Schema
+}
+
+func newMergeJoinPlan(x tx.Transaction, p1 Plan, p2 Plan, field1 string, field2 string) *mergeJoinPlan {
+	mp := &mergeJoinPlan{
+		x:       x,
+		p1:      p1,
+		p2:      p2,
+		field1:  field1,
+		field2:  field2,
+		sorted1: sortedOn(p1, field1),
+		schema:  newJoinedSchema(p1.Schema(), p2.Schema()),
+	}
+
+	sp, ok := p2.(*sortPlan)
+	mp.sorted2 = ok && sortedOn(sp, field2)
+
+	return mp
+}
+
+// sortedOn returns true if the output of the plan
+// is known to be sorted on the field, in ascending order.
+func sortedOn(p Plan, field string) bool {
+	switch p := p.(type) {
+	case *sortPlan:
+		return len(p.sortKeys) > 0 && p.sortKeys[0] == sql.SortKey{Field: field}
+	case *mergeJoinPlan:
+		return field == p.field1 || field == p.field2
+	case SelectPlan:
+		return sortedOn(p.plan, field)
+	}
+
+	return false
+}
+
+func (mp *mergeJoinPlan) Open() (Scan, error) {
+	p1 := mp.p1
+	if !mp.sorted1 {
+		p1 = newSortPlan(mp.x, mp.p1, ascending([]string{mp.field1}))
+	}
+
+	sp2, _ := mp.p2.(*sortPlan)
+	if !mp.sorted2 {
+		sp2 = newSortPlan(mp.x, mp.p2, ascending([]string{mp.field2}))
+	}
+
+	s1, err := p1.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	s2, err := sp2.Open()
+	if err != nil {
+		s1.Close()
+		return nil, err
+	}
+
+	return newMergeJoinScan(s1, s2.(*sortScan), mp.field1, mp.field2, mp.schema.ftype(mp.field1)), nil
+}
+
+// BlocksAccessed is the cost of reading both inputs.
+// Inputs that are not sorted are also written to
+// and read back from the temporary tables of the sort.
+func (mp *mergeJoinPlan) BlocksAccessed() int {
+	blocks := mp.p1.BlocksAccessed() + mp.p2.BlocksAccessed()
+	if !mp.sorted1 {
+		blocks += 2 * tmpTableBlocks(mp.x, mp.p1)
+	}
+
+	if !mp.sorted2 {
+		blocks += 2 * tmpTableBlocks(mp.x, mp.p2)
+	}
+
+	return blocks
+}
+
+func (mp *mergeJoinPlan) RecordsOutput() int {
+	d := max(mp.p1.DistinctValues(mp.field1), mp.p2.DistinctValues(mp.field2))
+	return mp.p1.RecordsOutput() * mp.p2.RecordsOutput() / max(1, d)
+}
+
+func (mp *mergeJoinPlan) DistinctValues(fieldName string) int {
+	if mp.p1.Schema().HasField(fieldName) {
+		return mp.p1.DistinctValues(fieldName)
+	}
+
+	return mp.p2.DistinctValues(fieldName)
+}
+
+func (mp *mergeJoinPlan) Schema() Schema {
+	return mp.schema
+}
+
+// mergeJoinScan merges two scans sorted on their join fields.
+// joinVal is the join field of the current group of records of s2,
+// whose first record is the saved position of s2.
+type mergeJoinScan struct {
+	s1      Scan
+	s2      *sortScan
+	field1  string
+	field2  string
+	typ     storage.FieldType
+	joinVal storage.Value
+}
+
+func newMergeJoinScan(s1 Scan, s2 *sortScan, field1 string, field2 string, typ storage.FieldType) *mergeJoinScan {
+	return &mergeJoinScan{
+		s1:     s1,
+		s2:     s2,
+		field1: field1,
+		field2: field2,
+		typ:    typ,
+	}
+}
+
+func (ms *mergeJoinScan) BeforeFirst() error {
+	ms.joinVal = storage.Null
+
+	if err := ms.s1.BeforeFirst(); err != nil {
+		return err
+	}
+
+	return ms.s2.BeforeFirst()
+}
+
+// Next moves to the next record of s2 with the current join value,
+// or to the next record of s1, rewinding s2 to the first record of the group
+// if the join value has not changed.
+// Otherwise, it advances the scan whose join field is lower
+// until both scans have the same join field.
+func (ms *mergeJoinScan) Next() error {
+	err2 := ms.s2.Next()
+	if err2 != nil && err2 != io.EOF {
+		return err2
+	}
+
+	if err2 == nil {
+		ok, err := ms.isJoinVal(ms.s2, ms.field2)
+		if err != nil || ok {
+			return err
+		}
+	}
+
+	err1 := ms.s1.Next()
+	if err1 != nil {
+		return err1
+	}
+
+	ok, err := ms.isJoinVal(ms.s1, ms.field1)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		ms.s2.restorePosition()
+		return nil
+	}
+
+	for err1 == nil && err2 == nil {
+		v1, err := ms.s1.Val(ms.field1)
+		if err != nil {
+			return err
+		}
+
+		v2, err := ms.s2.Val(ms.field2)
+		if err != nil {
+			return err
+		}
+
+		// NULLs sort last and match no record
+		if v1.IsNull() || v2.IsNull() {
+			return io.EOF
+		}
+
+		switch {
+		case v1.Less(ms.typ, v2):
+			err1 = ms.s1.Next()
+		case v1.More(ms.typ, v2):
+			err2 = ms.s2.Next()
+		default:
+			ms.s2.savePosition()
+			ms.joinVal = storage.Copy(v2)
+			return nil
+		}
+	}
+
+	if err1 != nil {
+		return err1
+	}
+
+	return err2
+}
+
+// isJoinVal returns true if the join field of the current record
+// of the scan is the current join value.
+func (ms *mergeJoinScan) isJoinVal(s Scan, field string) (bool, error) {
+	if ms.joinVal.IsNull() {
+		return false, nil
+	}
+
+	v, err := s.Val(field)
+	if err != nil {
+		return false, err
+	}
+
+	return v.Equals(ms.joinVal), nil
+}
+
+func (ms *mergeJoinScan) Val(fieldName string) (storage.Value, error) {
+	if ms.s1.HasField(fieldName) {
+		return ms.s1.Val(fieldName)
+	}
+
+	return ms.s2.Val(fieldName)
+}
+
+func (ms *mergeJoinScan) HasField(fieldName string) bool {
+	return ms.s1.HasField(fieldName) || ms.s2.HasField(fieldName)
+}
+
+func (ms *mergeJoinScan) Type(fieldName string) storage.FieldType {
+	if ms.s1.HasField(fieldName) {
+		return ms.s1.Type(fieldName)
+	}
+
+	return ms.s2.Type(fieldName)
+}
+
+func (ms *mergeJoinScan) Close() {
+	ms.s1.Close()
+	ms.s2.Close()
+}
@@ -3,6 +3,7 @@ package engine
 import (
 	"io"
 
+	"github.com/luigitni/simpledb/sql"
 	"github.com/luigitni/simpledb/storage"
 	"github.com/luigitni/simpledb/tx"
 )
@@ -19,19 +20,29 @@ type sortPlan struct {
 	recordComparator
 }
 
-func newSortPlan(x tx.Transaction, plan Plan, sortFields []string) *sortPlan {
+func newSortPlan(x tx.Transaction, plan Plan, sortKeys []sql.SortKey) *sortPlan {
 	schema := plan.Schema()
 	return &sortPlan{
 		p:      plan,
 		x:      x,
 		schema: schema,
 		recordComparator: recordComparator{
-			schema:     schema,
-			sortFields: sortFields,
+			schema:   schema,
+			sortKeys: sortKeys,
 		},
 	}
 }
 
+// ascending returns the sort keys that sort on the fields in ascending order.
+func ascending(fields []string) []sql.SortKey {
+	keys := make([]sql.SortKey, len(fields))
+	for i, f := range fields {
+		keys[i] = sql.SortKey{Field: f}
+	}
+
+	return keys
+}
+
 func (sp *sortPlan) Schema() Schema {
 	return sp.schema
 }
@@ -76,17 +87,31 @@ func (sp *sortPlan) splitIntoRuns(src Scan) ([]*tmpTable, error) {
 		return nil, err
 	}
 
-	if err := src.Next(); err != nil {
-		return nil, err
-	}
-
 	currentTmpTable := newTmpTable(sp.x, sp.schema)
 	tables = append(tables, currentTmpTable)
 
 	currentScan := currentTmpTable.Open()
 
+	// an empty source is sorted into a single empty run
+	if err := src.Next(); err != nil {
+		currentScan.Close()
+		if err == io.EOF {
+			return tables, nil
+		}
+
+		return nil, err
+	}
+
 	for {
-		err := sp.copy(src, currentScan)
+		// the keys of the record are copied before it is moved to the run,
+		// to compare it with the next record of the source
+		last, err := sp.keys(src)
+		if err != nil {
+			currentScan.Close()
+			return nil, err
+		}
+
+		err = sp.copy(src, currentScan)
 		if err == io.EOF {
 			break
 		}
@@ -96,7 +121,7 @@ func (sp *sortPlan) splitIntoRuns(src Scan) ([]*tmpTable, error) {
 			return nil, err
 		}
 
-		less, err := sp.Less(src, currentScan)
+		less, err := sp.Less(src, last)
 		if err != nil {
 			currentScan.Close()
 			return nil, err
@@ -155,6 +180,10 @@ func (sp *sortPlan) merge(first *tmpTable, second *tmpTable) (*tmpTable, error)
 	leftHasMore := left.Next()
 	rightHasMore := right.Next()
 
+	// the keys of the current record of the right run are copied once per record,
+	// rather than at each comparison with the records of the left run
+	var rightKeys memRecord
+
 	for {
 		if leftHasMore != nil && leftHasMore != io.EOF {
 			return nil, leftHasMore
@@ -169,7 +198,16 @@ func (sp *sortPlan) merge(first *tmpTable, second *tmpTable) (*tmpTable, error)
 		}
 
 		if leftHasMore == nil && rightHasMore == nil {
-			less, err := sp.Less(left, right)
+			if rightKeys.vals == nil {
+				keys, err := sp.keys(right)
+				if err != nil {
+					return nil, err
+				}
+
+				rightKeys = keys
+			}
+
+			less, err := sp.Less(left, rightKeys)
 			if err != nil {
 				return nil, err
 			}
@@ -178,6 +216,7 @@ func (sp *sortPlan) merge(first *tmpTable, second *tmpTable) (*tmpTable, error)
 				leftHasMore = sp.copy(left, dst)
 			} else {
 				rightHasMore = sp.copy(right, dst)
+				rightKeys = memRecord{}
 			}
 		} else if rightHasMore == io.EOF {
 			for {
@@ -209,9 +248,9 @@ func (sp *sortPlan) merge(first *tmpTable, second *tmpTable) (*tmpTable, error)
 
 func (sp *sortPlan) copy(src Scan, dst UpdateScan) error {
 	var size storage.Offset
-	vals := make(map[string]storage.Value)
+	vals := make([]storage.Value, len(sp.schema.fields))
 
-	for _, f := range sp.schema.fields {
+	for i, f := range sp.schema.fields {
 		v, err := src.Val(f)
 		if err != nil {
 			return err
@@ -220,15 +259,17 @@ func (sp *sortPlan) copy(src Scan, dst UpdateScan) error {
 		t := sp.schema.ftype(f)
 
 		size += v.Size(t)
-		vals[f] = v
+		vals[i] = storage.Copy(v)
 	}
 
 	if err := dst.Insert(size); err != nil {
 		return err
 	}
 
-	for f, v := range vals {
-		if err := dst.SetVal(f, v); err != nil {
+	// fields are written in the order of the layout,
+	// since the offset of a field depends on the size of the variable length fields before it.
+	for i, f := range sp.schema.fields {
+		if err := dst.SetVal(f, vals[i]); err != nil {
 			return err
 		}
 	}
@@ -249,41 +290,106 @@ func (sp *sortPlan) DistinctValues(fieldName string) int {
 	return sp.p.DistinctValues(fieldName)
 }
 
+// valueReader reads the values of the fields of a record.
+type valueReader interface {
+	Val(fname string) (storage.Value, error)
+}
+
 type recordComparator struct {
-	schema     Schema
-	sortFields []string
+	schema   Schema
+	sortKeys []sql.SortKey
 }
 
-func (rc recordComparator) Less(first Scan, second Scan) (bool, error) {
-	for _, field := range rc.sortFields {
-		f, err := first.Val(field)
+// Less returns true if the current record of first sorts before
+// the current record of second.
+// Records are compared field by field, in the order of the sort keys:
+// the first field on which the records differ decides the order,
+// according to the direction of its key.
+// NULLs are greater than any other value: they sort last in ascending order
+// and first in descending order.
+// The values of first are not copied before those of second are read,
+// so second must not read from a page when first does: it is then a record
+// held in memory, such as the keys copied by keys.
+func (rc recordComparator) Less(first valueReader, second valueReader) (bool, error) {
+	for _, key := range rc.sortKeys {
+		f, err := first.Val(key.Field)
 		if err != nil {
 			return false, err
 		}
 
-		s, err := second.Val(field)
+		s, err := second.Val(key.Field)
 		if err != nil {
 			return false, err
 		}
 
-		t := rc.schema.FieldInfo(field).Type
+		if key.Desc {
+			f, s = s, f
+		}
+
+		if f.IsNull() || s.IsNull() {
+			if f.IsNull() == s.IsNull() {
+				continue
+			}
+
+			return s.IsNull(), nil
+		}
+
+		t := rc.schema.FieldInfo(key.Field).Type
 
 		if f.Less(t, s) {
 			return true, nil
 		}
+
+		if f.More(t, s) {
+			return false, nil
+		}
 	}
 
 	return false, nil
 }
 
+// keys copies the values of the sort keys of the current record of r
+// into a record held in memory, which the other fields are missing from.
+func (rc recordComparator) keys(r valueReader) (memRecord, error) {
+	rec := memRecord{
+		schema: &rc.schema,
+		vals:   make([]storage.Value, len(rc.schema.fields)),
+	}
+
+	for _, key := range rc.sortKeys {
+		v, err := r.Val(key.Field)
+		if err != nil {
+			return memRecord{}, err
+		}
+
+		rec.vals[rc.schema.info[key.Field].Index] = storage.Copy(v)
+	}
+
+	return rec, nil
+}
+
 type sortScan struct {
 	recordComparator
-	firstScan      UpdateScan
-	secondScan     UpdateScan
-	currentScan    UpdateScan
-	firstHasMore   bool
-	secondHasMore  bool
-	savedPositions [2]RID
+	firstScan     UpdateScan
+	secondScan    UpdateScan
+	currentScan   UpdateScan
+	firstHasMore  bool
+	secondHasMore bool
+	// secondKeys are the keys of the current record of the second run,
+	// copied once when the run moves to the record.
+	secondKeys memRecord
+	saved      sortPosition
+}
+
+// sortPosition is the position of a sortScan saved by savePosition:
+// the record of each run, which run holds the current record,
+// whether the runs had more records and the keys of the record of the second run.
+type sortPosition struct {
+	rids          [2]RID
+	currentScan   UpdateScan
+	firstHasMore  bool
+	secondHasMore bool
+	secondKeys    memRecord
 }
 
 func newSortScan(recordComparator recordComparator, runs []*tmpTable) (*sortScan, error) {
@@ -309,12 +415,37 @@ func newSortScan(recordComparator recordComparator, runs []*tmpTable) (*sortScan
 
 		ss.secondHasMore = secondHasMore
 		ss.secondScan = secondScan
+
+		if err := ss.copySecondKeys(); err != nil {
+			return nil, err
+		}
 	}
 
 	return ss, nil
 }
 
+// copySecondKeys copies the keys of the current record of the second run, if any,
+// so that they are compared with the records of the first run without being read again.
+func (ss *sortScan) copySecondKeys() error {
+	if !ss.secondHasMore {
+		return nil
+	}
+
+	keys, err := ss.keys(ss.secondScan)
+	if err != nil {
+		return err
+	}
+
+	ss.secondKeys = keys
+
+	return nil
+}
+
 func (ss *sortScan) BeforeFirst() error {
+	// the runs are positioned on their first records,
+	// so the next call to Next must not advance them
+	ss.currentScan = nil
+
 	if err := ss.firstScan.BeforeFirst(); err != nil {
 		return err
 	}
@@ -337,6 +468,8 @@ func (ss *sortScan) BeforeFirst() error {
 		}
 
 		ss.secondHasMore = hasNext
+
+		return ss.copySecondKeys()
 	}
 
 	return nil
@@ -357,12 +490,16 @@ func (ss *sortScan) Next() error {
 		}
 
 		ss.secondHasMore = hasMore
+
+		if err := ss.copySecondKeys(); err != nil {
+			return err
+		}
 	}
 
 	if !ss.firstHasMore && !ss.secondHasMore {
 		return io.EOF
 	} else if ss.firstHasMore && ss.secondHasMore {
-		less, err := ss.Less(ss.firstScan, ss.secondScan)
+		less, err := ss.Less(ss.firstScan, ss.secondKeys)
 		if err != nil {
 			return err
 		}
@@ -393,19 +530,39 @@ func (ss *sortScan) Val(fieldName string) (storage.Value, error) {
 }
 
 func (ss *sortScan) HasField(fieldName string) bool {
-	return ss.currentScan.HasField(fieldName)
+	return ss.firstScan.HasField(fieldName)
+}
+
+// Type returns the type of the field from the first run,
+// since all the runs share the same schema.
+func (ss *sortScan) Type(fieldName string) storage.FieldType {
+	return ss.firstScan.Type(fieldName)
 }
 
+// savePosition saves the current position of the scan,
+// so that restorePosition can move the scan back to it.
 func (ss *sortScan) savePosition() {
-	ss.savedPositions[0] = ss.firstScan.GetRID()
+	ss.saved = sortPosition{
+		currentScan:   ss.currentScan,
+		firstHasMore:  ss.firstHasMore,
+		secondHasMore: ss.secondHasMore,
+		secondKeys:    ss.secondKeys,
+	}
+
+	ss.saved.rids[0] = ss.firstScan.GetRID()
 	if ss.secondScan != nil {
-		ss.savedPositions[1] = ss.secondScan.GetRID()
+		ss.saved.rids[1] = ss.secondScan.GetRID()
 	}
 }
 
 func (ss *sortScan) restorePosition() {
-	ss.firstScan.MoveToRID(ss.savedPositions[0])
+	ss.currentScan = ss.saved.currentScan
+	ss.firstHasMore = ss.saved.firstHasMore
+	ss.secondHasMore = ss.saved.secondHasMore
+	ss.secondKeys = ss.saved.secondKeys
+
+	ss.firstScan.MoveToRID(ss.saved.rids[0])
 	if ss.secondScan != nil {
-		ss.secondScan.MoveToRID(ss.savedPositions[1])
+		ss.secondScan.MoveToRID(ss.saved.rids[1])
 	}
 }
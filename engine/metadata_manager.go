@@ -11,12 +11,19 @@ import (
 //   - View metadata specifies the properties of each view, like its definition.
 //   - Index metadata keeps track of which fields in which table have an index associated
 //     so that they can be possibly used in planning.
+//   - Constraint metadata describes the keys of each table,
+//     which are enforced through indexes, and the foreign keys that refer to them.
+//   - Sequence metadata describes each sequence, whose next value is kept in a block of its own,
+//     and the blocks of dropped sequences, which are reused by new ones.
 //   - Stats metadata describes the size of each table and the distribution of its
 //     field values.
 type MetadataManager struct {
 	*tableManager
 	*viewManager
 	*indexManager
+	*constraintManager
+	*foreignKeyManager
+	*sequenceManager
 	*statManager
 }
 
@@ -25,12 +32,18 @@ func NewMetadataManager() *MetadataManager {
 	sm := newStatManager(tm)
 	im := newIndexManager(tm, sm)
 	vm := newViewManager(tm)
+	cm := newConstraintManager(tm)
+	fm := newForeignKeyManager(tm)
+	qm := newSequenceManager(tm)
 
 	return &MetadataManager{
-		tableManager: tm,
-		viewManager:  vm,
-		indexManager: im,
-		statManager:  sm,
+		tableManager:      tm,
+		viewManager:       vm,
+		indexManager:      im,
+		constraintManager: cm,
+		foreignKeyManager: fm,
+		sequenceManager:   qm,
+		statManager:       sm,
 	}
 }
 
@@ -51,5 +64,17 @@ func (man *MetadataManager) Init(trans tx.Transaction) error {
 		return err
 	}
 
+	if err := man.constraintManager.init(trans); err != nil {
+		return err
+	}
+
+	if err := man.foreignKeyManager.init(trans); err != nil {
+		return err
+	}
+
+	if err := man.sequenceManager.init(trans); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,278 @@
+package engine
+
+import (
+	"io"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+)
+
+var (
+	_ Plan = &outerJoinPlan{}
+	_ Scan = &outerJoinScan{}
+)
+
+// outerJoinPlan joins two plans on a predicate, like a select over their product,
+// but it also outputs the records of the preserved side that match no record
+// of the other side, padded with NULLs in place of the fields of the other side.
+// A LEFT join preserves the first plan, a RIGHT join the second
+// and a FULL join both.
+// The join is computed as a nested loop: the preserved side is the outer loop,
+// and for a FULL join, the records of the second plan that matched no record
+// are output in a final pass over the second plan.
+type outerJoinPlan struct {
+	kind   sql.JoinKind
+	p1     Plan
+	p2     Plan
+	on     sql.Predicate
+	schema Schema
+}
+
+func newOuterJoinPlan(kind sql.JoinKind, p1 Plan, p2 Plan, on sql.Predicate) (*outerJoinPlan, error) {
+	schema := newJoinedSchema(p1.Schema(), p2.Schema())
+	if !on.AppliesTo(schema) {
+		return nil, ErrNoField
+	}
+
+	return &outerJoinPlan{
+		kind:   kind,
+		p1:     p1,
+		p2:     p2,
+		on:     on,
+		schema: schema,
+	}, nil
+}
+
+// outer returns the plan of the outer loop, which is the preserved one,
+// and the plan of the inner loop.
+func (p *outerJoinPlan) outer() (Plan, Plan) {
+	if p.kind == sql.JoinRight {
+		return p.p2, p.p1
+	}
+
+	return p.p1, p.p2
+}
+
+func (p *outerJoinPlan) Open() (Scan, error) {
+	outer, inner := p.outer()
+
+	os, err := outer.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	is, err := inner.Open()
+	if err != nil {
+		os.Close()
+		return nil, err
+	}
+
+	return newOuterJoinScan(os, is, p.on, p.kind == sql.JoinFull), nil
+}
+
+// BlocksAccessed is the cost of the nested loop: the inner plan
+// is read once for each record of the outer one, and once more for a FULL join.
+func (p *outerJoinPlan) BlocksAccessed() int {
+	outer, inner := p.outer()
+
+	blocks := outer.BlocksAccessed() + outer.RecordsOutput()*inner.BlocksAccessed()
+	if p.kind == sql.JoinFull {
+		blocks += inner.BlocksAccessed()
+	}
+
+	return blocks
+}
+
+// RecordsOutput estimates the records of the inner join,
+// which cannot be less than the records of the preserved sides.
+func (p *outerJoinPlan) RecordsOutput() int {
+	joined := p.p1.RecordsOutput() * p.p2.RecordsOutput() / p.on.ReductionFactor(p)
+
+	switch p.kind {
+	case sql.JoinLeft:
+		return max(joined, p.p1.RecordsOutput())
+	case sql.JoinRight:
+		return max(joined, p.p2.RecordsOutput())
+	}
+
+	return max(joined, p.p1.RecordsOutput()+p.p2.RecordsOutput())
+}
+
+func (p *outerJoinPlan) DistinctValues(fieldName string) int {
+	if p.p1.Schema().HasField(fieldName) {
+		return p.p1.DistinctValues(fieldName)
+	}
+
+	return p.p2.DistinctValues(fieldName)
+}
+
+func (p *outerJoinPlan) Schema() Schema {
+	return p.schema
+}
+
+// outerJoinScan outputs, for each record of the outer scan,
+// the records of the inner scan that satisfy the predicate.
+// If none does, the outer record is output once with NULL inner fields.
+// If preserveInner is true, the records of the inner scan
+// that matched no outer record are output at the end, with NULL outer fields.
+type outerJoinScan struct {
+	outer         Scan
+	inner         Scan
+	on            sql.Predicate
+	preserveInner bool
+
+	// hasOuter is true if the outer scan is on a record
+	hasOuter bool
+	// outerMatched is true if the current outer record matched an inner record
+	outerMatched bool
+	// innerPos is the position of the current inner record in the inner scan
+	innerPos int
+	// innerMatched holds, for each position of the inner scan,
+	// whether the record matched any outer record
+	innerMatched []bool
+	// unmatchedInner is true during the final pass over the inner records
+	unmatchedInner bool
+
+	padOuter bool
+	padInner bool
+}
+
+func newOuterJoinScan(outer Scan, inner Scan, on sql.Predicate, preserveInner bool) *outerJoinScan {
+	return &outerJoinScan{
+		outer:         outer,
+		inner:         inner,
+		on:            on,
+		preserveInner: preserveInner,
+	}
+}
+
+func (s *outerJoinScan) BeforeFirst() error {
+	s.hasOuter = false
+	s.unmatchedInner = false
+	s.padOuter = false
+	s.padInner = false
+	s.innerMatched = s.innerMatched[:0]
+
+	return s.outer.BeforeFirst()
+}
+
+func (s *outerJoinScan) Next() error {
+	if s.unmatchedInner {
+		return s.nextUnmatchedInner()
+	}
+
+	s.padOuter = false
+	s.padInner = false
+
+	for {
+		if !s.hasOuter {
+			err := s.outer.Next()
+			if err == io.EOF && s.preserveInner {
+				return s.startUnmatchedInner()
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if err := s.inner.BeforeFirst(); err != nil {
+				return err
+			}
+
+			s.hasOuter = true
+			s.outerMatched = false
+			s.innerPos = -1
+		}
+
+		err := s.inner.Next()
+		if err == io.EOF {
+			s.hasOuter = false
+			if !s.outerMatched {
+				s.padInner = true
+				return nil
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		s.innerPos++
+		if s.innerPos == len(s.innerMatched) {
+			s.innerMatched = append(s.innerMatched, false)
+		}
+
+		ok, err := s.on.IsSatisfied(s)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			s.outerMatched = true
+			s.innerMatched[s.innerPos] = true
+			return nil
+		}
+	}
+}
+
+func (s *outerJoinScan) startUnmatchedInner() error {
+	s.unmatchedInner = true
+	s.padOuter = true
+	s.innerPos = -1
+
+	if err := s.inner.BeforeFirst(); err != nil {
+		return err
+	}
+
+	return s.nextUnmatchedInner()
+}
+
+func (s *outerJoinScan) nextUnmatchedInner() error {
+	for {
+		if err := s.inner.Next(); err != nil {
+			return err
+		}
+
+		s.innerPos++
+		// with no outer records, the inner records have never been read
+		if s.innerPos >= len(s.innerMatched) || !s.innerMatched[s.innerPos] {
+			return nil
+		}
+	}
+}
+
+// Val returns NULL for the fields of the side that is padded.
+func (s *outerJoinScan) Val(fieldName string) (storage.Value, error) {
+	if s.outer.HasField(fieldName) {
+		if s.padOuter {
+			return storage.Null, nil
+		}
+
+		return s.outer.Val(fieldName)
+	}
+
+	if s.padInner {
+		return storage.Null, nil
+	}
+
+	return s.inner.Val(fieldName)
+}
+
+func (s *outerJoinScan) HasField(fieldName string) bool {
+	return s.outer.HasField(fieldName) || s.inner.HasField(fieldName)
+}
+
+func (s *outerJoinScan) Type(fieldName string) storage.FieldType {
+	if s.outer.HasField(fieldName) {
+		return s.outer.Type(fieldName)
+	}
+
+	return s.inner.Type(fieldName)
+}
+
+func (s *outerJoinScan) Close() {
+	s.outer.Close()
+	s.inner.Close()
+}
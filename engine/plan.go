@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"github.com/luigitni/simpledb/sql"
 	"github.com/luigitni/simpledb/tx"
 )
 
@@ -26,11 +27,16 @@ type Plan interface {
 }
 
 // tablePlan obtains its cost estimates directly from the metadata manager.
+// Within a query, the fields of a table are qualified
+// by the name or the alias of the table, so that tables with fields
+// of the same name can be told apart, as in a self-join.
 type tablePlan struct {
 	tx        tx.Transaction
 	tableName string
 	layout    Layout
 	info      statInfo
+	qualifier string
+	schema    Schema
 }
 
 func newTablePlan(tx tx.Transaction, table string, md *MetadataManager) (tablePlan, error) {
@@ -49,11 +55,28 @@ func newTablePlan(tx tx.Transaction, table string, md *MetadataManager) (tablePl
 		tableName: table,
 		layout:    layout,
 		info:      statInfo,
+		schema:    *layout.Schema(),
 	}, nil
 }
 
+// newQualifiedTablePlan returns a table plan whose fields are qualified by qualifier.
+func newQualifiedTablePlan(tx tx.Transaction, table string, qualifier string, md *MetadataManager) (tablePlan, error) {
+	p, err := newTablePlan(tx, table, md)
+	if err != nil {
+		return tablePlan{}, err
+	}
+
+	p.qualifier = qualifier
+	p.schema = newQualifiedSchema(qualifier, p.schema)
+
+	return p, nil
+}
+
 func (p tablePlan) Open() (Scan, error) {
-	return newTableScan(p.tx, p.tableName, p.layout), nil
+	ts := newTableScan(p.tx, p.tableName, p.layout)
+	ts.qualifier = p.qualifier
+
+	return ts, nil
 }
 
 func (p tablePlan) BlocksAccessed() int {
@@ -66,11 +89,11 @@ func (p tablePlan) RecordsOutput() int {
 }
 
 func (p tablePlan) DistinctValues(fname string) int {
-	return p.info.distinctValues(fname)
+	return p.info.distinctValues(unqualified(fname))
 }
 
 func (p tablePlan) Schema() Schema {
-	return *p.layout.Schema()
+	return p.schema
 }
 
 // SelectPlan plans the cost of a SelectScan.
@@ -129,20 +152,50 @@ func (p SelectPlan) Schema() Schema {
 	return p.plan.Schema()
 }
 
+// ProjectPlan plans a Project scan.
+// Each output field is computed by an expression over the fields of the
+// underlying plan and named after the corresponding name. The type of computed fields
+// is resolved against the schema of the underlying plan.
 type ProjectPlan struct {
-	plan   Plan
-	schema Schema
+	plan        Plan
+	expressions map[string]sql.Expression
+	schema      Schema
 }
 
-func newProjectPlan(p Plan, fields []string) ProjectPlan {
+func newProjectPlan(p Plan, fields []sql.Expression, names []string) (ProjectPlan, error) {
 	schema := newSchema()
-	for _, f := range fields {
-		schema.add(f, p.Schema())
+	expressions := make(map[string]sql.Expression, len(fields))
+	for i, f := range fields {
+		if !f.AppliesTo(p.Schema()) {
+			return ProjectPlan{}, ErrNoField
+		}
+
+		t, err := f.Type(p.Schema())
+		if err != nil {
+			return ProjectPlan{}, err
+		}
+
+		name := names[i]
+		schema.addField(name, t)
+		expressions[name] = f
 	}
+
 	return ProjectPlan{
-		plan:   p,
-		schema: schema,
+		plan:        p,
+		expressions: expressions,
+		schema:      schema,
+	}, nil
+}
+
+// newRenamePlan projects the fields of the plan, in order, onto the given names.
+func newRenamePlan(p Plan, names []string) (ProjectPlan, error) {
+	fields := p.Schema().Fields()
+	exprs := make([]sql.Expression, len(fields))
+	for i, f := range fields {
+		exprs[i] = sql.NewExpressionWithField(f)
 	}
+
+	return newProjectPlan(p, exprs, names)
 }
 
 func (p ProjectPlan) Open() (Scan, error) {
@@ -150,7 +203,7 @@ func (p ProjectPlan) Open() (Scan, error) {
 	if err != nil {
 		return nil, err
 	}
-	return newProjectScan(s, p.schema.fields), nil
+	return newProjectScan(s, p.expressions, p.schema), nil
 }
 
 func (p ProjectPlan) BlocksAccessed() int {
@@ -161,8 +214,21 @@ func (p ProjectPlan) RecordsOutput() int {
 	return p.plan.RecordsOutput()
 }
 
+// DistinctValues returns the distinct values of the underlying field.
+// For computed fields, the estimate is the highest number of distinct
+// values among the fields the expression depends on.
 func (p ProjectPlan) DistinctValues(fiedName string) int {
-	return p.plan.DistinctValues(fiedName)
+	exp := p.expressions[fiedName]
+	if exp.IsFieldName() {
+		return p.plan.DistinctValues(exp.AsFieldName())
+	}
+
+	distinct := 1
+	for _, f := range exp.Fields() {
+		distinct = max(distinct, p.plan.DistinctValues(f))
+	}
+
+	return distinct
 }
 
 func (p ProjectPlan) Schema() Schema {
@@ -200,9 +266,10 @@ func (p ProductPlan) Open() (Scan, error) {
 	return newProduct(p1, p2), nil
 }
 
+// BlocksAccessed is the cost of the nested loop:
+// the second plan is read once for each record of the first one.
 func (p ProductPlan) BlocksAccessed() int {
-	tmp := p.p1.RecordsOutput() * p.p2.BlocksAccessed()
-	return p.p1.BlocksAccessed() * tmp
+	return p.p1.BlocksAccessed() + p.p1.RecordsOutput()*p.p2.BlocksAccessed()
 }
 
 func (p ProductPlan) RecordsOutput() int {
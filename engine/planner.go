@@ -14,17 +14,37 @@ type UpdatePlanner interface {
 	executeCreateTable(data sql.CreateTableCommand, x tx.Transaction) (int, error)
 	executeCreateView(data sql.CreateViewCommand, x tx.Transaction) (int, error)
 	executeCreateIndex(data sql.CreateIndexCommand, x tx.Transaction) (int, error)
+	executeDropTable(data sql.DropTableCommand, x tx.Transaction) (int, error)
+	executeDropView(data sql.DropViewCommand, x tx.Transaction) (int, error)
+	executeDropIndex(data sql.DropIndexCommand, x tx.Transaction) (int, error)
+	executeAlterTable(data sql.AlterTableCommand, x tx.Transaction) (int, error)
+	executeCreateSequence(data sql.CreateSequenceCommand, x tx.Transaction) (int, error)
+	executeDropSequence(data sql.DropSequenceCommand, x tx.Transaction) (int, error)
 }
 
 func NewUpdatePlanner(mdm *MetadataManager) UpdatePlanner {
 	return newIndexUpdatePlanner(mdm)
 }
 
+// ExecuteDMLStatement executes the INSERT, UPDATE or DELETE command in the transaction.
+// A statement that fails is undone as a whole, so that the transaction can go on
+// without the records the statement wrote before it failed.
 func ExecuteDMLStatement(planner UpdatePlanner, cmd sql.Command, x tx.Transaction) (int, error) {
 	if cmd.Type() != sql.CommandTypeDML {
 		return 0, errors.New("invalid command type. Expected DML command")
 	}
 
+	sp := x.Savepoint()
+
+	n, err := executeDML(planner, cmd, x)
+	if err != nil {
+		x.RollbackTo(sp)
+	}
+
+	return n, err
+}
+
+func executeDML(planner UpdatePlanner, cmd sql.Command, x tx.Transaction) (int, error) {
 	switch c := cmd.(type) {
 	case sql.InsertCommand:
 		return planner.executeInsert(c, x)
@@ -49,6 +69,18 @@ func ExecuteDDLStatement(planner UpdatePlanner, cmd sql.Command, x tx.Transactio
 		return planner.executeCreateView(c, x)
 	case sql.CreateIndexCommand:
 		return planner.executeCreateIndex(c, x)
+	case sql.DropTableCommand:
+		return planner.executeDropTable(c, x)
+	case sql.DropViewCommand:
+		return planner.executeDropView(c, x)
+	case sql.DropIndexCommand:
+		return planner.executeDropIndex(c, x)
+	case sql.AlterTableCommand:
+		return planner.executeAlterTable(c, x)
+	case sql.CreateSequenceCommand:
+		return planner.executeCreateSequence(c, x)
+	case sql.DropSequenceCommand:
+		return planner.executeDropSequence(c, x)
 	}
 
 	return 0, errors.New("invalid command type. Expected DML command")
@@ -0,0 +1,2351 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/luigitni/simpledb/buffer"
+	"github.com/luigitni/simpledb/file"
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/test"
+	"github.com/luigitni/simpledb/tx"
+	"github.com/luigitni/simpledb/wal"
+)
+
+// testDB runs SQL statements against a new database,
+// each one in its own transaction.
+type testDB struct {
+	t       *testing.T
+	dir     string
+	buffers int
+	fm      *file.FileManager
+	lm      *wal.WalWriter
+	bm      *buffer.BufferManager
+	mdm     *MetadataManager
+}
+
+func newTestDB(t *testing.T) testDB {
+	return newTestDBWithBuffers(t, 100)
+}
+
+// newTestDBWithBuffers returns a new database whose buffer pool holds the given number of buffers.
+func newTestDBWithBuffers(t *testing.T, buffers int) testDB {
+	conf := test.DefaultConfig(t)
+	conf.BuffersAvailable = buffers
+
+	fm, lm, bm := test.MakeManagersWithConfig(conf)
+
+	db := testDB{t: t, dir: conf.DbFolder, buffers: buffers, fm: fm, lm: lm, bm: bm, mdm: NewMetadataManager()}
+
+	x := db.newTx()
+	if err := db.mdm.Init(x); err != nil {
+		t.Fatal(err)
+	}
+
+	x.Commit()
+
+	return db
+}
+
+// restart opens the files of the database with new managers, as after a crash,
+// and recovers the database. Changes that were not flushed are lost.
+func (db testDB) restart() testDB {
+	conf := test.DefaultConfig(db.t)
+	conf.DbFolder = db.dir
+	conf.BuffersAvailable = db.buffers
+
+	fm, lm, bm := test.MakeManagersWithConfig(conf)
+
+	restarted := testDB{t: db.t, dir: db.dir, buffers: db.buffers, fm: fm, lm: lm, bm: bm, mdm: NewMetadataManager()}
+
+	x := restarted.newTx()
+	x.Recover()
+	x.Commit()
+
+	return restarted
+}
+
+func (db testDB) newTx() tx.Transaction {
+	return tx.NewTx(db.fm, db.lm, db.bm)
+}
+
+// exec executes DDL and DML statements.
+func (db testDB) exec(stmts ...string) {
+	db.t.Helper()
+
+	for _, src := range stmts {
+		if err := db.execute(src, true); err != nil {
+			db.t.Fatalf("error executing %q: %v", src, err)
+		}
+	}
+}
+
+// execute executes a DDL or DML statement in its own transaction,
+// which is committed if commit is true and the statement succeeds, and rolled back otherwise.
+func (db testDB) execute(src string, commit bool) error {
+	db.t.Helper()
+
+	x := db.newTx()
+	if err := db.executeIn(x, src); err != nil || !commit {
+		x.Rollback()
+		return err
+	}
+
+	x.Commit()
+
+	return nil
+}
+
+// executeIn executes a DDL or DML statement in the transaction x,
+// which is left open.
+func (db testDB) executeIn(x tx.Transaction, src string) error {
+	db.t.Helper()
+
+	cmd, err := sql.NewParser(src).Parse()
+	if err != nil {
+		db.t.Fatalf("error parsing %q: %v", src, err)
+	}
+
+	planner := NewUpdatePlanner(db.mdm)
+
+	if cmd.Type() == sql.CommandTypeDDL {
+		_, err = ExecuteDDLStatement(planner, cmd, x)
+	} else {
+		_, err = ExecuteDMLStatement(planner, cmd, x)
+	}
+
+	return err
+}
+
+// exists returns true if the database folder holds the file.
+func (db testDB) exists(fname string) bool {
+	_, err := os.Stat(filepath.Join(db.dir, fname))
+	return err == nil
+}
+
+// indexes returns the indexes defined over the fields of the table.
+func (db testDB) indexes(table string) map[string]*indexInfo {
+	db.t.Helper()
+
+	x := db.newTx()
+	defer x.Commit()
+
+	ii, err := db.mdm.indexInfo(x, table)
+	if err != nil {
+		db.t.Fatal(err)
+	}
+
+	return ii
+}
+
+// query runs the query and returns its rows,
+// with the values of each row joined by commas.
+func (db testDB) query(src string) ([]string, error) {
+	q, err := sql.NewParser(src).Query()
+	if err != nil {
+		return nil, err
+	}
+
+	x := db.newTx()
+	defer x.Commit()
+
+	p, err := NewHeuristicsQueryPlanner(db.mdm).CreatePlan(q, x)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := p.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.Close()
+
+	schema := p.Schema()
+
+	var rows []string
+	for {
+		err := s.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		vals := make([]string, len(schema.fields))
+		for i, f := range schema.fields {
+			v, err := s.Val(f)
+			if err != nil {
+				return nil, err
+			}
+
+			vals[i] = v.String(schema.ftype(f))
+		}
+
+		rows = append(rows, strings.Join(vals, ","))
+	}
+
+	return rows, nil
+}
+
+// expectRows runs the query and compares its rows with the expected ones.
+// If the query is not sorted, rows are compared regardless of their order.
+func (db testDB) expectRows(src string, exp ...string) {
+	db.t.Helper()
+
+	rows, err := db.query(src)
+	if err != nil {
+		db.t.Fatalf("error running %q: %v", src, err)
+	}
+
+	if !strings.Contains(src, "order by") {
+		slices.Sort(rows)
+		slices.Sort(exp)
+	}
+
+	if !slices.Equal(rows, exp) {
+		db.t.Fatalf("unexpected rows for %q:\nexpected %v\ngot      %v", src, exp, rows)
+	}
+}
+
+// planRows opens the plan and returns the values of the fields of its records,
+// joined by commas and sorted.
+func planRows(t *testing.T, p Plan, fields ...string) []string {
+	t.Helper()
+
+	s, err := p.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	return scanRows(t, s, fields...)
+}
+
+// scanRows returns the values of the fields of the records of the scan,
+// joined by commas and sorted.
+func scanRows(t *testing.T, s Scan, fields ...string) []string {
+	t.Helper()
+
+	var rows []string
+	for {
+		err := s.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		vals := make([]string, len(fields))
+		for i, f := range fields {
+			v, err := s.Val(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			vals[i] = v.String(s.Type(f))
+		}
+
+		rows = append(rows, strings.Join(vals, ","))
+	}
+
+	slices.Sort(rows)
+
+	return rows
+}
+
+func TestGroupBy(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table sales (id int, dept text, amount int, qty int)",
+		"insert into sales (id, dept, amount, qty) values (1, 'toys', 10, 1)",
+		"insert into sales (id, dept, amount, qty) values (2, 'toys', 30, 2)",
+		"insert into sales (id, dept, amount, qty) values (3, 'food', 5, 3)",
+		"insert into sales (id, dept, qty) values (4, 'food', 1)",
+		"insert into sales (id, dept, amount, qty) values (5, 'books', 50, 1)",
+		"insert into sales (id, amount, qty) values (6, 7, 1)",
+	)
+
+	db.expectRows(
+		"select count(*), count(amount), sum(amount), min(amount), max(amount), avg(amount) from sales",
+		"6,5,102,5,50,20",
+	)
+
+	db.expectRows(
+		"select dept, count(*), sum(amount) from sales group by dept",
+		"books,1,50", "food,2,5", "toys,2,40", "NULL,1,7",
+	)
+
+	db.expectRows(
+		"select dept, sum(amount * qty) from sales group by dept having sum(amount) > 20",
+		"books,50", "toys,70",
+	)
+
+	db.expectRows(
+		"select dept, max(id) from sales group by dept order by dept",
+		"books,5", "food,4", "toys,2", "NULL,6",
+	)
+
+	db.expectRows("select min(dept), max(dept) from sales", "books,toys")
+
+	// aggregating no records returns a single row,
+	// while grouping no records returns none
+	db.expectRows("select count(*), sum(amount) from sales where id > 100", "0,NULL")
+	db.expectRows("select dept, count(*) from sales where id > 100 group by dept")
+
+	for src, exp := range map[string]error{
+		"select id, count(*) from sales group by dept": ErrNoField,
+		"select sum(dept) from sales":                  sql.ErrInvalidOperandType,
+	} {
+		if _, err := db.query(src); err != exp {
+			t.Fatalf("expected %v for %q, got %v", exp, src, err)
+		}
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table sales (id int, dept text, qty int)",
+		"insert into sales (id, dept, qty) values (1, 'toys', 1)",
+		"insert into sales (id, dept, qty) values (2, 'toys', 2)",
+		"insert into sales (id, dept, qty) values (3, 'food', 1)",
+		"insert into sales (id, dept, qty) values (4, 'toys', 1)",
+		"insert into sales (id, qty) values (5, 1)",
+		"insert into sales (id, qty) values (6, 1)",
+	)
+
+	db.expectRows("select distinct dept from sales", "food", "toys", "NULL")
+	db.expectRows("select distinct dept, qty from sales", "food,1", "toys,1", "toys,2", "NULL,1")
+	db.expectRows("select distinct qty, dept from sales order by qty", "1,food", "1,toys", "1,NULL", "2,toys")
+	db.expectRows("select distinct qty * 2 from sales", "2", "4")
+	db.expectRows("select distinct count(*) from sales group by dept", "1", "3", "2")
+	db.expectRows("select distinct dept from sales where id > 100")
+}
+
+func TestLimit(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec("create table nums (n int, tag text)")
+	for _, n := range []string{"5", "3", "9", "1", "7", "NULL", "2"} {
+		db.exec("insert into nums (n, tag) values (" + n + ", 'x')")
+	}
+
+	db.expectRows("select n from nums order by n limit 3", "1", "2", "3")
+	db.expectRows("select n from nums order by n limit 3 offset 2", "3", "5", "7")
+	db.expectRows("select n from nums order by n limit 10 offset 5", "9", "NULL")
+	db.expectRows("select n from nums order by n limit 0")
+	db.expectRows("select n from nums order by n limit 2 offset 10")
+	db.expectRows("select distinct tag from nums order by tag limit 5", "x")
+
+	rows, err := db.query("select n from nums limit 4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 rows, got %v", rows)
+	}
+}
+
+func TestTopN(t *testing.T) {
+	db := newTestDB(t)
+	db.exec("create table nums (n int)")
+
+	x := db.newTx()
+	defer x.Commit()
+
+	p, err := newTablePlan(x, "nums", db.mdm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := p.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	us := s.(UpdateScan)
+	for _, n := range []storage.Int{8, 3, 11, 3, 0, 6, 1, 9} {
+		if err := us.Insert(storage.SizeOfInt); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := us.SetVal("n", storage.ValueFromInteger(storage.SizeOfInt, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	us.Close()
+
+	lp := newLimitPlan(newSortPlan(x, p, ascending([]string{"n"})), 4, 1)
+	if _, ok := lp.p.(*topNPlan); !ok {
+		t.Fatalf("expected a top-n plan, got %T", lp.p)
+	}
+
+	ls, err := lp.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ls.Close()
+
+	var got []storage.Int
+	for {
+		err := ls.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := ls.Val("n")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, v.AsFixedLen().AsInt())
+	}
+
+	if exp := []storage.Int{1, 3, 3, 6}; !slices.Equal(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestOrderByDirection(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec("create table people (id int, name text, age int)")
+
+	// records are inserted out of order, so that the sort
+	// splits them into several runs and merges them
+	ids := []int{7, 2, 9, 4, 1, 8, 3, 6, 5, 0}
+	names := []string{"'ann'", "'bob'", "'cy'", "NULL"}
+	for _, id := range ids {
+		db.exec(fmt.Sprintf(
+			"insert into people (id, name, age) values (%d, %s, %d)",
+			id, names[id%len(names)], 20+id%3,
+		))
+	}
+
+	db.expectRows(
+		"select id from people order by id desc",
+		"9", "8", "7", "6", "5", "4", "3", "2", "1", "0",
+	)
+
+	db.expectRows(
+		"select age, id from people order by age desc, id asc",
+		"22,2", "22,5", "22,8", "21,1", "21,4", "21,7", "20,0", "20,3", "20,6", "20,9",
+	)
+
+	// NULLs sort first in descending order
+	db.expectRows(
+		"select name, id from people order by name desc, id desc",
+		"NULL,7", "NULL,3", "cy,6", "cy,2", "bob,9", "bob,5", "bob,1", "ann,8", "ann,4", "ann,0",
+	)
+
+	db.expectRows("select id from people order by id desc limit 3 offset 1", "8", "7", "6")
+	db.expectRows("select distinct age from people order by age desc", "22", "21", "20")
+}
+
+func TestSelectStarAndAliases(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table items (id int, name text, price int, qty int)",
+		"insert into items (id, name, price, qty) values (1, 'pen', 2, 10)",
+		"insert into items (id, name, price, qty) values (2, 'ink', 7, 3)",
+		"insert into items (id, name, qty) values (3, 'cap', 1)",
+	)
+
+	db.expectRows("select * from items order by id", "1,pen,2,10", "2,ink,7,3", "3,cap,NULL,1")
+	db.expectRows("select distinct * from items where id = 2", "2,ink,7,3")
+
+	db.expectRows(
+		"select name as item, price * qty as total from items order by total desc",
+		"cap,NULL", "ink,21", "pen,20",
+	)
+
+	x := db.newTx()
+	defer x.Commit()
+
+	for src, exp := range map[string][]string{
+		"select id as key, name from items": {"key", "name"},
+		"select * from items":               {"id", "name", "price", "qty"},
+	} {
+		q, err := sql.NewParser(src).Query()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := NewHeuristicsQueryPlanner(db.mdm).CreatePlan(q, x)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(p.Schema().Fields(), exp) {
+			t.Fatalf("expected fields %v for %q, got %v", exp, src, p.Schema().Fields())
+		}
+	}
+}
+
+func TestQualifiedNames(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table users (id int, name text, manager int)",
+		"create table orders (id int, user_id int, total int)",
+		// indexes let the planner pick index selects and index joins
+		"create index users_id on users (id)",
+		"create index orders_user_id on orders (user_id)",
+		"insert into users (id, name) values (1, 'ada')",
+		"insert into users (id, name, manager) values (2, 'bob', 1)",
+		"insert into users (id, name, manager) values (3, 'cyd', 1)",
+		"insert into orders (id, user_id, total) values (10, 1, 5)",
+		"insert into orders (id, user_id, total) values (11, 2, 7)",
+		"insert into orders (id, user_id, total) values (12, 2, 9)",
+	)
+
+	db.expectRows(
+		"select u.id, o.id, total from users u, orders o where u.id = o.user_id",
+		"1,10,5", "2,11,7", "2,12,9",
+	)
+
+	db.expectRows(
+		"select name, o.id from users as u, orders o where u.id = o.user_id and o.total > 5 order by o.id desc",
+		"bob,12", "bob,11",
+	)
+
+	db.expectRows(
+		"select users.name, sum(orders.total) from users, orders where users.id = orders.user_id group by users.name",
+		"ada,5", "bob,16",
+	)
+
+	// self-join of the same table under different aliases
+	db.expectRows(
+		"select e.name, m.name from users e, users m where e.manager = m.id order by e.name",
+		"bob,ada", "cyd,ada",
+	)
+
+	db.expectRows("select * from users u, orders o where u.id = 1 and o.id = 10", "1,ada,NULL,10,1,5")
+
+	for src, exp := range map[string]error{
+		"select id from users, orders":            ErrAmbiguousField,
+		"select u.total from users u, orders o":   ErrNoField,
+		"select users.id from users u":            ErrNoField,
+		"select id from users, users":             ErrDuplicateTable,
+		"select id from users u order by o.id":    ErrNoField,
+		"select u.id from users u where x.id = 1": ErrNoField,
+	} {
+		if _, err := db.query(src); err != exp {
+			t.Fatalf("expected %v for %q, got %v", exp, src, err)
+		}
+	}
+
+	q, err := sql.NewParser("select * from users u, orders o").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := db.newTx()
+	defer x.Commit()
+
+	p, err := NewBasicQueryPlanner(db.mdm).CreatePlan(q, x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"u.id", "name", "manager", "o.id", "user_id", "total"}
+	if !slices.Equal(p.Schema().Fields(), exp) {
+		t.Fatalf("expected fields %v, got %v", exp, p.Schema().Fields())
+	}
+}
+
+func TestJoins(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table customers (id int, name text)",
+		"create table orders (id int, customer_id int, total int)",
+		"insert into customers (id, name) values (1, 'ada')",
+		"insert into customers (id, name) values (2, 'bob')",
+		"insert into customers (id, name) values (3, 'cyd')",
+		"insert into orders (id, customer_id, total) values (10, 1, 5)",
+		"insert into orders (id, customer_id, total) values (11, 1, 7)",
+		"insert into orders (id, customer_id, total) values (12, 3, 9)",
+		"insert into orders (id, customer_id, total) values (13, 4, 2)",
+		"insert into orders (id, total) values (14, 1)",
+	)
+
+	db.expectRows(
+		"select name, o.id from customers c join orders o on c.id = o.customer_id",
+		"ada,10", "ada,11", "cyd,12",
+	)
+
+	db.expectRows(
+		"select name, o.id from customers c left join orders o on c.id = o.customer_id",
+		"ada,10", "ada,11", "bob,NULL", "cyd,12",
+	)
+
+	// customers with zero orders
+	db.expectRows(
+		"select name from customers c left outer join orders o on c.id = o.customer_id where o.id is null",
+		"bob",
+	)
+
+	db.expectRows(
+		"select c.name, count(o.id) from customers c left join orders o on c.id = o.customer_id group by c.name",
+		"ada,2", "bob,0", "cyd,1",
+	)
+
+	db.expectRows(
+		"select name, o.id from customers c right join orders o on c.id = o.customer_id",
+		"ada,10", "ada,11", "cyd,12", "NULL,13", "NULL,14",
+	)
+
+	db.expectRows(
+		"select name, o.id from customers c full outer join orders o on c.id = o.customer_id",
+		"ada,10", "ada,11", "bob,NULL", "cyd,12", "NULL,13", "NULL,14",
+	)
+
+	// the ON predicate is applied while joining, the WHERE predicate after
+	db.expectRows(
+		"select name, o.id from customers c left join orders o on c.id = o.customer_id and o.total > 6",
+		"ada,11", "bob,NULL", "cyd,12",
+	)
+
+	db.expectRows(
+		"select name, o.id from customers c left join orders o on c.id = o.customer_id where o.total > 6",
+		"ada,11", "cyd,12",
+	)
+
+	// joins associate to the left
+	db.expectRows(
+		"select c1.name, c2.name from customers c1 left join orders o on c1.id = o.customer_id left join customers c2 on o.total = c2.id",
+		"ada,NULL", "ada,NULL", "bob,NULL", "cyd,NULL",
+	)
+
+	db.expectRows(
+		"select o.id, c2.name from orders o left join customers c1 on c1.id = o.customer_id join customers c2 on c2.id = o.total",
+		"13,bob", "14,ada",
+	)
+
+	db.expectRows(
+		"select name, o.id from customers c full join orders o on 1 = 0 where c.id = 2 or o.id = 13",
+		"bob,NULL", "NULL,13",
+	)
+
+	if _, err := db.query("select name from customers c left join orders o on c.id = x.customer_id"); err != ErrNoField {
+		t.Fatalf("expected %v, got %v", ErrNoField, err)
+	}
+}
+
+func TestHashJoin(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table customers (id int, name text)",
+		"create table orders (id int, customer_id int)",
+		"insert into customers (id, name) values (1, 'ada')",
+		"insert into customers (id, name) values (2, 'bob')",
+		"insert into customers (id, name) values (3, 'cyd')",
+		"insert into customers (name) values ('dan')",
+		"insert into orders (id, customer_id) values (10, 1)",
+		"insert into orders (id, customer_id) values (11, 1)",
+		"insert into orders (id, customer_id) values (12, 3)",
+		"insert into orders (id, customer_id) values (13, 4)",
+		"insert into orders (id) values (14)",
+	)
+
+	x := db.newTx()
+	defer x.Commit()
+
+	// the plans are costed from the statistics of the tables, with their records
+	if err := db.mdm.refreshStatistics(x); err != nil {
+		t.Fatal(err)
+	}
+
+	customers, err := newQualifiedTablePlan(x, "customers", "c", db.mdm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := newQualifiedTablePlan(x, "orders", "o", db.mdm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"ada,10", "ada,11", "cyd,12"}
+
+	// a budget of a few bytes makes the join spill to partitions
+	for _, budget := range []int{hashJoinMemoryBudget, 16} {
+		hp := newHashJoinPlan(x, customers, orders, "c.id", "o.customer_id")
+		hp.budget = budget
+
+		rows := planRows(t, hp, "c.name", "o.id")
+		if !slices.Equal(rows, exp) {
+			t.Fatalf("unexpected rows with a budget of %d bytes:\nexpected %v\ngot      %v", budget, exp, rows)
+		}
+	}
+
+	pred, err := sql.NewParser("select c.name from customers c, orders o where c.id = o.customer_id").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	planner, err := newTablePlanner(x, orders, pred.Predicate(), db.mdm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := planner.makeJoinPlan(customers)
+	sp, ok := p.(SelectPlan)
+	if !ok {
+		t.Fatalf("expected a select plan, got %T", p)
+	}
+
+	if _, ok := sp.plan.(*hashJoinPlan); !ok {
+		t.Fatalf("expected a hash join plan, got %T", sp.plan)
+	}
+
+	db.expectRows(
+		"select name, o.id from customers c, orders o where c.id = o.customer_id",
+		exp...,
+	)
+}
+
+func TestHashJoinSpill(t *testing.T) {
+	// with few buffers, the product of the tables reads the orders many times,
+	// while the joins write and read back both tables once
+	db := newTestDBWithBuffers(t, 10)
+
+	db.exec(
+		"create table customers (id int, name text)",
+		"create table orders (id int, customer_id int)",
+	)
+
+	// the customers, which are fewer than the orders and make the build side,
+	// are estimated to take, and take, more bytes than the memory budget of the hash join
+	const rows = 2000
+	padding := strings.Repeat("x", 100)
+	for i := 0; i < rows; i += 100 {
+		var customers, orders []string
+		for j := i; j < i+100; j++ {
+			customers = append(customers, fmt.Sprintf("(%d, 'customer %d %s')", j, j, padding))
+			orders = append(orders, fmt.Sprintf("(%d, %d), (%d, %d)", j, j, rows+j, j))
+		}
+
+		db.exec(
+			"insert into customers values "+strings.Join(customers, ", "),
+			"insert into orders values "+strings.Join(orders, ", "),
+		)
+	}
+
+	x := db.newTx()
+	if err := db.mdm.refreshStatistics(x); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := sql.NewParser("select name, o.id from customers c, orders o where c.id = o.customer_id").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewHeuristicsQueryPlanner(db.mdm).CreatePlan(q, x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the hash join would write and read back both tables, as the merge join does to sort them,
+	// and on ties the planner prefers the merge join
+	var mp *mergeJoinPlan
+	for plan := p; mp == nil; {
+		switch pp := plan.(type) {
+		case ProjectPlan:
+			plan = pp.plan
+		case SelectPlan:
+			plan = pp.plan
+		case *mergeJoinPlan:
+			mp = pp
+		default:
+			t.Fatalf("expected a merge join plan, got %T", plan)
+		}
+	}
+
+	hp := newHashJoinPlan(x, mp.p1, mp.p2, mp.field1, mp.field2)
+	if hp.fitsInMemory() {
+		t.Fatalf("expected the build side of the hash join not to fit in memory")
+	}
+
+	if hp.BlocksAccessed() < mp.BlocksAccessed() {
+		t.Fatalf("expected the hash join to access at least %d blocks, got %d", mp.BlocksAccessed(), hp.BlocksAccessed())
+	}
+
+	s, err := hp.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hs := s.(*hashJoinScan); len(hs.parts) == 0 {
+		t.Fatalf("expected the hash join to spill to partitions")
+	}
+
+	var exp []string
+	for j := 0; j < 2*rows; j++ {
+		exp = append(exp, fmt.Sprintf("customer %d %s,%d", j%rows, padding, j))
+	}
+
+	slices.Sort(exp)
+	if rows := scanRows(t, s, "c.name", "o.id"); !slices.Equal(rows, exp) {
+		t.Fatalf("unexpected rows from the hash join:\nexpected %d rows\ngot      %d", len(exp), len(rows))
+	}
+
+	s.Close()
+	x.Commit()
+
+	db.expectRows("select name, o.id from customers c, orders o where c.id = o.customer_id", exp...)
+}
+
+func TestHashJoinRepartition(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table customers (id int, name text)",
+		"create table orders (id int, customer_id int)",
+		"create table notes (customer_id int, note text)",
+		"insert into customers values (0, 'customer 0')",
+		"insert into orders values (0, 0)",
+	)
+
+	x := db.newTx()
+	if err := db.mdm.refreshStatistics(x); err != nil {
+		t.Fatal(err)
+	}
+
+	x.Commit()
+
+	// the statistics are not refreshed after the inserts,
+	// so the hash join expects the customers to fit in fewer partitions than they need
+	const rows = 1000
+	for i := 1; i < rows; i += 100 {
+		var customers, orders []string
+		for j := i; j < min(i+100, rows); j++ {
+			customers = append(customers, fmt.Sprintf("(%d, 'customer %d')", j, j))
+			orders = append(orders, fmt.Sprintf("(%d, %d), (%d, %d)", j, j, rows+j, j))
+		}
+
+		db.exec(
+			"insert into customers values "+strings.Join(customers, ", "),
+			"insert into orders values "+strings.Join(orders, ", "),
+		)
+	}
+
+	// the notes of a customer share the same join value, so their partition cannot be split
+	var notes []string
+	for j := range 300 {
+		notes = append(notes, fmt.Sprintf("(7, 'note %d')", j))
+	}
+
+	db.exec("insert into notes values " + strings.Join(notes, ", "))
+
+	tmpFiles := func() []string {
+		entries, err := os.ReadDir(db.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var files []string
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), strings.TrimSuffix(file.TmpTablePrefix, "%d")) {
+				files = append(files, e.Name())
+			}
+		}
+
+		return files
+	}
+
+	x = db.newTx()
+
+	customers, err := newQualifiedTablePlan(x, "customers", "c", db.mdm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := newQualifiedTablePlan(x, "orders", "o", db.mdm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hp := newHashJoinPlan(x, customers, orders, "c.id", "o.customer_id")
+	hp.budget = 4096
+
+	s, err := hp.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var exp []string
+	for j := 0; j < rows; j++ {
+		exp = append(exp, fmt.Sprintf("customer %d,%d", j, j))
+		if j > 0 {
+			exp = append(exp, fmt.Sprintf("customer %d,%d", j, rows+j))
+		}
+	}
+
+	slices.Sort(exp)
+	if rows := scanRows(t, s, "c.name", "o.id"); !slices.Equal(rows, exp) {
+		t.Fatalf("unexpected rows from the hash join:\nexpected %d rows\ngot      %d", len(exp), len(rows))
+	}
+
+	hs := s.(*hashJoinScan)
+	if !slices.ContainsFunc(hs.parts, func(p hashPartition) bool { return p.level > 0 }) {
+		t.Fatalf("expected the partitions that do not fit to be partitioned again")
+	}
+
+	// the notes, read with the statistics of an empty table, make the build side:
+	// they are joined a budget at a time once they cannot be partitioned further
+	notesPlan, err := newQualifiedTablePlan(x, "notes", "n", db.mdm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hp = newHashJoinPlan(x, customers, notesPlan, "c.id", "n.customer_id")
+	hp.budget = 1024
+
+	if build, _, _, _ := hp.build(); !build.Schema().HasField("n.note") {
+		t.Fatalf("expected the notes to make the build side")
+	}
+
+	ns, err := hp.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp = nil
+	for j := range 300 {
+		exp = append(exp, fmt.Sprintf("customer 7,note %d", j))
+	}
+
+	slices.Sort(exp)
+	if rows := scanRows(t, ns, "c.name", "n.note"); !slices.Equal(rows, exp) {
+		t.Fatalf("unexpected rows from the hash join of the notes:\nexpected %d rows\ngot      %d", len(exp), len(rows))
+	}
+
+	hs = ns.(*hashJoinScan)
+	if !slices.ContainsFunc(hs.parts, func(p hashPartition) bool { return p.level == hashJoinMaxLevel }) {
+		t.Fatalf("expected the partition of the notes to be partitioned %d times", hashJoinMaxLevel)
+	}
+
+	s.Close()
+	ns.Close()
+	x.Commit()
+
+	if files := tmpFiles(); len(files) > 0 {
+		t.Fatalf("expected the partitions to be dropped, found %v", files)
+	}
+}
+
+func TestMergeJoin(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table customers (id int, name text)",
+		"create table orders (id int, customer_id int)",
+		"insert into customers (id, name) values (3, 'cyd')",
+		"insert into customers (id, name) values (1, 'ada')",
+		"insert into customers (name) values ('dan')",
+		"insert into customers (id, name) values (2, 'bob')",
+		"insert into customers (id, name) values (1, 'amy')",
+		"insert into orders (id, customer_id) values (12, 3)",
+		"insert into orders (id) values (14)",
+		"insert into orders (id, customer_id) values (10, 1)",
+		"insert into orders (id, customer_id) values (13, 4)",
+		"insert into orders (id, customer_id) values (11, 1)",
+	)
+
+	x := db.newTx()
+	defer x.Commit()
+
+	if err := db.mdm.refreshStatistics(x); err != nil {
+		t.Fatal(err)
+	}
+
+	customers, err := newQualifiedTablePlan(x, "customers", "c", db.mdm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := newQualifiedTablePlan(x, "orders", "o", db.mdm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// duplicate join values on both sides rewind the second scan
+	exp := []string{"ada,10", "ada,11", "amy,10", "amy,11", "cyd,12"}
+
+	mp := newMergeJoinPlan(x, customers, orders, "c.id", "o.customer_id")
+	if rows := planRows(t, mp, "c.name", "o.id"); !slices.Equal(rows, exp) {
+		t.Fatalf("unexpected rows:\nexpected %v\ngot      %v", exp, rows)
+	}
+
+	mp = newMergeJoinPlan(x, orders, customers, "o.customer_id", "c.id")
+	if rows := planRows(t, mp, "c.name", "o.id"); !slices.Equal(rows, exp) {
+		t.Fatalf("unexpected rows with swapped inputs:\nexpected %v\ngot      %v", exp, rows)
+	}
+
+	// a plan already sorted on the join field is merged without sorting it again
+	sorted := newSortPlan(x, customers, ascending([]string{"c.id"}))
+
+	mp = newMergeJoinPlan(x, sorted, orders, "c.id", "o.customer_id")
+	if !mp.sorted1 {
+		t.Fatalf("expected the first input of the merge join to be sorted")
+	}
+
+	unsorted := newMergeJoinPlan(x, customers, orders, "c.id", "o.customer_id")
+	if got, max := mp.BlocksAccessed()-sorted.BlocksAccessed(), unsorted.BlocksAccessed()-customers.BlocksAccessed(); got >= max {
+		t.Fatalf("expected the sorted input not to be sorted again, got %d blocks over the inputs, expected fewer than %d", got, max)
+	}
+
+	if rows := planRows(t, mp, "c.name", "o.id"); !slices.Equal(rows, exp) {
+		t.Fatalf("unexpected rows:\nexpected %v\ngot      %v", exp, rows)
+	}
+}
+
+func TestBlockProduct(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table letters (l text)",
+		"create table nums (n int)",
+		"insert into nums (n) values (1)",
+		"insert into nums (n) values (2)",
+	)
+
+	// records large enough for the letters to span several blocks
+	padding := strings.Repeat("x", 1000)
+	var exp []string
+	for i := 0; i < 40; i++ {
+		l := fmt.Sprintf("%s%02d", padding, i)
+		db.exec(fmt.Sprintf("insert into letters (l) values ('%s')", l))
+		exp = append(exp, l+",1", l+",2")
+	}
+
+	slices.Sort(exp)
+
+	x := db.newTx()
+	defer x.Commit()
+
+	if err := db.mdm.refreshStatistics(x); err != nil {
+		t.Fatal(err)
+	}
+
+	letters, err := newTablePlan(x, "letters", db.mdm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nums, err := newTablePlan(x, "nums", db.mdm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bp := newBlockProductPlan(x, letters, nums)
+	if rows := planRows(t, bp, "l", "n"); !slices.Equal(rows, exp) {
+		t.Fatalf("unexpected rows:\nexpected %v\ngot      %v", exp, rows)
+	}
+
+	if pp := newProductPlan(letters, nums); bp.BlocksAccessed() >= pp.BlocksAccessed() {
+		t.Fatalf("expected the block product to access fewer blocks than the product, got %d and %d", bp.BlocksAccessed(), pp.BlocksAccessed())
+	}
+
+	// chunks of a single block read nums once for each block of letters
+	tt, err := newMaterializePlan(x, letters).materialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := x.Size(tt.fileName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blocks < 2 {
+		t.Fatalf("expected letters to span several blocks, got %d", blocks)
+	}
+
+	rhs, err := nums.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newBlockProductScan(x, tt, int(blocks), 1, rhs)
+	defer s.Close()
+
+	if rows := scanRows(t, s, "l", "n"); !slices.Equal(rows, exp) {
+		t.Fatalf("unexpected rows with single block chunks:\nexpected %v\ngot      %v", exp, rows)
+	}
+
+	db.expectRows("select l, n from letters, nums", exp...)
+
+	for _, tc := range []struct{ available, blocks, exp int }{
+		{available: 3, blocks: 10, exp: 1},
+		{available: 12, blocks: 10, exp: 10},
+		{available: 8, blocks: 10, exp: 5},
+		{available: 5, blocks: 10, exp: 3},
+	} {
+		if got := chunkSize(tc.available, tc.blocks); got != tc.exp {
+			t.Fatalf("expected chunks of %d blocks for %d available buffers and %d blocks, got %d", tc.exp, tc.available, tc.blocks, got)
+		}
+	}
+}
+
+func TestSubqueries(t *testing.T) {
+	db := newTestDB(t)
+
+	// correlated subqueries on orders join their outer record through the index
+	db.exec(
+		"create table customers (id int, name text)",
+		"create table orders (id int, customer_id int, total int)",
+		"create index orders_customer on orders (customer_id)",
+		"insert into customers (id, name) values (1, 'ada')",
+		"insert into customers (id, name) values (2, 'bob')",
+		"insert into customers (id, name) values (3, 'cyd')",
+		"insert into customers (name) values ('dan')",
+		"insert into orders (id, customer_id, total) values (10, 1, 5)",
+		"insert into orders (id, customer_id, total) values (11, 1, 7)",
+		"insert into orders (id, customer_id, total) values (12, 3, 9)",
+		"insert into orders (id, customer_id, total) values (13, 4, 2)",
+	)
+
+	db.expectRows(
+		"select name from customers where id in (select customer_id from orders)",
+		"ada", "cyd",
+	)
+
+	db.expectRows(
+		"select name from customers where id not in (select customer_id from orders)",
+		"bob",
+	)
+
+	db.expectRows(
+		"select name from customers where id not in (select customer_id from orders where total > 100)",
+		"ada", "bob", "cyd", "dan",
+	)
+
+	db.expectRows(
+		"select name from customers c where exists (select id from orders o where o.customer_id = c.id and total > 6)",
+		"ada", "cyd",
+	)
+
+	db.expectRows(
+		"select name from customers c where not exists (select * from orders where customer_id = c.id)",
+		"bob", "dan",
+	)
+
+	db.expectRows(
+		"select name, (select max(total) from orders o where o.customer_id = c.id) as top from customers c",
+		"ada,7", "bob,NULL", "cyd,9", "dan,NULL",
+	)
+
+	db.expectRows(
+		"select id from orders where total > (select min(total) from orders) + 4",
+		"11", "12",
+	)
+
+	db.expectRows(
+		"select name from customers c where id in (select customer_id from orders o where o.total > c.id * 3)",
+		"ada",
+	)
+
+	// the innermost subquery refers to the outermost query
+	db.expectRows(
+		"select name from customers c where exists (select id from orders o where o.customer_id = c.id and o.total = (select max(total) from orders where customer_id = c.id) and total > 6)",
+		"ada", "cyd",
+	)
+
+	if _, err := db.query("select name from customers where id = (select customer_id from orders)"); err != sql.ErrSubqueryRows {
+		t.Fatalf("expected %v, got %v", sql.ErrSubqueryRows, err)
+	}
+
+	if _, err := db.query("select name from customers where id in (select id, total from orders)"); err != sql.ErrSubqueryColumns {
+		t.Fatalf("expected %v, got %v", sql.ErrSubqueryColumns, err)
+	}
+
+	if _, err := db.query("select name from customers where id in (select x from orders)"); err != ErrNoField {
+		t.Fatalf("expected %v, got %v", ErrNoField, err)
+	}
+
+	x := db.newTx()
+	defer x.Commit()
+
+	// the planners agree on the records, while only the heuristics planner
+	// evaluates the uncorrelated IN with a semi-join
+	q, err := sql.NewParser("select name from customers c where id in (select customer_id from orders) and exists (select id from orders o where o.customer_id = c.id and o.total > 8)").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, planner := range []QueryPlanner{NewBasicQueryPlanner(db.mdm), NewHeuristicsQueryPlanner(db.mdm)} {
+		p, err := planner.CreatePlan(q, x)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rows := planRows(t, p, "name"); !slices.Equal(rows, []string{"cyd"}) {
+			t.Fatalf("unexpected rows %v", rows)
+		}
+	}
+
+	p, err := NewHeuristicsQueryPlanner(db.mdm).CreatePlan(q, x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pp, ok := p.(ProjectPlan)
+	if !ok {
+		t.Fatalf("expected a project plan, got %T", p)
+	}
+
+	if _, ok := pp.plan.(*semiJoinPlan); !ok {
+		t.Fatalf("expected a semi-join, got %T", pp.plan)
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table a (x int, s text)",
+		"create table b (y int, t text)",
+		"create table c (z int)",
+		"insert into a (x, s) values (1, 'one')",
+		"insert into a (x, s) values (1, 'one')",
+		"insert into a (x, s) values (2, 'two')",
+		"insert into a (x, s) values (3, 'three')",
+		"insert into a (s) values ('none')",
+		"insert into b (y, t) values (1, 'one')",
+		"insert into b (y, t) values (3, 'three')",
+		"insert into b (y, t) values (3, 'three')",
+		"insert into b (y, t) values (4, 'four')",
+		"insert into b (t) values ('none')",
+		"insert into c (z) values (1)",
+	)
+
+	db.expectRows(
+		"select x, s from a union select y, t from b",
+		"1,one", "2,two", "3,three", "4,four", "NULL,none",
+	)
+
+	db.expectRows(
+		"select x, s from a union all select y, t from b",
+		"1,one", "1,one", "2,two", "3,three", "NULL,none",
+		"1,one", "3,three", "3,three", "4,four", "NULL,none",
+	)
+
+	db.expectRows(
+		"select x from a intersect select y from b",
+		"1", "3", "NULL",
+	)
+
+	db.expectRows(
+		"select x from a intersect all select y from b",
+		"1", "3", "NULL",
+	)
+
+	db.expectRows(
+		"select x, s from a except select y, t from b",
+		"2,two",
+	)
+
+	db.expectRows(
+		"select x from a except all select y from b",
+		"1", "2",
+	)
+
+	// INTERSECT binds more tightly than UNION
+	db.expectRows(
+		"select z from c union select x from a intersect select y from b where y > 2",
+		"1", "3",
+	)
+
+	// the columns are named after the first query, which the ORDER BY clause refers to
+	db.expectRows(
+		"select x as n from a where x > 1 union all select y from b where y > 0 order by n desc limit 3",
+		"4", "3", "3",
+	)
+
+	db.expectRows(
+		"select s from a where x in (select y from b union select z from c)",
+		"one", "one", "three",
+	)
+
+	// a correlated subquery shares the outer record among its queries
+	db.expectRows(
+		"select x from a where exists (select y from b where y = x intersect select z from c where z = x)",
+		"1", "1",
+	)
+
+	if _, err := db.query("select x from a union select y, t from b"); err != ErrIncompatibleSchemas {
+		t.Fatalf("expected %v, got %v", ErrIncompatibleSchemas, err)
+	}
+
+	if _, err := db.query("select x from a union select t from b"); err != ErrIncompatibleSchemas {
+		t.Fatalf("expected %v, got %v", ErrIncompatibleSchemas, err)
+	}
+
+	if _, err := db.query("select x from a union select y from b order by y"); err != ErrNoField {
+		t.Fatalf("expected %v, got %v", ErrNoField, err)
+	}
+
+	x := db.newTx()
+	defer x.Commit()
+
+	q, err := sql.NewParser("select x from a except select y from b").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewBasicQueryPlanner(db.mdm).CreatePlan(q, x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rows := planRows(t, p, "x"); !slices.Equal(rows, []string{"2"}) {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+}
+
+func TestCommonTableExpressions(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table emp (id int, name text, manager int)",
+		"create table edge (src int, dst int)",
+		"insert into emp (id, name) values (1, 'ada')",
+		"insert into emp (id, name, manager) values (2, 'bob', 1)",
+		"insert into emp (id, name, manager) values (3, 'cy', 1)",
+		"insert into emp (id, name, manager) values (4, 'dee', 2)",
+		"insert into emp (id, name, manager) values (5, 'eve', 4)",
+		"insert into edge (src, dst) values (1, 2)",
+		"insert into edge (src, dst) values (2, 3)",
+		"insert into edge (src, dst) values (3, 1)",
+	)
+
+	db.expectRows(
+		"with junior as (select id, name from emp where id > 3) select name from junior",
+		"dee", "eve",
+	)
+
+	db.expectRows(
+		"with e (n, m) as (select name, manager from emp) select n from e where m = 1",
+		"bob", "cy",
+	)
+
+	// the common table expression is referred to twice, so it is materialized
+	db.expectRows(
+		"with m as (select id, manager from emp) select a.id from m a, m b where a.manager = b.id and b.manager = 1",
+		"4",
+	)
+
+	// a common table expression hides the table with the same name, but not to its own query
+	db.expectRows(
+		"with emp as (select id from emp where id = 1) select id from emp",
+		"1",
+	)
+
+	db.expectRows(
+		"with a as (select id, manager from emp where manager = 1), b as (select id from a where id > 2) select id from b",
+		"3",
+	)
+
+	db.expectRows(
+		"with m as (select manager from emp) select name from emp where id in (select manager from m)",
+		"ada", "bob", "dee",
+	)
+
+	db.expectRows(
+		"select name from emp where id in (with m as (select manager from emp where id > 3) select manager from m)",
+		"bob", "dee",
+	)
+
+	db.expectRows(
+		"with ids as (select id from emp union select manager from emp) select count(id) from ids",
+		"5",
+	)
+
+	// the subordinates of bob, and how far they are from him
+	db.expectRows(
+		"with recursive sub (id, name, depth) as (select id, name, 0 from emp where id = 2 union all select e.id, e.name, s.depth + 1 from emp e join sub s on e.manager = s.id) select name, depth from sub order by depth",
+		"bob,0", "dee,1", "eve,2",
+	)
+
+	// UNION discards the records already found, so the cycle ends the iteration
+	db.expectRows(
+		"with recursive reach (n) as (select dst from edge where src = 1 union select e.dst from edge e, reach r where e.src = r.n) select n from reach",
+		"1", "2", "3",
+	)
+
+	// WITH RECURSIVE allows common table expressions that do not refer to themselves
+	db.expectRows(
+		"with recursive boss as (select id from emp where manager is null), chain (id) as (select id from boss union select e.id from emp e, chain c where e.manager = c.id and e.id < 4) select id from chain",
+		"1", "2", "3",
+	)
+
+	for _, tc := range []struct {
+		src string
+		err error
+	}{
+		{"with e (a, b) as (select id from emp) select a from e", ErrCTEColumns},
+		{"with e (a, a) as (select id, name from emp) select a from e", ErrCTEColumns},
+		{"with e as (select id from emp), e as (select id from emp) select id from e", ErrDuplicateTable},
+		{"with recursive r (n) as (select id from emp intersect select n from r) select n from r", ErrRecursiveCTE},
+		{"with recursive r (n) as (select n from r union select id from emp) select n from r", ErrRecursiveCTE},
+		{"with recursive r (n) as (select id from emp union select name from emp e, r where e.id = r.n) select n from r", ErrIncompatibleSchemas},
+	} {
+		if _, err := db.query(tc.src); err != tc.err {
+			t.Fatalf("%q: expected %v, got %v", tc.src, tc.err, err)
+		}
+	}
+
+	x := db.newTx()
+	defer x.Commit()
+
+	q, err := sql.NewParser("with recursive sub (id) as (select id from emp where id = 2 union all select e.id from emp e, sub s where e.manager = s.id) select s.id from sub s, sub t where s.id = t.id").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewBasicQueryPlanner(db.mdm).CreatePlan(q, x)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := planRows(t, p, "s.id")
+	slices.Sort(rows)
+	if !slices.Equal(rows, []string{"2", "4", "5"}) {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+}
+
+func TestInsertRows(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table t (id int, name text)",
+		"create index t_id on t (id)",
+		"create table u (n int, s text)",
+	)
+
+	insert := func(src string) (int, error) {
+		cmd, err := sql.NewParser(src).Parse()
+		if err != nil {
+			t.Fatalf("error parsing %q: %v", src, err)
+		}
+
+		x := db.newTx()
+		defer x.Commit()
+
+		return ExecuteDMLStatement(NewUpdatePlanner(db.mdm), cmd, x)
+	}
+
+	for _, tc := range []struct {
+		src   string
+		count int
+	}{
+		{"insert into t (id, name) values (1, 'one'), (2, 'two'), (3, NULL)", 3},
+		{"insert into t values (4, 'four')", 1},
+		{"insert into u (s, n) values ('five', 5), ('six', 6)", 2},
+		{"insert into t select n, s from u where n > 5", 1},
+		// the records inserted by the command are not read by its query
+		{"insert into t (name, id) select name, id + 10 from t", 5},
+		{"insert into u (n) select id from t where id > 13 union select n from u", 4},
+	} {
+		count, err := insert(tc.src)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.src, err)
+		}
+
+		if count != tc.count {
+			t.Fatalf("%q: expected %d records, got %d", tc.src, tc.count, count)
+		}
+	}
+
+	db.expectRows(
+		"select id, name from t",
+		"1,one", "2,two", "3,NULL", "4,four", "6,six",
+		"11,one", "12,two", "13,NULL", "14,four", "16,six",
+	)
+
+	db.expectRows(
+		"select n, s from u",
+		"5,five", "6,six", "5,NULL", "6,NULL", "14,NULL", "16,NULL",
+	)
+
+	// a command that fails on a later row leaves none of its rows behind,
+	// even though its transaction commits.
+	db.exec(
+		"create table k (id int primary key, n int check (n < 10))",
+		"create index k_n on k (n)",
+		"insert into k (id, n) values (1, 1)",
+	)
+
+	for _, src := range []string{
+		"insert into k (id, n) values (2, 2), (1, 3)",
+		"insert into k (id, n) select id + 20, id from t",
+	} {
+		if _, err := insert(src); !errors.Is(err, ErrConstraintViolation) {
+			t.Fatalf("%q: expected %v, got %v", src, ErrConstraintViolation, err)
+		}
+	}
+
+	db.expectRows("select id, n from k", "1,1")
+	db.expectRows("select id from k where n = 2")
+	db.expectRows("select id from k where id = 21")
+
+	count, err := insert("insert into k (id, n) values (2, 2), (3, 3)")
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 records, got %d: %v", count, err)
+	}
+
+	db.expectRows("select id, n from k", "1,1", "2,2", "3,3")
+
+	// the index has an entry for every inserted record
+	x := db.newTx()
+	defer x.Commit()
+
+	for _, id := range []string{"1", "6", "12", "16"} {
+		q, err := sql.NewParser("select id from t where id = " + id).Query()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := NewHeuristicsQueryPlanner(db.mdm).CreatePlan(q, x)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sp := p.(ProjectPlan).plan.(SelectPlan)
+		if _, ok := sp.plan.(*IndexSelectPlan); !ok {
+			t.Fatalf("expected an index select, got %T", sp.plan)
+		}
+
+		if rows := planRows(t, p, "id"); !slices.Equal(rows, []string{id}) {
+			t.Fatalf("expected %v, got %v", []string{id}, rows)
+		}
+	}
+
+	for _, tc := range []struct {
+		src string
+		err error
+	}{
+		{"insert into t (id, name) values (1, 'one'), (2)", ErrInsertValues},
+		{"insert into t values (1)", ErrInsertValues},
+		{"insert into t (id) select n, s from u", ErrInsertValues},
+		{"insert into t (nope) values (1)", ErrNoField},
+		{"insert into t (id) select s from u", sql.ErrTypeMismatch},
+	} {
+		if _, err := insert(tc.src); err != tc.err {
+			t.Fatalf("%q: expected %v, got %v", tc.src, tc.err, err)
+		}
+	}
+}
+
+func TestDropStatements(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table t (id int, name text)",
+		"create index t_id on t (id)",
+		"create table u (n int)",
+		"create index u_n on u (n)",
+		"insert into t (id, name) values (1, 'one'), (2, 'two')",
+		"insert into u (n) values (1), (3)",
+		"create view v as select n from u",
+	)
+
+	files := []string{"t.tbl", "t_id_leaf", "t_id_dir"}
+
+	// a rolled back drop leaves the table as it was
+	if err := db.execute("drop table t", false); err != nil {
+		t.Fatal(err)
+	}
+
+	db.expectRows("select id, name from t", "1,one", "2,two")
+
+	for _, f := range files {
+		if !db.exists(f) {
+			t.Fatalf("expected %q to exist after rollback", f)
+		}
+	}
+
+	if err := db.execute("drop table t", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.query("select id from t"); !errors.Is(err, ErrViewNotFound) {
+		t.Fatalf("expected %v, got %v", ErrViewNotFound, err)
+	}
+
+	for _, f := range files {
+		if db.exists(f) {
+			t.Fatalf("expected %q to be removed", f)
+		}
+	}
+
+	if n := len(db.indexes("t")); n != 0 {
+		t.Fatalf("expected the indexes of the table to be dropped, got %d", n)
+	}
+
+	if _, ok := db.mdm.tableStats["t"]; ok {
+		t.Fatal("expected the statistics of the table to be dropped")
+	}
+
+	// a table with the same name starts empty
+	db.exec(
+		"create table t (id int)",
+		"insert into t (id) values (7)",
+	)
+
+	db.expectRows("select id from t", "7")
+
+	if err := db.execute("drop index u_n", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.exists("u_n_leaf") || db.exists("u_n_dir") {
+		t.Fatal("expected the files of the index to be removed")
+	}
+
+	if n := len(db.indexes("u")); n != 0 {
+		t.Fatalf("expected the index to be dropped, got %d", n)
+	}
+
+	db.expectRows("select n from u where n = 3", "3")
+
+	if err := db.execute("drop view v", true); err != nil {
+		t.Fatal(err)
+	}
+
+	x := db.newTx()
+	if _, err := db.mdm.viewDefinition("v", x); !errors.Is(err, ErrViewNotFound) {
+		t.Fatalf("expected %v, got %v", ErrViewNotFound, err)
+	}
+
+	x.Commit()
+
+	for _, tc := range []struct {
+		src string
+		err error
+	}{
+		{"drop table nope", ErrViewNotFound},
+		{"drop table v", ErrViewNotFound},
+		{"drop index nope", ErrIndexNotFound},
+		{"drop view nope", ErrViewNotFound},
+	} {
+		if err := db.execute(tc.src, true); !errors.Is(err, tc.err) {
+			t.Fatalf("%q: expected %v, got %v", tc.src, tc.err, err)
+		}
+	}
+}
+
+func TestAlterTable(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table t (id int, name text)",
+		"create index t_id on t (id)",
+		"create index t_name on t (name)",
+		"insert into t (id, name) values (1, 'one'), (2, 'two'), (3, NULL)",
+	)
+
+	// expectIndexSelect checks that the query selects its records through an index
+	expectIndexSelect := func(src string, exp ...string) {
+		t.Helper()
+
+		q, err := sql.NewParser(src).Query()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		x := db.newTx()
+		defer x.Commit()
+
+		p, err := NewHeuristicsQueryPlanner(db.mdm).CreatePlan(q, x)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sp := p.(ProjectPlan).plan.(SelectPlan)
+		if _, ok := sp.plan.(*IndexSelectPlan); !ok {
+			t.Fatalf("%q: expected an index select, got %T", src, sp.plan)
+		}
+
+		if rows := planRows(t, p, "id"); !slices.Equal(rows, exp) {
+			t.Fatalf("%q: expected %v, got %v", src, exp, rows)
+		}
+	}
+
+	db.exec(
+		"alter table t add column age int",
+		"insert into t values (4, 'four', 40)",
+	)
+
+	db.expectRows("select id, name, age from t", "1,one,NULL", "2,two,NULL", "3,NULL,NULL", "4,four,40")
+	expectIndexSelect("select id from t where id = 2", "2")
+	expectIndexSelect("select id from t where id = 4", "4")
+
+	db.exec("alter table t rename column name to label")
+
+	db.expectRows("select id, label from t", "1,one", "2,two", "3,NULL", "4,four")
+	expectIndexSelect("select id from t where label = 'two'", "2")
+
+	if _, err := db.query("select name from t"); !errors.Is(err, ErrNoField) {
+		t.Fatalf("expected %v, got %v", ErrNoField, err)
+	}
+
+	db.exec("alter table t drop column label")
+
+	db.expectRows("select * from t", "1,NULL", "2,NULL", "3,NULL", "4,40")
+
+	if _, ok := db.indexes("t")["label"]; ok {
+		t.Fatal("expected the index of the dropped field to be dropped")
+	}
+
+	if db.exists("t_name_leaf") || db.exists("t_name_dir") {
+		t.Fatal("expected the files of the index of the dropped field to be removed")
+	}
+
+	// a rolled back change leaves the table as it was
+	if err := db.execute("alter table t drop column age", false); err != nil {
+		t.Fatal(err)
+	}
+
+	db.expectRows("select id, age from t", "1,NULL", "2,NULL", "3,NULL", "4,40")
+
+	db.exec("alter table t rename to u")
+
+	db.expectRows("select id, age from u", "1,NULL", "2,NULL", "3,NULL", "4,40")
+	expectIndexSelect("select id from u where id = 3", "3")
+
+	if _, err := db.query("select id from t"); !errors.Is(err, ErrViewNotFound) {
+		t.Fatalf("expected %v, got %v", ErrViewNotFound, err)
+	}
+
+	if db.exists("t.tbl") {
+		t.Fatal("expected the file of the renamed table to be removed")
+	}
+
+	db.exec("create table w (n int)")
+
+	for _, tc := range []struct {
+		src string
+		err error
+	}{
+		{"alter table u add column id int", ErrDuplicateField},
+		{"alter table u drop column nope", ErrNoField},
+		{"alter table u rename column nope to other", ErrNoField},
+		{"alter table u rename column id to age", ErrDuplicateField},
+		{"alter table u rename to w", ErrTableExists},
+		{"alter table w drop column n", ErrDropLastField},
+		{"alter table nope add column n int", ErrViewNotFound},
+	} {
+		if err := db.execute(tc.src, true); !errors.Is(err, tc.err) {
+			t.Fatalf("%q: expected %v, got %v", tc.src, tc.err, err)
+		}
+	}
+
+	db.expectRows("select id, age from u", "1,NULL", "2,NULL", "3,NULL", "4,40")
+}
+
+func TestKeyConstraints(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table t (id int primary key, code text unique, a int, b int, unique (a, b))",
+		"insert into t (id, code, a, b) values (1, 'x', 1, 1), (2, 'y', 1, 2), (3, NULL, NULL, 1)",
+	)
+
+	expectViolation := func(src string) {
+		t.Helper()
+
+		if err := db.execute(src, true); !errors.Is(err, ErrConstraintViolation) {
+			t.Fatalf("%q: expected %v, got %v", src, ErrConstraintViolation, err)
+		}
+	}
+
+	expectViolation("insert into t (id, code) values (1, 'z')")
+	expectViolation("insert into t (id, code) values (4, 'x')")
+	expectViolation("insert into t (id, a, b) values (4, 1, 2)")
+	expectViolation("insert into t (code) values ('z')")
+	// the rows of a statement are checked against each other
+	expectViolation("insert into t (id) values (4), (4)")
+	expectViolation("update t set id = 2 where id = 1")
+	expectViolation("update t set b = 1 where id = 2")
+	expectViolation("update t set id = NULL where id = 1")
+
+	// NULLs do not break a unique key
+	db.exec(
+		"insert into t (id, code, a, b) values (4, NULL, NULL, 1), (5, 'z', 1, 3)",
+		"update t set id = 6 where id = 5",
+		"update t set code = 'x' where id = 1",
+	)
+
+	db.expectRows("select id, code, a, b from t", "1,x,1,1", "2,y,1,2", "3,NULL,NULL,1", "4,NULL,NULL,1", "6,z,1,3")
+
+	// a statement that breaks a key on a later row leaves none of its changes
+	// in a transaction that goes on and commits
+	x := db.newTx()
+	for _, src := range []string{
+		"insert into t (id) values (7), (8), (1)",
+		"insert into t (id) values (9), (9)",
+		"update t set a = 9, b = 9",
+		"update t set id = 10 - id",
+	} {
+		if err := db.executeIn(x, src); !errors.Is(err, ErrConstraintViolation) {
+			t.Fatalf("%q: expected %v, got %v", src, ErrConstraintViolation, err)
+		}
+	}
+
+	if err := db.executeIn(x, "insert into t (id) values (7)"); err != nil {
+		t.Fatal(err)
+	}
+
+	x.Commit()
+
+	db.expectRows("select id, code, a, b from t", "1,x,1,1", "2,y,1,2", "3,NULL,NULL,1", "4,NULL,NULL,1", "6,z,1,3", "7,NULL,NULL,NULL")
+	db.expectRows("select id from t where id = 8")
+	db.expectRows("select id from t where id = 9")
+	db.exec("delete from t where id = 7")
+
+	if _, ok := db.indexes("t")["id"]; !ok {
+		t.Fatal("expected the primary key to be indexed")
+	}
+
+	if err := db.execute("drop index t_pkey", true); !errors.Is(err, ErrConstraintIndex) {
+		t.Fatalf("expected %v, got %v", ErrConstraintIndex, err)
+	}
+
+	if err := db.execute("create table u (id int primary key, other int, primary key (other))", true); !errors.Is(err, ErrMultiplePrimaryKeys) {
+		t.Fatalf("expected %v, got %v", ErrMultiplePrimaryKeys, err)
+	}
+
+	if err := db.execute("create table u (id int, unique (nope))", true); !errors.Is(err, ErrNoField) {
+		t.Fatalf("expected %v, got %v", ErrNoField, err)
+	}
+
+	// keys follow the fields they are defined over
+	db.exec(
+		"alter table t rename column b to c",
+		"alter table t rename to u",
+	)
+
+	expectViolation("insert into u (id, a, c) values (7, 1, 2)")
+
+	db.exec("alter table u drop column a")
+	db.exec("insert into u (id, c) values (7, 1)")
+
+	if db.exists("t_a_b_key_leaf") || db.exists("t_a_b_key_dir") {
+		t.Fatal("expected the index of the dropped key to be removed")
+	}
+
+	expectViolation("insert into u (id) values (7)")
+
+	db.exec(
+		"drop table u",
+		"create table u (id int primary key)",
+		"insert into u (id) values (1)",
+	)
+
+	expectViolation("insert into u (id) values (1)")
+}
+
+func TestFieldConstraints(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table items (name text not null, status int default 1 + 1, qty int default 0 check (qty >= 0) check (qty < 100), price int check (price > qty))",
+		"insert into items (name) values ('a')",
+		"insert into items (name, status, qty, price) values ('b', 5, 10, 20), ('c', NULL, 3, NULL)",
+	)
+
+	db.expectRows("select name, status, qty, price from items", "a,2,0,NULL", "b,5,10,20", "c,NULL,3,NULL")
+
+	expectViolation := func(src string) {
+		t.Helper()
+
+		if err := db.execute(src, true); !errors.Is(err, ErrConstraintViolation) {
+			t.Fatalf("%q: expected %v, got %v", src, ErrConstraintViolation, err)
+		}
+	}
+
+	expectViolation("insert into items (status) values (1)")
+	expectViolation("insert into items (name, qty) values (NULL, 1)")
+	expectViolation("insert into items (name, qty) values ('d', 100)")
+	expectViolation("insert into items (name, qty, price) values ('d', 10, 5)")
+	expectViolation("insert into items (name, qty) select name, qty + 100 from items")
+	expectViolation("update items set name = NULL where name = 'a'")
+	expectViolation("update items set qty = 200 where name = 'b'")
+	expectViolation("update items set price = 1 where name = 'b'")
+
+	// a violation rolls back the whole statement
+	db.expectRows("select name, qty from items", "a,0", "b,10", "c,3")
+
+	if err := db.execute("create table other (a int check (b > 0))", true); !errors.Is(err, ErrNoField) {
+		t.Fatalf("expected %v, got %v", ErrNoField, err)
+	}
+
+	// constraints follow renamed fields, and the checks that refer to a dropped field are dropped
+	db.exec("alter table items rename column qty to quantity")
+	expectViolation("insert into items (name, quantity) values ('d', 100)")
+	expectViolation("insert into items (name, quantity, price) values ('d', 10, 5)")
+
+	db.exec(
+		"alter table items drop column quantity",
+		"insert into items (name, price) values ('d', 0)",
+	)
+
+	expectViolation("insert into items (price) values (1)")
+
+	// added fields take their default value, and cannot be NOT NULL without one
+	db.exec("alter table items add column color text not null default 'red'")
+	db.expectRows("select name, color from items", "a,red", "b,red", "c,red", "d,red")
+	expectViolation("alter table items add column size int not null")
+}
+
+func TestForeignKeys(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table authors (id int primary key, name text unique)",
+		"create table books (id int primary key, author int references authors on delete cascade, title text)",
+		"create table reviews (book int, score int, foreign key (book) references books (id))",
+		"create table quotes (author text references authors (name) on delete set null, quote text)",
+		"create table staff (id int primary key, boss int references staff on delete cascade)",
+		"insert into authors (id, name) values (1, 'ann'), (2, 'bob'), (3, 'cid')",
+		"insert into books (id, author, title) values (10, 1, 'a'), (11, 1, 'b'), (20, 2, 'c'), (30, NULL, 'd')",
+		"insert into reviews (book, score) values (20, 5)",
+		"insert into quotes (author, quote) values ('ann', 'x'), ('bob', 'y')",
+		"insert into staff (id, boss) values (1, 1), (2, 1), (3, 2), (4, NULL)",
+	)
+
+	expectErr := func(src string, target error) {
+		t.Helper()
+
+		if err := db.execute(src, true); !errors.Is(err, target) {
+			t.Fatalf("%q: expected %v, got %v", src, target, err)
+		}
+	}
+
+	// the referenced record must exist
+	expectErr("insert into books (id, author, title) values (40, 9, 'e')", ErrConstraintViolation)
+	expectErr("update books set author = 9 where id = 10", ErrConstraintViolation)
+	expectErr("insert into quotes (author, quote) values ('dan', 'z')", ErrConstraintViolation)
+
+	// a referenced record cannot be deleted or changed under RESTRICT
+	expectErr("delete from books where id = 20", ErrConstraintViolation)
+	expectErr("update books set id = 21 where id = 20", ErrConstraintViolation)
+	expectErr("delete from authors where id = 2", ErrConstraintViolation)
+	db.expectRows("select id from books", "10", "11", "20", "30")
+
+	// records that are not referred to can be changed
+	db.exec("update books set id = 12 where id = 11")
+
+	// CASCADE deletes the referencing records, SET NULL clears their foreign key
+	db.exec("delete from authors where id = 1")
+	db.expectRows("select id, author from books", "20,2", "30,NULL")
+	db.expectRows("select author, quote from quotes", "NULL,x", "bob,y")
+
+	// a self referencing table cascades recursively
+	db.exec("delete from staff where id = 1")
+	db.expectRows("select id, boss from staff", "4,NULL")
+
+	expectErr("create table bad (a text references books (title))", ErrNoReferencedKey)
+	expectErr("create table bad (a text references books (id))", ErrForeignKeyFields)
+	expectErr("create table bad (a int references reviews)", ErrNoReferencedKey)
+	expectErr("drop table books", ErrReferenced)
+	expectErr("alter table authors drop column id", ErrReferenced)
+
+	// foreign keys follow renamed tables and fields
+	db.exec(
+		"alter table authors rename to writers",
+		"alter table writers rename column id to wid",
+		"alter table books rename column author to writer",
+	)
+
+	expectErr("insert into books (id, writer, title) values (50, 9, 'f')", ErrConstraintViolation)
+	db.exec("insert into books (id, writer, title) values (50, 3, 'f')")
+	db.exec("delete from writers where wid = 3")
+	db.expectRows("select id from books", "20", "30")
+
+	// dropping the referencing tables and fields drops their foreign keys
+	db.exec(
+		"drop table reviews",
+		"alter table quotes drop column author",
+		"drop table books",
+		"drop table writers",
+	)
+
+	// the foreign keys are checked before the record is written,
+	// so that a rejected statement leaves nothing behind in a transaction that goes on.
+	db.exec(
+		"create table p (id int primary key)",
+		"create table c (id int primary key, pid int references p)",
+		"create table s (id int primary key, boss int references s)",
+		"insert into p (id) values (1)",
+		"insert into c (id, pid) values (1, 1)",
+		"insert into s (id, boss) values (1, 1)",
+	)
+
+	x := db.newTx()
+	for _, src := range []string{
+		"insert into c values (2, 99)",
+		"delete from p where id = 1",
+		"update c set pid = 77",
+		"update p set id = 2",
+		"update s set id = 2 where id = 1",
+	} {
+		if err := db.executeIn(x, src); !errors.Is(err, ErrConstraintViolation) {
+			t.Fatalf("%q: expected %v, got %v", src, ErrConstraintViolation, err)
+		}
+	}
+
+	// a record that refers to itself is its own parent
+	for _, src := range []string{
+		"insert into s (id, boss) values (3, 3)",
+		"update s set id = 4, boss = 4 where id = 3",
+	} {
+		if err := db.executeIn(x, src); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+	}
+
+	x.Commit()
+
+	db.expectRows("select id from p", "1")
+	db.expectRows("select id, pid from c", "1,1")
+	db.expectRows("select id, boss from s", "1,1", "4,4")
+
+	// a record that only refers to itself can be deleted
+	db.exec("delete from s where id = 4")
+	db.expectRows("select id, boss from s", "1,1")
+}
+
+func TestSequences(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create sequence codes start with 10 increment by 5",
+		"create table items (id serial primary key, code int default nextval('codes'), name text)",
+		"create table other (code int)",
+		"insert into items (name) values ('a'), ('b')",
+		"insert into items (id, name) values (nextval('codes'), 'c')",
+		"update items set code = nextval('codes') where name = 'b'",
+	)
+
+	db.expectRows("select id, code, name from items", "1,10,a", "2,30,b", "25,20,c")
+
+	// the values drawn by a transaction that rolls back are not given back
+	if err := db.execute("insert into items (name) values ('d')", false); err != nil {
+		t.Fatal(err)
+	}
+
+	db.exec("insert into items (name) values ('e')")
+	db.expectRows("select id, code from items where name = 'e'", "4,40")
+
+	// a sequence is not locked by the transactions that draw from it until they end
+	x1, x2 := db.newTx(), db.newTx()
+	if err := db.executeIn(x1, "insert into items (name) values ('f')"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.executeIn(x2, "insert into other values (nextval('codes'))"); err != nil {
+		t.Fatal(err)
+	}
+
+	x2.Commit()
+	x1.Rollback()
+
+	db.expectRows("select code from other", "50")
+
+	// a transaction that creates a sequence draws from it and from the other ones,
+	// and the values it draws are not given back when it rolls back
+	x3 := db.newTx()
+	for _, src := range []string{
+		"create sequence s9 start with 100",
+		"insert into items (name) values ('x')",
+		"insert into other values (nextval('s9')), (nextval('s9'))",
+	} {
+		if err := db.executeIn(x3, src); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+	}
+
+	x3.Rollback()
+
+	db.exec(
+		"create sequence s9 start with 200",
+		"insert into other values (nextval('s9'))",
+		"drop sequence s9",
+	)
+
+	db.expectRows("select code from other", "50", "200")
+
+	// drawn values survive a crash
+	db = db.restart()
+	db.exec("insert into items (name) values ('g')")
+	db.expectRows("select id, code from items where name = 'g'", "7,60")
+
+	if err := db.execute("drop sequence items_id_seq", true); !errors.Is(err, ErrSequenceOwned) {
+		t.Fatalf("expected %v, got %v", ErrSequenceOwned, err)
+	}
+
+	if err := db.execute("create sequence codes", true); !errors.Is(err, ErrSequenceExists) {
+		t.Fatalf("expected %v, got %v", ErrSequenceExists, err)
+	}
+
+	// values are only drawn for the records written by a statement
+	if _, err := db.query("select nextval('codes') from other"); !errors.Is(err, sql.ErrMisplacedNextval) {
+		t.Fatalf("expected %v, got %v", sql.ErrMisplacedNextval, err)
+	}
+
+	// an added auto increment field numbers the existing records,
+	// and its sequence follows the field until it is dropped
+	db.exec(
+		"alter table items add column seq int auto_increment",
+		"alter table items rename column seq to num",
+		"insert into items (name) values ('h')",
+	)
+
+	db.expectRows("select num from items where name = 'h'", "6")
+
+	db.exec(
+		"alter table items drop column num",
+		"create sequence items_num_seq",
+		"drop table items",
+		"create sequence items_id_seq",
+		"drop sequence codes",
+	)
+
+	if err := db.execute("insert into other values (nextval('codes'))", true); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestSequenceBlocksReuse(t *testing.T) {
+	db := newTestDB(t)
+
+	blocks := func() storage.Long {
+		x := db.newTx()
+		defer x.Commit()
+
+		size, err := x.Size(seqValuesFileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return size
+	}
+
+	db.exec(
+		"create table t (v int)",
+		"create sequence a start with 10",
+		"create sequence b start with 20",
+	)
+
+	if got := blocks(); got != 2 {
+		t.Fatalf("expected 2 blocks, got %d", got)
+	}
+
+	// the block freed by a transaction is not reused by it,
+	// and goes back to its sequence when the transaction rolls back
+	x := db.newTx()
+	for _, src := range []string{
+		"drop sequence a",
+		"create sequence c start with 30",
+	} {
+		if err := db.executeIn(x, src); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+	}
+
+	x.Rollback()
+
+	if got := blocks(); got != 3 {
+		t.Fatalf("expected 3 blocks, got %d", got)
+	}
+
+	db.exec("insert into t values (nextval('a'))")
+	db.expectRows("select v from t", "10")
+
+	// the blocks of dropped sequences are reused by the sequences created afterwards,
+	// while the one appended for c is left unused
+	db.exec(
+		"drop sequence a",
+		"create sequence d start with 40",
+		"create table s (id serial)",
+		"drop table s",
+		"create sequence e start with 50",
+		"insert into t values (nextval('b')), (nextval('d')), (nextval('e'))",
+	)
+
+	if got := blocks(); got != 4 {
+		t.Fatalf("expected 4 blocks, got %d", got)
+	}
+
+	db.expectRows("select v from t", "10", "20", "40", "50")
+}
+
+func TestCreateIndexOnExistingRows(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec(
+		"create table t (id int, name text)",
+		"insert into t values (1, 'one'), (2, 'two'), (3, NULL), (2, 'dos')",
+		"create index t_id on t (id)",
+		"create index t_name on t (name)",
+		"insert into t values (2, 'deux'), (4, 'one')",
+	)
+
+	// the records inserted before the indexes are found through them,
+	// together with those inserted afterwards
+	db.expectRows("select name from t where id = 2", "two", "dos", "deux")
+	db.expectRows("select id from t where name = 'one'", "1", "4")
+	db.expectRows("select id from t where id = 3", "3")
+
+	if err := db.execute("create index t_missing on t (missing)", true); !errors.Is(err, ErrNoField) {
+		t.Fatalf("expected %v, got %v", ErrNoField, err)
+	}
+}
+
+func TestCreateIndexOnManyRows(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec("create table t (id int, name text)")
+
+	// the ids repeat enough for their records to overflow the leaves,
+	// and the names fill enough leaves for the directory to grow above the root
+	const rows = 4000
+	padding := strings.Repeat("x", 100)
+	for i := 0; i < rows; i += 100 {
+		var vals []string
+		for j := i; j < i+100; j++ {
+			if j%97 == 0 {
+				vals = append(vals, "(NULL, NULL)")
+				continue
+			}
+
+			vals = append(vals, fmt.Sprintf("(%d, 'name %d %s')", j%8, j%1000, padding))
+		}
+
+		db.exec("insert into t values " + strings.Join(vals, ", "))
+	}
+
+	db.exec(
+		"create index t_id on t (id)",
+		"create index t_name on t (name)",
+	)
+
+	// expect compares the records found through the index with those found by a full scan,
+	// whose predicate is an expression that the index cannot serve
+	expect := func(indexed string, scanned string, n int) {
+		t.Helper()
+
+		got, err := db.query(indexed)
+		if err != nil {
+			t.Fatalf("%q: %v", indexed, err)
+		}
+
+		exp, err := db.query(scanned)
+		if err != nil {
+			t.Fatalf("%q: %v", scanned, err)
+		}
+
+		slices.Sort(got)
+		slices.Sort(exp)
+		if len(exp) != n || !slices.Equal(got, exp) {
+			t.Fatalf("%q: expected %d rows equal to those of a full scan, got %d and %d", indexed, n, len(got), len(exp))
+		}
+	}
+
+	check := func(extra int) {
+		t.Helper()
+
+		expect("select name from t where id = 0", "select name from t where id + 0 = 0", 494+extra)
+		expect("select name from t where id = 5", "select name from t where id + 0 = 5", 495)
+		expect("select name from t where id = 8", "select name from t where id + 0 = 8", 0)
+
+		for _, n := range []int{1, 500, 999} {
+			name := fmt.Sprintf("name %d %s", n, padding)
+			expect(
+				fmt.Sprintf("select id from t where name = '%s'", name),
+				fmt.Sprintf("select id from t where name || '' = '%s'", name),
+				4,
+			)
+		}
+
+		expect(
+			fmt.Sprintf("select id from t where name = 'name 1000 %s'", padding),
+			fmt.Sprintf("select id from t where name || '' = 'name 1000 %s'", padding),
+			extra,
+		)
+	}
+
+	check(0)
+
+	// the loaded indexes keep growing through the records inserted afterwards
+	var vals []string
+	for range 300 {
+		vals = append(vals, fmt.Sprintf("(0, 'name 1000 %s')", padding))
+	}
+
+	db.exec("insert into t values " + strings.Join(vals, ", "))
+
+	check(300)
+}
+
+func TestQueriesOverMoreRowsThanBuffers(t *testing.T) {
+	db := newTestDB(t)
+
+	db.exec("create table t (k int, v text)")
+
+	// the sorts of the queries go through more blocks than the buffer pool holds,
+	// so the buffers the catalog was read from are reused
+	const rows = 4000
+	for i := 0; i < rows; i += 100 {
+		var vals []string
+		for j := i; j < i+100; j++ {
+			vals = append(vals, fmt.Sprintf("(%d, 'value %d')", j%7, j))
+		}
+
+		db.exec("insert into t values " + strings.Join(vals, ", "))
+	}
+
+	db.expectRows("select k, count(v) from t group by k having count(v) > 571", "0,572", "1,572", "2,572")
+	db.expectRows("select distinct k from t where k < 3", "0", "1", "2")
+	db.expectRows("select count(v) from t where k in (select k from t where v = 'value 8')", "572")
+	db.expectRows("select b.v from t a join t b on a.k = b.k where a.v = 'value 3' and b.v = 'value 10'", "value 10")
+
+	// the constraints of the target table are read before the sort of the query runs
+	db.exec(
+		"create table c (k int check (k < 7), label text default 'unlabelled')",
+		"insert into c (k) select k from t order by k",
+	)
+
+	db.expectRows("select label, count(k) from c group by label", "unlabelled,4000")
+}
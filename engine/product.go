@@ -63,6 +63,15 @@ func (pr Product) HasField(fname string) bool {
 	return pr.first.HasField(fname) || pr.second.HasField(fname)
 }
 
+// Type implements Scan.
+func (pr Product) Type(fname string) storage.FieldType {
+	if pr.first.HasField(fname) {
+		return pr.first.Type(fname)
+	}
+
+	return pr.second.Type(fname)
+}
+
 // Next iterates through all possible combinations of records of the ProductScan's input.
 // Each call to Next moves the current record to the next record of the second input.
 // If such record exists, Next returns.
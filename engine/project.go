@@ -1,33 +1,35 @@
 package engine
 
 import (
-	"errors"
-
+	"github.com/luigitni/simpledb/pages"
+	"github.com/luigitni/simpledb/sql"
 	"github.com/luigitni/simpledb/storage"
 )
 
 // Project is a relational algebra operator.
 // Project returns a table that has the same rows
 // of its input table, but with some columns removed.
+// Output columns can also be computed from expressions
+// over the columns of the input table.
 // A Project Scan has a single underlying scan
 // and because it does not access any additional blocks compared with its underlying scan,
 // its cost is exactly the same.
 type Project struct {
 	scan Scan
-	// fields is the list of output fields.
-	fields map[string]struct{}
+	// fields maps each output field to the expression that computes it.
+	fields map[string]sql.Expression
+	schema Schema
 }
 
-var ErrNoField = errors.New("field not found")
+// ErrNoField is the same error returned by the pages,
+// so that a missing field is reported the same way by scans and by records.
+var ErrNoField = pages.ErrNoField
 
-func newProjectScan(scan Scan, fields []string) Project {
-	m := make(map[string]struct{})
-	for _, f := range fields {
-		m[f] = struct{}{}
-	}
+func newProjectScan(scan Scan, fields map[string]sql.Expression, schema Schema) Project {
 	return Project{
 		scan:   scan,
-		fields: m,
+		fields: fields,
+		schema: schema,
 	}
 }
 
@@ -36,6 +38,11 @@ func (project Project) HasField(fname string) bool {
 	return ok
 }
 
+// Type implements Scan.
+func (project Project) Type(fname string) storage.FieldType {
+	return project.schema.Type(fname)
+}
+
 // BeforeFirst implements Scan.
 func (project Project) BeforeFirst() error {
 	return project.scan.BeforeFirst()
@@ -47,13 +54,15 @@ func (project Project) Close() {
 }
 
 // Val checks if the specified fieldname is in the list.
-// If it is, it calls the underlying scan, if not, it returns an
-// ErrNoField error
+// If it is, it evaluates its expression over the underlying scan,
+// if not, it returns an ErrNoField error
 func (project Project) Val(fname string) (storage.Value, error) {
-	if !project.HasField(fname) {
+	exp, ok := project.fields[fname]
+	if !ok {
 		return storage.Value{}, ErrNoField
 	}
-	return project.scan.Val(fname)
+
+	return exp.Evaluate(project.scan)
 }
 
 // Next implements Scan.
@@ -0,0 +1,403 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+var (
+	ErrNoReferencedKey  = errors.New("referenced fields are not a key")
+	ErrForeignKeyFields = errors.New("foreign key fields do not match the referenced fields")
+	ErrReferenced       = errors.New("referenced by a foreign key")
+)
+
+// referencedKey returns the key whose fields are the fields, in any order.
+func referencedKey(keys []keyConstraint, fields []string) (keyConstraint, bool) {
+	for _, key := range keys {
+		if len(key.fields) != len(fields) {
+			continue
+		}
+
+		matches := true
+		for _, f := range fields {
+			matches = matches && slices.Contains(key.fields, f)
+		}
+
+		if matches {
+			return key, true
+		}
+	}
+
+	return keyConstraint{}, false
+}
+
+// foreignKeyValues returns the values of the fields, given the values of a record
+// in the order of the fields of the schema, and false if one of them is NULL.
+// A foreign key with a NULL field does not refer to any record.
+func foreignKeyValues(schema Schema, fields []string, vals []storage.Value) ([]storage.Value, bool) {
+	fkVals := make([]storage.Value, len(fields))
+	for i, f := range fields {
+		fkVals[i] = vals[schema.info[f].Index]
+		if fkVals[i].IsNull() {
+			return nil, false
+		}
+	}
+
+	return fkVals, true
+}
+
+// foreignKeyRef is a foreign key of a table, together with the layout of the referenced table
+// and the key the foreign key refers to, through whose index the referenced records are found.
+type foreignKeyRef struct {
+	foreignKey
+	refLayout Layout
+	refKey    keyConstraint
+}
+
+// foreignKeyRefs returns the foreign keys of the table,
+// or only those that include a field set by fields if it is not nil.
+// A foreign key that refers to its own table is also returned if fields sets one of the referenced fields,
+// since a record that refers to itself must still do so once updated.
+func (planner *IndexUpdatePlanner) foreignKeyRefs(x tx.Transaction, tblName string, fields []sql.UpdateField) ([]foreignKeyRef, error) {
+	fks, err := planner.mdm.foreignKeys(x, tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []foreignKeyRef
+	for _, fk := range fks {
+		if fields != nil && !slices.ContainsFunc(fields, func(f sql.UpdateField) bool {
+			return slices.Contains(fk.fields, f.Field) ||
+				fk.refTable == tblName && slices.Contains(fk.refFields, f.Field)
+		}) {
+			continue
+		}
+
+		layout, err := planner.mdm.layout(fk.refTable, x)
+		if err != nil {
+			return nil, err
+		}
+
+		keys, err := planner.mdm.keys(x, fk.refTable)
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := referencedKey(keys, fk.refFields)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrNoReferencedKey, fk.name)
+		}
+
+		refs = append(refs, foreignKeyRef{
+			foreignKey: fk,
+			refLayout:  layout,
+			refKey:     key,
+		})
+	}
+
+	return refs, nil
+}
+
+// checkForeignKeys returns an ErrConstraintViolation if a record with the values,
+// given in the order of the fields of the schema, refers through one of the foreign keys
+// to a record that does not exist.
+// The referenced records are looked up through the index of the referenced key.
+// It is called before the record is written: a record that refers to its own table
+// counts as its own parent if its referenced fields have the values of the foreign key,
+// and self is the record being updated, which is not counted as a parent with its old values.
+// self is nil if the record is being inserted.
+func checkForeignKeys(x tx.Transaction, schema Schema, refs []foreignKeyRef, vals []storage.Value, self *RID) error {
+	for _, ref := range refs {
+		fkVals, ok := foreignKeyValues(schema, ref.fields, vals)
+		if !ok {
+			continue
+		}
+
+		selfRef := ref.refTable == ref.table
+		if selfRef && slices.EqualFunc(ref.refFields, fkVals, func(f string, v storage.Value) bool {
+			return vals[schema.info[f].Index].Equals(v)
+		}) {
+			continue
+		}
+
+		// the values are looked up in the order of the fields of the key,
+		// since its index is defined over the first of them.
+		keyVals := make([]storage.Value, len(ref.refKey.fields))
+		for i, f := range ref.refKey.fields {
+			keyVals[i] = fkVals[slices.Index(ref.refFields, f)]
+		}
+
+		idx, err := NewBTreeIndex(x, ref.refKey.name, idxLayout(ref.refLayout.schema, ref.refKey.fields[0]))
+		if err != nil {
+			return err
+		}
+
+		rids, err := findRecords(x, ref.refTable, ref.refLayout, idx, ref.refKey.fields, keyVals)
+		idx.Close()
+		if err != nil {
+			return err
+		}
+
+		if selfRef && self != nil {
+			rids = slices.DeleteFunc(rids, func(rid RID) bool {
+				return rid == *self
+			})
+		}
+
+		if len(rids) == 0 {
+			return fmt.Errorf("%w: foreign key %q refers to a record of %q that does not exist", ErrConstraintViolation, ref.name, ref.refTable)
+		}
+	}
+
+	return nil
+}
+
+// referencingKey is a foreign key that refers to a table, together with the layout of the table
+// it belongs to and the name of an index over its first field, if there is one,
+// through which the referencing records are found.
+type referencingKey struct {
+	foreignKey
+	layout Layout
+	index  string
+}
+
+// referencingKeys returns the foreign keys that refer to the table,
+// or only those that refer to a field set by fields if it is not nil.
+func (planner *IndexUpdatePlanner) referencingKeys(x tx.Transaction, tblName string, fields []sql.UpdateField) ([]referencingKey, error) {
+	fks, err := planner.mdm.referencingKeys(x, tblName)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []referencingKey
+	for _, fk := range fks {
+		if fields != nil && !slices.ContainsFunc(fields, func(f sql.UpdateField) bool {
+			return slices.Contains(fk.refFields, f.Field)
+		}) {
+			continue
+		}
+
+		layout, err := planner.mdm.layout(fk.table, x)
+		if err != nil {
+			return nil, err
+		}
+
+		infos, err := planner.mdm.indexes(x, fk.table)
+		if err != nil {
+			return nil, err
+		}
+
+		ref := referencingKey{
+			foreignKey: fk,
+			layout:     layout,
+		}
+
+		for _, info := range infos {
+			if info.fieldName == fk.fields[0] {
+				ref.index = info.idxName
+				break
+			}
+		}
+
+		refs = append(refs, ref)
+	}
+
+	return refs, nil
+}
+
+// referencedValues returns the values of the referenced fields of the current record of the scan,
+// and false if one of them is NULL. The values are copied, so that they outlive the record.
+func (ref referencingKey) referencedValues(s Scan) ([]storage.Value, bool, error) {
+	vals := make([]storage.Value, len(ref.refFields))
+	for i, f := range ref.refFields {
+		v, err := s.Val(f)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if v.IsNull() {
+			return nil, false, nil
+		}
+
+		vals[i] = storage.Copy(v)
+	}
+
+	return vals, true, nil
+}
+
+// restrict returns an ErrConstraintViolation if a record of the referencing table
+// refers to the values of the referenced fields of the record self.
+// It is called before self is deleted or its referenced fields are updated,
+// so self does not count if the foreign key refers to its own table.
+func (ref referencingKey) restrict(x tx.Transaction, vals []storage.Value, self RID) error {
+	var idx Index
+	if ref.index != "" {
+		bt, err := NewBTreeIndex(x, ref.index, idxLayout(ref.layout.schema, ref.fields[0]))
+		if err != nil {
+			return err
+		}
+
+		defer bt.Close()
+		idx = bt
+	}
+
+	rids, err := findRecords(x, ref.table, ref.layout, idx, ref.fields, vals)
+	if err != nil {
+		return err
+	}
+
+	if ref.table == ref.refTable {
+		rids = slices.DeleteFunc(rids, func(rid RID) bool {
+			return rid == self
+		})
+	}
+
+	if len(rids) > 0 {
+		return fmt.Errorf("%w: record of %q is referred to by foreign key %q", ErrConstraintViolation, ref.refTable, ref.name)
+	}
+
+	return nil
+}
+
+// cascades reports whether the action of the foreign key changes the records that referred to a deleted record,
+// rather than preventing the deletion.
+func (ref referencingKey) cascades() bool {
+	return ref.onDelete == sql.ActionCascade || ref.onDelete == sql.ActionSetNull
+}
+
+// predicate returns the predicate satisfied by the records of the referencing table
+// that refer to the values of the referenced fields.
+func (ref referencingKey) predicate(vals []storage.Value) sql.Predicate {
+	exprs := make([]sql.Expression, len(ref.fields))
+	for i, f := range ref.fields {
+		exprs[i] = sql.NewExpressionWithVal(ref.layout.schema.ftype(f), vals[i])
+	}
+
+	return sql.NewEqualityPredicate(ref.fields, exprs)
+}
+
+// onDelete applies the action of the foreign key to the records that referred to a deleted record,
+// whose referenced fields had the values.
+// CASCADE deletes them and SET NULL sets their foreign key to NULL.
+// RESTRICT and NO ACTION are checked by restrict before the record is deleted, so there is nothing left to do.
+func (planner *IndexUpdatePlanner) onDelete(x tx.Transaction, ref referencingKey, vals []storage.Value) error {
+	switch ref.onDelete {
+	case sql.ActionCascade:
+		_, err := planner.executeDelete(sql.NewDeleteCommandWithPredicate(ref.table, ref.predicate(vals)), x)
+		return err
+	case sql.ActionSetNull:
+		fields := make([]sql.UpdateField, len(ref.fields))
+		for i, f := range ref.fields {
+			fields[i] = sql.UpdateField{
+				Field:    f,
+				NewValue: sql.NewExpressionWithVal(ref.layout.schema.ftype(f), storage.Null),
+			}
+		}
+
+		_, err := planner.executeUpdate(sql.NewUpdateCommandWithPredicate(ref.table, fields, ref.predicate(vals)), x)
+		return err
+	default:
+		return nil
+	}
+}
+
+// tableForeignKeys returns the foreign keys declared by the command, named after the table and their fields.
+// Each foreign key must refer to a key of the referenced table, with fields of the same types,
+// or to its primary key if the referenced fields are omitted.
+// A table can refer to itself, through the keys being created.
+// A foreign key whose name is already taken gets a numeric suffix.
+func (planner *IndexUpdatePlanner) tableForeignKeys(data sql.CreateTableCommand, schema Schema, keys []keyConstraint, x tx.Transaction) ([]foreignKey, error) {
+	var fks []foreignKey
+	for _, d := range data.ForeignKeys {
+		for i, f := range d.Fields {
+			if !schema.HasField(f) {
+				return nil, ErrNoField
+			}
+
+			if slices.Contains(d.Fields[:i], f) {
+				return nil, ErrDuplicateField
+			}
+		}
+
+		refSchema := schema
+		refKeys := keys
+		if d.RefTable != data.TableName {
+			layout, err := planner.mdm.layout(d.RefTable, x)
+			if err != nil {
+				return nil, err
+			}
+
+			refSchema = layout.schema
+			refKeys, err = planner.mdm.keys(x, d.RefTable)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		refFields := d.RefFields
+		if len(refFields) == 0 {
+			i := slices.IndexFunc(refKeys, func(key keyConstraint) bool {
+				return key.primary
+			})
+
+			if i < 0 {
+				return nil, fmt.Errorf("%w: %q has no primary key", ErrNoReferencedKey, d.RefTable)
+			}
+
+			refFields = refKeys[i].fields
+		}
+
+		if len(refFields) != len(d.Fields) {
+			return nil, ErrForeignKeyFields
+		}
+
+		for i, f := range refFields {
+			if !refSchema.HasField(f) {
+				return nil, ErrNoField
+			}
+
+			if refSchema.ftype(f) != schema.ftype(d.Fields[i]) {
+				return nil, ErrForeignKeyFields
+			}
+		}
+
+		if _, ok := referencedKey(refKeys, refFields); !ok {
+			return nil, ErrNoReferencedKey
+		}
+
+		base := foreignKeyName(data.TableName, d.Fields)
+		name := base
+		for n := 1; ; n++ {
+			exists, err := planner.mdm.foreignKeyExists(x, name)
+			if err != nil {
+				return nil, err
+			}
+
+			taken := slices.ContainsFunc(fks, func(fk foreignKey) bool {
+				return fk.name == name
+			})
+
+			if !exists && !taken {
+				break
+			}
+
+			name = fmt.Sprintf("%s%d", base, n)
+		}
+
+		fks = append(fks, foreignKey{
+			name:      name,
+			table:     data.TableName,
+			fields:    d.Fields,
+			refTable:  d.RefTable,
+			refFields: refFields,
+			onDelete:  d.OnDelete,
+		})
+	}
+
+	return fks, nil
+}
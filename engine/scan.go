@@ -19,6 +19,8 @@ type Scan interface {
 
 	HasField(fname string) bool
 
+	Type(fname string) storage.FieldType
+
 	Close()
 }
 
@@ -1,10 +1,24 @@
 package engine
 
-import "github.com/luigitni/simpledb/storage"
+import (
+	"strings"
+
+	"github.com/luigitni/simpledb/storage"
+)
 
 type fieldInfo struct {
 	Type  storage.FieldType
 	Index storage.SmallInt
+	fieldConstraints
+}
+
+// fieldConstraints are the NOT NULL, DEFAULT and CHECK constraints of a field of a table.
+// The default value and the check are kept as SQL text, which is empty if the field has none.
+// Only the schemas of stored tables have constraints.
+type fieldConstraints struct {
+	NotNull bool
+	Default string
+	Check   string
 }
 
 // Schema is the record schema of a table.
@@ -30,10 +44,40 @@ func newJoinedSchema(first Schema, second Schema) Schema {
 	return schema
 }
 
+// newQualifiedSchema returns a copy of the schema
+// whose fields are qualified by the qualifier, as in qualifier.field.
+func newQualifiedSchema(qualifier string, schema Schema) Schema {
+	qs := newSchema()
+	for _, f := range schema.fields {
+		qs.setFieldAtIndex(qualifier+"."+f, schema.ftype(f), schema.info[f].Index)
+	}
+
+	return qs
+}
+
+// unqualified returns the name of the field without its qualifier.
+func unqualified(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+
+	return name
+}
+
 func (s *Schema) ftype(name string) storage.FieldType {
 	return s.info[name].Type
 }
 
+// Fields returns the names of the fields, in index order.
+func (s Schema) Fields() []string {
+	return s.fields
+}
+
+// Type returns the type of the field.
+func (s Schema) Type(name string) storage.FieldType {
+	return s.info[name].Type
+}
+
 func (s *Schema) FieldInfo(name string) fieldInfo {
 	return s.info[name]
 }
@@ -54,6 +98,13 @@ func (s *Schema) setFieldAtIndex(name string, typ storage.FieldType, index stora
 	}
 }
 
+// setConstraints sets the constraints of the field.
+func (s *Schema) setConstraints(name string, c fieldConstraints) {
+	info := s.info[name]
+	info.fieldConstraints = c
+	s.info[name] = info
+}
+
 func (s *Schema) add(fname string, schema Schema) {
 	t := schema.ftype(fname)
 	s.addField(fname, t)
@@ -65,6 +116,22 @@ func (s *Schema) addAll(schema Schema) {
 	}
 }
 
+// compatibleWith returns true if the schema has as many fields as other,
+// and each field has the type of the field of other in the same position.
+func (s Schema) compatibleWith(other Schema) bool {
+	if len(s.fields) != len(other.fields) {
+		return false
+	}
+
+	for i, f := range s.fields {
+		if s.Type(f) != other.Type(other.fields[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (s Schema) HasField(fname string) bool {
 	_, ok := s.info[fname]
 	return ok
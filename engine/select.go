@@ -67,6 +67,11 @@ func (sel *Select) HasField(fname string) bool {
 	return sel.scan.HasField(fname)
 }
 
+// Type implements Scan.
+func (sel *Select) Type(fname string) storage.FieldType {
+	return sel.scan.Type(fname)
+}
+
 // Next estabilishes a new current record.
 // It loops through the underlying scan looking
 // for a record that satisfies the underlying predicate.
@@ -83,9 +88,14 @@ func (sel *Select) Next() error {
 			return err
 		}
 
-		if ok, err := sel.predicate.IsSatisfied(sel.scan); ok {
+		ok, err := sel.predicate.IsSatisfied(sel.scan)
+		if err != nil {
 			return err
 		}
+
+		if ok {
+			return nil
+		}
 	}
 
 	return io.EOF
@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"io"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+)
+
+var (
+	_ Plan = &semiJoinPlan{}
+	_ Scan = &semiJoinScan{}
+)
+
+// semiJoinPlan outputs the records of a plan for which
+// an expression is one of the values returned by an uncorrelated subquery,
+// as in WHERE e IN (SELECT ...), or, as an anti-join, for which it is none of them,
+// as in WHERE e NOT IN (SELECT ...).
+// The subquery runs once, when the plan is opened, and its values are loaded into a hash set.
+// NOT IN is unknown if either e or any value of the subquery is NULL,
+// so the anti-join outputs no record once the subquery returns a NULL,
+// unless the subquery returns no records at all.
+type semiJoinPlan struct {
+	p    Plan
+	pred sql.Predicate
+	lhs  sql.Expression
+	sub  Plan
+	// field is the column of the subquery
+	field string
+	anti  bool
+}
+
+func newSemiJoinPlan(p Plan, pred sql.Predicate, lhs sql.Expression, sub Plan, anti bool) (*semiJoinPlan, error) {
+	field := sub.Schema().Fields()[0]
+
+	lt, err := lhs.Type(p.Schema())
+	if err != nil {
+		return nil, err
+	}
+
+	st := sub.Schema().Type(field)
+	if lt != st && (!lt.IsInteger() || !st.IsInteger()) {
+		return nil, sql.ErrTypeMismatch
+	}
+
+	return &semiJoinPlan{
+		p:     p,
+		pred:  pred,
+		lhs:   lhs,
+		sub:   sub,
+		field: field,
+		anti:  anti,
+	}, nil
+}
+
+// semiJoinKey returns the key of the value in the hash set of a semi-join.
+// Integers of different sizes are widened to a LONG, so that equal integers have equal keys.
+func semiJoinKey(t storage.FieldType, v storage.Value) string {
+	if t.IsInteger() {
+		return string(storage.ValueFromLong(storage.LONG, storage.ValueAsLong(t, v)))
+	}
+
+	return string(v)
+}
+
+func (sp *semiJoinPlan) Open() (Scan, error) {
+	sub, err := sp.sub.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer sub.Close()
+
+	ss := &semiJoinScan{
+		lhs:   sp.lhs,
+		anti:  sp.anti,
+		empty: true,
+		set:   map[string]struct{}{},
+	}
+
+	t := sp.sub.Schema().Type(sp.field)
+	for {
+		err := sub.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := sub.Val(sp.field)
+		if err != nil {
+			return nil, err
+		}
+
+		ss.empty = false
+		if v.IsNull() {
+			ss.hasNull = true
+			continue
+		}
+
+		ss.set[semiJoinKey(t, v)] = struct{}{}
+	}
+
+	s, err := sp.p.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	ss.scan = s
+
+	return ss, nil
+}
+
+// BlocksAccessed is the cost of reading the plan and running the subquery once.
+func (sp *semiJoinPlan) BlocksAccessed() int {
+	return sp.p.BlocksAccessed() + sp.sub.BlocksAccessed()
+}
+
+func (sp *semiJoinPlan) RecordsOutput() int {
+	return sp.p.RecordsOutput() / sp.pred.ReductionFactor(sp)
+}
+
+func (sp *semiJoinPlan) DistinctValues(fieldName string) int {
+	return sp.p.DistinctValues(fieldName)
+}
+
+func (sp *semiJoinPlan) Schema() Schema {
+	return sp.p.Schema()
+}
+
+// semiJoinScan filters the records of the scan
+// by looking up the value of the expression in the hash set.
+type semiJoinScan struct {
+	scan Scan
+	lhs  sql.Expression
+	anti bool
+	set  map[string]struct{}
+	// empty is true if the subquery returned no records
+	empty bool
+	// hasNull is true if the subquery returned a NULL
+	hasNull bool
+}
+
+func (ss *semiJoinScan) BeforeFirst() error {
+	return ss.scan.BeforeFirst()
+}
+
+func (ss *semiJoinScan) Next() error {
+	for {
+		if err := ss.scan.Next(); err != nil {
+			return err
+		}
+
+		ok, err := ss.matches()
+		if err != nil || ok {
+			return err
+		}
+	}
+}
+
+// matches returns true if the current record satisfies IN, or NOT IN for an anti-join.
+func (ss *semiJoinScan) matches() (bool, error) {
+	if ss.anti && ss.empty {
+		return true, nil
+	}
+
+	v, err := ss.lhs.Evaluate(ss.scan)
+	if err != nil {
+		return false, err
+	}
+
+	if v.IsNull() {
+		return false, nil
+	}
+
+	t, err := ss.lhs.Type(ss.scan)
+	if err != nil {
+		return false, err
+	}
+
+	_, found := ss.set[semiJoinKey(t, v)]
+	if !ss.anti {
+		return found, nil
+	}
+
+	return !found && !ss.hasNull, nil
+}
+
+func (ss *semiJoinScan) Val(fieldName string) (storage.Value, error) {
+	return ss.scan.Val(fieldName)
+}
+
+func (ss *semiJoinScan) HasField(fieldName string) bool {
+	return ss.scan.HasField(fieldName)
+}
+
+func (ss *semiJoinScan) Type(fieldName string) storage.FieldType {
+	return ss.scan.Type(fieldName)
+}
+
+func (ss *semiJoinScan) Close() {
+	ss.scan.Close()
+}
+
+// semiJoins splits the uncorrelated IN and NOT IN conjuncts out of the <WHERE> clause,
+// and returns the query with the remaining conjuncts.
+func semiJoins(data sql.Query) (sql.Query, []sql.Predicate) {
+	var rest, semis []sql.Predicate
+	for _, c := range data.Predicate().Conjuncts() {
+		if _, sq, _, ok := c.SubqueryTerm(); ok && !sq.IsCorrelated() {
+			semis = append(semis, c)
+			continue
+		}
+
+		rest = append(rest, c)
+	}
+
+	return data.WithPredicate(sql.NewConjunction(rest)), semis
+}
+
+// addSemiJoins filters the plan with a semi-join or an anti-join for each of the conjuncts.
+func addSemiJoins(p Plan, semis []sql.Predicate) (Plan, error) {
+	for _, c := range semis {
+		lhs, sq, anti, _ := c.SubqueryTerm()
+
+		sp, err := newSemiJoinPlan(p, c, lhs, sq.Evaluator().(*subqueryEvaluator).plan, anti)
+		if err != nil {
+			return nil, err
+		}
+
+		p = sp
+	}
+
+	return p, nil
+}
@@ -0,0 +1,486 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+const (
+	seqCatalogTableName       = "sequences"
+	seqCatalogNameField       = "name"
+	seqCatalogBlockField      = "block"
+	seqCatalogIncrementField  = "increment"
+	seqCatalogOwnerTableField = "owner_table"
+	seqCatalogOwnerFieldField = "owner_field"
+
+	// seqValuesFileName is the file that holds the next value of each sequence,
+	// one block for each sequence.
+	seqValuesFileName = "sequence_values"
+
+	seqFreeTableName      = "sequence_free_blocks"
+	seqFreeBlockField     = "block"
+	seqFreeDroppedByField = "dropped_by"
+
+	sizeOfSeqCatalogRecord = storage.SizeOfName + // sequence name
+		storage.SizeOfLong + // block of the next value of the sequence
+		storage.SizeOfLong + // increment
+		storage.SizeOfName + // table of the field that owns the sequence
+		storage.SizeOfName // field that owns the sequence
+
+	sizeOfSeqFreeRecord = storage.SizeOfLong + // free block of the sequence values file
+		storage.SizeOfInt // transaction that dropped the sequence of the block
+)
+
+var (
+	ErrSequenceNotFound = errors.New("cannot find sequence in catalog")
+	ErrSequenceExists   = errors.New("sequence already exists")
+	ErrSequenceOwned    = errors.New("sequence is owned by a field")
+)
+
+// sequence is a generator of integers, which starts from next and grows by increment.
+// The sequence of an auto increment field is owned by the field, and is dropped together with it.
+// Sequences that are not owned by a field have empty owners.
+type sequence struct {
+	name       string
+	next       storage.Long
+	increment  storage.Long
+	ownerTable string
+	ownerField string
+}
+
+// sequenceName returns the name of the sequence owned by the field of the table.
+func sequenceName(table string, field string) string {
+	return table + "_" + field + "_seq"
+}
+
+// sequenceManager stores the sequences in the sequence catalog,
+// one record for each sequence, holding its increment and its owner.
+//
+// The next value of each sequence is kept apart from the catalog, in a block of its own
+// of the sequence values file, which is only ever written by autonomous transactions.
+// The value is stored when the sequence is created, and it is advanced when a value is drawn,
+// by an autonomous transaction that commits straight away: the update is logged and flushed to the WAL,
+// so that a value is never returned twice, even after a crash,
+// and the lock on the block is released as soon as the value is drawn.
+// Since the transactions of the statements never lock the blocks of the values,
+// a transaction can draw from any sequence, including the ones it created or whose catalog records it locked.
+// Values are not given back if the transaction that draws them rolls back, so sequences can have gaps.
+//
+// The blocks of dropped sequences are recorded in the free blocks catalog, and are reused by the sequences created later.
+// A block is freed by the transaction that drops its sequence, so it goes back to its sequence if the transaction rolls back,
+// and it is never reused by that same transaction:
+// the value stored by the new sequence is not undone by a rollback, and would overwrite the one of the dropped sequence.
+// The block of a sequence whose creation rolls back goes back to the free blocks catalog, if it came from there,
+// or is left unused otherwise.
+type sequenceManager struct {
+	l     Layout
+	freeL Layout
+	tm    *tableManager
+
+	// mu serializes the updates of the values,
+	// so that two autonomous transactions never wait on each other for the lock on a block.
+	mu sync.Mutex
+}
+
+func seqCatalogSchema() Schema {
+	schema := newSchema()
+	schema.addField(seqCatalogNameField, storage.NAME)
+	schema.addField(seqCatalogBlockField, storage.LONG)
+	schema.addField(seqCatalogIncrementField, storage.LONG)
+	schema.addField(seqCatalogOwnerTableField, storage.NAME)
+	schema.addField(seqCatalogOwnerFieldField, storage.NAME)
+	return schema
+}
+
+func seqFreeSchema() Schema {
+	schema := newSchema()
+	schema.addField(seqFreeBlockField, storage.LONG)
+	schema.addField(seqFreeDroppedByField, storage.INT)
+	return schema
+}
+
+func newSequenceManager(tm *tableManager) *sequenceManager {
+	return &sequenceManager{
+		l:     NewLayout(seqCatalogSchema()),
+		freeL: NewLayout(seqFreeSchema()),
+		tm:    tm,
+	}
+}
+
+func (sm *sequenceManager) init(x tx.Transaction) error {
+	if err := sm.tm.createTable(seqCatalogTableName, sm.l.schema, x); err != nil {
+		return err
+	}
+
+	return sm.tm.createTable(seqFreeTableName, sm.freeL.schema, x)
+}
+
+// createSequence stores the sequence into the catalog.
+// It returns ErrSequenceExists if another sequence has the same name.
+func (sm *sequenceManager) createSequence(x tx.Transaction, seq sequence) error {
+	exists, err := sm.sequenceExists(x, seq.name)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return fmt.Errorf("%w: %q", ErrSequenceExists, seq.name)
+	}
+
+	block, err := sm.storeValue(x, seq.next)
+	if err != nil {
+		return err
+	}
+
+	ts := newTableScan(x, seqCatalogTableName, sm.l)
+	defer ts.Close()
+
+	if err := ts.Insert(sizeOfSeqCatalogRecord); err != nil {
+		return err
+	}
+
+	for field, name := range map[string]string{
+		seqCatalogNameField:       seq.name,
+		seqCatalogOwnerTableField: seq.ownerTable,
+		seqCatalogOwnerFieldField: seq.ownerField,
+	} {
+		if err := ts.SetVal(field, storage.ValueFromName(storage.NewNameFromGoString(name))); err != nil {
+			return err
+		}
+	}
+
+	if err := ts.SetVal(seqCatalogBlockField, storage.ValueFromLong(storage.LONG, block.Number())); err != nil {
+		return err
+	}
+
+	return ts.SetVal(seqCatalogIncrementField, storage.ValueFromLong(storage.LONG, seq.increment))
+}
+
+// storeValue stores the value into a free block of the sequence values file,
+// or into a block appended to it if none is free, in an autonomous transaction.
+// The free block is taken from the free blocks catalog by the transaction.
+func (sm *sequenceManager) storeValue(x tx.Transaction, v storage.Long) (storage.Block, error) {
+	free, ok, err := sm.takeFreeBlock(x)
+	if err != nil {
+		return storage.Block{}, err
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	ax := x.Autonomous()
+
+	block := storage.NewBlock(seqValuesFileName, free)
+	if !ok {
+		block, err = ax.Append(seqValuesFileName)
+		if err != nil {
+			ax.Rollback()
+			return storage.Block{}, err
+		}
+	}
+
+	if err := ax.SetFixedlen(block, 0, storage.SizeOfLong, storage.IntegerToFixedLen[storage.Long](storage.SizeOfLong, v), true); err != nil {
+		ax.Rollback()
+		return storage.Block{}, err
+	}
+
+	ax.Commit()
+
+	return block, nil
+}
+
+// takeFreeBlock removes from the free blocks catalog the first block
+// that was not freed by the transaction, and returns its number.
+// It reports false if there is no such block.
+func (sm *sequenceManager) takeFreeBlock(x tx.Transaction) (storage.Long, bool, error) {
+	ts := newTableScan(x, seqFreeTableName, sm.freeL)
+	defer ts.Close()
+
+	for {
+		err := ts.Next()
+		if err == io.EOF {
+			return 0, false, nil
+		}
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		by, err := ts.Val(seqFreeDroppedByField)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if storage.ValueAsInteger[storage.TxID](by) == x.Id() {
+			continue
+		}
+
+		block, err := ts.Val(seqFreeBlockField)
+		if err != nil {
+			return 0, false, err
+		}
+
+		num := storage.ValueAsLong(storage.LONG, block)
+		if err := ts.Delete(); err != nil {
+			return 0, false, err
+		}
+
+		return num, true, nil
+	}
+}
+
+// freeBlocks records the blocks of the sequences dropped by the transaction in the free blocks catalog.
+func (sm *sequenceManager) freeBlocks(x tx.Transaction, blocks []storage.Long) error {
+	ts := newTableScan(x, seqFreeTableName, sm.freeL)
+	defer ts.Close()
+
+	for _, block := range blocks {
+		if err := ts.Insert(sizeOfSeqFreeRecord); err != nil {
+			return err
+		}
+
+		if err := ts.SetVal(seqFreeBlockField, storage.ValueFromLong(storage.LONG, block)); err != nil {
+			return err
+		}
+
+		if err := ts.SetVal(seqFreeDroppedByField, storage.ValueFromInteger(storage.SizeOfTxID, x.Id())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// seqBlock returns the block of the sequence values file of the record of the catalog the scan is on.
+func seqBlock(ts *tableScan) (storage.Long, error) {
+	v, err := ts.Val(seqCatalogBlockField)
+	if err != nil {
+		return 0, err
+	}
+
+	return storage.ValueAsLong(storage.LONG, v), nil
+}
+
+// sequenceExists reports whether a sequence is named name.
+func (sm *sequenceManager) sequenceExists(x tx.Transaction, name string) (bool, error) {
+	ts := newTableScan(x, seqCatalogTableName, sm.l)
+	defer ts.Close()
+
+	err := seekSequence(ts, name)
+	if errors.Is(err, ErrSequenceNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// seekSequence moves the scan of the catalog to the record of the sequence.
+func seekSequence(ts *tableScan, name string) error {
+	for {
+		err := ts.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%w: %q", ErrSequenceNotFound, name)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		v, err := ts.Val(seqCatalogNameField)
+		if err != nil {
+			return err
+		}
+
+		if v.AsName().AsGoString() == name {
+			return nil
+		}
+	}
+}
+
+// nextval returns the next value of the sequence and advances it.
+// The sequence is read from the catalog by the transaction,
+// while its value is advanced by an autonomous transaction.
+func (sm *sequenceManager) nextval(x tx.Transaction, name string) (storage.Long, error) {
+	ts := newTableScan(x, seqCatalogTableName, sm.l)
+	defer ts.Close()
+
+	if err := seekSequence(ts, name); err != nil {
+		return 0, err
+	}
+
+	block, err := seqBlock(ts)
+	if err != nil {
+		return 0, err
+	}
+
+	increment, err := ts.Val(seqCatalogIncrementField)
+	if err != nil {
+		return 0, err
+	}
+
+	return sm.advance(
+		x,
+		storage.NewBlock(seqValuesFileName, block),
+		storage.ValueAsLong(storage.LONG, increment),
+	)
+}
+
+// advance returns the value stored in the block and stores the one after it,
+// in an autonomous transaction.
+func (sm *sequenceManager) advance(x tx.Transaction, block storage.Block, increment storage.Long) (storage.Long, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	ax := x.Autonomous()
+
+	next, err := ax.Fixedlen(block, 0, storage.SizeOfLong)
+	if err != nil {
+		ax.Rollback()
+		return 0, err
+	}
+
+	v := storage.FixedLenToInteger[storage.Long](next)
+	if err := ax.SetFixedlen(block, 0, storage.SizeOfLong, storage.IntegerToFixedLen[storage.Long](storage.SizeOfLong, v+increment), true); err != nil {
+		ax.Rollback()
+		return 0, err
+	}
+
+	ax.Commit()
+
+	return v, nil
+}
+
+// dropSequence removes the sequence from the catalog and frees its block.
+// If the sequence cannot be found returns an ErrSequenceNotFound.
+// The sequence of an auto increment field is dropped with the field,
+// and dropping it on its own returns an ErrSequenceOwned.
+func (sm *sequenceManager) dropSequence(x tx.Transaction, name string) error {
+	owned := false
+	var blocks []storage.Long
+	n, err := deleteCatalogRecords(x, seqCatalogTableName, sm.l, seqCatalogNameField, name, func(ts *tableScan) (bool, error) {
+		v, err := ts.Val(seqCatalogOwnerTableField)
+		if err != nil {
+			return false, err
+		}
+
+		owned = v.AsName().AsGoString() != ""
+		if owned {
+			return false, nil
+		}
+
+		block, err := seqBlock(ts)
+		if err != nil {
+			return false, err
+		}
+
+		blocks = append(blocks, block)
+		return true, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if owned {
+		return fmt.Errorf("%w: %q", ErrSequenceOwned, name)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%w: %q", ErrSequenceNotFound, name)
+	}
+
+	return sm.freeBlocks(x, blocks)
+}
+
+// dropOwnedSequences removes from the catalog the sequences owned by the fields of the table,
+// or only the one owned by fldName if it is not empty, and frees their blocks.
+func (sm *sequenceManager) dropOwnedSequences(x tx.Transaction, tblName string, fldName string) error {
+	var blocks []storage.Long
+	_, err := deleteCatalogRecords(x, seqCatalogTableName, sm.l, seqCatalogOwnerTableField, tblName, func(ts *tableScan) (bool, error) {
+		if fldName != "" {
+			v, err := ts.Val(seqCatalogOwnerFieldField)
+			if err != nil {
+				return false, err
+			}
+
+			if v.AsName().AsGoString() != fldName {
+				return false, nil
+			}
+		}
+
+		block, err := seqBlock(ts)
+		if err != nil {
+			return false, err
+		}
+
+		blocks = append(blocks, block)
+		return true, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return sm.freeBlocks(x, blocks)
+}
+
+// renameOwners moves the sequences owned by the fields of the table to the table newTblName.
+// fields maps the names of the renamed fields to their new names.
+// Sequences keep their names, which the defaults of their fields refer to.
+func (sm *sequenceManager) renameOwners(x tx.Transaction, tblName string, newTblName string, fields map[string]string) error {
+	ts := newTableScan(x, seqCatalogTableName, sm.l)
+	defer ts.Close()
+
+	for {
+		err := ts.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		table, err := ts.Val(seqCatalogOwnerTableField)
+		if err != nil {
+			return err
+		}
+
+		if table.AsName().AsGoString() != tblName {
+			continue
+		}
+
+		if err := ts.SetVal(seqCatalogOwnerTableField, storage.ValueFromName(storage.NewNameFromGoString(newTblName))); err != nil {
+			return err
+		}
+
+		field, err := ts.Val(seqCatalogOwnerFieldField)
+		if err != nil {
+			return err
+		}
+
+		newName, ok := fields[field.AsName().AsGoString()]
+		if !ok {
+			continue
+		}
+
+		if err := ts.SetVal(seqCatalogOwnerFieldField, storage.ValueFromName(storage.NewNameFromGoString(newName))); err != nil {
+			return err
+		}
+	}
+}
+
+// sequenceGenerator draws the values of the sequences for the statements of a transaction.
+type sequenceGenerator struct {
+	x  tx.Transaction
+	sm *sequenceManager
+}
+
+func (g sequenceGenerator) NextVal(name string) (storage.Long, error) {
+	return g.sm.nextval(g.x, name)
+}
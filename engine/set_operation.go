@@ -0,0 +1,407 @@
+package engine
+
+import (
+	"errors"
+	"io"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+var ErrIncompatibleSchemas = errors.New("the queries of a set operation must return the same number of columns with the same types")
+
+var (
+	_ Plan = &setOperationPlan{}
+	_ Scan = &setOperationScan{}
+	_ Scan = &unionAllScan{}
+)
+
+// setOperationPlan combines the records of two plans with compatible schemas.
+// The columns of the second plan are renamed after those of the first.
+// UNION ALL reads the first plan and then the second.
+// The other set operations sort both plans on all their fields,
+// so that equal records are next to each other, and merge them:
+// each group of equal records is output as many times as the operation requires.
+// NULLs are not distinct from each other.
+type setOperationPlan struct {
+	x   tx.Transaction
+	op  sql.SetOp
+	all bool
+	p1  Plan
+	p2  Plan
+	// rhsFields are the fields of the second plan, before they are renamed
+	rhsFields []string
+}
+
+func newSetOperationPlan(x tx.Transaction, op sql.SetOp, all bool, p1 Plan, p2 Plan) (*setOperationPlan, error) {
+	s1, s2 := p1.Schema(), p2.Schema()
+	if !s1.compatibleWith(s2) {
+		return nil, ErrIncompatibleSchemas
+	}
+
+	renamed, err := newRenamePlan(p2, s1.Fields())
+	if err != nil {
+		return nil, err
+	}
+
+	return &setOperationPlan{
+		x:         x,
+		op:        op,
+		all:       all,
+		p1:        p1,
+		p2:        renamed,
+		rhsFields: s2.Fields(),
+	}, nil
+}
+
+// merges returns true if the plan sorts and merges its inputs.
+func (sp *setOperationPlan) merges() bool {
+	return sp.op != sql.SetUnion || !sp.all
+}
+
+// sorted returns the plans sorted on all their fields.
+func (sp *setOperationPlan) sorted() (*sortPlan, *sortPlan) {
+	keys := ascending(sp.p1.Schema().Fields())
+	return newSortPlan(sp.x, sp.p1, keys), newSortPlan(sp.x, sp.p2, keys)
+}
+
+func (sp *setOperationPlan) Open() (Scan, error) {
+	var p1, p2 Plan = sp.p1, sp.p2
+	if sp.merges() {
+		p1, p2 = sp.sorted()
+	}
+
+	s1, err := p1.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	s2, err := p2.Open()
+	if err != nil {
+		s1.Close()
+		return nil, err
+	}
+
+	if !sp.merges() {
+		return newUnionAllScan(s1, s2), nil
+	}
+
+	ss := newSetOperationScan(sp.op, sp.all, sp.p1.Schema(), s1, s2)
+	if err := ss.BeforeFirst(); err != nil {
+		ss.Close()
+		return nil, err
+	}
+
+	return ss, nil
+}
+
+// BlocksAccessed is the cost of reading both plans,
+// which is the cost of sorting them if they are merged.
+func (sp *setOperationPlan) BlocksAccessed() int {
+	if !sp.merges() {
+		return sp.p1.BlocksAccessed() + sp.p2.BlocksAccessed()
+	}
+
+	sp1, sp2 := sp.sorted()
+
+	return sp1.BlocksAccessed() + sp2.BlocksAccessed()
+}
+
+// RecordsOutput is the number of records of both plans for a union,
+// the number of records of the smaller plan for an intersection,
+// and the number of records of the first plan for a difference.
+func (sp *setOperationPlan) RecordsOutput() int {
+	r1, r2 := sp.p1.RecordsOutput(), sp.p2.RecordsOutput()
+	switch sp.op {
+	case sql.SetIntersect:
+		return min(r1, r2)
+	case sql.SetExcept:
+		return r1
+	}
+
+	return r1 + r2
+}
+
+func (sp *setOperationPlan) DistinctValues(fieldName string) int {
+	d1 := sp.p1.DistinctValues(fieldName)
+	d2 := sp.p2.DistinctValues(fieldName)
+	switch sp.op {
+	case sql.SetIntersect:
+		return min(d1, d2)
+	case sql.SetExcept:
+		return d1
+	}
+
+	return min(d1+d2, sp.RecordsOutput())
+}
+
+func (sp *setOperationPlan) Schema() Schema {
+	return sp.p1.Schema()
+}
+
+// setOperationScan merges two scans sorted on all their fields.
+// The current record is a copy of the group of equal records being output,
+// and remaining is the number of times it has yet to be output.
+type setOperationScan struct {
+	op         sql.SetOp
+	all        bool
+	schema     Schema
+	comparator recordComparator
+	s1         Scan
+	s2         Scan
+	// has1 and has2 are true if the scans are positioned on a record
+	has1      bool
+	has2      bool
+	current   memRecord
+	remaining int
+}
+
+func newSetOperationScan(op sql.SetOp, all bool, schema Schema, s1 Scan, s2 Scan) *setOperationScan {
+	return &setOperationScan{
+		op:     op,
+		all:    all,
+		schema: schema,
+		comparator: recordComparator{
+			schema:   schema,
+			sortKeys: ascending(schema.Fields()),
+		},
+		s1: s1,
+		s2: s2,
+	}
+}
+
+func (ss *setOperationScan) BeforeFirst() error {
+	ss.remaining = 0
+
+	if err := ss.s1.BeforeFirst(); err != nil {
+		return err
+	}
+
+	if err := ss.s2.BeforeFirst(); err != nil {
+		return err
+	}
+
+	var err error
+	ss.has1, err = hasNextOrError(ss.s1)
+	if err != nil {
+		return err
+	}
+
+	ss.has2, err = hasNextOrError(ss.s2)
+
+	return err
+}
+
+// Next outputs the current group again, if it has copies left.
+// Otherwise, it moves to the group of records equal to the lowest record of the two scans,
+// counts how many of them each scan has, and decides how many copies to output.
+func (ss *setOperationScan) Next() error {
+	for ss.remaining == 0 {
+		if !ss.has1 && !ss.has2 {
+			return io.EOF
+		}
+
+		src := ss.s1
+		if !ss.has1 {
+			src = ss.s2
+		}
+
+		rec, err := copyRecord(&ss.schema, src)
+		if err != nil {
+			return err
+		}
+
+		// the record of the first scan is compared once it is copied,
+		// since reading the second scan can take over the buffer it is read from
+		if ss.has1 && ss.has2 {
+			less, err := ss.comparator.Less(ss.s2, rec)
+			if err != nil {
+				return err
+			}
+
+			if less {
+				if rec, err = copyRecord(&ss.schema, ss.s2); err != nil {
+					return err
+				}
+			}
+		}
+
+		m, err := ss.countGroup(ss.s1, &ss.has1, rec)
+		if err != nil {
+			return err
+		}
+
+		n, err := ss.countGroup(ss.s2, &ss.has2, rec)
+		if err != nil {
+			return err
+		}
+
+		ss.current = rec
+		ss.remaining = ss.copies(m, n)
+	}
+
+	ss.remaining--
+
+	return nil
+}
+
+// countGroup moves the scan past the records equal to rec, and returns how many they are.
+func (ss *setOperationScan) countGroup(s Scan, has *bool, rec memRecord) (int, error) {
+	var count int
+	for *has {
+		less, err := ss.comparator.Less(rec, s)
+		if err != nil || less {
+			return count, err
+		}
+
+		count++
+
+		*has, err = hasNextOrError(s)
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// copies returns how many times a record is output,
+// if the first scan has m copies of it and the second n.
+func (ss *setOperationScan) copies(m int, n int) int {
+	switch ss.op {
+	case sql.SetIntersect:
+		if ss.all {
+			return min(m, n)
+		}
+
+		if m > 0 && n > 0 {
+			return 1
+		}
+	case sql.SetExcept:
+		if ss.all {
+			return max(m-n, 0)
+		}
+
+		if m > 0 && n == 0 {
+			return 1
+		}
+	default:
+		return 1
+	}
+
+	return 0
+}
+
+func (ss *setOperationScan) Val(fieldName string) (storage.Value, error) {
+	return ss.current.Val(fieldName)
+}
+
+func (ss *setOperationScan) HasField(fieldName string) bool {
+	return ss.schema.HasField(fieldName)
+}
+
+func (ss *setOperationScan) Type(fieldName string) storage.FieldType {
+	return ss.schema.Type(fieldName)
+}
+
+func (ss *setOperationScan) Close() {
+	ss.s1.Close()
+	ss.s2.Close()
+}
+
+// unionAllScan outputs the records of the first scan, followed by those of the second.
+type unionAllScan struct {
+	s1     Scan
+	s2     Scan
+	second bool
+}
+
+func newUnionAllScan(s1 Scan, s2 Scan) *unionAllScan {
+	return &unionAllScan{
+		s1: s1,
+		s2: s2,
+	}
+}
+
+func (us *unionAllScan) BeforeFirst() error {
+	us.second = false
+
+	if err := us.s1.BeforeFirst(); err != nil {
+		return err
+	}
+
+	return us.s2.BeforeFirst()
+}
+
+func (us *unionAllScan) Next() error {
+	if !us.second {
+		err := us.s1.Next()
+		if err != io.EOF {
+			return err
+		}
+
+		us.second = true
+	}
+
+	return us.s2.Next()
+}
+
+func (us *unionAllScan) Val(fieldName string) (storage.Value, error) {
+	if us.second {
+		return us.s2.Val(fieldName)
+	}
+
+	return us.s1.Val(fieldName)
+}
+
+func (us *unionAllScan) HasField(fieldName string) bool {
+	return us.s1.HasField(fieldName)
+}
+
+func (us *unionAllScan) Type(fieldName string) storage.FieldType {
+	return us.s1.Type(fieldName)
+}
+
+func (us *unionAllScan) Close() {
+	us.s1.Close()
+	us.s2.Close()
+}
+
+// planSetOperation plans a query whose records are computed by a set operation.
+// Each side is planned on its own, in the scope of the query,
+// and the result is sorted on the fields of the <ORDER BY> clause,
+// which must be output columns, and limited by the <LIMIT> clause.
+func planSetOperation(x tx.Transaction, qp subqueryPlanner, so sql.SetOperation, data sql.Query, scope *subqueryScope) (Plan, error) {
+	p1, err := qp.createPlan(so.Lhs, x, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	p2, err := qp.createPlan(so.Rhs, x, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	var out Plan
+	out, err = newSetOperationPlan(x, so.Op, so.All, p1, p2)
+	if err != nil {
+		return nil, err
+	}
+
+	if keys := data.OrderBy(); len(keys) > 0 {
+		for _, k := range keys {
+			if !out.Schema().HasField(k.Field) {
+				return nil, ErrNoField
+			}
+		}
+
+		out = newSortPlan(x, out, keys)
+	}
+
+	if limit, ok := data.Limit(); ok {
+		out = newLimitPlan(out, limit, data.Offset())
+	}
+
+	return out, nil
+}
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"io"
+	"strings"
 	"sync"
 
 	"github.com/luigitni/simpledb/storage"
@@ -11,10 +12,19 @@ import (
 type statInfo struct {
 	blocks  storage.Long
 	records int
+	// distinct holds the number of distinct non-NULL values of each field
+	distinct map[string]int
 }
 
+// distinctValues returns the number of distinct values of the field.
+// If the field has not been counted, the value is estimated from the number of records.
 func (si statInfo) distinctValues(fieldName string) int {
-	return 1 + si.records/3 // todo: this is just a stub
+	d, ok := si.distinct[fieldName]
+	if !ok {
+		return 1 + si.records/3
+	}
+
+	return max(1, d)
 }
 
 // statManager manages the statistical information about each table
@@ -83,6 +93,16 @@ func (sm *statManager) statInfo(tname string, layout Layout, trans tx.Transactio
 	return si, nil
 }
 
+// dropStats discards the statistics of the table.
+// They are computed again the next time they are requested,
+// if the table still exists.
+func (sm *statManager) dropStats(tname string) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	delete(sm.tableStats, tname)
+}
+
 // refreshStatistics is invoked by statInfo. It reads the table catalogue and
 // re-computes the statInfo object for each table.
 func (sm *statManager) refreshStatistics(x tx.Transaction) error {
@@ -111,7 +131,8 @@ func (sm *statManager) refreshStatistics(x tx.Transaction) error {
 			return err
 		}
 
-		n := tname.AsName().AsGoString()
+		// the name is kept as a key of the statistics, so it is cloned out of the buffer of the catalog
+		n := strings.Clone(tname.AsName().AsGoString())
 
 		layout, err := sm.layout(n, x)
 		if err != nil {
@@ -131,14 +152,19 @@ func (sm *statManager) refreshStatistics(x tx.Transaction) error {
 	return nil
 }
 
-// calcTableStats scans the whole provided table to count records and blocks and refresh the statInfo
-// for the provided table
+// calcTableStats scans the whole provided table to count records, blocks
+// and the distinct values of each field, and refresh the statInfo for the provided table
 func (sm *statManager) calcTableStats(tname string, layout Layout, trans tx.Transaction) (statInfo, error) {
 	var recs int
 	var blocks storage.Long
 	ts := newTableScan(trans, tname, layout)
 	defer ts.Close()
 
+	values := make(map[string]map[string]struct{}, len(layout.schema.fields))
+	for _, f := range layout.schema.fields {
+		values[f] = map[string]struct{}{}
+	}
+
 	for {
 		err := ts.Next()
 		if err == io.EOF {
@@ -151,10 +177,29 @@ func (sm *statManager) calcTableStats(tname string, layout Layout, trans tx.Tran
 
 		recs++
 		blocks = ts.GetRID().Blocknum + 1
+
+		for _, f := range layout.schema.fields {
+			v, err := ts.Val(f)
+			if err != nil {
+				return statInfo{}, err
+			}
+
+			if v.IsNull() {
+				continue
+			}
+
+			values[f][string(v)] = struct{}{}
+		}
+	}
+
+	distinct := make(map[string]int, len(values))
+	for f, vals := range values {
+		distinct[f] = len(vals)
 	}
 
 	return statInfo{
-		blocks:  blocks,
-		records: recs,
+		blocks:   blocks,
+		records:  recs,
+		distinct: distinct,
 	}, nil
 }
@@ -0,0 +1,348 @@
+package engine
+
+import (
+	"errors"
+	"io"
+	"slices"
+
+	"github.com/luigitni/simpledb/sql"
+	"github.com/luigitni/simpledb/storage"
+	"github.com/luigitni/simpledb/tx"
+)
+
+var (
+	_ Plan                  = &outerRowPlan{}
+	_ Scan                  = &outerRowScan{}
+	_ sql.SubqueryEvaluator = &subqueryEvaluator{}
+)
+
+// subqueryPlanner is a QueryPlanner that can plan the subqueries of a query,
+// whose fields may refer to the fields of the queries they are nested in,
+// and the common table expressions they can read.
+type subqueryPlanner interface {
+	createPlan(data sql.Query, x tx.Transaction, scope *subqueryScope) (Plan, error)
+	// ctes returns the common table expressions visible to the planner
+	ctes() *cteScope
+	// withCTEs returns a copy of the planner that sees the given common table expressions
+	withCTEs(ctes *cteScope) subqueryPlanner
+}
+
+// subqueryScope holds the fields of the outer queries that a subquery can refer to.
+// Fields of the subquery are resolved against its own tables first,
+// then against the outer queries. The fields resolved by the outer queries
+// are the outer references of the subquery, which make it correlated.
+// A correlated subquery reads its outer references from an outer row plan,
+// a single record whose values are those of the current record of the outer query.
+type subqueryScope struct {
+	resolve sql.FieldResolver
+	// schema holds the fields visible to the outer query
+	schema Schema
+	refs   []string
+	row    *outerRow
+}
+
+func newSubqueryScope(resolve sql.FieldResolver, schema Schema) *subqueryScope {
+	return &subqueryScope{
+		resolve: resolve,
+		schema:  schema,
+	}
+}
+
+// resolver returns the resolver of the fields of the subquery,
+// which falls back to the outer queries for the fields that inner does not resolve.
+// A nil scope is the scope of a query that is not nested, so inner is returned as is.
+func (sc *subqueryScope) resolver(inner sql.FieldResolver) sql.FieldResolver {
+	if sc == nil {
+		return inner
+	}
+
+	return func(field string) (string, error) {
+		f, err := inner(field)
+		if !errors.Is(err, ErrNoField) {
+			return f, err
+		}
+
+		f, err = sc.resolve(field)
+		if err != nil {
+			return "", err
+		}
+
+		if !slices.Contains(sc.refs, f) {
+			sc.refs = append(sc.refs, f)
+		}
+
+		return f, nil
+	}
+}
+
+// visibleSchema returns the schema of the fields visible to a query with the given tables:
+// the fields of its tables, followed by the fields of the outer queries that they do not hide.
+func (sc *subqueryScope) visibleSchema(schemas []Schema) Schema {
+	visible := newSchema()
+	for _, s := range schemas {
+		visible.addAll(s)
+	}
+
+	if sc == nil {
+		return visible
+	}
+
+	for _, f := range sc.schema.fields {
+		if !visible.HasField(f) {
+			visible.add(f, sc.schema)
+		}
+	}
+
+	return visible
+}
+
+// rowPlan returns the outer row plan of the subquery,
+// or nil if the subquery is not correlated.
+// It must be called once all the fields of the subquery,
+// including those of its own subqueries, have been resolved.
+// The queries of a set operation share the scope, and so the outer row.
+func (sc *subqueryScope) rowPlan() *outerRowPlan {
+	if sc == nil || len(sc.refs) == 0 {
+		return nil
+	}
+
+	schema := newSchema()
+	for _, f := range sc.refs {
+		schema.add(f, sc.schema)
+	}
+
+	if sc.row == nil {
+		sc.row = &outerRow{}
+	}
+
+	return &outerRowPlan{
+		row:    sc.row,
+		schema: schema,
+	}
+}
+
+// subqueryOutput is queryOutput for a query that may be a correlated subquery,
+// whose outer row plan is row.
+// The fields of the outer row are projected away before *
+// is expanded, so that it only selects the fields of the tables of the subquery.
+func subqueryOutput(x tx.Transaction, p Plan, data sql.Query, resolve sql.FieldResolver, row *outerRowPlan) (Plan, error) {
+	if row == nil || !data.IsStar() {
+		return queryOutput(x, p, data, resolve)
+	}
+
+	var exprs []sql.Expression
+	var names []string
+	for _, f := range p.Schema().Fields() {
+		if !row.schema.HasField(f) {
+			exprs = append(exprs, sql.NewExpressionWithField(f))
+			names = append(names, f)
+		}
+	}
+
+	hidden, err := newProjectPlan(p, exprs, names)
+	if err != nil {
+		return nil, err
+	}
+
+	return queryOutput(x, hidden, data, resolve)
+}
+
+// bindSubqueries plans the subqueries of the query and binds each of them to its evaluator.
+// resolve resolves the fields of the query, and visible holds their types.
+func bindSubqueries(x tx.Transaction, qp subqueryPlanner, data sql.Query, resolve sql.FieldResolver, visible Schema) (sql.Query, error) {
+	return data.BindSubqueries(func(sq sql.Subquery) (sql.Subquery, error) {
+		scope := newSubqueryScope(resolve, visible)
+
+		p, err := qp.createPlan(sq.Query(), x, scope)
+		if err != nil {
+			return sql.Subquery{}, err
+		}
+
+		fields := p.Schema().Fields()
+		if !sq.IsExists() && len(fields) != 1 {
+			return sql.Subquery{}, sql.ErrSubqueryColumns
+		}
+
+		eval := newSubqueryEvaluator(x, p, fields[0], scope.row)
+
+		return sq.Bind(eval, scope.refs), nil
+	})
+}
+
+// subqueryEvaluator evaluates a subquery for the current record of the outer query.
+// An uncorrelated subquery is materialized the first time it is evaluated,
+// and the values of its first column are kept for the following evaluations.
+// A correlated subquery runs again for each record of the outer query,
+// whose values are read through the outer row.
+type subqueryEvaluator struct {
+	x     tx.Transaction
+	plan  Plan
+	field string
+	// row is nil if the subquery is not correlated
+	row       *outerRow
+	vals      []storage.Value
+	evaluated bool
+}
+
+func newSubqueryEvaluator(x tx.Transaction, plan Plan, field string, row *outerRow) *subqueryEvaluator {
+	return &subqueryEvaluator{
+		x:     x,
+		plan:  plan,
+		field: field,
+		row:   row,
+	}
+}
+
+func (se *subqueryEvaluator) Type() storage.FieldType {
+	return se.plan.Schema().Type(se.field)
+}
+
+func (se *subqueryEvaluator) Values(outer sql.Scan) ([]storage.Value, error) {
+	if se.row != nil {
+		se.row.scan = outer
+
+		s, err := se.plan.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		defer s.Close()
+
+		return columnValues(s, se.field)
+	}
+
+	if se.evaluated {
+		return se.vals, nil
+	}
+
+	tt, err := newMaterializePlan(se.x, se.plan).materialize()
+	if err != nil {
+		return nil, err
+	}
+
+	s := tt.Open()
+	defer s.Close()
+
+	vals, err := columnValues(s, se.field)
+	if err != nil {
+		return nil, err
+	}
+
+	se.vals = vals
+	se.evaluated = true
+
+	return vals, nil
+}
+
+func (se *subqueryEvaluator) Exists(outer sql.Scan) (bool, error) {
+	if se.row == nil {
+		vals, err := se.Values(outer)
+		return len(vals) > 0, err
+	}
+
+	se.row.scan = outer
+
+	s, err := se.plan.Open()
+	if err != nil {
+		return false, err
+	}
+
+	defer s.Close()
+
+	return hasNextOrError(s)
+}
+
+// columnValues reads the values of the field from all the records of the scan.
+func columnValues(s Scan, field string) ([]storage.Value, error) {
+	var vals []storage.Value
+	for {
+		err := s.Next()
+		if err == io.EOF {
+			return vals, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := s.Val(field)
+		if err != nil {
+			return nil, err
+		}
+
+		vals = append(vals, storage.Copy(v))
+	}
+}
+
+// outerRow holds the scan of the outer query,
+// positioned on the record a correlated subquery is being evaluated for.
+type outerRow struct {
+	scan sql.Scan
+}
+
+// outerRowPlan outputs a single record,
+// the current record of the outer query, restricted to the outer references of a subquery.
+// It takes part in the plan of the subquery like any other table,
+// so that the predicates on the outer references
+// can be evaluated by selects and joins.
+type outerRowPlan struct {
+	row    *outerRow
+	schema Schema
+}
+
+func (op *outerRowPlan) Open() (Scan, error) {
+	return &outerRowScan{
+		row:    op.row,
+		schema: op.schema,
+	}, nil
+}
+
+func (op *outerRowPlan) BlocksAccessed() int {
+	return 0
+}
+
+func (op *outerRowPlan) RecordsOutput() int {
+	return 1
+}
+
+func (op *outerRowPlan) DistinctValues(fieldName string) int {
+	return 1
+}
+
+func (op *outerRowPlan) Schema() Schema {
+	return op.schema
+}
+
+type outerRowScan struct {
+	row    *outerRow
+	schema Schema
+	done   bool
+}
+
+func (os *outerRowScan) BeforeFirst() error {
+	os.done = false
+	return nil
+}
+
+func (os *outerRowScan) Next() error {
+	if os.done {
+		return io.EOF
+	}
+
+	os.done = true
+	return nil
+}
+
+func (os *outerRowScan) Val(fieldName string) (storage.Value, error) {
+	return os.row.scan.Val(fieldName)
+}
+
+func (os *outerRowScan) HasField(fieldName string) bool {
+	return os.schema.HasField(fieldName)
+}
+
+func (os *outerRowScan) Type(fieldName string) storage.FieldType {
+	return os.schema.ftype(fieldName)
+}
+
+func (os *outerRowScan) Close() {}
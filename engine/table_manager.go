@@ -1,9 +1,12 @@
 package engine
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"strings"
 
 	"github.com/luigitni/simpledb/sql"
 	"github.com/luigitni/simpledb/storage"
@@ -26,12 +29,18 @@ const (
 	fieldsCatalogTypeNameField  = "type_name"
 	fieldsCatalogSizeField      = "field_size"
 	fieldsCatalogIndexField     = "field_index"
+	fieldsCatalogNotNullField   = "not_null"
+	fieldsCatalogDefaultField   = "default_value"
+	fieldsCatalogCheckField     = "check_def"
 
+	// the default value and the check are variable length fields,
+	// whose size is added to that of each record
 	sizeOfFieldsCatalogRecord = storage.SizeOfName + // field name
 		storage.SizeOfName + // table name
 		storage.SizeOfName + // field type
 		storage.SizeOfSmallInt + // field size
-		storage.SizeOfName // field index
+		storage.SizeOfName + // field index
+		storage.SizeOfSmallInt // not null flag
 )
 
 var ErrViewNotFound = errors.New("cannot find table in catalog")
@@ -47,6 +56,7 @@ var ErrViewNotFound = errors.New("cannot find table in catalog")
 //     -- FldName: name of the field.
 //     -- Type: type of the field.
 //     -- FldIdx: index of the field in the table's record layout.
+//     -- NotNull, Default, Check: the constraints of the field, if any.
 type tableManager struct {
 	// tablesCatalog is the catalog table for tables
 	tablesCatalog Layout
@@ -74,6 +84,12 @@ func newTableManager() *tableManager {
 	fieldsCatalog.addField(fieldsCatalogSizeField, storage.SMALLINT)
 	// index of the field within the record
 	fieldsCatalog.addField(fieldsCatalogIndexField, storage.SMALLINT)
+	// 1 if the field cannot be NULL
+	fieldsCatalog.addField(fieldsCatalogNotNullField, storage.SMALLINT)
+	// SQL expression of the default value of the field, NULL if none
+	fieldsCatalog.addField(fieldsCatalogDefaultField, storage.TEXT)
+	// SQL predicate the records must not violate, NULL if none
+	fieldsCatalog.addField(fieldsCatalogCheckField, storage.TEXT)
 
 	fieldsCatalogLayout := NewLayout(fieldsCatalog)
 
@@ -162,8 +178,13 @@ func (tm *tableManager) createTable(tblname string, sch Schema, x tx.Transaction
 	defer fcat.Close()
 
 	for _, fname := range sch.fields {
+		info := sch.FieldInfo(fname)
+		def := constraintText(info.Default)
+		check := constraintText(info.Check)
+
 		// scan up to the first available slot and add the field data to the field catalog
-		if err := fcat.Insert(sizeOfFieldsCatalogRecord); err != nil {
+		size := sizeOfFieldsCatalogRecord + def.Size(storage.TEXT) + check.Size(storage.TEXT)
+		if err := fcat.Insert(size); err != nil {
 			return err
 		}
 
@@ -177,8 +198,6 @@ func (tm *tableManager) createTable(tblname string, sch Schema, x tx.Transaction
 			return err
 		}
 
-		info := sch.FieldInfo(fname)
-
 		if err := fcat.SetVal(
 			fieldsCatalogTypeIDField,
 			storage.ValueFromInteger[storage.SmallInt](storage.SizeOfSmallInt, storage.SmallInt(info.Type)),
@@ -204,11 +223,116 @@ func (tm *tableManager) createTable(tblname string, sch Schema, x tx.Transaction
 		); err != nil {
 			return err
 		}
+
+		var notNull storage.SmallInt
+		if info.NotNull {
+			notNull = 1
+		}
+
+		if err := fcat.SetVal(
+			fieldsCatalogNotNullField,
+			storage.ValueFromInteger[storage.SmallInt](storage.SizeOfSmallInt, notNull),
+		); err != nil {
+			return err
+		}
+
+		if err := fcat.SetVal(fieldsCatalogDefaultField, def); err != nil {
+			return err
+		}
+
+		if err := fcat.SetVal(fieldsCatalogCheckField, check); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// constraintText returns the SQL text of a constraint as a TEXT value,
+// which is NULL if the text is empty.
+func constraintText(s string) storage.Value {
+	if s == "" {
+		return storage.Null
+	}
+
+	return storage.ValueFromGoString(s)
+}
+
+// constraintString returns the SQL text of a constraint stored as a TEXT value.
+// The text is cloned out of the buffer of the catalog, since it is kept in the schema.
+func constraintString(v storage.Value) string {
+	if v.IsNull() {
+		return ""
+	}
+
+	return strings.Clone(storage.VarlenToGoString(v.AsVarlen()))
+}
+
+// dropTable removes the table from the table catalog, and its fields from the field catalog.
+// If the table cannot be found returns an ErrViewNotFound
+func (tm *tableManager) dropTable(tblname string, x tx.Transaction) error {
+	n, err := deleteCatalogRecords(x, tableCatalogTableName, tm.tablesCatalog, tableCatalogNameField, tblname, nil)
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%w: %q", ErrViewNotFound, tblname)
+	}
+
+	_, err = deleteCatalogRecords(x, fieldsCatalogTableName, tm.fieldsCatalog, fieldsCatalogTableNameField, tblname, nil)
+
+	return err
+}
+
+// deleteCatalogRecords deletes the records of a catalog table whose field holds the given name,
+// and returns how many they were.
+// If match is not nil, it is called with the scan positioned on each of those records,
+// and only the records for which it returns true are deleted.
+// Since the records are deleted by the transaction, they are restored if it rolls back.
+func deleteCatalogRecords(x tx.Transaction, catalog string, layout Layout, field string, name string, match func(ts *tableScan) (bool, error)) (int, error) {
+	ts := newTableScan(x, catalog, layout)
+	defer ts.Close()
+
+	var n int
+	for {
+		err := ts.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+
+		if err != nil {
+			return n, err
+		}
+
+		v, err := ts.Val(field)
+		if err != nil {
+			return n, err
+		}
+
+		if v.AsName().AsGoString() != name {
+			continue
+		}
+
+		if match != nil {
+			ok, err := match(ts)
+			if err != nil {
+				return n, err
+			}
+
+			if !ok {
+				continue
+			}
+		}
+
+		if err := ts.Delete(); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+}
+
 // layout opens two table scans, one into the table catalog table and the other one
 // into the fields catalog, and retrieves the layout of the requested table.
 func (tm *tableManager) layout(tblname string, x tx.Transaction) (Layout, error) {
@@ -279,13 +403,43 @@ func (tm *tableManager) layout(tblname string, x tx.Transaction) (Layout, error)
 				return empty, err
 			}
 
+			notNull, err := fcat.Val(fieldsCatalogNotNullField)
+			if err != nil {
+				return empty, err
+			}
+
+			def, err := fcat.Val(fieldsCatalogDefaultField)
+			if err != nil {
+				return empty, err
+			}
+
+			check, err := fcat.Val(fieldsCatalogCheckField)
+			if err != nil {
+				return empty, err
+			}
+
+			// the name is cloned, since it points into the buffer of the catalog,
+			// which is reused once the buffer is unpinned, while the layout can be kept for longer.
+			name := strings.Clone(fldname.AsName().AsGoString())
 			schema.setFieldAtIndex(
-				fldname.AsName().AsGoString(),
+				name,
 				storage.FieldType(storage.ValueAsInteger[storage.SmallInt](fldtype)),
 				storage.ValueAsInteger[storage.SmallInt](fldidx),
 			)
+
+			schema.setConstraints(name, fieldConstraints{
+				NotNull: storage.ValueAsInteger[storage.SmallInt](notNull) == 1,
+				Default: constraintString(def),
+				Check:   constraintString(check),
+			})
 		}
 	}
 
+	// the records of the fields catalog are not in index order,
+	// once the slots freed by deleted records have been reused
+	slices.SortFunc(schema.fields, func(a, b string) int {
+		return cmp.Compare(schema.info[a].Index, schema.info[b].Index)
+	})
+
 	return NewLayout(schema), nil
 }
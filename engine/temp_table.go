@@ -5,6 +5,7 @@ import (
 	"sync/atomic"
 
 	"github.com/luigitni/simpledb/file"
+	"github.com/luigitni/simpledb/storage"
 	"github.com/luigitni/simpledb/tx"
 )
 
@@ -28,7 +29,46 @@ func (tt *tmpTable) Open() UpdateScan {
 	return newTableScan(tt.x, tt.tblName, tt.layout)
 }
 
+// drop removes the file of the table when the transaction commits.
+func (tt *tmpTable) drop() error {
+	return tt.x.RemoveFile(tt.fileName())
+}
+
+// fileName returns the name of the file of the table.
+func (tt *tmpTable) fileName() string {
+	return tableFileName(tt.tblName)
+}
+
 func nextTableName() string {
 	v := atomic.AddInt64(&nextTableNum, 1)
 	return fmt.Sprintf(file.TmpTablePrefix, v)
 }
+
+// varlenEstimatedSize is the size assumed for a variable length field
+// when estimating the size of a record.
+// The layout reserves the maximum size for these fields,
+// but they only take as many bytes as their values.
+const varlenEstimatedSize = 64
+
+// estimatedRecordSize estimates the number of bytes of a record of the schema.
+func estimatedRecordSize(schema Schema) int {
+	var size int
+	for _, f := range schema.fields {
+		s := schema.ftype(f).Size()
+		if s == storage.SizeOfVarlen {
+			size += varlenEstimatedSize
+			continue
+		}
+
+		size += int(s)
+	}
+
+	return max(1, size)
+}
+
+// tmpTableBlocks estimates the number of blocks
+// of a temporary table that holds the output of the plan.
+func tmpTableBlocks(x tx.Transaction, p Plan) int {
+	recordsPerBlock := max(1, int(x.BlockSize())/estimatedRecordSize(p.Schema()))
+	return (p.RecordsOutput() + recordsPerBlock - 1) / recordsPerBlock
+}
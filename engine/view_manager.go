@@ -1,7 +1,9 @@
 package engine
 
 import (
+	"fmt"
 	"io"
+	"strings"
 
 	"github.com/luigitni/simpledb/storage"
 	"github.com/luigitni/simpledb/tx"
@@ -14,7 +16,7 @@ const (
 )
 
 // viewManager stores view definitions in the view catalog.
-// Each view is stored as a single record into the viewcat table.
+// Each view is stored as a single record into the views table.
 type viewManager struct {
 	*tableManager
 }
@@ -34,21 +36,26 @@ func (vm viewManager) init(trans tx.Transaction) error {
 
 // createView adds a view entry into the view catalog.
 func (vm viewManager) createView(vname string, vdef string, trans tx.Transaction) error {
-	layout, err := vm.layout("viewcat", trans)
+	layout, err := vm.layout(viewCatalogTableName, trans)
 	if err != nil {
 		return err
 	}
 
-	ts := newTableScan(trans, "viewcat", layout)
+	ts := newTableScan(trans, viewCatalogTableName, layout)
 	defer ts.Close()
 
-	vn := storage.NewNameFromGoString(vname)
+	name := storage.ValueFromName(storage.NewNameFromGoString(vname))
+	def := storage.ValueFromGoString(vdef)
 
-	if err := ts.SetVal("viewname", storage.ValueFromName(vn)); err != nil {
+	if err := ts.Insert(name.Size(storage.NAME) + def.Size(storage.TEXT)); err != nil {
 		return err
 	}
 
-	if err := ts.SetVal("viewdef", storage.ValueFromGoString(vdef)); err != nil {
+	if err := ts.SetVal(fieldViewName, name); err != nil {
+		return err
+	}
+
+	if err := ts.SetVal(fieldViewDef, def); err != nil {
 		return err
 	}
 
@@ -58,12 +65,12 @@ func (vm viewManager) createView(vname string, vdef string, trans tx.Transaction
 // viewDefinition looks within the view catalog table for the requested view definition.
 // If the view cannot be found returns an ErrViewNotFound
 func (vm viewManager) viewDefinition(vname string, trans tx.Transaction) (string, error) {
-	layout, err := vm.layout("viewcat", trans)
+	layout, err := vm.layout(viewCatalogTableName, trans)
 	if err != nil {
 		return "", err
 	}
 
-	ts := newTableScan(trans, "viewcat", layout)
+	ts := newTableScan(trans, viewCatalogTableName, layout)
 	defer ts.Close()
 
 	for {
@@ -76,19 +83,39 @@ func (vm viewManager) viewDefinition(vname string, trans tx.Transaction) (string
 			return "", err
 		}
 
-		s, err := ts.Varlen("viewname")
+		s, err := ts.Val(fieldViewName)
 		if err != nil {
 			return "", err
 		}
 
-		if storage.VarlenToGoString(s) == vname {
-			res, err := ts.Varlen("viewdef")
+		if s.AsName().AsGoString() == vname {
+			res, err := ts.Varlen(fieldViewDef)
 			if err != nil {
 				return "", err
 			}
-			return storage.VarlenToGoString(res), nil
+			return strings.Clone(storage.VarlenToGoString(res)), nil
 		}
 	}
 
 	return "", ErrViewNotFound
 }
+
+// dropView removes the view entry from the view catalog.
+// If the view cannot be found returns an ErrViewNotFound
+func (vm viewManager) dropView(vname string, trans tx.Transaction) error {
+	layout, err := vm.layout(viewCatalogTableName, trans)
+	if err != nil {
+		return err
+	}
+
+	n, err := deleteCatalogRecords(trans, viewCatalogTableName, layout, fieldViewName, vname, nil)
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%w: %q", ErrViewNotFound, vname)
+	}
+
+	return nil
+}
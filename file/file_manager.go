@@ -147,6 +147,28 @@ func (manager *FileManager) Size(filename string) storage.Long {
 	return storage.Long(finfo.Size()) / manager.blockSize
 }
 
+// Remove closes the given file, if it is open, and deletes it from disk.
+// Removing a file that does not exist is not an error.
+func (manager *FileManager) Remove(fname string) error {
+	manager.Lock()
+	defer manager.Unlock()
+
+	if f, ok := manager.openFiles[fname]; ok {
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		delete(manager.openFiles, fname)
+	}
+
+	err := os.Remove(path.Join(manager.folder, fname))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
 // Append seeks to the end of the file and writes an empty array of bytes to the file
 // todo: this might not be needed in go
 func (manager *FileManager) Append(fname string) storage.Block {
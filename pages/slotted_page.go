@@ -13,6 +13,8 @@ import (
 var (
 	errNoFreeSpaceAvailable = errors.New("no free space available on page to insert record")
 	ErrNoFreeSlot           = errors.New("no free slot available")
+	ErrNoField              = errors.New("field not found")
+	ErrUnsupportedFormat    = errors.New("page written in an unsupported format")
 )
 
 type flag storage.Int
@@ -30,6 +32,14 @@ const (
 	PageTypeBTree
 )
 
+// heapFormatVersion is the version of the format of the records of heap pages,
+// written by Format.
+// Version 1 added the null bitmap that follows the header of each record.
+// Pages written before have version 0, since the byte that holds it was never written:
+// their records are read at the wrong offsets, so they are rejected with ErrUnsupportedFormat
+// and the data directory must be recreated.
+const heapFormatVersion storage.TinyInt = 1
+
 const sizeOfHeaderEntry = storage.SizeOfLong
 
 // slottedPageHeaderEntry represents an entry in the page header.
@@ -94,6 +104,9 @@ const (
 	blockNumberOffset storage.Offset = 0
 	// pageType is a storage.TinyInt that stores the type of the page
 	pageTypeOffset storage.Offset = blockNumberOffset + storage.SizeOfLong
+	// formatVersion is a storage.TinyInt that stores the version of the format of the records.
+	// It follows the page type, within the storage.Long reserved for it.
+	formatVersionOffset storage.Offset = pageTypeOffset + storage.SizeOfTinyInt
 	// numSlots is a storage.SmallInt that stores the number of slots in the page
 	numSlotsOffset storage.Offset = pageTypeOffset + storage.SizeOfLong
 	// freeSpaceEnd is a storage.SmallInt that stores the end of the free space in the page
@@ -155,6 +168,9 @@ func (h slottedPageHeader) setPageType(pageType PageType) error {
 	)
 }
 
+// pageType returns the type of the page.
+// It returns ErrUnsupportedFormat if the page is a heap page
+// whose records are not in the format of heapFormatVersion.
 func (h slottedPageHeader) pageType() (PageType, error) {
 	v, err := h.x.Fixedlen(*h.block, pageTypeOffset, storage.SizeOfTinyInt)
 
@@ -162,7 +178,47 @@ func (h slottedPageHeader) pageType() (PageType, error) {
 		return 0, err
 	}
 
-	return PageType(storage.FixedLenToInteger[storage.TinyInt](v)), nil
+	pt := PageType(storage.FixedLenToInteger[storage.TinyInt](v))
+	if pt != PageTypeHeap {
+		return pt, nil
+	}
+
+	version, err := h.formatVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	if version != heapFormatVersion {
+		return 0, fmt.Errorf(
+			"%w: block %d of %s has version %d, expected %d",
+			ErrUnsupportedFormat,
+			h.block.Number(),
+			h.block.FileName(),
+			version,
+			heapFormatVersion,
+		)
+	}
+
+	return pt, nil
+}
+
+func (h slottedPageHeader) setFormatVersion(version storage.TinyInt) error {
+	return h.x.SetFixedlen(
+		*h.block,
+		formatVersionOffset,
+		storage.SizeOfTinyInt,
+		storage.IntegerToFixedLen[storage.TinyInt](storage.SizeOfTinyInt, version),
+		true,
+	)
+}
+
+func (h slottedPageHeader) formatVersion() (storage.TinyInt, error) {
+	v, err := h.x.Fixedlen(*h.block, formatVersionOffset, storage.SizeOfTinyInt)
+	if err != nil {
+		return 0, err
+	}
+
+	return storage.FixedLenToInteger[storage.TinyInt](v), nil
 }
 
 func (h slottedPageHeader) setNumSlots(numSlots storage.SmallInt) error {
@@ -360,7 +416,9 @@ type SlottedPage struct {
 	layout Layout
 }
 
-// recordHeader represents the header stored before each record on disk
+// recordHeader represents the header stored before each record on disk.
+// In heap pages, the header is followed by the null bitmap of the record,
+// which holds a bit for each field of the layout. A set bit marks the field as NULL.
 // It stores the offsets of the ends of each field to allow direct access
 // todo: this can be represented by an array of 16 bit ints to save space
 // and possibly speed up access, once support for smaller ints is added
@@ -431,12 +489,19 @@ func (p *SlottedPage) RecordSizeIncludingRecordHeader(recordSize storage.Offset)
 	}
 
 	if pt == PageTypeHeap {
-		return recordHeaderSize + recordSize, nil
+		return recordHeaderSize + p.nullBitmapSize() + recordSize, nil
 	}
 
 	return recordSize, nil
 }
 
+// nullBitmapSize returns the size of the null bitmap that follows
+// the header of each record in a heap page.
+// The bitmap holds a bit for each field of the layout.
+func (p *SlottedPage) nullBitmapSize() storage.Offset {
+	return storage.Offset((p.layout.FieldsCount() + 7) / 8)
+}
+
 func (p *SlottedPage) Header() slottedPageHeader {
 
 	return slottedPageHeader{
@@ -574,7 +639,7 @@ func (p *SlottedPage) fieldOffset(slot storage.SmallInt, fieldname string) (stor
 	}
 
 	if pt == PageTypeHeap {
-		offset += recordHeaderSize
+		offset += recordHeaderSize + p.nullBitmapSize()
 	}
 
 	idx := p.layout.FieldIndex(fieldname)
@@ -599,6 +664,112 @@ func (p *SlottedPage) fieldOffset(slot storage.SmallInt, fieldname string) (stor
 	return offset, nil
 }
 
+// nullBit returns the offset of the byte of the null bitmap that holds
+// the bit of the field, for the record pointed by the given slot, together with the mask of the bit.
+// ok is false if the page does not store null bitmaps.
+// It returns ErrNoField if the layout of the page does not have the field.
+func (p *SlottedPage) nullBit(slot storage.SmallInt, fieldname string) (offset storage.Offset, mask byte, ok bool, err error) {
+	header := p.Header()
+	pt, err := header.pageType()
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	if pt != PageTypeHeap {
+		return 0, 0, false, nil
+	}
+
+	entry, err := p.entry(slot)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	idx := p.layout.FieldIndex(fieldname)
+	if idx < 0 {
+		return 0, 0, false, fmt.Errorf("%w: %q", ErrNoField, fieldname)
+	}
+
+	offset = entry.recordOffset() + recordHeaderSize + storage.Offset(idx/8)
+
+	return offset, 1 << (idx % 8), true, nil
+}
+
+// setNullBit sets or clears the null bit of the field
+// for the record pointed by the given slot.
+func (p *SlottedPage) setNullBit(slot storage.SmallInt, fieldname string, null bool) error {
+	offset, mask, ok, err := p.nullBit(slot, fieldname)
+	if err != nil || !ok {
+		return err
+	}
+
+	v, err := p.x.Fixedlen(p.block, offset, 1)
+	if err != nil {
+		return err
+	}
+
+	b := v[0] &^ mask
+	if null {
+		b |= mask
+	}
+
+	if b == v[0] {
+		return nil
+	}
+
+	return p.x.SetFixedlen(p.block, offset, 1, storage.FixedLen{b}, true)
+}
+
+// writeNullBitmap marks all the fields of the record at offset as NULL.
+// Fields stop being NULL as soon as they are set.
+func (p *SlottedPage) writeNullBitmap(offset storage.Offset) error {
+	size := p.nullBitmapSize()
+	if size == 0 {
+		return nil
+	}
+
+	bitmap := make(storage.FixedLen, size)
+	for i := range bitmap {
+		bitmap[i] = 0xff
+	}
+
+	return p.x.SetFixedlen(p.block, offset+recordHeaderSize, size, bitmap, true)
+}
+
+// IsNull returns true if the field of the record pointed by the given slot is NULL.
+// Only records in heap pages can hold NULLs.
+func (p *SlottedPage) IsNull(slot storage.SmallInt, fieldname string) (bool, error) {
+	offset, mask, ok, err := p.nullBit(slot, fieldname)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	v, err := p.x.Fixedlen(p.block, offset, 1)
+	if err != nil {
+		return false, err
+	}
+
+	return v[0]&mask != 0, nil
+}
+
+// SetNull sets the field of the record pointed by the given slot to NULL.
+// A NULL field keeps occupying the space of its type: fixed length fields
+// keep their bytes, while variable length fields are overwritten with an empty value
+// so that the offsets of the following fields can still be computed.
+func (p *SlottedPage) SetNull(slot storage.SmallInt, fieldname string) error {
+	if p.layout.FieldSize(fieldname) == storage.SizeOfVarlen {
+		offset, err := p.fieldOffset(slot, fieldname)
+		if err != nil {
+			return err
+		}
+
+		if err := p.x.SetVarlen(p.block, offset, storage.NewVarlenFromGoString(""), true); err != nil {
+			return err
+		}
+	}
+
+	return p.setNullBit(slot, fieldname, true)
+}
+
 // Int returns the value of an integer field for the record pointed by the given slot
 func (p *SlottedPage) FixedLen(slot storage.SmallInt, fieldname string) (storage.FixedLen, error) {
 	offset, err := p.fieldOffset(slot, fieldname)
@@ -637,7 +808,7 @@ func (p *SlottedPage) SetFixedLen(slot storage.SmallInt, fieldname string, val s
 		return err
 	}
 
-	return nil
+	return p.setNullBit(slot, fieldname, false)
 }
 
 // SetString sets the value of a string field for the record pointed by the given slot
@@ -651,7 +822,7 @@ func (p *SlottedPage) SetVarLen(slot storage.SmallInt, fieldname string, val sto
 		return err
 	}
 
-	return nil
+	return p.setNullBit(slot, fieldname, false)
 }
 
 // SetFixedLenAtSpecial sets the value of a fixed len field in the special space.
@@ -754,6 +925,12 @@ func (p *SlottedPage) Format(pageType PageType, specialSpaceSize storage.Offset)
 		return err
 	}
 
+	if pageType == PageTypeHeap {
+		if err := header.setFormatVersion(heapFormatVersion); err != nil {
+			return err
+		}
+	}
+
 	if err := header.setNumSlots(0); err != nil {
 		return err
 	}
@@ -837,6 +1014,10 @@ func (p *SlottedPage) InsertAfter(slot storage.SmallInt, recordSize storage.Offs
 			return InvalidSlot, err
 		}
 
+		if err := p.writeNullBitmap(header.mustFreeSpaceEnd()); err != nil {
+			return InvalidSlot, err
+		}
+
 		return header.mustNumSlots() - 1, nil
 	}
 
@@ -1,6 +1,7 @@
 package pages
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -15,7 +16,12 @@ type mockLayout struct {
 }
 
 func (m mockLayout) FieldIndex(fname string) int {
-	return m.indexes[fname]
+	idx, ok := m.indexes[fname]
+	if !ok {
+		return -1
+	}
+
+	return idx
 }
 
 func (m mockLayout) FieldsCount() int {
@@ -477,6 +483,144 @@ func TestSlottedPageSet(t *testing.T) {
 	}
 }
 
+func TestSlottedPageNull(t *testing.T) {
+	fm, lm, bm := test.MakeManagers(t)
+
+	x := tx.NewTx(fm, lm, bm)
+	defer x.Commit()
+
+	block := storage.NewBlock("null_page", 0)
+	x.Append(block.FileName())
+
+	layout := mockLayout{
+		indexes: map[string]int{
+			"field1": 0,
+			"field2": 1,
+			"field3": 2,
+		},
+		sizes: map[string]storage.Offset{
+			"field1": storage.SizeOfInt,
+			"field2": storage.SizeOfVarlen,
+			"field3": storage.SizeOfInt,
+		},
+	}
+
+	page := NewSlottedPage(x, storage.NewBlock("file", 1), layout)
+	if err := page.Format(PageTypeHeap, 0); err != nil {
+		t.Fatalf("error formatting page: %v", err)
+	}
+
+	recordLength := storage.SizeOfInt*2 + storage.SizeOfVarlenLen
+
+	slot, err := page.InsertAfter(BeforeFirstSlot, recordLength, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fields of a new record are NULL until they are set
+	for _, f := range []string{"field1", "field2", "field3"} {
+		null, err := page.IsNull(slot, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !null {
+			t.Fatalf("expected %s of a new record to be NULL", f)
+		}
+	}
+
+	if err := page.SetFixedLen(slot, "field1", storage.IntegerToFixedLen[storage.Int](storage.SizeOfInt, 7)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := page.SetNull(slot, "field2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := page.SetFixedLen(slot, "field3", storage.IntegerToFixedLen[storage.Int](storage.SizeOfInt, 11)); err != nil {
+		t.Fatal(err)
+	}
+
+	for f, exp := range map[string]bool{"field1": false, "field2": true, "field3": false} {
+		null, err := page.IsNull(slot, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if null != exp {
+			t.Fatalf("expected %s to be NULL: %t, got %t", f, exp, null)
+		}
+	}
+
+	// the NULL varlen must not shift the fields that follow it
+	v, err := page.FixedLen(slot, "field3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := storage.FixedLenToInteger[storage.Int](v); got != 11 {
+		t.Fatalf("expected field3 to be %d, got %d", 11, got)
+	}
+
+	if err := page.SetNull(slot, "field1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if null, err := page.IsNull(slot, "field1"); err != nil || !null {
+		t.Fatalf("expected field1 to be NULL, got %t (%v)", null, err)
+	}
+	if _, err := page.IsNull(slot, "missing"); !errors.Is(err, ErrNoField) {
+		t.Fatalf("expected %v, got %v", ErrNoField, err)
+	}
+
+	if err := page.SetNull(slot, "missing"); !errors.Is(err, ErrNoField) {
+		t.Fatalf("expected %v, got %v", ErrNoField, err)
+	}
+}
+
+func TestSlottedPageFormatVersion(t *testing.T) {
+	fm, lm, bm := test.MakeManagers(t)
+
+	x := tx.NewTx(fm, lm, bm)
+	defer x.Commit()
+
+	block := storage.NewBlock("version_page", 0)
+	x.Append(block.FileName())
+
+	layout := mockLayout{
+		indexes: map[string]int{"field1": 0},
+		sizes:   map[string]storage.Offset{"field1": storage.SizeOfInt},
+	}
+
+	page := NewSlottedPage(x, block, layout)
+	if err := page.Format(PageTypeHeap, 0); err != nil {
+		t.Fatalf("error formatting page: %v", err)
+	}
+
+	slot, err := page.InsertAfter(BeforeFirstSlot, storage.SizeOfInt, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := page.FixedLen(slot, "field1"); err != nil {
+		t.Fatalf("unexpected error reading a record of the current format: %v", err)
+	}
+
+	// a page written before the null bitmap was added has version 0
+	header := page.Header()
+	if err := header.setFormatVersion(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := page.FixedLen(slot, "field1"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected %v, got %v", ErrUnsupportedFormat, err)
+	}
+
+	if _, err := page.IsNull(slot, "field1"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("expected %v, got %v", ErrUnsupportedFormat, err)
+	}
+}
+
 func TestSlottedPageSetAtSpecial(t *testing.T) {
 	fm, lm, bm := test.MakeManagers(t)
 
@@ -834,7 +978,7 @@ func TestSlottedPageCompact(t *testing.T) {
 			}
 		}
 
-		recordSize := storage.SizeOfInt + recordHeaderSize
+		recordSize := storage.SizeOfInt + recordHeaderSize + page.nullBitmapSize()
 
 		// test that the first record is at offset 0 from the end.
 		// test that the second record is at offset storage.SizeOfInt from the end.
@@ -1017,7 +1161,7 @@ func TestSlottedPageTruncate(t *testing.T) {
 	}
 
 	// expect the end of the free space to be at the end of the last record
-	expectedRecordSize := storage.SizeOfSmallInt + recordHeaderSize
+	expectedRecordSize := storage.SizeOfSmallInt + recordHeaderSize + page.nullBitmapSize()
 	expectedFreeSpaceEnd := defaultFreeSpaceEnd - (50 * expectedRecordSize)
 	if got := header.mustFreeSpaceEnd(); got != expectedFreeSpaceEnd {
 		t.Fatalf("expected free space end to be %d, got %d", expectedFreeSpaceEnd, got)
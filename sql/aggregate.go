@@ -0,0 +1,135 @@
+package sql
+
+import (
+	"errors"
+
+	"github.com/luigitni/simpledb/storage"
+)
+
+var ErrMisplacedAggregate = errors.New("aggregates are not allowed in WHERE clauses")
+
+// AggregateFunc is the function of an aggregate Expression.
+type AggregateFunc byte
+
+const (
+	AggregateCount AggregateFunc = iota
+	AggregateSum
+	AggregateMin
+	AggregateMax
+	AggregateAvg
+)
+
+var aggregateFuncNames = [...]string{
+	AggregateCount: "count",
+	AggregateSum:   "sum",
+	AggregateMin:   "min",
+	AggregateMax:   "max",
+	AggregateAvg:   "avg",
+}
+
+// aggregateFuncs maps the name of each aggregate function to the function.
+// Aggregate functions are not keywords, so that they can still be used as field names.
+var aggregateFuncs = map[string]AggregateFunc{
+	"count": AggregateCount,
+	"sum":   AggregateSum,
+	"min":   AggregateMin,
+	"max":   AggregateMax,
+	"avg":   AggregateAvg,
+}
+
+func (fn AggregateFunc) String() string {
+	return aggregateFuncNames[fn]
+}
+
+// newAggregateExpression returns an aggregate of fn over operand.
+// A nil operand stands for *, as in COUNT(*).
+func newAggregateExpression(fn AggregateFunc, operand *Expression) Expression {
+	exp := Expression{kind: exprAggregate, fn: fn}
+	if operand != nil {
+		exp.operands = []Expression{*operand}
+	}
+
+	return exp
+}
+
+func (exp Expression) IsAggregate() bool {
+	return exp.kind == exprAggregate
+}
+
+func (exp Expression) AggregateFunc() AggregateFunc {
+	return exp.fn
+}
+
+// AggregateOperand returns the expression the aggregate is computed over.
+// COUNT(*) has no operand.
+func (exp Expression) AggregateOperand() (Expression, bool) {
+	if len(exp.operands) == 0 {
+		return Expression{}, false
+	}
+
+	return exp.operands[0], true
+}
+
+// Aggregates returns the aggregate expressions within the expression.
+func (exp Expression) Aggregates() []Expression {
+	if exp.kind == exprAggregate {
+		return []Expression{exp}
+	}
+
+	var aggs []Expression
+	for _, o := range exp.operands {
+		aggs = append(aggs, o.Aggregates()...)
+	}
+
+	return aggs
+}
+
+// AggregateType returns the type of the result of the aggregate,
+// given the types of the records it aggregates.
+// COUNT, SUM and AVG return a LONG, while MIN and MAX
+// return a value of the same type of their operand.
+// SUM and AVG are only defined over integers.
+func (exp Expression) AggregateType(input FieldTypes) (storage.FieldType, error) {
+	operand, ok := exp.AggregateOperand()
+	if !ok || exp.fn == AggregateCount {
+		return storage.LONG, nil
+	}
+
+	t, err := operand.Type(input)
+	if err != nil {
+		return 0, err
+	}
+
+	if exp.fn == AggregateMin || exp.fn == AggregateMax {
+		return t, nil
+	}
+
+	if !t.IsInteger() {
+		return 0, ErrInvalidOperandType
+	}
+
+	return storage.LONG, nil
+}
+
+func (exp Expression) aggregateString() string {
+	operand, ok := exp.AggregateOperand()
+	if !ok {
+		return exp.fn.String() + "(*)"
+	}
+
+	return exp.fn.String() + "(" + operand.String() + ")"
+}
+
+// Aggregates returns the aggregate expressions within the terms of the predicate.
+func (p Predicate) Aggregates() []Expression {
+	if p.op == boolTerm {
+		return append(p.term.lhs.Aggregates(), p.term.rhs.Aggregates()...)
+	}
+
+	var aggs []Expression
+	for _, o := range p.operands {
+		aggs = append(aggs, o.Aggregates()...)
+	}
+
+	return aggs
+}
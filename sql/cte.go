@@ -0,0 +1,135 @@
+package sql
+
+import "strings"
+
+// CommonTableExpression is a named query of a WITH clause,
+// which the query that follows it can refer to as if it were a table.
+// Columns renames the columns of the query, if it is not empty.
+// A common table expression of a WITH RECURSIVE clause can refer to itself.
+type CommonTableExpression struct {
+	Name      string
+	Columns   []string
+	Query     Query
+	Recursive bool
+}
+
+func (cte CommonTableExpression) String() string {
+	s := cte.Name
+	if len(cte.Columns) > 0 {
+		s += " (" + strings.Join(cte.Columns, ", ") + ")"
+	}
+
+	return s + " AS (" + cte.Query.String() + ")"
+}
+
+// CTEs returns the common table expressions of the WITH clause.
+func (qd Query) CTEs() []CommonTableExpression {
+	return qd.ctes
+}
+
+// WithCTEs returns a copy of the query with the given WITH clause.
+func (qd Query) WithCTEs(ctes []CommonTableExpression) Query {
+	qd.ctes = ctes
+	return qd
+}
+
+// writeWithClause writes the WITH clause of the query.
+func (qd Query) writeWithClause(sb *strings.Builder) {
+	if len(qd.ctes) == 0 {
+		return
+	}
+
+	sb.WriteString("WITH ")
+	if qd.ctes[0].Recursive {
+		sb.WriteString("RECURSIVE ")
+	}
+
+	for i, cte := range qd.ctes {
+		sb.WriteString(cte.String())
+		if i != len(qd.ctes)-1 {
+			sb.WriteString(", ")
+		}
+	}
+
+	sb.WriteString(" ")
+}
+
+// <WithClause> := WITH [ RECURSIVE ] <CommonTableExpr> [, <CommonTableExpr> ... ]
+func (p Parser) withClause() ([]CommonTableExpression, error) {
+	if err := p.eatTokenType(TokenWith); err != nil {
+		return nil, err
+	}
+
+	recursive := p.matchTokenType(TokenRecursive)
+	if recursive {
+		if err := p.eatTokenType(TokenRecursive); err != nil {
+			return nil, err
+		}
+	}
+
+	var ctes []CommonTableExpression
+	for {
+		cte, err := p.commonTableExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		cte.Recursive = recursive
+		ctes = append(ctes, cte)
+
+		if !p.matchTokenType(TokenComma) {
+			return ctes, nil
+		}
+
+		if err := p.eatTokenType(TokenComma); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// <CommonTableExpr> := TokenIdentifier [ ( <FieldList> ) ] AS ( <Query> )
+func (p Parser) commonTableExpression() (CommonTableExpression, error) {
+	name, err := p.eatIdentifier()
+	if err != nil {
+		return CommonTableExpression{}, err
+	}
+
+	var columns []string
+	if p.matchTokenType(TokenLeftParen) {
+		if err := p.eatTokenType(TokenLeftParen); err != nil {
+			return CommonTableExpression{}, err
+		}
+
+		columns, err = p.fieldList()
+		if err != nil {
+			return CommonTableExpression{}, err
+		}
+
+		if err := p.eatTokenType(TokenRightParen); err != nil {
+			return CommonTableExpression{}, err
+		}
+	}
+
+	if err := p.eatTokenType(TokenAs); err != nil {
+		return CommonTableExpression{}, err
+	}
+
+	if err := p.eatTokenType(TokenLeftParen); err != nil {
+		return CommonTableExpression{}, err
+	}
+
+	q, err := p.Query()
+	if err != nil {
+		return CommonTableExpression{}, err
+	}
+
+	if err := p.eatTokenType(TokenRightParen); err != nil {
+		return CommonTableExpression{}, err
+	}
+
+	return CommonTableExpression{
+		Name:    name,
+		Columns: columns,
+		Query:   q,
+	}, nil
+}
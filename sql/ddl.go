@@ -1,23 +1,79 @@
 package sql
 
-import "github.com/luigitni/simpledb/storage"
+import (
+	"errors"
+	"strings"
+
+	"github.com/luigitni/simpledb/storage"
+)
+
+// ErrInvalidConstraint is returned when the expression of a DEFAULT or CHECK constraint
+// contains a subquery or an aggregate, when a default value refers to a field
+// or when a check draws from a sequence.
+var ErrInvalidConstraint = errors.New("invalid constraint expression")
 
 type FieldDef struct {
 	Name string
 	Type storage.FieldType
 	Len  int
+	// NotNull is true if the field cannot be NULL
+	NotNull bool
+	// Default is the value of the field when an INSERT omits it,
+	// nil if the field defaults to NULL
+	Default *Expression
+	// Check is the condition the records must not violate, nil if the field has none
+	Check *Predicate
+	// AutoIncrement is true if the field takes its values from a sequence of its own,
+	// as declared by SERIAL or AUTO_INCREMENT
+	AutoIncrement bool
+}
+
+// KeyConstraint is a PRIMARY KEY or UNIQUE constraint over one or more fields of a table.
+type KeyConstraint struct {
+	Primary bool
+	Fields  []string
+}
+
+// ReferentialAction is the action taken on the records that refer
+// through a foreign key to a record being deleted.
+type ReferentialAction int
+
+const (
+	// ActionRestrict rejects the deletion of a record that is referred to
+	ActionRestrict ReferentialAction = iota
+	// ActionCascade deletes the records that refer to the deleted record
+	ActionCascade
+	// ActionSetNull sets the fields of the foreign key to NULL in the records that refer to the deleted record
+	ActionSetNull
+)
+
+// ForeignKey is a FOREIGN KEY constraint, which makes the fields of a table
+// refer to the fields of a key of RefTable.
+// If RefFields is empty, the fields refer to the primary key of RefTable.
+type ForeignKey struct {
+	Fields    []string
+	RefTable  string
+	RefFields []string
+	OnDelete  ReferentialAction
 }
 
 type CreateTableCommand struct {
 	DDLCommandType
 	TableName string
 	Fields    []FieldDef
+	// Keys holds the key constraints of the table,
+	// both those declared with a column and those declared on their own
+	Keys []KeyConstraint
+	// ForeignKeys holds the foreign keys of the table, declared in the same ways as keys
+	ForeignKeys []ForeignKey
 }
 
-func NewCreateTableCommand(name string, fieldsDef []FieldDef) CreateTableCommand {
+func NewCreateTableCommand(name string, fieldsDef []FieldDef, keys []KeyConstraint, foreignKeys []ForeignKey) CreateTableCommand {
 	return CreateTableCommand{
-		TableName: name,
-		Fields:    fieldsDef,
+		TableName:   name,
+		Fields:      fieldsDef,
+		Keys:        keys,
+		ForeignKeys: foreignKeys,
 	}
 }
 
@@ -53,11 +109,107 @@ func (cvd CreateViewCommand) Definition() string {
 	return cvd.Query.String()
 }
 
+type DropTableCommand struct {
+	DDLCommandType
+	TableName string
+}
+
+func NewDropTableCommand(name string) DropTableCommand {
+	return DropTableCommand{
+		TableName: name,
+	}
+}
+
+type DropIndexCommand struct {
+	DDLCommandType
+	IndexName string
+}
+
+func NewDropIndexCommand(name string) DropIndexCommand {
+	return DropIndexCommand{
+		IndexName: name,
+	}
+}
+
+type DropViewCommand struct {
+	DDLCommandType
+	ViewName string
+}
+
+func NewDropViewCommand(name string) DropViewCommand {
+	return DropViewCommand{
+		ViewName: name,
+	}
+}
+
+// AlterTableAction is the change made to a table by ALTER TABLE.
+type AlterTableAction int
+
+const (
+	AlterAddColumn AlterTableAction = iota
+	AlterDropColumn
+	AlterRenameColumn
+	AlterRenameTable
+)
+
+type AlterTableCommand struct {
+	DDLCommandType
+	TableName string
+	Action    AlterTableAction
+	// Field is the definition of the column added by ADD COLUMN
+	Field FieldDef
+	// Column is the column dropped by DROP COLUMN or renamed by RENAME COLUMN
+	Column string
+	// NewName is the new name of the column or of the table
+	NewName string
+}
+
+func NewAddColumnCommand(table string, field FieldDef) AlterTableCommand {
+	return AlterTableCommand{
+		TableName: table,
+		Action:    AlterAddColumn,
+		Field:     field,
+	}
+}
+
+func NewDropColumnCommand(table string, column string) AlterTableCommand {
+	return AlterTableCommand{
+		TableName: table,
+		Action:    AlterDropColumn,
+		Column:    column,
+	}
+}
+
+func NewRenameColumnCommand(table string, column string, newName string) AlterTableCommand {
+	return AlterTableCommand{
+		TableName: table,
+		Action:    AlterRenameColumn,
+		Column:    column,
+		NewName:   newName,
+	}
+}
+
+func NewRenameTableCommand(table string, newName string) AlterTableCommand {
+	return AlterTableCommand{
+		TableName: table,
+		Action:    AlterRenameTable,
+		NewName:   newName,
+	}
+}
+
 func (p Parser) isDDL() bool {
-	return p.matchKeyword("create")
+	return p.matchKeyword("create") || p.matchKeyword("drop") || p.matchKeyword("alter")
 }
 
 func (p Parser) ddl() (Command, error) {
+	if p.matchKeyword("drop") {
+		return p.drop()
+	}
+
+	if p.matchKeyword("alter") {
+		return p.alterTable()
+	}
+
 	if err := p.eatKeyword("create"); err != nil {
 		return nil, err
 	}
@@ -70,10 +222,14 @@ func (p Parser) ddl() (Command, error) {
 		return p.createIndex()
 	}
 
+	if p.matchWord("sequence") {
+		return p.createSequence()
+	}
+
 	return p.createView()
 }
 
-// <CreateTable> := CREATE TABLE TokenIdentifier ( <FieldDefs> )
+// <CreateTable> := CREATE TABLE TokenIdentifier ( <TableElements> )
 func (p Parser) createTable() (CreateTableCommand, error) {
 	p.eatKeyword("table")
 
@@ -86,35 +242,183 @@ func (p Parser) createTable() (CreateTableCommand, error) {
 		return CreateTableCommand{}, err
 	}
 
-	fields, err := p.fieldDefs()
-	if err != nil {
-		return CreateTableCommand{}, err
+	var fields []FieldDef
+	var keys []KeyConstraint
+	var foreignKeys []ForeignKey
+	for {
+		if p.matchKeyword("primary") || p.matchKeyword("unique") {
+			key, err := p.keyConstraint()
+			if err != nil {
+				return CreateTableCommand{}, err
+			}
+
+			keys = append(keys, key)
+		} else if p.matchKeyword("foreign") {
+			fk, err := p.foreignKey()
+			if err != nil {
+				return CreateTableCommand{}, err
+			}
+
+			foreignKeys = append(foreignKeys, fk)
+		} else {
+			f, err := p.fieldDef()
+			if err != nil {
+				return CreateTableCommand{}, err
+			}
+
+			if len(f) == 0 {
+				return CreateTableCommand{}, ErrInvalidSyntax
+			}
+
+			columnKeys, columnForeignKeys, err := p.columnConstraints(&f[0])
+			if err != nil {
+				return CreateTableCommand{}, err
+			}
+
+			fields = append(fields, f...)
+			keys = append(keys, columnKeys...)
+			foreignKeys = append(foreignKeys, columnForeignKeys...)
+		}
+
+		if !p.matchTokenType(TokenComma) {
+			break
+		}
+
+		p.eatTokenType(TokenComma)
 	}
 
 	if err := p.eatTokenType(TokenRightParen); err != nil {
 		return CreateTableCommand{}, err
 	}
 
-	return NewCreateTableCommand(table, fields), nil
+	return NewCreateTableCommand(table, fields, keys, foreignKeys), nil
 }
 
-func (p Parser) fieldDefs() ([]FieldDef, error) {
-	fields, err := p.fieldDef()
-	if err != nil {
-		return nil, err
+// columnConstraints parses the constraints that follow the definition of the field.
+// NOT NULL, DEFAULT, CHECK and AUTO_INCREMENT are set on the field, while its keys and foreign keys are returned.
+// The checks of a field are ANDed together.
+// An auto increment field must be an integer, and cannot have a default of its own.
+func (p Parser) columnConstraints(field *FieldDef) ([]KeyConstraint, []ForeignKey, error) {
+	var keys []KeyConstraint
+	var foreignKeys []ForeignKey
+	for {
+		switch {
+		case p.matchKeyword("primary"):
+			p.eatKeyword("primary")
+			if err := p.eatWord("key"); err != nil {
+				return nil, nil, err
+			}
+
+			keys = append(keys, KeyConstraint{Primary: true, Fields: []string{field.Name}})
+		case p.matchKeyword("unique"):
+			p.eatKeyword("unique")
+
+			keys = append(keys, KeyConstraint{Fields: []string{field.Name}})
+		case p.matchKeyword("not"):
+			p.eatKeyword("not")
+			if err := p.eatKeyword("null"); err != nil {
+				return nil, nil, err
+			}
+
+			field.NotNull = true
+		case p.matchKeyword("default"):
+			p.eatKeyword("default")
+
+			exp, err := p.expression()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if len(exp.Fields()) > 0 || exp.hasSubquery() || len(exp.Aggregates()) > 0 {
+				return nil, nil, ErrInvalidConstraint
+			}
+
+			field.Default = &exp
+		case p.matchKeyword("check"):
+			p.eatKeyword("check")
+
+			pred, err := p.nestedPredicate()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if pred.hasSubquery() || len(pred.Aggregates()) > 0 || pred.hasNextval() {
+				return nil, nil, ErrInvalidConstraint
+			}
+
+			if field.Check != nil {
+				field.Check.CojoinWith(pred)
+				continue
+			}
+
+			field.Check = &pred
+		case p.matchKeyword("references"):
+			fk, err := p.references([]string{field.Name})
+			if err != nil {
+				return nil, nil, err
+			}
+
+			foreignKeys = append(foreignKeys, fk)
+		case p.matchWord("auto_increment"):
+			p.eatWord("auto_increment")
+
+			field.AutoIncrement = true
+		default:
+			if field.AutoIncrement && (field.Default != nil || !field.Type.IsInteger()) {
+				return nil, nil, ErrInvalidConstraint
+			}
+
+			return keys, foreignKeys, nil
+		}
 	}
+}
 
-	if p.matchTokenType(TokenComma) {
-		p.eatTokenType(TokenComma)
-		s, err := p.fieldDefs()
-		if err != nil {
-			return nil, err
+// matchWord returns true if the current token is the identifier word.
+// Words, such as the KEY of PRIMARY KEY, are part of the syntax but are not reserved,
+// so that fields and aliases can still be named after them.
+func (p Parser) matchWord(word string) bool {
+	return p.matchIdentifier() && strings.EqualFold(tokenToString(p.tokenizer.src, p.current), word)
+}
+
+// eatWord eats the identifier word.
+func (p Parser) eatWord(word string) error {
+	if !p.matchWord(word) {
+		return ErrInvalidSyntax
+	}
+
+	return p.nextToken()
+}
+
+// <KeyConstraint> := { PRIMARY KEY | UNIQUE } ( <FieldList> )
+func (p Parser) keyConstraint() (KeyConstraint, error) {
+	var key KeyConstraint
+	if p.matchKeyword("primary") {
+		p.eatKeyword("primary")
+		if err := p.eatWord("key"); err != nil {
+			return KeyConstraint{}, err
 		}
 
-		fields = append(fields, s...)
+		key.Primary = true
+	} else if err := p.eatKeyword("unique"); err != nil {
+		return KeyConstraint{}, err
 	}
 
-	return fields, nil
+	if err := p.eatTokenType(TokenLeftParen); err != nil {
+		return KeyConstraint{}, err
+	}
+
+	fields, err := p.fieldList()
+	if err != nil {
+		return KeyConstraint{}, err
+	}
+
+	if err := p.eatTokenType(TokenRightParen); err != nil {
+		return KeyConstraint{}, err
+	}
+
+	key.Fields = fields
+
+	return key, nil
 }
 
 func (p Parser) fieldDef() ([]FieldDef, error) {
@@ -134,9 +438,21 @@ var fieldNames = map[string]storage.FieldType{
 	"text":     storage.TEXT,
 }
 
+// SERIAL is an INT that takes its values from a sequence, as if declared with AUTO_INCREMENT.
 func (p Parser) fieldType(field string) ([]FieldDef, error) {
 	var fields []FieldDef
 
+	if p.matchWord("serial") {
+		p.eatWord("serial")
+		fields = append(fields, FieldDef{
+			Name:          field,
+			Type:          storage.INT,
+			AutoIncrement: true,
+		})
+
+		return fields, nil
+	}
+
 	for k, v := range fieldNames {
 		if p.matchKeyword(k) {
 			p.eatKeyword(k)
@@ -193,7 +509,7 @@ func (p Parser) createView() (CreateViewCommand, error) {
 		return CreateViewCommand{}, err
 	}
 
-	if err := p.eatKeyword("on"); err != nil {
+	if err := p.eatKeyword("as"); err != nil {
 		return CreateViewCommand{}, err
 	}
 
@@ -204,3 +520,229 @@ func (p Parser) createView() (CreateViewCommand, error) {
 
 	return NewCreateViewCommand(id, query), nil
 }
+
+// <Drop> := DROP { TABLE | INDEX | VIEW | SEQUENCE } TokenIdentifier
+func (p Parser) drop() (Command, error) {
+	if err := p.eatKeyword("drop"); err != nil {
+		return nil, err
+	}
+
+	if p.matchWord("sequence") {
+		p.eatWord("sequence")
+
+		name, err := p.eatIdentifier()
+		if err != nil {
+			return nil, err
+		}
+
+		return NewDropSequenceCommand(name), nil
+	}
+
+	var kind string
+	for _, kw := range []string{"table", "index", "view"} {
+		if p.matchKeyword(kw) {
+			kind = kw
+		}
+	}
+
+	if err := p.eatKeyword(kind); err != nil {
+		return nil, err
+	}
+
+	name, err := p.eatIdentifier()
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case "table":
+		return NewDropTableCommand(name), nil
+	case "index":
+		return NewDropIndexCommand(name), nil
+	}
+
+	return NewDropViewCommand(name), nil
+}
+
+// <ForeignKey> := FOREIGN KEY ( <FieldList> ) <References>
+func (p Parser) foreignKey() (ForeignKey, error) {
+	if err := p.eatKeyword("foreign"); err != nil {
+		return ForeignKey{}, err
+	}
+
+	if err := p.eatWord("key"); err != nil {
+		return ForeignKey{}, err
+	}
+
+	if err := p.eatTokenType(TokenLeftParen); err != nil {
+		return ForeignKey{}, err
+	}
+
+	fields, err := p.fieldList()
+	if err != nil {
+		return ForeignKey{}, err
+	}
+
+	if err := p.eatTokenType(TokenRightParen); err != nil {
+		return ForeignKey{}, err
+	}
+
+	return p.references(fields)
+}
+
+// <References> := REFERENCES TokenIdentifier [ ( <FieldList> ) ] [ ON DELETE <ReferentialAction> ]
+// <ReferentialAction> := RESTRICT | CASCADE | SET NULL
+func (p Parser) references(fields []string) (ForeignKey, error) {
+	if err := p.eatKeyword("references"); err != nil {
+		return ForeignKey{}, err
+	}
+
+	table, err := p.eatIdentifier()
+	if err != nil {
+		return ForeignKey{}, err
+	}
+
+	fk := ForeignKey{
+		Fields:   fields,
+		RefTable: table,
+	}
+
+	if p.matchTokenType(TokenLeftParen) {
+		p.eatTokenType(TokenLeftParen)
+
+		fk.RefFields, err = p.fieldList()
+		if err != nil {
+			return ForeignKey{}, err
+		}
+
+		if err := p.eatTokenType(TokenRightParen); err != nil {
+			return ForeignKey{}, err
+		}
+	}
+
+	if !p.matchKeyword("on") {
+		return fk, nil
+	}
+
+	p.eatKeyword("on")
+	if err := p.eatKeyword("delete"); err != nil {
+		return ForeignKey{}, err
+	}
+
+	switch {
+	case p.matchKeyword("restrict"):
+		p.eatKeyword("restrict")
+		fk.OnDelete = ActionRestrict
+	case p.matchKeyword("cascade"):
+		p.eatKeyword("cascade")
+		fk.OnDelete = ActionCascade
+	case p.matchKeyword("set"):
+		p.eatKeyword("set")
+		if err := p.eatKeyword("null"); err != nil {
+			return ForeignKey{}, err
+		}
+
+		fk.OnDelete = ActionSetNull
+	default:
+		return ForeignKey{}, ErrInvalidSyntax
+	}
+
+	return fk, nil
+}
+
+// <AlterTable> := ALTER TABLE TokenIdentifier <AlterAction>
+// <AlterAction> := ADD [ COLUMN ] <FieldDef> [ <ColumnConstraint> ... ]
+// | DROP [ COLUMN ] TokenIdentifier
+// | RENAME [ COLUMN ] TokenIdentifier TO TokenIdentifier
+// | RENAME TO TokenIdentifier
+func (p Parser) alterTable() (AlterTableCommand, error) {
+	if err := p.eatKeyword("alter"); err != nil {
+		return AlterTableCommand{}, err
+	}
+
+	if err := p.eatKeyword("table"); err != nil {
+		return AlterTableCommand{}, err
+	}
+
+	table, err := p.eatIdentifier()
+	if err != nil {
+		return AlterTableCommand{}, err
+	}
+
+	switch {
+	case p.matchKeyword("add"):
+		p.eatKeyword("add")
+		p.optionalColumn()
+
+		fields, err := p.fieldDef()
+		if err != nil {
+			return AlterTableCommand{}, err
+		}
+
+		if len(fields) == 0 {
+			return AlterTableCommand{}, ErrInvalidSyntax
+		}
+
+		// keys and foreign keys cannot be added together with a column
+		keys, foreignKeys, err := p.columnConstraints(&fields[0])
+		if err != nil {
+			return AlterTableCommand{}, err
+		}
+
+		if len(keys) > 0 || len(foreignKeys) > 0 {
+			return AlterTableCommand{}, ErrInvalidSyntax
+		}
+
+		return NewAddColumnCommand(table, fields[0]), nil
+	case p.matchKeyword("drop"):
+		p.eatKeyword("drop")
+		p.optionalColumn()
+
+		column, err := p.eatIdentifier()
+		if err != nil {
+			return AlterTableCommand{}, err
+		}
+
+		return NewDropColumnCommand(table, column), nil
+	case p.matchKeyword("rename"):
+		p.eatKeyword("rename")
+
+		if p.matchKeyword("to") {
+			p.eatKeyword("to")
+
+			newName, err := p.eatIdentifier()
+			if err != nil {
+				return AlterTableCommand{}, err
+			}
+
+			return NewRenameTableCommand(table, newName), nil
+		}
+
+		p.optionalColumn()
+
+		column, err := p.eatIdentifier()
+		if err != nil {
+			return AlterTableCommand{}, err
+		}
+
+		if err := p.eatKeyword("to"); err != nil {
+			return AlterTableCommand{}, err
+		}
+
+		newName, err := p.eatIdentifier()
+		if err != nil {
+			return AlterTableCommand{}, err
+		}
+
+		return NewRenameColumnCommand(table, column, newName), nil
+	}
+
+	return AlterTableCommand{}, ErrInvalidSyntax
+}
+
+// optionalColumn eats the optional COLUMN keyword of ALTER TABLE.
+func (p Parser) optionalColumn() {
+	if p.matchKeyword("column") {
+		p.eatKeyword("column")
+	}
+}
@@ -1,7 +1,5 @@
 package sql
 
-import "github.com/luigitni/simpledb/storage"
-
 type CommandType byte
 
 const (
@@ -39,18 +37,32 @@ type Command interface {
 	Type() CommandType
 }
 
+// InsertCommand inserts records into a table,
+// either the rows of the VALUES clause or the records returned by a query.
+// If Fields is empty, the values are assigned to all the fields of the table, in order.
 type InsertCommand struct {
 	DMLCommandType
 	TableName string
 	Fields    []string
-	Values    []storage.Value
+	// Rows holds the values of each row of the VALUES clause
+	Rows [][]Expression
+	// Query is the query whose records are inserted, or nil if the command has a VALUES clause
+	Query *Query
+}
+
+func NewInsertCommand(table string, fields []string, rows [][]Expression) InsertCommand {
+	return InsertCommand{
+		TableName: table,
+		Fields:    fields,
+		Rows:      rows,
+	}
 }
 
-func NewInsertCommand(table string, fields []string, values []storage.Value) InsertCommand {
+func NewInsertQueryCommand(table string, fields []string, query Query) InsertCommand {
 	return InsertCommand{
 		TableName: table,
 		Fields:    fields,
-		Values:    values,
+		Query:     &query,
 	}
 }
 
@@ -140,13 +152,17 @@ func (p Parser) delete() (DeleteCommand, error) {
 			return DeleteCommand{}, err
 		}
 
+		if err := checkNextval(nil, pred); err != nil {
+			return DeleteCommand{}, err
+		}
+
 		return NewDeleteCommandWithPredicate(table, pred), nil
 	}
 
 	return NewDeleteCommand(table), nil
 }
 
-// <Insert> := INSERT INTO TokenIdentifier ( <FieldList> ) VALUES ( <ConstList> )
+// <Insert> := INSERT INTO TokenIdentifier [ ( <FieldList> ) ] { VALUES <RowList> | <Query> }
 func (p Parser) insert() (InsertCommand, error) {
 	if err := p.eatKeyword("insert"); err != nil {
 		return InsertCommand{}, err
@@ -161,37 +177,70 @@ func (p Parser) insert() (InsertCommand, error) {
 		return InsertCommand{}, err
 	}
 
-	if err := p.eatTokenType(TokenLeftParen); err != nil {
-		return InsertCommand{}, err
-	}
+	var fields []string
+	if p.matchTokenType(TokenLeftParen) {
+		if err := p.eatTokenType(TokenLeftParen); err != nil {
+			return InsertCommand{}, err
+		}
 
-	fields, err := p.fieldList()
-	if err != nil {
-		return InsertCommand{}, err
-	}
+		fields, err = p.fieldList()
+		if err != nil {
+			return InsertCommand{}, err
+		}
 
-	if err := p.eatTokenType(TokenRightParen); err != nil {
-		return InsertCommand{}, err
+		if err := p.eatTokenType(TokenRightParen); err != nil {
+			return InsertCommand{}, err
+		}
 	}
 
-	if err := p.eatKeyword("values"); err != nil {
-		return InsertCommand{}, err
+	if p.isQuery() {
+		q, err := p.Query()
+		if err != nil {
+			return InsertCommand{}, err
+		}
+
+		return NewInsertQueryCommand(table, fields, q), nil
 	}
 
-	if err := p.eatTokenType(TokenLeftParen); err != nil {
+	if err := p.eatKeyword("values"); err != nil {
 		return InsertCommand{}, err
 	}
 
-	constants, err := p.constantList()
+	rows, err := p.rowList()
 	if err != nil {
 		return InsertCommand{}, err
 	}
 
-	if err := p.eatTokenType(TokenRightParen); err != nil {
-		return InsertCommand{}, err
-	}
+	return NewInsertCommand(table, fields, rows), nil
+}
+
+// <RowList> := ( <ConstList> ) [, <RowList> ]
+func (p Parser) rowList() ([][]Expression, error) {
+	var rows [][]Expression
+	for {
+		if err := p.eatTokenType(TokenLeftParen); err != nil {
+			return nil, err
+		}
+
+		constants, err := p.constantList()
+		if err != nil {
+			return nil, err
+		}
 
-	return NewInsertCommand(table, fields, constants), nil
+		if err := p.eatTokenType(TokenRightParen); err != nil {
+			return nil, err
+		}
+
+		rows = append(rows, constants)
+
+		if !p.matchTokenType(TokenComma) {
+			return rows, nil
+		}
+
+		if err := p.eatTokenType(TokenComma); err != nil {
+			return nil, err
+		}
+	}
 }
 
 func (p Parser) fieldList() ([]string, error) {
@@ -219,14 +268,26 @@ func (p Parser) fieldList() ([]string, error) {
 	return list, nil
 }
 
-func (p Parser) constantList() ([]storage.Value, error) {
-	var list []storage.Value
-	c, err := p.constant()
-	if err != nil {
-		return nil, err
-	}
+// <ConstList> := { <Constant> | <Nextval> } [, <ConstList> ]
+func (p Parser) constantList() ([]Expression, error) {
+	var list []Expression
+	if p.matchWord("nextval") {
+		p.eatWord("nextval")
 
-	list = append(list, c)
+		exp, err := p.nextval()
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, exp)
+	} else {
+		t, c, err := p.constant()
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, NewExpressionWithVal(t, c))
+	}
 
 	if !p.matchTokenType(TokenComma) {
 		return list, nil
@@ -314,6 +375,10 @@ func (p Parser) modify() (UpdateCommand, error) {
 			return UpdateCommand{}, err
 		}
 
+		if err := checkNextval(nil, pred); err != nil {
+			return UpdateCommand{}, err
+		}
+
 		return NewUpdateCommandWithPredicate(table, fields, pred), nil
 	}
 
@@ -1,8 +1,27 @@
 package sql
 
-import "github.com/luigitni/simpledb/storage"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/luigitni/simpledb/storage"
+)
+
+var (
+	ErrDivisionByZero     = errors.New("division by zero")
+	ErrOutOfRange         = errors.New("integer out of range")
+	ErrInvalidOperandType = errors.New("invalid operand type")
+)
+
+// FieldTypes resolves the type of a field.
+// Scans resolve types while they are being iterated,
+// Schemas while the query is being planned.
+type FieldTypes interface {
+	Type(fieldName string) storage.FieldType
+}
 
 type Scan interface {
+	FieldTypes
 	Val(fieldName string) (storage.Value, error)
 }
 
@@ -10,21 +29,102 @@ type Schema interface {
 	HasField(fieldName string) bool
 }
 
+// exprKind is the kind of node of an Expression tree
+type exprKind byte
+
+const (
+	exprField exprKind = iota
+	exprConstant
+	exprUnary
+	exprBinary
+	exprAggregate
+	exprSubquery
+	exprNextval
+)
+
+// arithOp is the operator of an unary or binary Expression.
+type arithOp byte
+
+const (
+	opAdd arithOp = iota
+	opSub
+	opMul
+	opDiv
+	opMod
+	opConcat
+	opNeg
+)
+
+var arithOpStrings = [...]string{
+	opAdd:    "+",
+	opSub:    "-",
+	opMul:    "*",
+	opDiv:    "/",
+	opMod:    "%",
+	opConcat: "||",
+	opNeg:    "-",
+}
+
+func (op arithOp) String() string {
+	return arithOpStrings[op]
+}
+
+// Expression is a typed expression tree.
+// Leaves are either field names, constants, which carry their own type,
+// scalar subqueries, which evaluate to the only value they return,
+// or calls to nextval, which draw the next value of a sequence.
+// Inner nodes apply an arithmetic operator to their operands.
+// Arithmetic is defined over the integer types and the result has the type
+// of the widest operand. Since integers are unsigned,
+// subtractions and negations that go below zero are out of range.
+// TEXT values can only be concatenated.
+// Any operation involving a NULL evaluates to NULL.
 type Expression struct {
-	val   storage.Value
-	fname string
+	kind     exprKind
+	val      storage.Value
+	typ      storage.FieldType
+	fname    string
+	op       arithOp
+	fn       AggregateFunc
+	sub      *Subquery
+	seq      string
+	gen      SequenceGenerator
+	operands []Expression
+}
+
+func NewExpressionWithVal(t storage.FieldType, v storage.Value) Expression {
+	return Expression{kind: exprConstant, val: v, typ: t}
 }
 
-func NewExpressionWithVal(v storage.Value) Expression {
-	return Expression{val: v}
+// newNullExpression returns the NULL constant.
+// NULL has no type of its own: it is typed as an INT,
+// and takes the type of the other operand when it appears in a binary expression.
+func newNullExpression() Expression {
+	return NewExpressionWithVal(storage.INT, storage.Null)
 }
 
 func NewExpressionWithField(fname string) Expression {
-	return Expression{fname: fname}
+	return Expression{kind: exprField, fname: fname}
+}
+
+func newUnaryExpression(op arithOp, operand Expression) Expression {
+	return Expression{kind: exprUnary, op: op, operands: []Expression{operand}}
+}
+
+func newBinaryExpression(op arithOp, lhs Expression, rhs Expression) Expression {
+	return Expression{kind: exprBinary, op: op, operands: []Expression{lhs, rhs}}
 }
 
 func (exp Expression) IsFieldName() bool {
-	return exp.fname != ""
+	return exp.kind == exprField
+}
+
+func (exp Expression) IsConstant() bool {
+	return exp.kind == exprConstant
+}
+
+func (exp Expression) isNull() bool {
+	return exp.kind == exprConstant && exp.val.IsNull()
 }
 
 func (exp Expression) AsConstant() storage.Value {
@@ -36,25 +136,286 @@ func (exp Expression) AsFieldName() string {
 }
 
 func (exp Expression) Evaluate(scan Scan) (storage.Value, error) {
-	if exp.val != nil {
+	switch exp.kind {
+	case exprConstant:
 		return exp.val, nil
+	case exprField:
+		return scan.Val(exp.fname)
+	case exprAggregate:
+		// aggregates are computed while grouping records, and
+		// are exposed as fields named after the aggregate expression.
+		return scan.Val(exp.String())
+	case exprSubquery:
+		return exp.evaluateSubquery(scan)
+	case exprNextval:
+		return exp.evaluateNextval()
+	}
+
+	t, err := exp.Type(scan)
+	if err != nil {
+		return nil, err
 	}
 
-	return scan.Val(exp.fname)
+	vals := make([]storage.Value, len(exp.operands))
+	for i, o := range exp.operands {
+		v, err := o.Evaluate(scan)
+		if err != nil {
+			return nil, err
+		}
+
+		// any operation involving a NULL evaluates to NULL
+		if v.IsNull() {
+			return storage.Null, nil
+		}
+
+		vals[i] = v
+	}
+
+	if exp.op == opConcat {
+		return storage.ValueFromGoString(
+			storage.ValueAsGoString(vals[0]) + storage.ValueAsGoString(vals[1]),
+		), nil
+	}
+
+	operands := make([]storage.Long, len(vals))
+	for i, v := range vals {
+		ot, err := exp.operands[i].Type(scan)
+		if err != nil {
+			return nil, err
+		}
+
+		operands[i] = storage.ValueAsLong(ot, v)
+	}
+
+	res, err := exp.op.apply(operands)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.ValueFromLong(t, res), nil
+}
+
+func (op arithOp) apply(operands []storage.Long) (storage.Long, error) {
+	if op == opNeg {
+		if operands[0] != 0 {
+			return 0, ErrOutOfRange
+		}
+
+		return 0, nil
+	}
+
+	a, b := operands[0], operands[1]
+	switch op {
+	case opAdd:
+		return a + b, nil
+	case opSub:
+		if a < b {
+			return 0, ErrOutOfRange
+		}
+
+		return a - b, nil
+	case opMul:
+		return a * b, nil
+	}
+
+	if b == 0 {
+		return 0, ErrDivisionByZero
+	}
+
+	if op == opDiv {
+		return a / b, nil
+	}
+
+	return a % b, nil
+}
+
+// EvaluateAs evaluates the expression and converts the result
+// to the type t, which is usually the type of the field the value
+// is about to be assigned to.
+func (exp Expression) EvaluateAs(scan Scan, t storage.FieldType) (storage.Value, error) {
+	v, err := exp.Evaluate(scan)
+	if err != nil {
+		return nil, err
+	}
+
+	vt, err := exp.Type(scan)
+	if err != nil {
+		return nil, err
+	}
+
+	return convert(vt, t, v)
+}
+
+// convert converts a value of type from to type to.
+// Integers can be converted to any other integer type,
+// while all other types must match.
+// NULL can be converted to any type.
+func convert(from storage.FieldType, to storage.FieldType, v storage.Value) (storage.Value, error) {
+	if from == to || v.IsNull() {
+		return v, nil
+	}
+
+	if !from.IsInteger() || !to.IsInteger() {
+		return nil, ErrTypeMismatch
+	}
+
+	return storage.ValueFromLong(to, storage.ValueAsLong(from, v)), nil
+}
+
+// Type returns the type of the value the expression evaluates to.
+// Constants carry their own type, while the type of a field
+// is resolved by types, which is either a Scan or a Schema.
+func (exp Expression) Type(types FieldTypes) (storage.FieldType, error) {
+	switch exp.kind {
+	case exprConstant:
+		return exp.typ, nil
+	case exprField:
+		return types.Type(exp.fname), nil
+	case exprAggregate:
+		return types.Type(exp.String()), nil
+	case exprSubquery:
+		if exp.sub.eval == nil {
+			return 0, ErrUnboundSubquery
+		}
+
+		return exp.sub.eval.Type(), nil
+	case exprNextval:
+		return storage.LONG, nil
+	}
+
+	t, err := exp.operands[0].Type(types)
+	if err != nil {
+		return 0, err
+	}
+
+	if exp.kind == exprUnary {
+		if !t.IsInteger() {
+			return 0, ErrInvalidOperandType
+		}
+
+		return t, nil
+	}
+
+	rt, err := exp.operands[1].Type(types)
+	if err != nil {
+		return 0, err
+	}
+
+	lhsNull, rhsNull := exp.operands[0].isNull(), exp.operands[1].isNull()
+
+	if exp.op == opConcat {
+		if t != storage.TEXT && !lhsNull || rt != storage.TEXT && !rhsNull {
+			return 0, ErrInvalidOperandType
+		}
+
+		return storage.TEXT, nil
+	}
+
+	if lhsNull {
+		t = rt
+	}
+
+	if rhsNull {
+		rt = t
+	}
+
+	if !t.IsInteger() || !rt.IsInteger() {
+		return 0, ErrInvalidOperandType
+	}
+
+	// integer types are declared from the narrowest to the widest
+	if rt > t {
+		return rt, nil
+	}
+
+	return t, nil
+}
+
+// Fields returns the names of the fields the expression depends on.
+// An aggregate depends on the field named after it,
+// and a subquery on the fields of the outer query it refers to.
+func (exp Expression) Fields() []string {
+	switch exp.kind {
+	case exprField:
+		return []string{exp.fname}
+	case exprAggregate:
+		return []string{exp.String()}
+	case exprSubquery:
+		return exp.sub.outer
+	}
+
+	var fields []string
+	for _, o := range exp.operands {
+		fields = append(fields, o.Fields()...)
+	}
+
+	return fields
 }
 
 func (exp Expression) AppliesTo(schema Schema) bool {
-	if exp.val != nil {
-		return true
+	for _, f := range exp.Fields() {
+		if !schema.HasField(f) {
+			return false
+		}
 	}
 
-	return schema.HasField(exp.fname)
+	return true
 }
 
-func (exp Expression) String(t storage.FieldType) string {
-	if exp.val != nil {
-		return exp.val.String(t)
+func (exp Expression) String() string {
+	switch exp.kind {
+	case exprConstant:
+		if exp.typ == storage.TEXT {
+			return "'" + exp.val.String(exp.typ) + "'"
+		}
+
+		return exp.val.String(exp.typ)
+	case exprField:
+		return exp.fname
+	case exprUnary:
+		return exp.op.String() + exp.operands[0].operandString(exp.op, true)
+	case exprAggregate:
+		return exp.aggregateString()
+	case exprSubquery:
+		return exp.sub.String()
+	case exprNextval:
+		return "nextval('" + exp.seq + "')"
 	}
 
-	return exp.fname
+	return fmt.Sprintf(
+		"%s %s %s",
+		exp.operands[0].operandString(exp.op, false),
+		exp.op,
+		exp.operands[1].operandString(exp.op, true),
+	)
+}
+
+// precedence returns the binding strength of the operator.
+func (op arithOp) precedence() int {
+	switch op {
+	case opNeg:
+		return 3
+	case opMul, opDiv, opMod:
+		return 2
+	}
+
+	return 1
+}
+
+// operandString returns the string representation of the expression
+// as an operand of parent, wrapped in parentheses if the expression
+// binds less tightly than its parent.
+// Since operators are left associative, right operands
+// with the same precedence of their parent need parentheses too.
+func (exp Expression) operandString(parent arithOp, right bool) string {
+	if exp.kind != exprBinary {
+		return exp.String()
+	}
+
+	p, pp := exp.op.precedence(), parent.precedence()
+	if p < pp || right && p == pp {
+		return "(" + exp.String() + ")"
+	}
+
+	return exp.String()
 }
@@ -0,0 +1,107 @@
+package sql
+
+// JoinKind is the kind of join between a table of the FROM clause
+// and the tables on its left.
+type JoinKind byte
+
+const (
+	// JoinNone is the zero value: the table is the first table of the FROM clause
+	// or it follows a comma, and its records are combined with all the records on its left.
+	JoinNone JoinKind = iota
+	JoinInner
+	JoinLeft
+	JoinRight
+	JoinFull
+)
+
+var joinKindNames = [...]string{
+	JoinNone:  ",",
+	JoinInner: "JOIN",
+	JoinLeft:  "LEFT JOIN",
+	JoinRight: "RIGHT JOIN",
+	JoinFull:  "FULL OUTER JOIN",
+}
+
+func (k JoinKind) String() string {
+	return joinKindNames[k]
+}
+
+// IsOuter returns true if the join preserves
+// the records of either side that have no match on the other.
+func (k JoinKind) IsOuter() bool {
+	return k == JoinLeft || k == JoinRight || k == JoinFull
+}
+
+// Join describes how a table of the FROM clause is joined
+// to the tables on its left.
+// Joins associate to the left: a JOIN applies to the result
+// of the joins that precede it, up to the previous comma.
+type Join struct {
+	Kind JoinKind
+	// On is the predicate of the ON clause.
+	// It is empty for JoinNone.
+	On Predicate
+}
+
+var joinKinds = map[tokenType]JoinKind{
+	TokenJoin:  JoinInner,
+	TokenInner: JoinInner,
+	TokenLeft:  JoinLeft,
+	TokenRight: JoinRight,
+	TokenFull:  JoinFull,
+}
+
+// joinKind parses the join type and the JOIN keyword:
+// <JoinType> := [ INNER ] JOIN | LEFT [ OUTER ] JOIN | RIGHT [ OUTER ] JOIN | FULL [ OUTER ] JOIN
+// The second return value is false if the current token does not start a join.
+func (p Parser) joinKind() (JoinKind, bool, error) {
+	for tkn, kind := range joinKinds {
+		if !p.matchTokenType(tkn) {
+			continue
+		}
+
+		if err := p.eatTokenType(tkn); err != nil {
+			return 0, false, err
+		}
+
+		if tkn == TokenJoin {
+			return kind, true, nil
+		}
+
+		if kind.IsOuter() && p.matchTokenType(TokenOuter) {
+			p.eatTokenType(TokenOuter)
+		}
+
+		if err := p.eatTokenType(TokenJoin); err != nil {
+			return 0, false, err
+		}
+
+		return kind, true, nil
+	}
+
+	return JoinNone, false, nil
+}
+
+// joinOn parses the ON clause of a join.
+// Joins are computed before records are grouped,
+// so the predicate cannot contain aggregates.
+func (p Parser) joinOn() (Predicate, error) {
+	if err := p.eatTokenType(TokenOn); err != nil {
+		return Predicate{}, err
+	}
+
+	on, err := p.predicate()
+	if err != nil {
+		return Predicate{}, err
+	}
+
+	if len(on.Aggregates()) > 0 {
+		return Predicate{}, ErrMisplacedAggregate
+	}
+
+	if err := checkNextval(nil, on); err != nil {
+		return Predicate{}, err
+	}
+
+	return on, nil
+}
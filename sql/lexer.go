@@ -81,6 +81,30 @@ func (lexer *Lexer) eatIdentifier() (string, error) {
 	return tokenToString(lexer.tokenizer.src, lexer.current), nil
 }
 
+// lexerState is a snapshot of the position of the lexer.
+type lexerState struct {
+	current Token
+	pos     int
+	line    int
+}
+
+// mark returns the current state of the lexer,
+// so that the parser can backtrack to it with reset.
+func (lexer *Lexer) mark() lexerState {
+	return lexerState{
+		current: lexer.current,
+		pos:     lexer.tokenizer.current,
+		line:    lexer.tokenizer.line,
+	}
+}
+
+// reset moves the lexer back to a state returned by mark.
+func (lexer *Lexer) reset(state lexerState) {
+	lexer.current = state.current
+	lexer.tokenizer.current = state.pos
+	lexer.tokenizer.line = state.line
+}
+
 func (lexer *Lexer) nextToken() error {
 	tkn, err := lexer.tokenizer.nextToken()
 	if err != nil && err != io.EOF {
@@ -4,26 +4,60 @@ import "github.com/luigitni/simpledb/storage"
 
 // Entire grammar for the SQL subset supported by SimpleDB
 // <Field> := TokenIdentifier
-// <Constant> := TokenString | TokenNumber
-// <Expression> := <Field> | <Constant>
-// <Term> := <Expression> = <Expression>
-// <Predicate> := <Term> [AND <Predicate>]
-// <Query> := SELECT <SelectList> FROM <TableList> [ WHERE <Predicate> ] [ORDER BY <Field> [, <FieldList>]]
-// <SelectList> := <Field> [, <SelectList> ]
-// <TableList> := TokenIdentifier [, <TableList> ]
-// <UpdateCmd> := <Insert> | <Delete> | <Modify> | <Create>
-// <Create> := <CreateTable> | <CreateView> | <CreateIndex>
-// <Insert> := INSERT INTO TokenIdentifier ( <FieldList> ) VALUES ( <ConstList> )
+// <QualifiedField> := [ TokenIdentifier . ] <Field>
+// <Constant> := TokenString | TokenNumber | NULL
+// <Expression> := <Product> [ { + | - | || } <Product> ... ]
+// <Product> := <Unary> [ { * | / | % } <Unary> ... ]
+// <Unary> := - <Unary> | <Primary>
+// <Primary> := <QualifiedField> | <Constant> | <Aggregate> | <Nextval> | ( <Expression> ) | <Subquery>
+// <Nextval> := NEXTVAL ( TokenString )
+// <Subquery> := ( <Query> )
+// <Aggregate> := COUNT ( * ) | <AggregateFunc> ( <Expression> )
+// <AggregateFunc> := COUNT | SUM | MIN | MAX | AVG
+// <CompareOp> := = | <> | != | < | <= | > | >=
+// <Term> := <Expression> <CompareOp> <Expression> | <Expression> IS [ NOT ] NULL | <Expression> [ NOT ] IN <Subquery>
+// <Predicate> := <Conjunction> [ OR <Predicate> ]
+// <Conjunction> := <Condition> [ AND <Conjunction> ]
+// <Condition> := NOT <Condition> | EXISTS <Subquery> | ( <Predicate> ) | <Term>
+// <Query> := [ <WithClause> ] <CompoundQuery>
+// <WithClause> := WITH [ RECURSIVE ] <CommonTableExpr> [, <CommonTableExpr> ... ]
+// <CommonTableExpr> := TokenIdentifier [ ( <FieldList> ) ] AS ( <Query> )
+// <CompoundQuery> := <SetOperand> [ { UNION | EXCEPT } [ ALL ] <SetOperand> ... ]
+// <SetOperand> := <SelectQuery> [ INTERSECT [ ALL ] <SelectQuery> ... ]
+// <SelectQuery> := SELECT [ DISTINCT ] <SelectList> FROM <TableList> [ WHERE <Predicate> ] [ GROUP BY <QualifiedFieldList> ] [ HAVING <Predicate> ] [ ORDER BY <SortList> ] [ LIMIT TokenNumber [ OFFSET TokenNumber ] ]
+// <SelectList> := * | <SelectItem> [, <SelectItem> ... ]
+// <SelectItem> := <Expression> [ AS TokenIdentifier ]
+// <SortList> := <QualifiedField> [ ASC | DESC ] [, <SortList> ]
+// <QualifiedFieldList> := <QualifiedField> [, <QualifiedFieldList> ]
+// <TableList> := <JoinedTable> [, <TableList> ]
+// <JoinedTable> := <TableRef> [ <JoinType> <TableRef> ON <Predicate> ... ]
+// <JoinType> := [ INNER ] JOIN | LEFT [ OUTER ] JOIN | RIGHT [ OUTER ] JOIN | FULL [ OUTER ] JOIN
+// <TableRef> := TokenIdentifier [ [ AS ] TokenIdentifier ]
+// <UpdateCmd> := <Insert> | <Delete> | <Modify> | <Create> | <Drop> | <AlterTable>
+// <Create> := <CreateTable> | <CreateView> | <CreateIndex> | <CreateSequence>
+// <Insert> := INSERT INTO TokenIdentifier [ ( <FieldList> ) ] { VALUES <RowList> | <Query> }
+// <RowList> := ( <ConstList> ) [, <RowList> ]
 // <FieldList> := <Field> [, <FieldList> ]
-// <ConstList> := <Constant> [, <ConstList> ]
+// <ConstList> := { <Constant> | <Nextval> } [, <ConstList> ]
 // <Delete> := DELETE FROM TokenIdentifier [ WHERE <Predicate> ]
-// <Modify> := UPDATE TokenIdentifier SET <Field> = <Expression> [ WHERE <Predicate> ]
-// <CreateTable> := CREATE TABLE TokenIdentifier ( <FieldDefs> )
-// <FieldDefs> := <FieldDef> [, <FieldDefs> ]
+// <Modify> := UPDATE TokenIdentifier SET <Field> = <Expression> [, <Field> = <Expression> ...] [ WHERE <Predicate> ]
+// <CreateTable> := CREATE TABLE TokenIdentifier ( <TableElements> )
+// <TableElements> := <TableElement> [, <TableElements> ]
+// <TableElement> := <FieldDef> [ <ColumnConstraint> ... ] | <KeyConstraint> | <ForeignKey>
 // <FieldDef> := TokenIdentifier <TypeDef>
-// <TypeDef> := INT | TEXT | VARCHAR ( TokenNumber )
+// <ColumnConstraint> := PRIMARY KEY | UNIQUE | NOT NULL | DEFAULT <Expression> | CHECK ( <Predicate> ) | <References> | AUTO_INCREMENT
+// <KeyConstraint> := { PRIMARY KEY | UNIQUE } ( <FieldList> )
+// <ForeignKey> := FOREIGN KEY ( <FieldList> ) <References>
+// <References> := REFERENCES TokenIdentifier [ ( <FieldList> ) ] [ ON DELETE <ReferentialAction> ]
+// <ReferentialAction> := RESTRICT | CASCADE | SET NULL
+// <TypeDef> := INT | TEXT | VARCHAR ( TokenNumber ) | SERIAL
 // <CreateView> := CREATE VIEW TokenIdentifier AS <Query>
 // <CreateIndex> := CREATE INDEX TokenIdentifier ON TokenIdentifier ( <Field> )
+// <CreateSequence> := CREATE SEQUENCE TokenIdentifier [ START [ WITH ] TokenNumber ] [ INCREMENT [ BY ] TokenNumber ]
+// <Drop> := DROP { TABLE | INDEX | VIEW | SEQUENCE } TokenIdentifier
+// <AlterTable> := ALTER TABLE TokenIdentifier <AlterAction>
+// <AlterAction> := ADD [ COLUMN ] <FieldDef> [ <ColumnConstraint> ... ] | DROP [ COLUMN ] TokenIdentifier
+// | RENAME [ COLUMN ] TokenIdentifier TO TokenIdentifier | RENAME TO TokenIdentifier
 // <BegingTransaction> := BEGIN
 // <Commit> := COMMIT
 // <Rollback> := ROLLBACK
@@ -58,47 +92,279 @@ func (p Parser) field() (string, error) {
 	return p.eatIdentifier()
 }
 
-func (p Parser) constant() (storage.Value, error) {
+// qualifiedField parses a field that can be qualified
+// by the name or the alias of its table, as in table.field.
+// The qualified name is returned as a single string.
+func (p Parser) qualifiedField() (string, error) {
+	f, err := p.field()
+	if err != nil {
+		return "", err
+	}
+
+	if !p.matchTokenType(TokenDot) {
+		return f, nil
+	}
+
+	if err := p.eatTokenType(TokenDot); err != nil {
+		return "", err
+	}
+
+	qf, err := p.field()
+	if err != nil {
+		return "", err
+	}
+
+	return f + "." + qf, nil
+}
+
+// <QualifiedFieldList> := <QualifiedField> [, <QualifiedFieldList> ]
+func (p Parser) qualifiedFieldList() ([]string, error) {
+	var list []string
+	for {
+		f, err := p.qualifiedField()
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, f)
+
+		if !p.matchTokenType(TokenComma) {
+			return list, nil
+		}
+
+		p.eatTokenType(TokenComma)
+	}
+}
+
+func (p Parser) constant() (storage.FieldType, storage.Value, error) {
+	if p.matchTokenType(TokenNull) {
+		if err := p.eatTokenType(TokenNull); err != nil {
+			return 0, storage.Value{}, err
+		}
+
+		null := newNullExpression()
+		return null.typ, null.val, nil
+	}
+
 	if p.matchStringValue() {
 		s, err := p.eatStringValue()
 		if err != nil {
-			return storage.Value{}, err
+			return 0, storage.Value{}, err
 		}
 		// remove quotes from the parsed raw string
-		return storage.ValueFromGoString(s[1 : len(s)-1]), nil
+		return storage.TEXT, storage.ValueFromGoString(s[1 : len(s)-1]), nil
 	}
 
 	v, err := p.eatIntValue()
 	if err != nil {
-		return storage.Value{}, err
+		return 0, storage.Value{}, err
 	}
 
-	return storage.ValueFromInteger[storage.Int](storage.SizeOfInt, storage.Int(v)), nil
+	return storage.INT, storage.ValueFromInteger[storage.Int](storage.SizeOfInt, storage.Int(v)), nil
+}
+
+var additiveOps = map[tokenType]arithOp{
+	TokenPlus:   opAdd,
+	TokenMinus:  opSub,
+	TokenConcat: opConcat,
+}
+
+var multiplicativeOps = map[tokenType]arithOp{
+	TokenStar:    opMul,
+	TokenSlash:   opDiv,
+	TokenPercent: opMod,
+}
+
+// <Expression> := <Product> [ { + | - | || } <Product> ... ]
+// Expression parses a single expression, such as the default value of a field.
+func (p Parser) Expression() (Expression, error) {
+	return p.expression()
 }
 
 func (p Parser) expression() (Expression, error) {
+	return p.binaryExpression(additiveOps, p.product)
+}
+
+// <Product> := <Unary> [ { * | / | % } <Unary> ... ]
+func (p Parser) product() (Expression, error) {
+	return p.binaryExpression(multiplicativeOps, p.unary)
+}
+
+// binaryExpression parses a left associative chain of operands,
+// separated by any of the given operators.
+func (p Parser) binaryExpression(ops map[tokenType]arithOp, operand func() (Expression, error)) (Expression, error) {
+	lhs, err := operand()
+	if err != nil {
+		return Expression{}, err
+	}
+
+	for {
+		op, ok := ops[p.current.TokenType]
+		if !ok {
+			return lhs, nil
+		}
+
+		if err := p.nextToken(); err != nil {
+			return Expression{}, err
+		}
+
+		rhs, err := operand()
+		if err != nil {
+			return Expression{}, err
+		}
+
+		lhs = newBinaryExpression(op, lhs, rhs)
+	}
+}
+
+// <Unary> := - <Unary> | <Primary>
+func (p Parser) unary() (Expression, error) {
+	if !p.matchTokenType(TokenMinus) {
+		return p.primary()
+	}
+
+	if err := p.eatTokenType(TokenMinus); err != nil {
+		return Expression{}, err
+	}
+
+	operand, err := p.unary()
+	if err != nil {
+		return Expression{}, err
+	}
+
+	return newUnaryExpression(opNeg, operand), nil
+}
+
+// <Primary> := <Field> | <Constant> | <Aggregate> | <Nextval> | ( <Expression> ) | <Subquery>
+func (p Parser) primary() (Expression, error) {
+	if p.matchTokenType(TokenLeftParen) {
+		state := p.mark()
+		if err := p.eatTokenType(TokenLeftParen); err != nil {
+			return Expression{}, err
+		}
+
+		if p.matchTokenType(TokenSelect) || p.matchTokenType(TokenWith) {
+			p.reset(state)
+
+			sq, err := p.subquery(false)
+			if err != nil {
+				return Expression{}, err
+			}
+
+			return newSubqueryExpression(sq), nil
+		}
+
+		exp, err := p.expression()
+		if err != nil {
+			return Expression{}, err
+		}
+
+		if err := p.eatTokenType(TokenRightParen); err != nil {
+			return Expression{}, err
+		}
+
+		return exp, nil
+	}
+
 	if p.matchIdentifier() {
-		f, err := p.field()
+		f, err := p.qualifiedField()
 		if err != nil {
 			return Expression{}, err
 		}
+
+		if fn, ok := aggregateFuncs[f]; ok && p.matchTokenType(TokenLeftParen) {
+			return p.aggregate(fn)
+		}
+
+		if f == "nextval" && p.matchTokenType(TokenLeftParen) {
+			return p.nextval()
+		}
+
 		return NewExpressionWithField(f), nil
 	}
 
-	c, err := p.constant()
+	t, c, err := p.constant()
 	if err != nil {
 		return Expression{}, err
 	}
-	return NewExpressionWithVal(c), nil
+	return NewExpressionWithVal(t, c), nil
 }
 
+// <Aggregate> := COUNT ( * ) | <AggregateFunc> ( <Expression> )
+// The name of the function has already been consumed.
+// Aggregates cannot be nested.
+func (p Parser) aggregate(fn AggregateFunc) (Expression, error) {
+	if err := p.eatTokenType(TokenLeftParen); err != nil {
+		return Expression{}, err
+	}
+
+	var operand *Expression
+	if fn == AggregateCount && p.matchTokenType(TokenStar) {
+		if err := p.eatTokenType(TokenStar); err != nil {
+			return Expression{}, err
+		}
+	} else {
+		exp, err := p.expression()
+		if err != nil {
+			return Expression{}, err
+		}
+
+		if len(exp.Aggregates()) > 0 {
+			return Expression{}, ErrInvalidSyntax
+		}
+
+		operand = &exp
+	}
+
+	if err := p.eatTokenType(TokenRightParen); err != nil {
+		return Expression{}, err
+	}
+
+	return newAggregateExpression(fn, operand), nil
+}
+
+var compareOps = map[tokenType]compareOp{
+	TokenEqual:        opEqual,
+	TokenEqualEqual:   opEqual,
+	TokenLessGreater:  opNotEqual,
+	TokenBangEqual:    opNotEqual,
+	TokenLess:         opLess,
+	TokenLessEqual:    opLessEqual,
+	TokenGreater:      opGreater,
+	TokenGreaterEqual: opGreaterEqual,
+}
+
+// <CompareOp> := = | <> | != | < | <= | > | >=
+func (p Parser) compareOp() (compareOp, error) {
+	op, ok := compareOps[p.current.TokenType]
+	if !ok {
+		return 0, ErrInvalidSyntax
+	}
+
+	if err := p.nextToken(); err != nil {
+		return 0, err
+	}
+
+	return op, nil
+}
+
+// <Term> := <Expression> <CompareOp> <Expression> | <Expression> IS [ NOT ] NULL | <Expression> [ NOT ] IN <Subquery>
 func (p Parser) term() (Term, error) {
 	lhs, err := p.expression()
 	if err != nil {
 		return Term{}, err
 	}
 
-	if err := p.eatTokenType(TokenEqual); err != nil {
+	if p.matchTokenType(TokenIs) {
+		return p.nullTerm(lhs)
+	}
+
+	if p.matchTokenType(TokenIn) || p.matchTokenType(TokenNot) {
+		return p.inTerm(lhs)
+	}
+
+	op, err := p.compareOp()
+	if err != nil {
 		return Term{}, err
 	}
 
@@ -106,18 +372,109 @@ func (p Parser) term() (Term, error) {
 	if err != nil {
 		return Term{}, err
 	}
-	return newTerm(lhs, rhs), nil
+	return newTerm(op, lhs, rhs), nil
+}
+
+// nullTerm parses the IS [ NOT ] NULL test on lhs.
+func (p Parser) nullTerm(lhs Expression) (Term, error) {
+	if err := p.eatTokenType(TokenIs); err != nil {
+		return Term{}, err
+	}
+
+	op := opIsNull
+	if p.matchTokenType(TokenNot) {
+		if err := p.eatTokenType(TokenNot); err != nil {
+			return Term{}, err
+		}
+
+		op = opIsNotNull
+	}
+
+	if err := p.eatTokenType(TokenNull); err != nil {
+		return Term{}, err
+	}
+
+	return newNullTerm(op, lhs), nil
+}
+
+// inTerm parses the [ NOT ] IN test of lhs against a subquery.
+func (p Parser) inTerm(lhs Expression) (Term, error) {
+	op := opIn
+	if p.matchTokenType(TokenNot) {
+		if err := p.eatTokenType(TokenNot); err != nil {
+			return Term{}, err
+		}
+
+		op = opNotIn
+	}
+
+	if err := p.eatTokenType(TokenIn); err != nil {
+		return Term{}, err
+	}
+
+	sq, err := p.subquery(false)
+	if err != nil {
+		return Term{}, err
+	}
+
+	return newTerm(op, lhs, newSubqueryExpression(sq)), nil
 }
 
+// <Subquery> := ( <Query> )
+func (p Parser) subquery(exists bool) (Subquery, error) {
+	if err := p.eatTokenType(TokenLeftParen); err != nil {
+		return Subquery{}, err
+	}
+
+	q, err := p.Query()
+	if err != nil {
+		return Subquery{}, err
+	}
+
+	if err := p.eatTokenType(TokenRightParen); err != nil {
+		return Subquery{}, err
+	}
+
+	return Subquery{query: q, exists: exists}, nil
+}
+
+// Predicate parses a single predicate, such as the check of a field.
+func (p Parser) Predicate() (Predicate, error) {
+	return p.predicate()
+}
+
+// <Predicate> := <Conjunction> [ OR <Predicate> ]
 func (p Parser) predicate() (Predicate, error) {
-	term, err := p.term()
+	pred, err := p.conjunction()
+	if err != nil {
+		return Predicate{}, err
+	}
+
+	if !p.matchTokenType(TokenOr) {
+		return pred, nil
+	}
+
+	if err := p.eatTokenType(TokenOr); err != nil {
+		return Predicate{}, err
+	}
+
+	other, err := p.predicate()
+	if err != nil {
+		return Predicate{}, err
+	}
+
+	return newOrPredicate(pred, other), nil
+}
+
+// <Conjunction> := <Condition> [ AND <Conjunction> ]
+func (p Parser) conjunction() (Predicate, error) {
+	pred, err := p.condition()
 	if err != nil {
 		return Predicate{}, err
 	}
 
-	pred := newPredicateWithTerm(term)
 	// check if the next token is an AND
-	// if not, we are done, otherwise recursively add another predicate
+	// if not, we are done, otherwise recursively add another conjunction
 	if !p.matchTokenType(TokenAnd) {
 		return pred, nil
 	}
@@ -126,37 +483,173 @@ func (p Parser) predicate() (Predicate, error) {
 		return Predicate{}, err
 	}
 
-	other, err := p.predicate()
+	other, err := p.conjunction()
 	if err != nil {
-		return Predicate{}, nil
+		return Predicate{}, err
 	}
 
 	pred.CojoinWith(other)
 	return pred, nil
 }
 
+// <Condition> := NOT <Condition> | EXISTS <Subquery> | ( <Predicate> ) | <Term>
+func (p Parser) condition() (Predicate, error) {
+	if p.matchTokenType(TokenExists) {
+		if err := p.eatTokenType(TokenExists); err != nil {
+			return Predicate{}, err
+		}
+
+		sq, err := p.subquery(true)
+		if err != nil {
+			return Predicate{}, err
+		}
+
+		return newPredicateWithTerm(newExistsTerm(sq)), nil
+	}
+
+	if p.matchTokenType(TokenNot) {
+		if err := p.eatTokenType(TokenNot); err != nil {
+			return Predicate{}, err
+		}
+
+		pred, err := p.condition()
+		if err != nil {
+			return Predicate{}, err
+		}
+
+		return newNotPredicate(pred), nil
+	}
+
+	// a parenthesis opens either a nested predicate or
+	// an expression, as in (a + b) > c.
+	// Try the former first and backtrack if it fails.
+	if p.matchTokenType(TokenLeftParen) {
+		state := p.mark()
+		if pred, err := p.nestedPredicate(); err == nil {
+			return pred, nil
+		}
+
+		p.reset(state)
+	}
+
+	term, err := p.term()
+	if err != nil {
+		return Predicate{}, err
+	}
+
+	return newPredicateWithTerm(term), nil
+}
+
+func (p Parser) nestedPredicate() (Predicate, error) {
+	if err := p.eatTokenType(TokenLeftParen); err != nil {
+		return Predicate{}, err
+	}
+
+	pred, err := p.predicate()
+	if err != nil {
+		return Predicate{}, err
+	}
+
+	if err := p.eatTokenType(TokenRightParen); err != nil {
+		return Predicate{}, err
+	}
+
+	return pred, nil
+}
+
 // Query parsing methods
-// <Query> := SELECT <SelectList> FROM <TableList> [ WHERE <Predicate> ] [ ORDER BY <Field>,]
+// <Query> := [ <WithClause> ] <CompoundQuery>
 func (p Parser) Query() (Query, error) {
-	if err := p.eatTokenType(TokenSelect); err != nil {
+	if !p.matchTokenType(TokenWith) {
+		return p.compoundQuery()
+	}
+
+	ctes, err := p.withClause()
+	if err != nil {
 		return Query{}, err
 	}
 
-	selects, err := p.selectList()
+	q, err := p.compoundQuery()
 	if err != nil {
 		return Query{}, err
 	}
 
+	return q.WithCTEs(ctes), nil
+}
+
+// <CompoundQuery> := <SetOperand> [ { UNION | EXCEPT } [ ALL ] <SetOperand> ... ]
+// INTERSECT binds more tightly than UNION and EXCEPT, and set operations associate to the left.
+// The ORDER BY and LIMIT clauses of the last query apply to the result of the set operations.
+func (p Parser) compoundQuery() (Query, error) {
+	q, err := p.setOperand()
+	if err != nil {
+		return Query{}, err
+	}
+
+	for {
+		op, all, ok, err := p.setOperator(TokenUnion, TokenExcept)
+		if err != nil {
+			return Query{}, err
+		}
+
+		if !ok {
+			return q, nil
+		}
+
+		rhs, err := p.setOperand()
+		if err != nil {
+			return Query{}, err
+		}
+
+		q, err = newSetOperationQuery(op, all, q, rhs)
+		if err != nil {
+			return Query{}, err
+		}
+	}
+}
+
+// <SelectQuery> := SELECT [ DISTINCT ] <SelectList> FROM <TableList> [ WHERE <Predicate> ] [ GROUP BY <QualifiedFieldList> ] [ HAVING <Predicate> ] [ ORDER BY <SortList> ] [ LIMIT TokenNumber [ OFFSET TokenNumber ] ]
+func (p Parser) selectQuery() (Query, error) {
+	if err := p.eatTokenType(TokenSelect); err != nil {
+		return Query{}, err
+	}
+
+	distinct := p.matchTokenType(TokenDistinct)
+	if distinct {
+		if err := p.eatTokenType(TokenDistinct); err != nil {
+			return Query{}, err
+		}
+	}
+
+	var selects []Expression
+	var aliases []string
+	star := p.matchTokenType(TokenStar)
+	if star {
+		if err := p.eatTokenType(TokenStar); err != nil {
+			return Query{}, err
+		}
+	} else {
+		var err error
+		selects, aliases, err = p.selectList()
+		if err != nil {
+			return Query{}, err
+		}
+	}
+
 	if err := p.eatTokenType(TokenFrom); err != nil {
 		return Query{}, err
 	}
 
-	tables, err := p.tableList()
+	tables, joins, err := p.tableList()
 	if err != nil {
 		return Query{}, err
 	}
 
 	q := NewQuery(selects, tables)
+	q.joins = joins
+	q.distinct = distinct
+	q.star = star
+	q.aliases = aliases
 
 	if p.matchTokenType(TokenWhere) {
 		if err := p.eatTokenType(TokenWhere); err != nil {
@@ -168,76 +661,229 @@ func (p Parser) Query() (Query, error) {
 			return Query{}, err
 		}
 
+		// WHERE filters records before they are grouped
+		if len(pred.Aggregates()) > 0 {
+			return Query{}, ErrMisplacedAggregate
+		}
+
 		q.predicate = pred
 	}
 
+	if p.matchTokenType(TokenGroupBy) {
+		if err := p.eatTokenType(TokenGroupBy); err != nil {
+			return Query{}, err
+		}
+
+		groupByFields, err := p.qualifiedFieldList()
+		if err != nil {
+			return Query{}, err
+		}
+
+		q.groupByFields = groupByFields
+	}
+
+	if p.matchTokenType(TokenHaving) {
+		if err := p.eatTokenType(TokenHaving); err != nil {
+			return Query{}, err
+		}
+
+		having, err := p.predicate()
+		if err != nil {
+			return Query{}, err
+		}
+
+		q.having = having
+	}
+
 	if p.matchTokenType(TokenOrderBy) {
-		orderByFields, err := p.orderBy()
+		orderBy, err := p.orderBy()
 		if err != nil {
 			return Query{}, err
 		}
 
-		q.orderByFields = orderByFields
+		q.orderBy = orderBy
 	}
 
-	return q, nil
-}
+	if p.matchTokenType(TokenLimit) {
+		limit, offset, err := p.limit()
+		if err != nil {
+			return Query{}, err
+		}
 
-func (p Parser) orderBy() ([]string, error) {
-	p.eatTokenType(TokenOrderBy)
+		q.limit = limit
+		q.offset = offset
+	}
 
-	ff, err := p.fieldList()
-	if err != nil {
-		return nil, err
+	if err := checkNextval(q.fields, q.predicate, q.having); err != nil {
+		return Query{}, err
 	}
 
-	return ff, nil
+	return q, nil
 }
 
-func (p Parser) selectList() ([]string, error) {
-	var sl []string
-	f, err := p.field()
+// limit parses the LIMIT clause and its optional OFFSET.
+func (p Parser) limit() (int, int, error) {
+	if err := p.eatTokenType(TokenLimit); err != nil {
+		return 0, 0, err
+	}
+
+	limit, err := p.eatIntValue()
 	if err != nil {
-		return nil, err
+		return 0, 0, err
 	}
 
-	sl = append(sl, f)
-	if !p.matchTokenType(TokenComma) {
-		return sl, nil
+	if !p.matchTokenType(TokenOffset) {
+		return limit, 0, nil
 	}
 
-	p.eatTokenType(TokenComma)
+	if err := p.eatTokenType(TokenOffset); err != nil {
+		return 0, 0, err
+	}
 
-	other, err := p.selectList()
+	offset, err := p.eatIntValue()
 	if err != nil {
-		return nil, err
+		return 0, 0, err
 	}
 
-	sl = append(sl, other...)
-	return sl, nil
+	return limit, offset, nil
 }
 
-func (p Parser) tableList() ([]string, error) {
-	var tl []string
-	f, err := p.eatIdentifier()
-	if err != nil {
-		return nil, err
+func (p Parser) orderBy() ([]SortKey, error) {
+	p.eatTokenType(TokenOrderBy)
+
+	return p.sortList()
+}
+
+// <SortList> := <QualifiedField> [ ASC | DESC ] [, <SortList> ]
+func (p Parser) sortList() ([]SortKey, error) {
+	var keys []SortKey
+	for {
+		f, err := p.qualifiedField()
+		if err != nil {
+			return nil, err
+		}
+
+		key := SortKey{Field: f}
+
+		if p.matchTokenType(TokenAsc) {
+			p.eatTokenType(TokenAsc)
+		} else if p.matchTokenType(TokenDesc) {
+			p.eatTokenType(TokenDesc)
+			key.Desc = true
+		}
+
+		keys = append(keys, key)
+
+		if !p.matchTokenType(TokenComma) {
+			return keys, nil
+		}
+
+		p.eatTokenType(TokenComma)
 	}
+}
+
+// <SelectList> := <SelectItem> [, <SelectItem> ... ]
+// <SelectItem> := <Expression> [ AS TokenIdentifier ]
+// selectList returns the expressions of the list and their aliases.
+// Expressions without an alias have an empty one.
+func (p Parser) selectList() ([]Expression, []string, error) {
+	var sl []Expression
+	var aliases []string
+	for {
+		f, err := p.expression()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var alias string
+		if p.matchTokenType(TokenAs) {
+			p.eatTokenType(TokenAs)
+
+			alias, err = p.eatIdentifier()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		sl = append(sl, f)
+		aliases = append(aliases, alias)
+
+		if !p.matchTokenType(TokenComma) {
+			return sl, aliases, nil
+		}
 
-	tl = append(tl, f)
-	if !p.matchTokenType(TokenComma) {
-		return tl, nil
+		p.eatTokenType(TokenComma)
 	}
+}
+
+// <TableList> := <JoinedTable> [, <TableList> ]
+// <JoinedTable> := <TableRef> [ <JoinType> <TableRef> ON <Predicate> ... ]
+// tableList returns the tables of the list and how each one is joined to the tables on its left.
+func (p Parser) tableList() ([]TableRef, []Join, error) {
+	var tl []TableRef
+	var joins []Join
+	for {
+		ref, err := p.tableRef()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tl = append(tl, ref)
+		joins = append(joins, Join{})
+
+		for {
+			kind, ok, err := p.joinKind()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if !ok {
+				break
+			}
+
+			ref, err := p.tableRef()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			on, err := p.joinOn()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			tl = append(tl, ref)
+			joins = append(joins, Join{Kind: kind, On: on})
+		}
+
+		if !p.matchTokenType(TokenComma) {
+			return tl, joins, nil
+		}
 
-	if err := p.eatTokenType(TokenComma); err != nil {
-		return nil, err
+		if err := p.eatTokenType(TokenComma); err != nil {
+			return nil, nil, err
+		}
 	}
+}
 
-	other, err := p.tableList()
+// <TableRef> := TokenIdentifier [ [ AS ] TokenIdentifier ]
+func (p Parser) tableRef() (TableRef, error) {
+	name, err := p.eatIdentifier()
 	if err != nil {
-		return nil, err
+		return TableRef{}, err
+	}
+
+	ref := TableRef{Name: name}
+	if p.matchTokenType(TokenAs) {
+		p.eatTokenType(TokenAs)
+
+		if ref.Alias, err = p.eatIdentifier(); err != nil {
+			return TableRef{}, err
+		}
+	} else if p.matchIdentifier() {
+		if ref.Alias, err = p.eatIdentifier(); err != nil {
+			return TableRef{}, err
+		}
 	}
 
-	tl = append(tl, other...)
-	return tl, nil
+	return ref, nil
 }
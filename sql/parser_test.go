@@ -7,6 +7,18 @@ import (
 	"github.com/luigitni/simpledb/storage"
 )
 
+// conjunctTerms returns the terms ANDed together at the root of the predicate.
+func conjunctTerms(p Predicate) []Term {
+	var terms []Term
+	for _, c := range p.conjuncts() {
+		if c.op == boolTerm {
+			terms = append(terms, c.term)
+		}
+	}
+
+	return terms
+}
+
 func TestParseField(t *testing.T) {
 	p := NewParser("field")
 
@@ -23,7 +35,7 @@ func TestFieldList(t *testing.T) {
 	const src = "first, second, third"
 	p := NewParser(src)
 
-	sl, err := p.selectList()
+	sl, _, err := p.selectList()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -34,12 +46,178 @@ func TestFieldList(t *testing.T) {
 
 	exp := []string{"first", "second", "third"}
 	for i := range sl {
-		if sl[i] != exp[i] {
+		if sl[i].AsFieldName() != exp[i] {
 			t.Fatalf("expected %q got %q at position %d", exp[i], sl[i], i)
 		}
 	}
 }
 
+func TestQualifiedFields(t *testing.T) {
+	const src = "SELECT u.name, total FROM users u, orders AS o, items WHERE u.id = o.user_id GROUP BY u.name ORDER BY u.name DESC"
+
+	qd, err := NewParser(src).Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []TableRef{{Name: "users", Alias: "u"}, {Name: "orders", Alias: "o"}, {Name: "items"}}
+	if !slices.Equal(qd.TableRefs(), exp) {
+		t.Fatalf("expected tables %v, got %v", exp, qd.TableRefs())
+	}
+
+	if !slices.Equal(qd.Tables(), []string{"users", "orders", "items"}) {
+		t.Fatalf("unexpected table names %v", qd.Tables())
+	}
+
+	if !slices.Equal(qd.Fields(), []string{"u.name", "total"}) {
+		t.Fatalf("unexpected fields %v", qd.Fields())
+	}
+
+	if !slices.Equal(qd.GroupByFields(), []string{"u.name"}) {
+		t.Fatalf("unexpected group by fields %v", qd.GroupByFields())
+	}
+
+	if s := qd.String(); s != "SELECT u.name, total FROM users u, orders o, items WHERE u.id = o.user_id GROUP BY u.name ORDER BY u.name DESC" {
+		t.Fatalf("unexpected query %q", s)
+	}
+
+	for _, src := range []string{
+		"SELECT u. FROM users u",
+		"SELECT a FROM users AS",
+		"SELECT a FROM users AS WHERE a = 1",
+	} {
+		if _, err := NewParser(src).Query(); err != ErrInvalidSyntax {
+			t.Fatalf("expected %v for %q, got %v", ErrInvalidSyntax, src, err)
+		}
+	}
+}
+
+func TestJoinQuery(t *testing.T) {
+	const src = "SELECT c.name, o.id FROM customers c LEFT OUTER JOIN orders o ON c.id = o.customer_id INNER JOIN items i ON i.order_id = o.id, shops RIGHT JOIN towns ON shops.town = towns.id FULL JOIN regions r ON r.id = towns.region WHERE c.id > 1"
+
+	qd, err := NewParser(src).Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(qd.Tables(), []string{"customers", "orders", "items", "shops", "towns", "regions"}) {
+		t.Fatalf("unexpected tables %v", qd.Tables())
+	}
+
+	var kinds []JoinKind
+	var ons []string
+	for _, j := range qd.Joins() {
+		kinds = append(kinds, j.Kind)
+		ons = append(ons, j.On.String())
+	}
+
+	if exp := []JoinKind{JoinNone, JoinLeft, JoinInner, JoinNone, JoinRight, JoinFull}; !slices.Equal(kinds, exp) {
+		t.Fatalf("expected join kinds %v, got %v", exp, kinds)
+	}
+
+	if exp := []string{"", "c.id = o.customer_id", "i.order_id = o.id", "", "shops.town = towns.id", "r.id = towns.region"}; !slices.Equal(ons, exp) {
+		t.Fatalf("expected join predicates %q, got %q", exp, ons)
+	}
+
+	if !qd.HasOuterJoin() {
+		t.Fatal("expected query to have outer joins")
+	}
+
+	const exp = "SELECT c.name, o.id FROM customers c LEFT JOIN orders o ON c.id = o.customer_id JOIN items i ON i.order_id = o.id, shops RIGHT JOIN towns ON shops.town = towns.id FULL OUTER JOIN regions r ON r.id = towns.region WHERE c.id > 1"
+	if s := qd.String(); s != exp {
+		t.Fatalf("expected %q, got %q", exp, s)
+	}
+
+	qd, err = NewParser("SELECT a FROM t JOIN u ON t.id = u.id").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if qd.HasOuterJoin() {
+		t.Fatal("expected query not to have outer joins")
+	}
+
+	for _, src := range []string{
+		"SELECT a FROM t JOIN u",
+		"SELECT a FROM t LEFT u ON t.id = u.id",
+		"SELECT a FROM t INNER OUTER JOIN u ON t.id = u.id",
+		"SELECT a FROM t JOIN ON t.id = u.id",
+	} {
+		if _, err := NewParser(src).Query(); err != ErrInvalidSyntax {
+			t.Fatalf("expected %v for %q, got %v", ErrInvalidSyntax, src, err)
+		}
+	}
+
+	if _, err := NewParser("SELECT a FROM t JOIN u ON count(*) > 1").Query(); err != ErrMisplacedAggregate {
+		t.Fatalf("expected %v, got %v", ErrMisplacedAggregate, err)
+	}
+}
+
+func TestQualifyQuery(t *testing.T) {
+	qd, err := NewParser("SELECT name, id + 1, count(*) FROM users WHERE id > 1 GROUP BY name, id HAVING max(id) > 2 ORDER BY name").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qd, err = qd.Qualify(func(f string) (string, error) {
+		if f == "missing" {
+			return "", ErrInvalidSyntax
+		}
+
+		return "users." + f, nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const exp = "SELECT users.name AS name, users.id + 1 AS id + 1, count(*) FROM users WHERE users.id > 1 GROUP BY users.name, users.id HAVING max(users.id) > 2 ORDER BY name"
+	if s := qd.String(); s != exp {
+		t.Fatalf("expected %q, got %q", exp, s)
+	}
+
+	if !slices.Equal(qd.Fields(), []string{"name", "id + 1", "count(*)"}) {
+		t.Fatalf("unexpected fields %v", qd.Fields())
+	}
+}
+
+func TestSelectListAliases(t *testing.T) {
+	qd, err := NewParser("SELECT id AS key, price * qty AS total, name FROM items").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(qd.Fields(), []string{"key", "total", "name"}) {
+		t.Fatalf("unexpected fields %v", qd.Fields())
+	}
+
+	if s := qd.String(); s != "SELECT id AS key, price * qty AS total, name FROM items" {
+		t.Fatalf("unexpected query %q", s)
+	}
+
+	qd, err = NewParser("SELECT * FROM items WHERE id = 1").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !qd.IsStar() || len(qd.Expressions()) != 0 {
+		t.Fatalf("expected a SELECT * query, got %q", qd.String())
+	}
+
+	if s := qd.String(); s != "SELECT * FROM items WHERE id = 1" {
+		t.Fatalf("unexpected query %q", s)
+	}
+
+	for _, src := range []string{
+		"SELECT id AS FROM items",
+		"SELECT *, id FROM items",
+	} {
+		if _, err := NewParser(src).Query(); err != ErrInvalidSyntax {
+			t.Fatalf("expected %v for %q, got %v", ErrInvalidSyntax, src, err)
+		}
+	}
+}
+
 func TestConstant(t *testing.T) {
 	type test struct {
 		src string
@@ -58,11 +236,15 @@ func TestConstant(t *testing.T) {
 	} {
 		p := NewParser(v.src)
 
-		c, err := p.constant()
+		typ, c, err := p.constant()
 		if err != nil {
 			t.Fatal(err)
 		}
 
+		if typ != storage.TEXT {
+			t.Fatalf("expected %s, got %s", storage.TEXT, typ)
+		}
+
 		if s := c.AsVarlen().AsGoString(); s != v.exp {
 			t.Fatalf("expected %q, got %s", v.exp, s)
 		}
@@ -86,31 +268,560 @@ func TestQuery(t *testing.T) {
 		t.Fatalf("unexpected fields %v", qd.Fields())
 	}
 
-	predicate := qd.Predicate()
-	if len(predicate.terms) != 2 {
-		t.Fatalf("expected 2 terms, got %d", len(predicate.terms))
-	}
+	predicate := qd.Predicate()
+	if len(conjunctTerms(predicate)) != 2 {
+		t.Fatalf("expected 2 terms, got %d", len(conjunctTerms(predicate)))
+	}
+
+	feq1 := conjunctTerms(predicate)[0]
+	if feq1.lhs.fname != "first" {
+		t.Fatalf("expected field to be %q, got %q", "first", feq1.lhs.fname)
+	}
+
+	if got := feq1.rhs.AsConstant().AsFixedLen().AsInt(); got != 1 {
+		t.Fatalf("expected value to be %d, got %d", 1, got)
+	}
+
+	feq2 := conjunctTerms(predicate)[1]
+	if feq2.lhs.fname != "second" {
+		t.Fatalf("expected field to be %q, got %q", "second", feq2.lhs.fname)
+	}
+
+	if got := feq2.rhs.AsConstant().AsVarlen().AsGoString(); got != "second" {
+		t.Fatalf("expected value to be %q, got %q", "second", got)
+	}
+
+	if !slices.Equal(qd.OrderByFields(), []string{"second"}) {
+		t.Fatalf("unexpected order by fields %v", qd.OrderByFields())
+	}
+}
+
+func TestAggregateQuery(t *testing.T) {
+	const src = "SELECT dept, COUNT(*), SUM(price * qty) FROM sales WHERE id > 1 GROUP BY dept HAVING COUNT(*) > 1 AND MAX(qty) < 10 ORDER BY dept"
+
+	qd, err := NewParser(src).Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(qd.Fields(), []string{"dept", "count(*)", "sum(price * qty)"}) {
+		t.Fatalf("unexpected fields %v", qd.Fields())
+	}
+
+	if !slices.Equal(qd.GroupByFields(), []string{"dept"}) {
+		t.Fatalf("unexpected group by fields %v", qd.GroupByFields())
+	}
+
+	var aggs []string
+	for _, a := range qd.Aggregates() {
+		aggs = append(aggs, a.String())
+	}
+
+	if !slices.Equal(aggs, []string{"count(*)", "sum(price * qty)", "max(qty)"}) {
+		t.Fatalf("unexpected aggregates %v", aggs)
+	}
+
+	if s := qd.Having().String(); s != "count(*) > 1 AND max(qty) < 10" {
+		t.Fatalf("unexpected having %q", s)
+	}
+
+	if !qd.IsGrouped() {
+		t.Fatal("expected query to be grouped")
+	}
+
+	// aggregate function names are not keywords
+	qd, err = NewParser("SELECT count FROM sales").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if qd.IsGrouped() {
+		t.Fatal("expected query not to be grouped")
+	}
+
+	for _, src := range []string{
+		"SELECT SUM(MAX(a)) FROM sales",
+		"SELECT SUM(*) FROM sales",
+	} {
+		if _, err := NewParser(src).Query(); err != ErrInvalidSyntax {
+			t.Fatalf("expected %v for %q, got %v", ErrInvalidSyntax, src, err)
+		}
+	}
+
+	if _, err := NewParser("SELECT a FROM sales WHERE COUNT(*) > 1").Query(); err != ErrMisplacedAggregate {
+		t.Fatalf("expected %v, got %v", ErrMisplacedAggregate, err)
+	}
+}
+
+func TestDistinctQuery(t *testing.T) {
+	qd, err := NewParser("SELECT DISTINCT dept, qty FROM sales ORDER BY dept").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !qd.IsDistinct() {
+		t.Fatal("expected query to be distinct")
+	}
+
+	if !slices.Equal(qd.Fields(), []string{"dept", "qty"}) {
+		t.Fatalf("unexpected fields %v", qd.Fields())
+	}
+
+	if s := qd.String(); s != "SELECT DISTINCT dept, qty FROM sales ORDER BY dept" {
+		t.Fatalf("unexpected query %q", s)
+	}
+
+	qd, err = NewParser("SELECT dept FROM sales").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if qd.IsDistinct() {
+		t.Fatal("expected query not to be distinct")
+	}
+}
+
+func TestOrderByDirection(t *testing.T) {
+	qd, err := NewParser("SELECT a, b, c FROM t ORDER BY a DESC, b ASC, c").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []SortKey{{Field: "a", Desc: true}, {Field: "b"}, {Field: "c"}}
+	if !slices.Equal(qd.OrderBy(), exp) {
+		t.Fatalf("expected sort keys %v, got %v", exp, qd.OrderBy())
+	}
+
+	if s := qd.String(); s != "SELECT a, b, c FROM t ORDER BY a DESC, b, c" {
+		t.Fatalf("unexpected query %q", s)
+	}
+
+	if _, err := NewParser("SELECT a FROM t ORDER BY DESC").Query(); err != ErrInvalidSyntax {
+		t.Fatalf("expected %v, got %v", ErrInvalidSyntax, err)
+	}
+}
+
+func TestLimitQuery(t *testing.T) {
+	for _, tc := range []struct {
+		src    string
+		limit  int
+		ok     bool
+		offset int
+	}{
+		{src: "SELECT a FROM t", limit: -1},
+		{src: "SELECT a FROM t LIMIT 10", limit: 10, ok: true},
+		{src: "SELECT a FROM t ORDER BY a LIMIT 10 OFFSET 5", limit: 10, ok: true, offset: 5},
+		{src: "SELECT a FROM t LIMIT 0", limit: 0, ok: true},
+	} {
+		qd, err := NewParser(tc.src).Query()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		limit, ok := qd.Limit()
+		if limit != tc.limit || ok != tc.ok || qd.Offset() != tc.offset {
+			t.Fatalf("unexpected limit %d, %t, offset %d for %q", limit, ok, qd.Offset(), tc.src)
+		}
+
+		if s := qd.String(); s != tc.src {
+			t.Fatalf("unexpected query %q", s)
+		}
+	}
+
+	for _, src := range []string{
+		"SELECT a FROM t LIMIT",
+		"SELECT a FROM t LIMIT a",
+		"SELECT a FROM t LIMIT 1 OFFSET",
+	} {
+		if _, err := NewParser(src).Query(); err != ErrInvalidSyntax {
+			t.Fatalf("expected %v for %q, got %v", ErrInvalidSyntax, src, err)
+		}
+	}
+}
+
+func TestTermOperators(t *testing.T) {
+	type test struct {
+		src string
+		op  compareOp
+	}
+
+	for _, tc := range []test{
+		{src: "a = 1", op: opEqual},
+		{src: "a <> 1", op: opNotEqual},
+		{src: "a != 1", op: opNotEqual},
+		{src: "a < 1", op: opLess},
+		{src: "a <= 1", op: opLessEqual},
+		{src: "a > 1", op: opGreater},
+		{src: "a >= 1", op: opGreaterEqual},
+		{src: "a IS NULL", op: opIsNull},
+		{src: "a IS NOT NULL", op: opIsNotNull},
+		{src: "a IN (SELECT b FROM t)", op: opIn},
+		{src: "a NOT IN (SELECT b FROM t)", op: opNotIn},
+	} {
+		p := NewParser(tc.src)
+
+		term, err := p.term()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if term.op != tc.op {
+			t.Fatalf("expected operator %s for %q, got %s", tc.op, tc.src, term.op)
+		}
+
+		if term.lhs.fname != "a" {
+			t.Fatalf("expected field to be %q, got %q", "a", term.lhs.fname)
+		}
+	}
+}
+
+func TestSubqueryQuery(t *testing.T) {
+	for _, src := range []string{
+		"SELECT a FROM t WHERE a IN (SELECT b FROM u WHERE c = 1)",
+		"SELECT a FROM t WHERE a NOT IN (SELECT b FROM u) AND d > 2",
+		"SELECT a FROM t WHERE EXISTS (SELECT * FROM u WHERE u.b = t.a)",
+		"SELECT a FROM t WHERE NOT EXISTS (SELECT b FROM u WHERE u.b = t.a) OR a = 1",
+		"SELECT a, (SELECT max(b) FROM u) AS m FROM t",
+		"SELECT a FROM t WHERE a > (SELECT min(b) FROM u WHERE u.c = t.c) + 1",
+	} {
+		qd, err := NewParser(src).Query()
+		if err != nil {
+			t.Fatalf("%q: %s", src, err)
+		}
+
+		if s := qd.String(); s != src {
+			t.Fatalf("expected %q, got %q", src, s)
+		}
+	}
+
+	qd, err := NewParser("SELECT a FROM t WHERE EXISTS (SELECT b FROM u) AND a IN (SELECT c FROM v)").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bound []string
+	qd, err = qd.BindSubqueries(func(sq Subquery) (Subquery, error) {
+		bound = append(bound, sq.Query().Tables()[0])
+		return sq.Bind(nil, []string{"t.a"}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(bound, []string{"u", "v"}) {
+		t.Fatalf("expected subqueries on u and v to be bound, got %v", bound)
+	}
+
+	for _, c := range qd.Predicate().Conjuncts() {
+		if !slices.Equal(c.term.lhs.Fields(), []string{"t.a"}) && !slices.Equal(c.term.rhs.Fields(), []string{"t.a"}) {
+			t.Fatalf("expected %s to refer to the outer field t.a", c)
+		}
+	}
+
+	for _, src := range []string{
+		"SELECT a FROM t WHERE a IN (b)",
+		"SELECT a FROM t WHERE EXISTS b",
+		"SELECT a FROM t WHERE a IN (SELECT b FROM u",
+	} {
+		if _, err := NewParser(src).Query(); err == nil {
+			t.Fatalf("expected %q to fail", src)
+		}
+	}
+}
+
+func TestSetOperationQuery(t *testing.T) {
+	for _, src := range []string{
+		"SELECT a FROM t UNION SELECT b FROM u",
+		"SELECT a FROM t UNION ALL SELECT b FROM u WHERE b > 1",
+		"SELECT a FROM t EXCEPT SELECT b FROM u INTERSECT ALL SELECT c FROM v",
+		"SELECT * FROM t INTERSECT SELECT * FROM u EXCEPT ALL SELECT * FROM v ORDER BY a DESC LIMIT 2",
+	} {
+		qd, err := NewParser(src).Query()
+		if err != nil {
+			t.Fatalf("%q: %s", src, err)
+		}
+
+		if s := qd.String(); s != src {
+			t.Fatalf("expected %q, got %q", src, s)
+		}
+	}
+
+	// INTERSECT binds more tightly than EXCEPT
+	qd, err := NewParser("SELECT a FROM t EXCEPT SELECT b FROM u INTERSECT ALL SELECT c FROM v ORDER BY a").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	so, ok := qd.SetOperation()
+	if !ok || so.Op != SetExcept || so.All {
+		t.Fatalf("expected an EXCEPT, got %v", so)
+	}
+
+	if _, ok := so.Lhs.SetOperation(); ok {
+		t.Fatalf("expected the left hand side to be a SELECT, got %s", so.Lhs)
+	}
+
+	rhs, ok := so.Rhs.SetOperation()
+	if !ok || rhs.Op != SetIntersect || !rhs.All {
+		t.Fatalf("expected the right hand side to be an INTERSECT ALL, got %s", so.Rhs)
+	}
+
+	// the ORDER BY clause of the last query applies to the whole set operation
+	if !slices.Equal(qd.OrderBy(), []SortKey{{Field: "a"}}) {
+		t.Fatalf("unexpected sort keys %v", qd.OrderBy())
+	}
+
+	if len(rhs.Rhs.OrderBy()) > 0 {
+		t.Fatalf("unexpected sort keys %v on the last query", rhs.Rhs.OrderBy())
+	}
+
+	for _, src := range []string{
+		"SELECT a FROM t ORDER BY a UNION SELECT b FROM u",
+		"SELECT a FROM t LIMIT 1 INTERSECT SELECT b FROM u",
+		"SELECT a FROM t UNION",
+		"SELECT a FROM t UNION ALL ALL SELECT b FROM u",
+	} {
+		if _, err := NewParser(src).Query(); err == nil {
+			t.Fatalf("expected %q to fail", src)
+		}
+	}
+}
+
+func TestCTEQuery(t *testing.T) {
+	for _, src := range []string{
+		"WITH c AS (SELECT a FROM t) SELECT a FROM c",
+		"WITH c (x, y) AS (SELECT a, b FROM t WHERE a > 1), d AS (SELECT x FROM c) SELECT * FROM c, d",
+		"WITH RECURSIVE r (n) AS (SELECT a FROM t UNION ALL SELECT n FROM r WHERE n < 3) SELECT n FROM r ORDER BY n",
+		"WITH c AS (SELECT a FROM t) SELECT a FROM c UNION SELECT a FROM c",
+		"SELECT a FROM t WHERE a IN (WITH c AS (SELECT b FROM u) SELECT b FROM c)",
+	} {
+		qd, err := NewParser(src).Query()
+		if err != nil {
+			t.Fatalf("%q: %s", src, err)
+		}
+
+		if s := qd.String(); s != src {
+			t.Fatalf("expected %q, got %q", src, s)
+		}
+	}
+
+	qd, err := NewParser("WITH RECURSIVE r (n) AS (SELECT a FROM t), s AS (SELECT n FROM r) SELECT n FROM s").Query()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctes := qd.CTEs()
+	if len(ctes) != 2 {
+		t.Fatalf("expected 2 common table expressions, got %d", len(ctes))
+	}
+
+	if ctes[0].Name != "r" || !slices.Equal(ctes[0].Columns, []string{"n"}) || !ctes[0].Recursive {
+		t.Fatalf("unexpected common table expression %v", ctes[0])
+	}
+
+	if ctes[1].Name != "s" || len(ctes[1].Columns) > 0 || !ctes[1].Recursive {
+		t.Fatalf("unexpected common table expression %v", ctes[1])
+	}
+
+	if !slices.Equal(qd.Tables(), []string{"s"}) {
+		t.Fatalf("unexpected tables %v", qd.Tables())
+	}
+
+	for _, src := range []string{
+		"WITH SELECT a FROM t",
+		"WITH c AS SELECT a FROM t SELECT a FROM c",
+		"WITH c () AS (SELECT a FROM t) SELECT a FROM c",
+		"WITH c AS (SELECT a FROM t)",
+	} {
+		if _, err := NewParser(src).Query(); err == nil {
+			t.Fatalf("expected %q to fail", src)
+		}
+	}
+}
+
+func TestPredicateTree(t *testing.T) {
+	const src = "a = 1 OR (b = 2 AND NOT c = 3)"
+
+	pred, err := NewParser(src).predicate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pred.op != boolOr {
+		t.Fatalf("expected an OR predicate, got %d", pred.op)
+	}
+
+	and := pred.operands[1]
+	if and.op != boolAnd || len(and.operands) != 2 {
+		t.Fatalf("expected an AND predicate with 2 operands, got %+v", and)
+	}
+
+	if not := and.operands[1]; not.op != boolNot || not.operands[0].term.lhs.fname != "c" {
+		t.Fatalf("expected NOT c = 3, got %s", not)
+	}
+
+	if s := pred.String(); s != "a = 1 OR (b = 2 AND NOT c = 3)" {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	scan := testScan{
+		"a": intField(0),
+		"b": intField(2),
+		"c": intField(4),
+	}
+
+	ok, err := pred.IsSatisfied(scan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatalf("expected %q to be satisfied", src)
+	}
+
+	scan["c"] = intField(3)
+
+	ok, err = pred.IsSatisfied(scan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok {
+		t.Fatalf("expected %q not to be satisfied", src)
+	}
+}
+
+func TestSubPredicates(t *testing.T) {
+	pred, err := NewParser("a = 1 AND (a = 2 OR b = 3) AND b = c").predicate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, ok := pred.SelectSubPredicate(testSchema{"a": {}})
+	if !ok {
+		t.Fatal("expected a select sub predicate")
+	}
+
+	if s := sub.String(); s != "a = 1" {
+		t.Fatalf("unexpected select sub predicate %q", s)
+	}
+
+	first := testSchema{"a": {}, "b": {}}
+	second := testSchema{"c": {}}
+	joined := testSchema{"a": {}, "b": {}, "c": {}}
+
+	sub, ok = pred.JoinSubPredicate(joined, first, second)
+	if !ok {
+		t.Fatal("expected a join sub predicate")
+	}
+
+	if s := sub.String(); s != "b = c" {
+		t.Fatalf("unexpected join sub predicate %q", s)
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	type test struct {
+		src string
+		exp string
+	}
+
+	for _, tc := range []test{
+		{src: "a + b * c", exp: "a + b * c"},
+		{src: "(a + b) * c", exp: "(a + b) * c"},
+		{src: "a - b - c", exp: "a - b - c"},
+		{src: "a - (b - c)", exp: "a - (b - c)"},
+		{src: "-a % 3", exp: "-a % 3"},
+		{src: "-(a + 1)", exp: "-(a + 1)"},
+		{src: "name || 'suffix'", exp: "name || 'suffix'"},
+	} {
+		exp, err := NewParser(tc.src).expression()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s := exp.String(); s != tc.exp {
+			t.Fatalf("expected %q, got %q", tc.exp, s)
+		}
+	}
+}
+
+func TestEvaluateExpressions(t *testing.T) {
+	scan := testScan{
+		"price": intField(7),
+		"qty":   intField(3),
+		"small": {typ: storage.TINYINT, val: storage.ValueFromInteger[storage.TinyInt](storage.SizeOfTinyInt, 2)},
+		"name":  {typ: storage.TEXT, val: storage.ValueFromGoString("simple")},
+	}
+
+	type test struct {
+		src string
+		typ storage.FieldType
+		exp string
+	}
+
+	for _, tc := range []test{
+		{src: "price * qty", typ: storage.INT, exp: "21"},
+		{src: "price + qty * 2", typ: storage.INT, exp: "13"},
+		{src: "(price + qty) * 2", typ: storage.INT, exp: "20"},
+		{src: "price / qty", typ: storage.INT, exp: "2"},
+		{src: "price % qty", typ: storage.INT, exp: "1"},
+		{src: "small + small", typ: storage.TINYINT, exp: "4"},
+		{src: "small * price", typ: storage.INT, exp: "14"},
+		{src: "price - qty", typ: storage.INT, exp: "4"},
+		{src: "-(qty - qty)", typ: storage.INT, exp: "0"},
+		{src: "name || 'db'", typ: storage.TEXT, exp: "simpledb"},
+	} {
+		exp, err := NewParser(tc.src).expression()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		typ, err := exp.Type(scan)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if typ != tc.typ {
+			t.Fatalf("expected %q to be of type %s, got %s", tc.src, tc.typ, typ)
+		}
+
+		v, err := exp.Evaluate(scan)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	feq1 := predicate.terms[0]
-	if feq1.lhs.fname != "first" {
-		t.Fatalf("expected field to be %q, got %q", "first", feq1.lhs.fname)
+		if s := v.String(typ); s != tc.exp {
+			t.Fatalf("expected %q to be %s, got %s", tc.src, tc.exp, s)
+		}
 	}
 
-	if got := feq1.rhs.AsConstant().AsFixedLen().AsInt(); got != 1 {
-		t.Fatalf("expected value to be %d, got %d", 1, got)
-	}
+	for src, expErr := range map[string]error{
+		"price / 0":   ErrDivisionByZero,
+		"qty - price": ErrOutOfRange,
+		"-qty":        ErrOutOfRange,
+		"name + 1":    ErrInvalidOperandType,
+		"price || 1":  ErrInvalidOperandType,
+	} {
+		exp, err := NewParser(src).expression()
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	feq2 := predicate.terms[1]
-	if feq2.lhs.fname != "second" {
-		t.Fatalf("expected field to be %q, got %q", "second", feq2.lhs.fname)
+		if _, err := exp.Evaluate(scan); err != expErr {
+			t.Fatalf("expected %v for %q, got %v", expErr, src, err)
+		}
 	}
+}
 
-	if got := feq2.rhs.AsConstant().AsVarlen().AsGoString(); got != "second" {
-		t.Fatalf("expected value to be %q, got %q", "second", got)
+func TestParenthesizedTerm(t *testing.T) {
+	pred, err := NewParser("(a + 1) * 2 > b AND (a = 1 OR b = 2)").predicate()
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if !slices.Equal(qd.OrderByFields(), []string{"second"}) {
-		t.Fatalf("unexpected order by fields %v", qd.OrderByFields())
+	if s := pred.String(); s != "(a + 1) * 2 > b AND (a = 1 OR b = 2)" {
+		t.Fatalf("unexpected predicate %q", s)
 	}
 }
 
@@ -136,7 +847,7 @@ func TestUpdateCommandSimple(t *testing.T) {
 		t.Fatalf("expected field to be %q, got %s", "col", field.Field)
 	}
 
-	if v := field.NewValue.String(storage.INT); v != "5" {
+	if v := field.NewValue.String(); v != "5" {
 		t.Fatalf("expected newValue to be %q, got %s", "5", field.Field)
 	}
 }
@@ -167,7 +878,7 @@ func TestUpdateCommandPredicate(t *testing.T) {
 		t.Fatalf("expected newValue to be %d, got %d", 5, v)
 	}
 
-	aeq3 := upd.Predicate.terms
+	aeq3 := conjunctTerms(upd.Predicate)
 	if len(aeq3) != 1 {
 		t.Fatalf("expected 1 term, got %d", len(aeq3))
 	}
@@ -233,7 +944,7 @@ func TestUpdateCommandMultipleFields(t *testing.T) {
 		}
 	}
 
-	aeq3 := upd.Predicate.terms
+	aeq3 := conjunctTerms(upd.Predicate)
 	if len(aeq3) != 1 {
 		t.Fatalf("expected 1 term, got %d", len(aeq3))
 	}
@@ -263,11 +974,11 @@ func TestDeleteCommand(t *testing.T) {
 		t.Fatalf("expected target table to be %q, got %q", "atable", del.TableName)
 	}
 
-	if len(del.Predicate.terms) != 1 {
-		t.Fatalf("expected 1 term, got %d", len(del.Predicate.terms))
+	if len(conjunctTerms(del.Predicate)) != 1 {
+		t.Fatalf("expected 1 term, got %d", len(conjunctTerms(del.Predicate)))
 	}
 
-	term := del.Predicate.terms[0]
+	term := conjunctTerms(del.Predicate)[0]
 	if term.lhs.fname != "acol" {
 		t.Fatalf("expected field to be %q, got %q", "acol", term.lhs.fname)
 	}
@@ -299,15 +1010,112 @@ func TestInsertCommand(t *testing.T) {
 		}
 	}
 
-	if v := ins.Values[0].AsVarlen().AsGoString(); v != "aval" {
+	if v := ins.Rows[0][0].AsConstant().AsVarlen().AsGoString(); v != "aval" {
 		t.Fatalf("expected value to be %q, got %q", "aval", v)
 	}
 
-	if v := ins.Values[1]; storage.ValueAsInteger[storage.Int](v) != 5 {
+	if v := ins.Rows[0][1].AsConstant(); storage.ValueAsInteger[storage.Int](v) != 5 {
 		t.Fatalf("expected value to be %d, got %d", 5, v)
 	}
 }
 
+func TestInsertCommandNull(t *testing.T) {
+	const src = "INSERT INTO atable (acolumn, anothercolumn) VALUES (NULL, 5)"
+
+	p := NewParser(src)
+
+	cmd, err := p.dml()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ins := cmd.(InsertCommand)
+
+	if v := ins.Rows[0][0].AsConstant(); !v.IsNull() {
+		t.Fatalf("expected value to be NULL, got %v", v)
+	}
+
+	if v := ins.Rows[0][1].AsConstant(); v.IsNull() {
+		t.Fatal("expected value not to be NULL")
+	}
+}
+
+func TestInsertCommandRows(t *testing.T) {
+	const src = "INSERT INTO atable VALUES (1, 'a'), (2, NULL), (3, 'c')"
+
+	cmd, err := NewParser(src).dml()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ins := cmd.(InsertCommand)
+
+	if len(ins.Fields) > 0 {
+		t.Fatalf("expected no fields, got %v", ins.Fields)
+	}
+
+	if ins.Query != nil {
+		t.Fatalf("unexpected query %s", ins.Query)
+	}
+
+	if len(ins.Rows) != 3 {
+		t.Fatalf("expected 3 rows, got %d", len(ins.Rows))
+	}
+
+	for i, row := range ins.Rows {
+		if len(row) != 2 {
+			t.Fatalf("expected 2 values in row %d, got %d", i, len(row))
+		}
+
+		if v := row[0].AsConstant(); storage.ValueAsInteger[storage.Int](v) != storage.Int(i+1) {
+			t.Fatalf("expected value to be %d, got %v", i+1, v)
+		}
+	}
+
+	if v := ins.Rows[1][1].AsConstant(); !v.IsNull() {
+		t.Fatalf("expected value to be NULL, got %v", v)
+	}
+
+	for _, src := range []string{
+		"INSERT INTO atable VALUES (1), ",
+		"INSERT INTO atable (a) VALUES",
+	} {
+		if _, err := NewParser(src).dml(); err == nil {
+			t.Fatalf("expected %q to fail", src)
+		}
+	}
+}
+
+func TestInsertQueryCommand(t *testing.T) {
+	for _, tc := range []struct {
+		src    string
+		fields []string
+		query  string
+	}{
+		{"INSERT INTO atable SELECT a, b FROM other WHERE a > 1", nil, "SELECT a, b FROM other WHERE a > 1"},
+		{"INSERT INTO atable (x) SELECT a FROM other UNION SELECT b FROM other", []string{"x"}, "SELECT a FROM other UNION SELECT b FROM other"},
+		{"INSERT INTO atable WITH c AS (SELECT a FROM other) SELECT a FROM c", nil, "WITH c AS (SELECT a FROM other) SELECT a FROM c"},
+	} {
+		cmd, err := NewParser(tc.src).dml()
+		if err != nil {
+			t.Fatalf("%q: %s", tc.src, err)
+		}
+
+		ins := cmd.(InsertCommand)
+		if !slices.Equal(ins.Fields, tc.fields) {
+			t.Fatalf("expected fields %v, got %v", tc.fields, ins.Fields)
+		}
+
+		if ins.Query == nil || ins.Query.String() != tc.query {
+			t.Fatalf("expected query %q, got %v", tc.query, ins.Query)
+		}
+
+		if len(ins.Rows) > 0 {
+			t.Fatalf("unexpected rows %v", ins.Rows)
+		}
+	}
+}
+
 func TestCreateTableCommand(t *testing.T) {
 	const src = "CREATE TABLE atable (name TEXT, age INT)"
 
@@ -348,6 +1156,300 @@ func TestCreateTableCommand(t *testing.T) {
 	}
 }
 
+func TestCreateTableKeys(t *testing.T) {
+	for _, tc := range []struct {
+		src    string
+		fields []string
+		keys   []KeyConstraint
+	}{
+		{
+			src:    "CREATE TABLE atable (id INT PRIMARY KEY, name TEXT UNIQUE)",
+			fields: []string{"id", "name"},
+			keys: []KeyConstraint{
+				{Primary: true, Fields: []string{"id"}},
+				{Fields: []string{"name"}},
+			},
+		},
+		{
+			src:    "CREATE TABLE atable (id INT, name TEXT, PRIMARY KEY (id), UNIQUE (name, id))",
+			fields: []string{"id", "name"},
+			keys: []KeyConstraint{
+				{Primary: true, Fields: []string{"id"}},
+				{Fields: []string{"name", "id"}},
+			},
+		},
+		{
+			src:    "CREATE TABLE atable (id INT UNIQUE PRIMARY KEY)",
+			fields: []string{"id"},
+			keys: []KeyConstraint{
+				{Fields: []string{"id"}},
+				{Primary: true, Fields: []string{"id"}},
+			},
+		},
+	} {
+		cmd, err := NewParser(tc.src).Parse()
+		if err != nil {
+			t.Fatalf("%q: %s", tc.src, err)
+		}
+
+		cr, ok := cmd.(CreateTableCommand)
+		if !ok {
+			t.Fatalf("%q: expected CreateTableCommand, got %T", tc.src, cmd)
+		}
+
+		var fields []string
+		for _, f := range cr.Fields {
+			fields = append(fields, f.Name)
+		}
+
+		if !slices.Equal(fields, tc.fields) {
+			t.Fatalf("%q: expected fields %v, got %v", tc.src, tc.fields, fields)
+		}
+
+		if len(cr.Keys) != len(tc.keys) {
+			t.Fatalf("%q: expected keys %+v, got %+v", tc.src, tc.keys, cr.Keys)
+		}
+
+		for i, k := range cr.Keys {
+			exp := tc.keys[i]
+			if k.Primary != exp.Primary || !slices.Equal(k.Fields, exp.Fields) {
+				t.Fatalf("%q: expected key %+v at %d, got %+v", tc.src, exp, i, k)
+			}
+		}
+	}
+
+	for _, src := range []string{
+		"CREATE TABLE atable (id INT PRIMARY)",
+		"CREATE TABLE atable (id INT, UNIQUE id)",
+		"CREATE TABLE atable (id INT, PRIMARY KEY ())",
+	} {
+		if _, err := NewParser(src).Parse(); err == nil {
+			t.Fatalf("%q: expected an error", src)
+		}
+	}
+}
+
+func TestColumnConstraints(t *testing.T) {
+	const src = "CREATE TABLE items (name TEXT NOT NULL, status INT DEFAULT 1 + 1, qty INT NOT NULL DEFAULT 0 CHECK (qty >= 0) CHECK (qty < 100), note TEXT DEFAULT 'none')"
+
+	cmd, err := NewParser(src).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr := cmd.(CreateTableCommand)
+
+	type constraints struct {
+		notNull bool
+		def     string
+		check   string
+	}
+
+	exp := []constraints{
+		{notNull: true},
+		{def: "1 + 1"},
+		{notNull: true, def: "0", check: "qty >= 0 AND qty < 100"},
+		{def: "'none'"},
+	}
+
+	if len(cr.Fields) != len(exp) {
+		t.Fatalf("expected %d fields, got %d", len(exp), len(cr.Fields))
+	}
+
+	for i, f := range cr.Fields {
+		var got constraints
+		got.notNull = f.NotNull
+		if f.Default != nil {
+			got.def = f.Default.String()
+		}
+
+		if f.Check != nil {
+			got.check = f.Check.String()
+		}
+
+		if got != exp[i] {
+			t.Fatalf("expected constraints %+v for %q, got %+v", exp[i], f.Name, got)
+		}
+	}
+
+	alter, err := NewParser("ALTER TABLE items ADD COLUMN price INT NOT NULL DEFAULT 10").Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f := alter.(AlterTableCommand).Field; !f.NotNull || f.Default == nil || f.Default.String() != "10" {
+		t.Fatalf("unexpected added field %+v", f)
+	}
+
+	for _, src := range []string{
+		"CREATE TABLE items (qty INT DEFAULT other)",
+		"CREATE TABLE items (qty INT DEFAULT (SELECT a FROM t))",
+		"CREATE TABLE items (qty INT CHECK (qty IN (SELECT a FROM t)))",
+		"CREATE TABLE items (qty INT CHECK (count(*) > 0))",
+	} {
+		if _, err := NewParser(src).Parse(); err != ErrInvalidConstraint {
+			t.Fatalf("%q: expected %v, got %v", src, ErrInvalidConstraint, err)
+		}
+	}
+}
+
+func TestForeignKeys(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		exp []ForeignKey
+	}{
+		{
+			src: "CREATE TABLE orders (id INT, customer INT REFERENCES customers)",
+			exp: []ForeignKey{
+				{Fields: []string{"customer"}, RefTable: "customers"},
+			},
+		},
+		{
+			src: "CREATE TABLE orders (id INT, customer INT NOT NULL REFERENCES customers (id) ON DELETE CASCADE)",
+			exp: []ForeignKey{
+				{Fields: []string{"customer"}, RefTable: "customers", RefFields: []string{"id"}, OnDelete: ActionCascade},
+			},
+		},
+		{
+			src: "CREATE TABLE lines (a INT, b INT, FOREIGN KEY (a, b) REFERENCES orders (x, y) ON DELETE SET NULL, FOREIGN KEY (b) REFERENCES items ON DELETE RESTRICT)",
+			exp: []ForeignKey{
+				{Fields: []string{"a", "b"}, RefTable: "orders", RefFields: []string{"x", "y"}, OnDelete: ActionSetNull},
+				{Fields: []string{"b"}, RefTable: "items", OnDelete: ActionRestrict},
+			},
+		},
+	} {
+		cmd, err := NewParser(tc.src).Parse()
+		if err != nil {
+			t.Fatalf("%q: %s", tc.src, err)
+		}
+
+		fks := cmd.(CreateTableCommand).ForeignKeys
+		if len(fks) != len(tc.exp) {
+			t.Fatalf("%q: expected foreign keys %+v, got %+v", tc.src, tc.exp, fks)
+		}
+
+		for i, fk := range fks {
+			exp := tc.exp[i]
+			if !slices.Equal(fk.Fields, exp.Fields) ||
+				fk.RefTable != exp.RefTable ||
+				!slices.Equal(fk.RefFields, exp.RefFields) ||
+				fk.OnDelete != exp.OnDelete {
+				t.Fatalf("%q: expected foreign key %+v at %d, got %+v", tc.src, exp, i, fk)
+			}
+		}
+	}
+
+	for _, src := range []string{
+		"CREATE TABLE orders (customer INT REFERENCES)",
+		"CREATE TABLE orders (customer INT REFERENCES customers ON DELETE NOTHING)",
+		"CREATE TABLE orders (customer INT, FOREIGN (customer) REFERENCES customers)",
+		"ALTER TABLE orders ADD COLUMN customer INT REFERENCES customers",
+	} {
+		if _, err := NewParser(src).Parse(); err == nil {
+			t.Fatalf("%q: expected an error", src)
+		}
+	}
+}
+
+func TestDropCommands(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		exp Command
+	}{
+		{
+			src: "DROP TABLE atable",
+			exp: NewDropTableCommand("atable"),
+		},
+		{
+			src: "DROP INDEX anindex",
+			exp: NewDropIndexCommand("anindex"),
+		},
+		{
+			src: "DROP VIEW aview",
+			exp: NewDropViewCommand("aview"),
+		},
+		{
+			src: "DROP SEQUENCE aseq",
+			exp: NewDropSequenceCommand("aseq"),
+		},
+	} {
+		cmd, err := NewParser(tc.src).Parse()
+		if err != nil {
+			t.Fatalf("%q: %s", tc.src, err)
+		}
+
+		if cmd != tc.exp {
+			t.Fatalf("%q: expected %+v, got %+v", tc.src, tc.exp, cmd)
+		}
+
+		if cmd.Type() != CommandTypeDDL {
+			t.Fatalf("%q: expected a DDL command, got %v", tc.src, cmd.Type())
+		}
+	}
+
+	for _, src := range []string{"DROP atable", "DROP TABLE"} {
+		if _, err := NewParser(src).Parse(); err != ErrInvalidSyntax {
+			t.Fatalf("%q: expected %v, got %v", src, ErrInvalidSyntax, err)
+		}
+	}
+}
+
+func TestAlterTableCommand(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		exp AlterTableCommand
+	}{
+		{
+			src: "ALTER TABLE atable ADD COLUMN age INT",
+			exp: NewAddColumnCommand("atable", FieldDef{Name: "age", Type: storage.INT}),
+		},
+		{
+			src: "ALTER TABLE atable ADD name TEXT",
+			exp: NewAddColumnCommand("atable", FieldDef{Name: "name", Type: storage.TEXT}),
+		},
+		{
+			src: "ALTER TABLE atable DROP COLUMN age",
+			exp: NewDropColumnCommand("atable", "age"),
+		},
+		{
+			src: "ALTER TABLE atable DROP age",
+			exp: NewDropColumnCommand("atable", "age"),
+		},
+		{
+			src: "ALTER TABLE atable RENAME COLUMN age TO years",
+			exp: NewRenameColumnCommand("atable", "age", "years"),
+		},
+		{
+			src: "ALTER TABLE atable RENAME age TO years",
+			exp: NewRenameColumnCommand("atable", "age", "years"),
+		},
+		{
+			src: "ALTER TABLE atable RENAME TO another",
+			exp: NewRenameTableCommand("atable", "another"),
+		},
+	} {
+		cmd, err := NewParser(tc.src).Parse()
+		if err != nil {
+			t.Fatalf("%q: %s", tc.src, err)
+		}
+
+		if cmd != tc.exp {
+			t.Fatalf("%q: expected %+v, got %+v", tc.src, tc.exp, cmd)
+		}
+	}
+
+	for _, src := range []string{
+		"ALTER atable ADD age INT",
+		"ALTER TABLE atable ADD age",
+		"ALTER TABLE atable RENAME age years",
+		"ALTER TABLE atable MODIFY age INT",
+	} {
+		if _, err := NewParser(src).Parse(); err != ErrInvalidSyntax {
+			t.Fatalf("%q: expected %v, got %v", src, ErrInvalidSyntax, err)
+		}
+	}
+}
+
 func TestTCLCommands(t *testing.T) {
 	t.Parallel()
 	type test struct {
@@ -385,3 +1487,115 @@ func TestTCLCommands(t *testing.T) {
 		})
 	}
 }
+
+func TestSequences(t *testing.T) {
+	for _, tc := range []struct {
+		src string
+		exp CreateSequenceCommand
+	}{
+		{
+			src: "CREATE SEQUENCE ids",
+			exp: NewCreateSequenceCommand("ids", 1, 1),
+		},
+		{
+			src: "CREATE SEQUENCE ids START WITH 100 INCREMENT BY 10",
+			exp: NewCreateSequenceCommand("ids", 100, 10),
+		},
+		{
+			src: "CREATE SEQUENCE ids START 5 INCREMENT 2",
+			exp: NewCreateSequenceCommand("ids", 5, 2),
+		},
+	} {
+		cmd, err := NewParser(tc.src).Parse()
+		if err != nil {
+			t.Fatalf("%q: %s", tc.src, err)
+		}
+
+		if cmd != tc.exp {
+			t.Fatalf("%q: expected %+v, got %+v", tc.src, tc.exp, cmd)
+		}
+	}
+
+	for _, tc := range []struct {
+		src           string
+		autoIncrement []bool
+		defaults      []string
+	}{
+		{
+			src:           "CREATE TABLE items (id SERIAL, qty INT)",
+			autoIncrement: []bool{true, false},
+			defaults:      []string{"", ""},
+		},
+		{
+			src:           "CREATE TABLE items (id INT AUTO_INCREMENT PRIMARY KEY, code INT DEFAULT nextval('codes'))",
+			autoIncrement: []bool{true, false},
+			defaults:      []string{"", "nextval('codes')"},
+		},
+	} {
+		cmd, err := NewParser(tc.src).Parse()
+		if err != nil {
+			t.Fatalf("%q: %s", tc.src, err)
+		}
+
+		for i, f := range cmd.(CreateTableCommand).Fields {
+			if f.Type != storage.INT || f.AutoIncrement != tc.autoIncrement[i] {
+				t.Fatalf("%q: unexpected definition of field %q: %+v", tc.src, f.Name, f)
+			}
+
+			var def string
+			if f.Default != nil {
+				def = f.Default.String()
+			}
+
+			if def != tc.defaults[i] {
+				t.Fatalf("%q: expected default %q for field %q, got %q", tc.src, tc.defaults[i], f.Name, def)
+			}
+		}
+	}
+
+	cmd, err := NewParser("INSERT INTO items (id, name) VALUES (nextval('ids'), 'a')").Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := cmd.(InsertCommand).Rows[0][0]; exp.String() != "nextval('ids')" {
+		t.Fatalf("expected nextval('ids'), got %s", exp)
+	}
+
+	if _, err := cmd.(InsertCommand).Rows[0][0].Evaluate(nil); err != ErrUnboundSequence {
+		t.Fatalf("expected %v, got %v", ErrUnboundSequence, err)
+	}
+
+	for _, src := range []string{
+		"CREATE SEQUENCE",
+		"CREATE SEQUENCE ids INCREMENT BY 0",
+		"CREATE TABLE items (id TEXT AUTO_INCREMENT)",
+		"CREATE TABLE items (id SERIAL DEFAULT 1)",
+		"CREATE TABLE items (id INT CHECK (id > nextval('ids')))",
+		"DROP SEQUENCE",
+	} {
+		if _, err := NewParser(src).Parse(); err == nil {
+			t.Fatalf("%q: expected an error", src)
+		}
+	}
+
+	// values are only drawn for the records written by INSERT and UPDATE
+	for _, src := range []string{
+		"SELECT nextval('ids') FROM items",
+		"SELECT id FROM items WHERE id = nextval('ids')",
+		"SELECT id, count(name) FROM items GROUP BY id HAVING count(name) > nextval('ids')",
+		"SELECT a.id FROM items a JOIN items b ON a.id = nextval('ids')",
+		"SELECT id FROM items WHERE id IN (SELECT nextval('ids') FROM items)",
+		"INSERT INTO items (id) SELECT nextval('ids') FROM items",
+		"UPDATE items SET name = 'a' WHERE id = nextval('ids')",
+		"DELETE FROM items WHERE id = nextval('ids')",
+	} {
+		if _, err := NewParser(src).Parse(); err != ErrMisplacedNextval {
+			t.Fatalf("%q: expected %v, got %v", src, ErrMisplacedNextval, err)
+		}
+	}
+
+	if _, err := NewParser("UPDATE items SET id = nextval('ids') WHERE id > 10").Parse(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,96 +1,284 @@
 package sql
 
 import (
+	"math"
 	"strings"
 
 	"github.com/luigitni/simpledb/storage"
 )
 
+// boolOp is the boolean connective of a Predicate node.
+type boolOp byte
+
+const (
+	// boolAnd is the zero value, so that an empty Predicate
+	// is an empty conjunction, which is always satisfied.
+	boolAnd boolOp = iota
+	boolOr
+	boolNot
+	boolTerm
+)
+
 // Predicate specifies a condition that returns
 // true or false for each ROW of a given scan.
 // If the condition returns true, then
 // the row satisfies the predicate.
 // In SQL, a Predicate is a Term or a boolean combination
 // of Terms.
+// A Predicate is a tree: leaves hold a Term, while inner nodes
+// combine their operands with AND, OR or NOT.
+// AND nodes are kept flat, so that the operands of the root
+// are the conjuncts of the predicate.
 type Predicate struct {
-	terms []Term
+	op       boolOp
+	term     Term
+	operands []Predicate
 }
 
 func NewPredicate() Predicate {
 	return Predicate{
-		terms: make([]Term, 0),
+		operands: make([]Predicate, 0),
 	}
 }
 
 func newPredicateWithTerm(t Term) Predicate {
-	var ts []Term
-	ts = append(ts, t)
-	return Predicate{terms: ts}
+	return Predicate{op: boolTerm, term: t}
+}
+
+func newOrPredicate(lhs Predicate, rhs Predicate) Predicate {
+	return Predicate{op: boolOr, operands: []Predicate{lhs, rhs}}
+}
+
+func newNotPredicate(p Predicate) Predicate {
+	return Predicate{op: boolNot, operands: []Predicate{p}}
+}
+
+// NewEqualityPredicate returns the conjunction of the equalities between each field
+// and the expression at the same position, as in F1 = E1 AND F2 = E2.
+func NewEqualityPredicate(fields []string, exprs []Expression) Predicate {
+	conjuncts := make([]Predicate, len(fields))
+	for i, f := range fields {
+		conjuncts[i] = newPredicateWithTerm(newTerm(opEqual, NewExpressionWithField(f), exprs[i]))
+	}
+
+	return newConjunction(conjuncts)
 }
 
+// CojoinWith ANDs the predicate with other.
 func (p *Predicate) CojoinWith(other Predicate) {
-	p.terms = append(p.terms, other.terms...)
+	if other.isEmpty() {
+		return
+	}
+
+	if p.isEmpty() {
+		*p = other
+		return
+	}
+
+	*p = Predicate{
+		op:       boolAnd,
+		operands: append(p.conjuncts(), other.conjuncts()...),
+	}
+}
+
+func (p Predicate) isEmpty() bool {
+	return p.op == boolAnd && len(p.operands) == 0
+}
+
+// conjuncts returns the predicates that are ANDed together
+// at the root of the predicate.
+func (p Predicate) conjuncts() []Predicate {
+	if p.op == boolAnd {
+		return p.operands
+	}
+
+	return []Predicate{p}
+}
+
+func newConjunction(conjuncts []Predicate) Predicate {
+	if len(conjuncts) == 1 {
+		return conjuncts[0]
+	}
+
+	return Predicate{op: boolAnd, operands: conjuncts}
 }
 
+// IsSatisfied returns true if the predicate holds for the current record of the scan.
+// Predicates are evaluated under three-valued logic, since comparisons
+// involving NULLs are unknown: records only satisfy the predicate if it is true.
 func (p Predicate) IsSatisfied(s Scan) (bool, error) {
-	for _, t := range p.terms {
-		ok, err := t.IsSatisfied(s)
+	res, err := p.evaluate(s)
+	if err != nil {
+		return false, err
+	}
+
+	return res == truthTrue, nil
+}
+
+// IsFalse returns true if the predicate is false for the current record of the scan.
+// Unlike IsSatisfied, a predicate whose result is unknown is not false:
+// a CHECK constraint only rejects the records for which it is false.
+func (p Predicate) IsFalse(s Scan) (bool, error) {
+	res, err := p.evaluate(s)
+	if err != nil {
+		return false, err
+	}
+
+	return res == truthFalse, nil
+}
+
+func (p Predicate) evaluate(s Scan) (truth, error) {
+	switch p.op {
+	case boolTerm:
+		return p.term.evaluate(s)
+	case boolNot:
+		res, err := p.operands[0].evaluate(s)
 		if err != nil {
-			return false, err
+			return truthFalse, err
 		}
-		if !ok {
-			return false, nil
+
+		return res.not(), nil
+	}
+
+	// a disjunction is true as soon as one of its operands is true,
+	// while a conjunction is false as soon as one of its operands is false.
+	// Otherwise, the result is unknown if any of the operands is unknown.
+	decisive := truthFalse
+	if p.op == boolOr {
+		decisive = truthTrue
+	}
+
+	res := decisive.not()
+	for _, o := range p.operands {
+		r, err := o.evaluate(s)
+		if err != nil {
+			return truthFalse, err
+		}
+
+		if r == decisive {
+			return decisive, nil
+		}
+
+		if r == truthUnknown {
+			res = truthUnknown
 		}
 	}
 
-	return true, nil
+	return res, nil
 }
 
+// ReductionFactor estimates by how much the predicate reduces
+// the number of records of the plan.
+// The factor of a conjunction is the product of the factors of its conjuncts.
+// Disjunctions and negations are estimated from the selectivities
+// of their operands, assuming they are independent:
+// s(a OR b) = s(a) + s(b) - s(a)s(b) and s(NOT a) = 1 - s(a).
 func (p Predicate) ReductionFactor(plan Plan) int {
+	switch p.op {
+	case boolTerm:
+		return p.term.ReductionFactor(plan)
+	case boolNot:
+		return factorFromSelectivity(1 - p.operands[0].selectivity(plan))
+	case boolOr:
+		rejected := 1.0
+		for _, o := range p.operands {
+			rejected *= 1 - o.selectivity(plan)
+		}
+
+		return factorFromSelectivity(1 - rejected)
+	}
+
 	factor := 1
-	for _, t := range p.terms {
-		factor *= t.ReductionFactor(plan)
+	for _, o := range p.operands {
+		factor *= o.ReductionFactor(plan)
 	}
 	return factor
 }
 
+func (p Predicate) selectivity(plan Plan) float64 {
+	return 1 / float64(p.ReductionFactor(plan))
+}
+
+func factorFromSelectivity(s float64) int {
+	if s <= 0 {
+		return math.MaxInt
+	}
+
+	return int(math.Round(1 / s))
+}
+
+// AppliesTo returns true if all the terms of the predicate
+// apply to the schema.
+func (p Predicate) AppliesTo(schema Schema) bool {
+	if p.op == boolTerm {
+		return p.term.AppliesTo(schema)
+	}
+
+	for _, o := range p.operands {
+		if !o.AppliesTo(schema) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (p Predicate) SelectSubPredicate(schema Schema) (Predicate, bool) {
-	result := Predicate{}
-	for _, t := range p.terms {
-		if t.AppliesTo(schema) {
-			result.terms = append(result.terms, t)
+	var conjuncts []Predicate
+	for _, c := range p.conjuncts() {
+		if c.AppliesTo(schema) {
+			conjuncts = append(conjuncts, c)
 		}
 	}
 
-	return result, len(result.terms) > 0
+	if len(conjuncts) == 0 {
+		return Predicate{}, false
+	}
+
+	return newConjunction(conjuncts), true
 }
 
 func (p Predicate) JoinSubPredicate(joined Schema, first Schema, second Schema) (Predicate, bool) {
-	out := Predicate{}
-
-	for _, t := range p.terms {
-		if !t.AppliesTo(first) && !t.AppliesTo(second) && t.AppliesTo(joined) {
-			out.terms = append(out.terms, t)
+	var conjuncts []Predicate
+	for _, c := range p.conjuncts() {
+		if !c.AppliesTo(first) && !c.AppliesTo(second) && c.AppliesTo(joined) {
+			conjuncts = append(conjuncts, c)
 		}
 	}
 
-	return out, len(out.terms) > 0
+	if len(conjuncts) == 0 {
+		return Predicate{}, false
+	}
+
+	return newConjunction(conjuncts), true
 }
 
+// EquatesWithConstant looks for a conjunct of the form "F = c",
+// where F is the specified field and c is a constant.
 func (p Predicate) EquatesWithConstant(fieldName string) (storage.Value, bool) {
-	for _, t := range p.terms {
-		ok, c := t.EquatesWithConstant(fieldName)
+	for _, c := range p.conjuncts() {
+		if c.op != boolTerm {
+			continue
+		}
+
+		ok, v := c.term.EquatesWithConstant(fieldName)
 		if ok {
-			return c, true
+			return v, true
 		}
 	}
 
 	return storage.Value{}, false
 }
 
+// EquatesWithField looks for a conjunct of the form "F1 = F2",
+// where F1 is the specified field and F2 is another field.
 func (p Predicate) EquatesWithField(fieldname string) (string, bool) {
-	for _, t := range p.terms {
-		ok, v := t.EquatesWithField(fieldname)
+	for _, c := range p.conjuncts() {
+		if c.op != boolTerm {
+			continue
+		}
+
+		ok, v := c.term.EquatesWithField(fieldname)
 		if ok {
 			return v, true
 		}
@@ -99,13 +287,35 @@ func (p Predicate) EquatesWithField(fieldname string) (string, bool) {
 	return "", false
 }
 
+var boolOpStrings = [...]string{
+	boolAnd: " AND ",
+	boolOr:  " OR ",
+}
+
 func (p Predicate) String() string {
+	switch p.op {
+	case boolTerm:
+		return p.term.String()
+	case boolNot:
+		return "NOT " + p.operands[0].operandString(p.op)
+	}
+
 	var sb strings.Builder
-	for i, t := range p.terms {
-		sb.WriteString(t.String())
-		if i != len(p.terms)-1 {
-			sb.WriteString(" AND ")
+	for i, o := range p.operands {
+		sb.WriteString(o.operandString(p.op))
+		if i != len(p.operands)-1 {
+			sb.WriteString(boolOpStrings[p.op])
 		}
 	}
 	return sb.String()
 }
+
+// operandString returns the string representation of the predicate
+// as an operand of parent, wrapped in parentheses when needed.
+func (p Predicate) operandString(parent boolOp) string {
+	if p.op == boolTerm || p.op == boolNot || p.op == parent {
+		return p.String()
+	}
+
+	return "(" + p.String() + ")"
+}
@@ -0,0 +1,146 @@
+package sql
+
+// FieldResolver maps the name of a field, as written in a query,
+// to the name of the field it refers to.
+type FieldResolver func(field string) (string, error)
+
+// qualify returns a copy of the expression
+// with each field name replaced by its resolved name.
+func (exp Expression) qualify(resolve FieldResolver) (Expression, error) {
+	if exp.kind == exprField {
+		f, err := resolve(exp.fname)
+		if err != nil {
+			return Expression{}, err
+		}
+
+		exp.fname = f
+		return exp, nil
+	}
+
+	if len(exp.operands) == 0 {
+		return exp, nil
+	}
+
+	operands := make([]Expression, len(exp.operands))
+	for i, o := range exp.operands {
+		q, err := o.qualify(resolve)
+		if err != nil {
+			return Expression{}, err
+		}
+
+		operands[i] = q
+	}
+
+	exp.operands = operands
+
+	return exp, nil
+}
+
+func (t Term) qualify(resolve FieldResolver) (Term, error) {
+	lhs, err := t.lhs.qualify(resolve)
+	if err != nil {
+		return Term{}, err
+	}
+
+	if t.op.isUnary() {
+		return Term{op: t.op, lhs: lhs}, nil
+	}
+
+	rhs, err := t.rhs.qualify(resolve)
+	if err != nil {
+		return Term{}, err
+	}
+
+	return Term{op: t.op, lhs: lhs, rhs: rhs}, nil
+}
+
+// Qualify returns a copy of the predicate
+// with each field name replaced by its resolved name.
+func (p Predicate) Qualify(resolve FieldResolver) (Predicate, error) {
+	return p.qualify(resolve)
+}
+
+func (p Predicate) qualify(resolve FieldResolver) (Predicate, error) {
+	if p.op == boolTerm {
+		t, err := p.term.qualify(resolve)
+		if err != nil {
+			return Predicate{}, err
+		}
+
+		return newPredicateWithTerm(t), nil
+	}
+
+	operands := make([]Predicate, len(p.operands))
+	for i, o := range p.operands {
+		q, err := o.qualify(resolve)
+		if err != nil {
+			return Predicate{}, err
+		}
+
+		operands[i] = q
+	}
+
+	return Predicate{op: p.op, operands: operands}, nil
+}
+
+// Qualify returns a copy of the query whose field references
+// in the SELECT, ON, WHERE, GROUP BY and HAVING clauses are replaced by their resolved names.
+// Output columns keep their names: a field whose name changes
+// is aliased to the name it had in the query.
+// The fields of the ORDER BY clause refer to the output columns,
+// so they are left as they are.
+func (qd Query) Qualify(resolve FieldResolver) (Query, error) {
+	names := qd.Fields()
+
+	q := qd
+	q.fields = make([]Expression, len(qd.fields))
+	q.aliases = make([]string, len(qd.fields))
+	for i, f := range qd.fields {
+		qf, err := f.qualify(resolve)
+		if err != nil {
+			return Query{}, err
+		}
+
+		q.fields[i] = qf
+		q.aliases[i] = qd.aliases[i]
+		if qf.String() != f.String() {
+			q.aliases[i] = names[i]
+		}
+	}
+
+	q.joins = make([]Join, len(qd.joins))
+	for i, j := range qd.joins {
+		on, err := j.On.qualify(resolve)
+		if err != nil {
+			return Query{}, err
+		}
+
+		q.joins[i] = Join{Kind: j.Kind, On: on}
+	}
+
+	predicate, err := qd.predicate.qualify(resolve)
+	if err != nil {
+		return Query{}, err
+	}
+
+	q.predicate = predicate
+
+	having, err := qd.having.qualify(resolve)
+	if err != nil {
+		return Query{}, err
+	}
+
+	q.having = having
+
+	q.groupByFields = make([]string, len(qd.groupByFields))
+	for i, f := range qd.groupByFields {
+		qf, err := resolve(f)
+		if err != nil {
+			return Query{}, err
+		}
+
+		q.groupByFields[i] = qf
+	}
+
+	return q, nil
+}
@@ -1,20 +1,141 @@
 package sql
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+)
+
+// SortKey is a field of the ORDER BY clause
+// together with its sort direction.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+func (k SortKey) String() string {
+	if k.Desc {
+		return k.Field + " DESC"
+	}
+
+	return k.Field
+}
+
+// TableRef is a table of the FROM clause,
+// together with the alias it is given in the query.
+type TableRef struct {
+	Name  string
+	Alias string
+}
+
+// RangeName returns the name that qualifies the fields of the table in the query:
+// its alias, if it has one, or the name of the table.
+func (r TableRef) RangeName() string {
+	if r.Alias != "" {
+		return r.Alias
+	}
+
+	return r.Name
+}
+
+func (r TableRef) String() string {
+	if r.Alias != "" {
+		return r.Name + " " + r.Alias
+	}
+
+	return r.Name
+}
 
 type Query struct {
 	QueryCommandType
-	fields        []string
-	tables        []string
+	distinct bool
+	star     bool
+	fields   []Expression
+	// aliases holds the name given with AS to each field,
+	// or an empty string if the field has no alias.
+	aliases []string
+	tables  []TableRef
+	// joins holds how each table is joined to the tables on its left
+	joins         []Join
 	predicate     Predicate
-	orderByFields []string
+	groupByFields []string
+	having        Predicate
+	orderBy       []SortKey
+	// limit is the maximum number of records returned by the query,
+	// or -1 if the query has no LIMIT clause.
+	limit  int
+	offset int
+	// setOp is the set operation that computes the records of the query,
+	// or nil if the query is a plain SELECT.
+	setOp *SetOperation
+	// ctes holds the common table expressions of the WITH clause
+	ctes []CommonTableExpression
 }
 
+// IsDistinct returns true if duplicate rows
+// must be removed from the output of the query.
+func (qd Query) IsDistinct() bool {
+	return qd.distinct
+}
+
+// Tables returns the names of the tables of the FROM clause.
 func (qd Query) Tables() []string {
+	names := make([]string, len(qd.tables))
+	for i, t := range qd.tables {
+		names[i] = t.Name
+	}
+
+	return names
+}
+
+// TableRefs returns the tables of the FROM clause with their aliases.
+func (qd Query) TableRefs() []TableRef {
 	return qd.tables
 }
 
+// Joins returns how each table of the FROM clause
+// is joined to the tables on its left.
+// The i-th join refers to the i-th table of TableRefs.
+func (qd Query) Joins() []Join {
+	return qd.joins
+}
+
+// HasOuterJoin returns true if any of the tables
+// of the FROM clause is outer joined.
+func (qd Query) HasOuterJoin() bool {
+	for _, j := range qd.joins {
+		if j.Kind.IsOuter() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsStar returns true if the query selects
+// all the fields of its tables with *.
+func (qd Query) IsStar() bool {
+	return qd.star
+}
+
+// Fields returns the names of the output columns of the query.
+// Columns are named after their alias, if they have one,
+// and computed columns are named after their expression.
+// The columns of a SELECT * query are only known from the schema of its tables,
+// so no names are returned.
 func (qd Query) Fields() []string {
+	names := make([]string, len(qd.fields))
+	for i, f := range qd.fields {
+		names[i] = f.String()
+		if qd.aliases[i] != "" {
+			names[i] = qd.aliases[i]
+		}
+	}
+
+	return names
+}
+
+// Expressions returns the expressions of the SELECT clause
+func (qd Query) Expressions() []Expression {
 	return qd.fields
 }
 
@@ -22,56 +143,178 @@ func (qd Query) Predicate() Predicate {
 	return qd.predicate
 }
 
+func (qd Query) GroupByFields() []string {
+	return qd.groupByFields
+}
+
+// Having returns the predicate of the HAVING clause,
+// which filters the groups of the query.
+func (qd Query) Having() Predicate {
+	return qd.having
+}
+
+// Aggregates returns the aggregates computed by the query,
+// from both the SELECT and the HAVING clauses.
+// Aggregates that appear more than once are only returned once.
+func (qd Query) Aggregates() []Expression {
+	var all []Expression
+	for _, f := range qd.fields {
+		all = append(all, f.Aggregates()...)
+	}
+
+	all = append(all, qd.having.Aggregates()...)
+
+	var aggs []Expression
+	seen := map[string]struct{}{}
+	for _, a := range all {
+		name := a.String()
+		if _, ok := seen[name]; ok {
+			continue
+		}
+
+		seen[name] = struct{}{}
+		aggs = append(aggs, a)
+	}
+
+	return aggs
+}
+
+// IsGrouped returns true if the query groups its records,
+// either because it has a GROUP BY or a HAVING clause or because it computes aggregates.
+func (qd Query) IsGrouped() bool {
+	return len(qd.groupByFields) > 0 || !qd.having.isEmpty() || len(qd.Aggregates()) > 0
+}
+
+// OrderBy returns the sort keys of the ORDER BY clause.
+func (qd Query) OrderBy() []SortKey {
+	return qd.orderBy
+}
+
+// OrderByFields returns the names of the fields of the ORDER BY clause.
 func (qd Query) OrderByFields() []string {
-	return qd.orderByFields
+	var fields []string
+	for _, k := range qd.orderBy {
+		fields = append(fields, k.Field)
+	}
+
+	return fields
+}
+
+// Limit returns the maximum number of records returned by the query.
+// The second return value is false if the query has no LIMIT clause.
+func (qd Query) Limit() (int, bool) {
+	return qd.limit, qd.limit >= 0
+}
+
+// Offset returns the number of records to skip
+// before the query starts returning them.
+func (qd Query) Offset() int {
+	return qd.offset
 }
 
 func (p Parser) isQuery() bool {
-	return p.matchKeyword("select")
+	return p.matchKeyword("select") || p.matchKeyword("with")
 }
 
-func NewQuery(selects []string, tables []string) Query {
+func NewQuery(selects []Expression, tables []TableRef) Query {
 	return Query{
-		fields: selects,
-		tables: tables,
+		fields:  selects,
+		aliases: make([]string, len(selects)),
+		tables:  tables,
+		joins:   make([]Join, len(tables)),
+		limit:   -1,
 	}
 }
 
 func (qd Query) String() string {
 	var sb strings.Builder
+	qd.writeWithClause(&sb)
+
+	if qd.setOp != nil {
+		sb.WriteString(qd.setOp.String())
+		qd.writeOutputClauses(&sb)
+
+		return sb.String()
+	}
+
 	sb.WriteString("SELECT ")
+	if qd.distinct {
+		sb.WriteString("DISTINCT ")
+	}
+
+	if qd.star {
+		sb.WriteString("*")
+	}
+
 	for i, f := range qd.fields {
-		sb.WriteString(f)
+		sb.WriteString(f.String())
+		if qd.aliases[i] != "" {
+			sb.WriteString(" AS ")
+			sb.WriteString(qd.aliases[i])
+		}
+
 		if i != len(qd.fields)-1 {
 			sb.WriteString(", ")
 		}
 	}
 	sb.WriteString(" FROM ")
 	for i, t := range qd.tables {
-		sb.WriteString(t)
-		if i != len(qd.tables)-1 {
-			sb.WriteString(", ")
+		j := qd.joins[i]
+		if i > 0 {
+			if j.Kind == JoinNone {
+				sb.WriteString(", ")
+			} else {
+				sb.WriteString(" " + j.Kind.String() + " ")
+			}
+		}
+
+		sb.WriteString(t.String())
+
+		if j.Kind != JoinNone {
+			sb.WriteString(" ON ")
+			sb.WriteString(j.On.String())
 		}
 	}
 
-	if len(qd.predicate.terms) == 0 {
-		return sb.String()
+	if !qd.predicate.isEmpty() {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(qd.predicate.String())
 	}
 
-	sb.WriteString(" WHERE ")
-	sb.WriteString(qd.predicate.String())
+	if len(qd.groupByFields) > 0 {
+		sb.WriteString(" GROUP BY ")
+		sb.WriteString(strings.Join(qd.groupByFields, ", "))
+	}
 
-	if len(qd.orderByFields) == 0 {
-		return sb.String()
+	if !qd.having.isEmpty() {
+		sb.WriteString(" HAVING ")
+		sb.WriteString(qd.having.String())
 	}
 
-	sb.WriteString(" ORDER BY ")
-	for i, f := range qd.orderByFields {
-		sb.WriteString(f)
-		if i != len(qd.tables)-1 {
-			sb.WriteString(", ")
+	qd.writeOutputClauses(&sb)
+
+	return sb.String()
+}
+
+// writeOutputClauses writes the ORDER BY and LIMIT clauses of the query.
+func (qd Query) writeOutputClauses(sb *strings.Builder) {
+	if len(qd.orderBy) > 0 {
+		sb.WriteString(" ORDER BY ")
+		for i, k := range qd.orderBy {
+			sb.WriteString(k.String())
+			if i != len(qd.orderBy)-1 {
+				sb.WriteString(", ")
+			}
 		}
 	}
 
-	return sb.String()
+	if qd.limit >= 0 {
+		sb.WriteString(" LIMIT ")
+		sb.WriteString(strconv.Itoa(qd.limit))
+	}
+
+	if qd.offset > 0 {
+		sb.WriteString(" OFFSET ")
+		sb.WriteString(strconv.Itoa(qd.offset))
+	}
 }
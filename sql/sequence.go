@@ -0,0 +1,201 @@
+package sql
+
+import (
+	"errors"
+
+	"github.com/luigitni/simpledb/storage"
+)
+
+var (
+	ErrUnboundSequence  = errors.New("nextval is not bound to a sequence generator")
+	ErrMisplacedNextval = errors.New("nextval is only allowed in the VALUES of INSERT, the SET of UPDATE and DEFAULT")
+)
+
+// SequenceGenerator draws the values of the sequences.
+type SequenceGenerator interface {
+	// NextVal advances the sequence and returns its new value.
+	NextVal(sequence string) (storage.Long, error)
+}
+
+type CreateSequenceCommand struct {
+	DDLCommandType
+	SequenceName string
+	// Start is the first value returned by the sequence
+	Start int
+	// Increment is added to the value of the sequence each time it is drawn
+	Increment int
+}
+
+func NewCreateSequenceCommand(name string, start int, increment int) CreateSequenceCommand {
+	return CreateSequenceCommand{
+		SequenceName: name,
+		Start:        start,
+		Increment:    increment,
+	}
+}
+
+type DropSequenceCommand struct {
+	DDLCommandType
+	SequenceName string
+}
+
+func NewDropSequenceCommand(name string) DropSequenceCommand {
+	return DropSequenceCommand{
+		SequenceName: name,
+	}
+}
+
+// NewNextvalExpression returns the expression that draws the next value of the sequence.
+// nextval evaluates to a LONG.
+func NewNextvalExpression(sequence string) Expression {
+	return Expression{kind: exprNextval, seq: sequence}
+}
+
+// evaluateNextval draws the next value of the sequence from the generator the expression is bound to.
+func (exp Expression) evaluateNextval() (storage.Value, error) {
+	if exp.gen == nil {
+		return nil, ErrUnboundSequence
+	}
+
+	v, err := exp.gen.NextVal(exp.seq)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.ValueFromLong(storage.LONG, v), nil
+}
+
+// checkNextval returns ErrMisplacedNextval if any of the expressions or of the predicates
+// draws a value from a sequence.
+// Values are only drawn for the records written by a statement,
+// so nextval cannot appear in queries and in the predicates of UPDATE and DELETE.
+func checkNextval(exps []Expression, preds ...Predicate) error {
+	for _, exp := range exps {
+		if exp.hasNextval() {
+			return ErrMisplacedNextval
+		}
+	}
+
+	for _, pred := range preds {
+		if pred.hasNextval() {
+			return ErrMisplacedNextval
+		}
+	}
+
+	return nil
+}
+
+// hasNextval returns true if the expression draws a value from a sequence.
+func (exp Expression) hasNextval() bool {
+	if exp.kind == exprNextval {
+		return true
+	}
+
+	for _, o := range exp.operands {
+		if o.hasNextval() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasNextval returns true if the predicate draws a value from a sequence.
+func (p Predicate) hasNextval() bool {
+	if p.op == boolTerm {
+		return p.term.lhs.hasNextval() || p.term.rhs.hasNextval()
+	}
+
+	for _, o := range p.operands {
+		if o.hasNextval() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BindSequences returns a copy of the expression whose calls to nextval draw their values from gen.
+func (exp Expression) BindSequences(gen SequenceGenerator) Expression {
+	if exp.kind == exprNextval {
+		exp.gen = gen
+		return exp
+	}
+
+	if len(exp.operands) == 0 {
+		return exp
+	}
+
+	operands := make([]Expression, len(exp.operands))
+	for i, o := range exp.operands {
+		operands[i] = o.BindSequences(gen)
+	}
+
+	exp.operands = operands
+
+	return exp
+}
+
+// <Nextval> := NEXTVAL ( TokenString )
+// The name of the function has already been consumed.
+func (p Parser) nextval() (Expression, error) {
+	if err := p.eatTokenType(TokenLeftParen); err != nil {
+		return Expression{}, err
+	}
+
+	s, err := p.eatStringValue()
+	if err != nil {
+		return Expression{}, err
+	}
+
+	if err := p.eatTokenType(TokenRightParen); err != nil {
+		return Expression{}, err
+	}
+
+	// remove quotes from the parsed raw string
+	return NewNextvalExpression(s[1 : len(s)-1]), nil
+}
+
+// <CreateSequence> := CREATE SEQUENCE TokenIdentifier [ START [ WITH ] TokenNumber ] [ INCREMENT [ BY ] TokenNumber ]
+// The increment must be greater than zero.
+func (p Parser) createSequence() (CreateSequenceCommand, error) {
+	if err := p.eatWord("sequence"); err != nil {
+		return CreateSequenceCommand{}, err
+	}
+
+	name, err := p.eatIdentifier()
+	if err != nil {
+		return CreateSequenceCommand{}, err
+	}
+
+	start, increment := 1, 1
+	if p.matchWord("start") {
+		p.eatWord("start")
+		if p.matchKeyword("with") {
+			p.eatKeyword("with")
+		}
+
+		start, err = p.eatIntValue()
+		if err != nil {
+			return CreateSequenceCommand{}, err
+		}
+	}
+
+	if p.matchWord("increment") {
+		p.eatWord("increment")
+		if p.matchWord("by") {
+			p.eatWord("by")
+		}
+
+		increment, err = p.eatIntValue()
+		if err != nil {
+			return CreateSequenceCommand{}, err
+		}
+
+		if increment == 0 {
+			return CreateSequenceCommand{}, ErrInvalidSyntax
+		}
+	}
+
+	return NewCreateSequenceCommand(name, start, increment), nil
+}
@@ -0,0 +1,145 @@
+package sql
+
+// SetOp is the operator of a set operation between two queries.
+type SetOp byte
+
+const (
+	SetUnion SetOp = iota
+	SetIntersect
+	SetExcept
+)
+
+var setOpNames = [...]string{
+	SetUnion:     "UNION",
+	SetIntersect: "INTERSECT",
+	SetExcept:    "EXCEPT",
+}
+
+func (op SetOp) String() string {
+	return setOpNames[op]
+}
+
+// SetOperation combines the records of two queries,
+// which must return the same number of columns with the same types.
+// The columns of the result are named after the columns of the left hand side.
+// Unless All is true, duplicate records are removed from the result.
+// With All, UNION returns the records of both sides,
+// INTERSECT returns a record as many times as it is returned by both sides,
+// and EXCEPT as many times as the left hand side returns it more than the right hand side.
+type SetOperation struct {
+	Op  SetOp
+	All bool
+	Lhs Query
+	Rhs Query
+}
+
+func (so SetOperation) String() string {
+	op := so.Op.String()
+	if so.All {
+		op += " ALL"
+	}
+
+	return so.Lhs.String() + " " + op + " " + so.Rhs.String()
+}
+
+// SetOperation returns the set operation of the query,
+// and false if the query is a plain SELECT.
+// The ORDER BY and LIMIT clauses of a set operation
+// apply to its result, and are returned by the query itself.
+func (qd Query) SetOperation() (SetOperation, bool) {
+	if qd.setOp == nil {
+		return SetOperation{}, false
+	}
+
+	return *qd.setOp, true
+}
+
+// hasOutputClauses returns true if the query has an ORDER BY or a LIMIT clause.
+func (qd Query) hasOutputClauses() bool {
+	return len(qd.orderBy) > 0 || qd.limit >= 0 || qd.offset > 0
+}
+
+// newSetOperationQuery returns the query that combines lhs and rhs with the operator.
+// Since only the last query of a chain of set operations can have the ORDER BY and LIMIT clauses,
+// the clauses of rhs are moved to the result.
+func newSetOperationQuery(op SetOp, all bool, lhs Query, rhs Query) (Query, error) {
+	if lhs.hasOutputClauses() {
+		return Query{}, ErrInvalidSyntax
+	}
+
+	q := Query{
+		setOp:   &SetOperation{Op: op, All: all},
+		orderBy: rhs.orderBy,
+		limit:   rhs.limit,
+		offset:  rhs.offset,
+	}
+
+	rhs.orderBy = nil
+	rhs.limit = -1
+	rhs.offset = 0
+
+	q.setOp.Lhs = lhs
+	q.setOp.Rhs = rhs
+
+	return q, nil
+}
+
+var setOps = map[tokenType]SetOp{
+	TokenUnion:     SetUnion,
+	TokenIntersect: SetIntersect,
+	TokenExcept:    SetExcept,
+}
+
+// setOperator parses one of the set operators, followed by an optional ALL.
+// The third return value is false if the current token is none of them.
+func (p Parser) setOperator(tokens ...tokenType) (SetOp, bool, bool, error) {
+	for _, tkn := range tokens {
+		if !p.matchTokenType(tkn) {
+			continue
+		}
+
+		if err := p.eatTokenType(tkn); err != nil {
+			return 0, false, false, err
+		}
+
+		all := p.matchTokenType(TokenAll)
+		if all {
+			if err := p.eatTokenType(TokenAll); err != nil {
+				return 0, false, false, err
+			}
+		}
+
+		return setOps[tkn], all, true, nil
+	}
+
+	return 0, false, false, nil
+}
+
+// <SetOperand> := <SelectQuery> [ INTERSECT [ ALL ] <SelectQuery> ... ]
+func (p Parser) setOperand() (Query, error) {
+	q, err := p.selectQuery()
+	if err != nil {
+		return Query{}, err
+	}
+
+	for {
+		op, all, ok, err := p.setOperator(TokenIntersect)
+		if err != nil {
+			return Query{}, err
+		}
+
+		if !ok {
+			return q, nil
+		}
+
+		rhs, err := p.selectQuery()
+		if err != nil {
+			return Query{}, err
+		}
+
+		q, err = newSetOperationQuery(op, all, q, rhs)
+		if err != nil {
+			return Query{}, err
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package sql
+
+import (
+	"errors"
+
+	"github.com/luigitni/simpledb/storage"
+)
+
+var (
+	ErrSubqueryRows    = errors.New("more than one record returned by a subquery used as an expression")
+	ErrSubqueryColumns = errors.New("subquery must return a single column")
+	ErrUnboundSubquery = errors.New("subquery is not bound to an evaluator")
+)
+
+// SubqueryEvaluator runs a subquery for the current record of the outer query.
+// The records of an uncorrelated subquery are the same for every outer record,
+// so evaluators are free to compute them once.
+type SubqueryEvaluator interface {
+	// Type returns the type of the first column of the subquery.
+	Type() storage.FieldType
+	// Values returns the values of the first column of the records of the subquery.
+	Values(outer Scan) ([]storage.Value, error)
+	// Exists returns true if the subquery returns at least one record.
+	Exists(outer Scan) (bool, error)
+}
+
+// Subquery is a query nested in an expression or in a condition of another query.
+// Before the outer query is evaluated, the engine plans the subquery
+// and binds it to an evaluator.
+// The fields of the outer query that the subquery refers to,
+// which make it correlated, are known once it is bound.
+type Subquery struct {
+	query  Query
+	exists bool
+	eval   SubqueryEvaluator
+	outer  []string
+}
+
+// Query returns the nested query.
+func (sq Subquery) Query() Query {
+	return sq.query
+}
+
+// IsExists returns true if the subquery is the operand of EXISTS,
+// which is the only place where it can return more than one column.
+func (sq Subquery) IsExists() bool {
+	return sq.exists
+}
+
+// Bind returns a copy of the subquery that is evaluated by eval
+// and refers to the fields outer of the outer query.
+func (sq Subquery) Bind(eval SubqueryEvaluator, outer []string) Subquery {
+	sq.eval = eval
+	sq.outer = outer
+	return sq
+}
+
+// Evaluator returns the evaluator the subquery is bound to.
+func (sq Subquery) Evaluator() SubqueryEvaluator {
+	return sq.eval
+}
+
+// IsCorrelated returns true if the subquery refers to fields of the outer query.
+func (sq Subquery) IsCorrelated() bool {
+	return len(sq.outer) > 0
+}
+
+func (sq Subquery) String() string {
+	return "(" + sq.query.String() + ")"
+}
+
+func newSubqueryExpression(sq Subquery) Expression {
+	return Expression{kind: exprSubquery, sub: &sq}
+}
+
+// IsSubquery returns true if the expression is a scalar subquery
+// or the subquery operand of IN or EXISTS.
+func (exp Expression) IsSubquery() bool {
+	return exp.kind == exprSubquery
+}
+
+func (exp Expression) AsSubquery() Subquery {
+	return *exp.sub
+}
+
+// hasSubquery returns true if the expression contains a subquery.
+func (exp Expression) hasSubquery() bool {
+	if exp.kind == exprSubquery {
+		return true
+	}
+
+	for _, o := range exp.operands {
+		if o.hasSubquery() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasSubquery returns true if the predicate contains a subquery.
+func (p Predicate) hasSubquery() bool {
+	if p.op == boolTerm {
+		return p.term.lhs.hasSubquery() || p.term.rhs.hasSubquery()
+	}
+
+	for _, o := range p.operands {
+		if o.hasSubquery() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evaluateSubquery returns the only value of the scalar subquery,
+// or NULL if the subquery returns no records.
+func (exp Expression) evaluateSubquery(scan Scan) (storage.Value, error) {
+	if exp.sub.eval == nil {
+		return nil, ErrUnboundSubquery
+	}
+
+	vals, err := exp.sub.eval.Values(scan)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(vals) {
+	case 0:
+		return storage.Null, nil
+	case 1:
+		return vals[0], nil
+	}
+
+	return nil, ErrSubqueryRows
+}
+
+// evaluateIn evaluates lhs IN (subquery), which is true if the subquery returns the value of lhs.
+// Otherwise, it is unknown if either lhs or any value of the subquery is NULL,
+// since NULL may stand for the value of lhs, and false if the subquery returns no NULLs.
+// The subquery returning no records makes the condition false, even if lhs is NULL.
+func (t Term) evaluateIn(s Scan) (truth, error) {
+	sub := t.rhs.sub
+	if sub.eval == nil {
+		return truthFalse, ErrUnboundSubquery
+	}
+
+	vals, err := sub.eval.Values(s)
+	if err != nil {
+		return truthFalse, err
+	}
+
+	if len(vals) == 0 {
+		return truthFalse, nil
+	}
+
+	lc, err := t.lhs.Evaluate(s)
+	if err != nil {
+		return truthFalse, err
+	}
+
+	if lc.IsNull() {
+		return truthUnknown, nil
+	}
+
+	lt, err := t.lhs.Type(s)
+	if err != nil {
+		return truthFalse, err
+	}
+
+	res := truthFalse
+	for _, v := range vals {
+		if v.IsNull() {
+			res = truthUnknown
+			continue
+		}
+
+		cmp, err := compare(lt, lc, sub.eval.Type(), v)
+		if err != nil {
+			return truthFalse, err
+		}
+
+		if cmp == 0 {
+			return truthTrue, nil
+		}
+	}
+
+	return res, nil
+}
+
+func (t Term) evaluateExists(s Scan) (truth, error) {
+	sub := t.lhs.sub
+	if sub.eval == nil {
+		return truthFalse, ErrUnboundSubquery
+	}
+
+	ok, err := sub.eval.Exists(s)
+	if err != nil {
+		return truthFalse, err
+	}
+
+	return truthOf(ok), nil
+}
+
+// SubqueryBinder binds a subquery to its evaluator.
+type SubqueryBinder func(sq Subquery) (Subquery, error)
+
+func (exp Expression) bind(binder SubqueryBinder) (Expression, error) {
+	if exp.kind == exprSubquery {
+		sq, err := binder(*exp.sub)
+		if err != nil {
+			return Expression{}, err
+		}
+
+		return newSubqueryExpression(sq), nil
+	}
+
+	if len(exp.operands) == 0 {
+		return exp, nil
+	}
+
+	operands := make([]Expression, len(exp.operands))
+	for i, o := range exp.operands {
+		b, err := o.bind(binder)
+		if err != nil {
+			return Expression{}, err
+		}
+
+		operands[i] = b
+	}
+
+	exp.operands = operands
+
+	return exp, nil
+}
+
+func (t Term) bind(binder SubqueryBinder) (Term, error) {
+	lhs, err := t.lhs.bind(binder)
+	if err != nil {
+		return Term{}, err
+	}
+
+	if t.op.isUnary() {
+		return Term{op: t.op, lhs: lhs}, nil
+	}
+
+	rhs, err := t.rhs.bind(binder)
+	if err != nil {
+		return Term{}, err
+	}
+
+	return Term{op: t.op, lhs: lhs, rhs: rhs}, nil
+}
+
+func (p Predicate) bind(binder SubqueryBinder) (Predicate, error) {
+	if p.op == boolTerm {
+		t, err := p.term.bind(binder)
+		if err != nil {
+			return Predicate{}, err
+		}
+
+		return newPredicateWithTerm(t), nil
+	}
+
+	operands := make([]Predicate, len(p.operands))
+	for i, o := range p.operands {
+		b, err := o.bind(binder)
+		if err != nil {
+			return Predicate{}, err
+		}
+
+		operands[i] = b
+	}
+
+	return Predicate{op: p.op, operands: operands}, nil
+}
+
+// BindSubqueries returns a copy of the query whose subqueries,
+// in the SELECT, ON, WHERE and HAVING clauses, are replaced by the ones returned by binder.
+// Subqueries nested in other subqueries are left to the binder.
+func (qd Query) BindSubqueries(binder SubqueryBinder) (Query, error) {
+	q := qd
+	q.fields = make([]Expression, len(qd.fields))
+	for i, f := range qd.fields {
+		b, err := f.bind(binder)
+		if err != nil {
+			return Query{}, err
+		}
+
+		q.fields[i] = b
+	}
+
+	q.joins = make([]Join, len(qd.joins))
+	for i, j := range qd.joins {
+		on, err := j.On.bind(binder)
+		if err != nil {
+			return Query{}, err
+		}
+
+		q.joins[i] = Join{Kind: j.Kind, On: on}
+	}
+
+	predicate, err := qd.predicate.bind(binder)
+	if err != nil {
+		return Query{}, err
+	}
+
+	q.predicate = predicate
+
+	having, err := qd.having.bind(binder)
+	if err != nil {
+		return Query{}, err
+	}
+
+	q.having = having
+
+	return q, nil
+}
+
+// SubqueryTerm returns the subquery of an IN or NOT IN term,
+// together with its left hand side and true if the term is a NOT IN.
+// The last return value is false if the predicate is not such a term.
+func (p Predicate) SubqueryTerm() (Expression, Subquery, bool, bool) {
+	if p.op != boolTerm || p.term.op != opIn && p.term.op != opNotIn {
+		return Expression{}, Subquery{}, false, false
+	}
+
+	return p.term.lhs, *p.term.rhs.sub, p.term.op == opNotIn, true
+}
+
+// Conjuncts returns the predicates that are ANDed together at the root of the predicate.
+func (p Predicate) Conjuncts() []Predicate {
+	if p.isEmpty() {
+		return nil
+	}
+
+	return p.conjuncts()
+}
+
+// NewConjunction returns the predicate that ANDs together the conjuncts.
+func NewConjunction(conjuncts []Predicate) Predicate {
+	if len(conjuncts) == 0 {
+		return NewPredicate()
+	}
+
+	return newConjunction(conjuncts)
+}
+
+// WithPredicate returns a copy of the query whose <WHERE> clause is pred.
+func (qd Query) WithPredicate(pred Predicate) Query {
+	qd.predicate = pred
+	return qd
+}
@@ -1,96 +1,326 @@
 package sql
 
 import (
+	"errors"
 	"fmt"
 	"math"
 
 	"github.com/luigitni/simpledb/storage"
 )
 
+var ErrTypeMismatch = errors.New("cannot compare values of different types")
+
 type Plan interface {
 	DistinctValues(fieldName string) int
 }
 
-// Term is a comparison between two Expressions.
+// compareOp is the comparison operator of a Term.
+type compareOp byte
+
+const (
+	opEqual compareOp = iota
+	opNotEqual
+	opLess
+	opLessEqual
+	opGreater
+	opGreaterEqual
+	opIsNull
+	opIsNotNull
+	opIn
+	opNotIn
+	opExists
+)
+
+var compareOpStrings = [...]string{
+	opEqual:        "=",
+	opNotEqual:     "<>",
+	opLess:         "<",
+	opLessEqual:    "<=",
+	opGreater:      ">",
+	opGreaterEqual: ">=",
+	opIsNull:       "IS NULL",
+	opIsNotNull:    "IS NOT NULL",
+	opIn:           "IN",
+	opNotIn:        "NOT IN",
+	opExists:       "EXISTS",
+}
+
+func (op compareOp) String() string {
+	return compareOpStrings[op]
+}
+
+// isUnary returns true if the operator only applies to the left hand side of the Term.
+// The left hand side of EXISTS is its subquery.
+func (op compareOp) isUnary() bool {
+	return op == opIsNull || op == opIsNotNull || op == opExists
+}
+
+// truth is the outcome of a condition under SQL three-valued logic.
+// A comparison involving a NULL is neither true nor false, but unknown.
+type truth byte
+
+const (
+	truthFalse truth = iota
+	truthTrue
+	truthUnknown
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return truthTrue
+	}
+
+	return truthFalse
+}
+
+func (t truth) not() truth {
+	switch t {
+	case truthTrue:
+		return truthFalse
+	case truthFalse:
+		return truthTrue
+	}
+
+	return truthUnknown
+}
+
+// rangeReductionFactor is the reduction factor of a range comparison.
+// Without histograms there is no way to tell how many values fall
+// within the range, so we assume that a third of the records satisfy it.
+const rangeReductionFactor = 3
+
+// nullReductionFactor is the reduction factor of an IS NULL test.
+// The catalog does not keep track of NULLs, so we assume
+// that one record in ten has a NULL in the tested expression.
+const nullReductionFactor = 10
+
+// Term is a comparison between two Expressions,
+// or a NULL test on a single Expression.
+// The right hand side of IN and NOT IN, and the only side of EXISTS, is a subquery.
 type Term struct {
+	op  compareOp
 	lhs Expression
 	rhs Expression
 }
 
-func newTerm(lhs Expression, rhs Expression) Term {
-	return Term{lhs: lhs, rhs: rhs}
+func newTerm(op compareOp, lhs Expression, rhs Expression) Term {
+	return Term{op: op, lhs: lhs, rhs: rhs}
+}
+
+func newNullTerm(op compareOp, lhs Expression) Term {
+	return Term{op: op, lhs: lhs}
+}
+
+func newExistsTerm(sq Subquery) Term {
+	return Term{op: opExists, lhs: newSubqueryExpression(sq)}
 }
 
+// IsSatisfied returns true if the term holds for the current record of the scan.
+// A comparison involving a NULL is not satisfied.
 func (t Term) IsSatisfied(s Scan) (bool, error) {
-	lc, err := t.lhs.Evaluate(s)
+	res, err := t.evaluate(s)
 	if err != nil {
 		return false, err
 	}
 
+	return res == truthTrue, nil
+}
+
+func (t Term) evaluate(s Scan) (truth, error) {
+	switch t.op {
+	case opIn:
+		return t.evaluateIn(s)
+	case opNotIn:
+		res, err := t.evaluateIn(s)
+		return res.not(), err
+	case opExists:
+		return t.evaluateExists(s)
+	}
+
+	lc, err := t.lhs.Evaluate(s)
+	if err != nil {
+		return truthFalse, err
+	}
+
+	if t.op.isUnary() {
+		return truthOf(lc.IsNull() == (t.op == opIsNull)), nil
+	}
+
 	rc, err := t.rhs.Evaluate(s)
 	if err != nil {
-		return false, err
+		return truthFalse, err
+	}
+
+	if lc.IsNull() || rc.IsNull() {
+		return truthUnknown, nil
+	}
+
+	lt, err := t.lhs.Type(s)
+	if err != nil {
+		return truthFalse, err
+	}
+
+	rt, err := t.rhs.Type(s)
+	if err != nil {
+		return truthFalse, err
 	}
 
-	return lc.Equals(rc), nil
+	cmp, err := compare(lt, lc, rt, rc)
+	if err != nil {
+		return truthFalse, err
+	}
+
+	return truthOf(t.op.holds(cmp)), nil
 }
 
-func (t Term) ReductionFactor(p Plan) int {
-	if t.lhs.IsFieldName() && t.rhs.IsFieldName() {
-		lhsName := t.lhs.AsFieldName()
-		rhsName := t.rhs.AsFieldName()
-		if m := p.DistinctValues(lhsName); m > p.DistinctValues(rhsName) {
-			return m
+// holds returns whether the operator is satisfied
+// by the outcome cmp of a comparison.
+func (op compareOp) holds(cmp int) bool {
+	switch op {
+	case opNotEqual:
+		return cmp != 0
+	case opLess:
+		return cmp < 0
+	case opLessEqual:
+		return cmp <= 0
+	case opGreater:
+		return cmp > 0
+	case opGreaterEqual:
+		return cmp >= 0
+	}
+
+	return cmp == 0
+}
+
+// compare compares lv, of type lt, with rv, of type rt.
+// It returns -1 if lv is less than rv, 0 if they are equal and 1 otherwise.
+// Integers of different sizes are widened to a LONG before being compared.
+func compare(lt storage.FieldType, lv storage.Value, rt storage.FieldType, rv storage.Value) (int, error) {
+	if lt != rt {
+		if !lt.IsInteger() || !rt.IsInteger() {
+			return 0, ErrTypeMismatch
 		}
-		return p.DistinctValues(rhsName)
+
+		lv = storage.ValueFromLong(storage.LONG, storage.ValueAsLong(lt, lv))
+		rv = storage.ValueFromLong(storage.LONG, storage.ValueAsLong(rt, rv))
+		lt = storage.LONG
+	}
+
+	if lv.Less(lt, rv) {
+		return -1, nil
+	}
+
+	if lv.More(lt, rv) {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+func (t Term) ReductionFactor(p Plan) int {
+	lhsFields := t.lhs.Fields()
+	rhsFields := t.rhs.Fields()
+	if t.op.isUnary() {
+		rhsFields = nil
 	}
 
-	if t.lhs.IsFieldName() {
-		return p.DistinctValues(t.lhs.AsFieldName())
+	// the selectivity of a subquery is unknown until it runs,
+	// so IN and EXISTS are estimated as range comparisons.
+	// NOT IN may reject no record at all.
+	switch t.op {
+	case opIn, opExists:
+		return rangeReductionFactor
+	case opNotIn:
+		return 1
 	}
 
-	if t.rhs.IsFieldName() {
-		return p.DistinctValues(t.rhs.AsFieldName())
+	// the term compares two constants, hence it
+	// is either always satisfied or never satisfied.
+	// Subqueries are not evaluated while the query is being planned.
+	if len(lhsFields) == 0 && len(rhsFields) == 0 && !t.lhs.hasSubquery() && !t.rhs.hasSubquery() {
+		if ok, err := t.IsSatisfied(nil); err == nil && ok {
+			return 1
+		}
+
+		return math.MaxInt
 	}
 
-	lc := t.lhs.AsConstant()
-	rc := t.rhs.AsConstant()
-	if lc.Equals(rc) {
+	switch t.op {
+	case opEqual:
+		return max(maxDistinctValues(p, lhsFields), maxDistinctValues(p, rhsFields))
+	case opNotEqual, opIsNotNull:
 		return 1
+	case opIsNull:
+		return nullReductionFactor
 	}
 
-	return math.MaxInt
+	return rangeReductionFactor
+}
+
+// maxDistinctValues returns the highest number of distinct values
+// among the given fields of the plan.
+func maxDistinctValues(p Plan, fields []string) int {
+	m := 1
+	for _, f := range fields {
+		m = max(m, p.DistinctValues(f))
+	}
+
+	return m
 }
 
 func (t Term) AppliesTo(schema Schema) bool {
+	if t.op.isUnary() {
+		return t.lhs.AppliesTo(schema)
+	}
+
 	return t.lhs.AppliesTo(schema) && t.rhs.AppliesTo(schema)
 }
 
-// todo: check why here the index returns nil
+// EquatesWithConstant returns the constant the field is compared against,
+// if the term is an equality between the field and a constant.
 func (t Term) EquatesWithConstant(fieldName string) (bool, storage.Value) {
-	if t.lhs.IsFieldName() && t.lhs.fname == fieldName && !t.rhs.IsFieldName() {
+	if t.op != opEqual {
+		return false, storage.Value{}
+	}
+
+	// NULL is never equal to anything
+	if t.lhs.IsFieldName() && t.lhs.fname == fieldName && t.rhs.IsConstant() && !t.rhs.isNull() {
 		return true, t.rhs.AsConstant()
 	}
 
-	if t.rhs.IsFieldName() && t.rhs.fname == fieldName && !t.lhs.IsFieldName() {
+	if t.rhs.IsFieldName() && t.rhs.fname == fieldName && t.lhs.IsConstant() && !t.lhs.isNull() {
 		return true, t.lhs.AsConstant()
 	}
 
 	return false, storage.Value{}
 }
 
+// EquatesWithField returns the name of the other field,
+// if the term is an equality between the field and another field.
 func (t Term) EquatesWithField(fieldName string) (bool, string) {
-	if t.lhs.IsFieldName() && t.lhs.fname == fieldName && !t.rhs.IsFieldName() {
-		return true, t.lhs.AsFieldName()
+	if t.op != opEqual {
+		return false, ""
 	}
 
-	if t.rhs.IsFieldName() && t.rhs.fname == fieldName && !t.lhs.IsFieldName() {
+	if t.lhs.IsFieldName() && t.lhs.fname == fieldName && t.rhs.IsFieldName() {
 		return true, t.rhs.AsFieldName()
 	}
 
+	if t.rhs.IsFieldName() && t.rhs.fname == fieldName && t.lhs.IsFieldName() {
+		return true, t.lhs.AsFieldName()
+	}
+
 	return false, ""
 }
 
 func (t Term) String() string {
-	return fmt.Sprintf("%s = %s", t.lhs, t.rhs)
+	if t.op == opExists {
+		return fmt.Sprintf("%s %s", t.op, t.lhs)
+	}
+
+	if t.op.isUnary() {
+		return fmt.Sprintf("%s %s", t.lhs, t.op)
+	}
+
+	return fmt.Sprintf("%s %s %s", t.lhs, t.op, t.rhs)
 }
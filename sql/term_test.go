@@ -0,0 +1,209 @@
+package sql
+
+import (
+	"testing"
+
+	"github.com/luigitni/simpledb/storage"
+)
+
+type testField struct {
+	typ storage.FieldType
+	val storage.Value
+}
+
+type testScan map[string]testField
+
+func intField(v storage.Int) testField {
+	return testField{typ: storage.INT, val: storage.ValueFromInteger[storage.Int](storage.SizeOfInt, v)}
+}
+
+func (s testScan) Val(fieldName string) (storage.Value, error) {
+	return s[fieldName].val, nil
+}
+
+func (s testScan) Type(fieldName string) storage.FieldType {
+	return s[fieldName].typ
+}
+
+func TestTermIsSatisfied(t *testing.T) {
+	scan := testScan{
+		"small": {typ: storage.TINYINT, val: storage.ValueFromInteger[storage.TinyInt](storage.SizeOfTinyInt, 10)},
+		"big":   {typ: storage.LONG, val: storage.ValueFromInteger[storage.Long](storage.SizeOfLong, 1000)},
+		"name":  {typ: storage.TEXT, val: storage.ValueFromGoString("luigi")},
+	}
+
+	type test struct {
+		src string
+		exp bool
+	}
+
+	for _, tc := range []test{
+		{src: "small = 10", exp: true},
+		{src: "small <> 10", exp: false},
+		{src: "small < 11", exp: true},
+		{src: "small <= 9", exp: false},
+		{src: "small > 9", exp: true},
+		{src: "small >= 11", exp: false},
+		{src: "small < big", exp: true},
+		{src: "big > 999", exp: true},
+		{src: "name < 'mario'", exp: true},
+		{src: "name >= 'luigi'", exp: true},
+		{src: "name <> 'luigi'", exp: false},
+	} {
+		term, err := NewParser(tc.src).term()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ok, err := term.IsSatisfied(scan)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok != tc.exp {
+			t.Fatalf("expected %q to be %t, got %t", tc.src, tc.exp, ok)
+		}
+	}
+
+	term, err := NewParser("name > 3").term()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := term.IsSatisfied(scan); err != ErrTypeMismatch {
+		t.Fatalf("expected %v, got %v", ErrTypeMismatch, err)
+	}
+}
+
+func TestThreeValuedLogic(t *testing.T) {
+	scan := testScan{
+		"a":    intField(1),
+		"null": {typ: storage.INT, val: storage.Null},
+		"name": {typ: storage.TEXT, val: storage.Null},
+	}
+
+	type test struct {
+		src string
+		exp bool
+	}
+
+	for _, tc := range []test{
+		{src: "null IS NULL", exp: true},
+		{src: "null IS NOT NULL", exp: false},
+		{src: "a IS NULL", exp: false},
+		{src: "a IS NOT NULL", exp: true},
+		{src: "name IS NULL", exp: true},
+		{src: "null = 1", exp: false},
+		{src: "NOT null = 1", exp: false},
+		{src: "null = NULL", exp: false},
+		{src: "null + 1 IS NULL", exp: true},
+		{src: "name || 'x' IS NULL", exp: true},
+		{src: "name = 'luigi'", exp: false},
+		{src: "a = 1 OR null = 1", exp: true},
+		{src: "NOT (a = 2 OR null = 1)", exp: false},
+		{src: "NOT (a = 2 AND null = 1)", exp: true},
+	} {
+		pred, err := NewParser(tc.src).predicate()
+		if err != nil {
+			t.Fatalf("error parsing %q: %v", tc.src, err)
+		}
+
+		ok, err := pred.IsSatisfied(scan)
+		if err != nil {
+			t.Fatalf("error evaluating %q: %v", tc.src, err)
+		}
+
+		if ok != tc.exp {
+			t.Fatalf("expected %q to be %t, got %t", tc.src, tc.exp, ok)
+		}
+	}
+}
+
+type testSchema map[string]struct{}
+
+func (s testSchema) HasField(fieldName string) bool {
+	_, ok := s[fieldName]
+	return ok
+}
+
+type testEvaluator []storage.Value
+
+func (e testEvaluator) Type() storage.FieldType {
+	return storage.INT
+}
+
+func (e testEvaluator) Values(outer Scan) ([]storage.Value, error) {
+	return e, nil
+}
+
+func (e testEvaluator) Exists(outer Scan) (bool, error) {
+	return len(e) > 0, nil
+}
+
+func TestSubqueryTerms(t *testing.T) {
+	one := intField(1).val
+	two := intField(2).val
+
+	scan := testScan{
+		"a": intField(1),
+		"n": {typ: storage.INT, val: storage.Null},
+	}
+
+	type test struct {
+		src  string
+		vals []storage.Value
+		exp  truth
+	}
+
+	for _, tc := range []test{
+		{src: "a IN (SELECT b FROM t)", vals: []storage.Value{two, one}, exp: truthTrue},
+		{src: "a IN (SELECT b FROM t)", vals: []storage.Value{two}, exp: truthFalse},
+		{src: "a IN (SELECT b FROM t)", vals: []storage.Value{two, storage.Null}, exp: truthUnknown},
+		{src: "a IN (SELECT b FROM t)", vals: nil, exp: truthFalse},
+		{src: "n IN (SELECT b FROM t)", vals: []storage.Value{one}, exp: truthUnknown},
+		{src: "a NOT IN (SELECT b FROM t)", vals: []storage.Value{two}, exp: truthTrue},
+		{src: "a NOT IN (SELECT b FROM t)", vals: []storage.Value{two, storage.Null}, exp: truthUnknown},
+		{src: "n NOT IN (SELECT b FROM t)", vals: nil, exp: truthTrue},
+		{src: "EXISTS (SELECT b FROM t)", vals: []storage.Value{storage.Null}, exp: truthTrue},
+		{src: "EXISTS (SELECT b FROM t)", vals: nil, exp: truthFalse},
+		{src: "a = (SELECT b FROM t)", vals: []storage.Value{one}, exp: truthTrue},
+		{src: "a = (SELECT b FROM t)", vals: nil, exp: truthUnknown},
+	} {
+		pred, err := NewParser(tc.src).condition()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pred, err = pred.bind(func(sq Subquery) (Subquery, error) {
+			return sq.Bind(testEvaluator(tc.vals), nil), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := pred.evaluate(scan)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res != tc.exp {
+			t.Fatalf("expected %q to evaluate to %d, got %d", tc.src, tc.exp, res)
+		}
+	}
+
+	term, err := NewParser("a = (SELECT b FROM t)").term()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	term, err = term.bind(func(sq Subquery) (Subquery, error) {
+		return sq.Bind(testEvaluator{one, two}, nil), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := term.IsSatisfied(scan); err != ErrSubqueryRows {
+		t.Fatalf("expected %v, got %v", ErrSubqueryRows, err)
+	}
+}
@@ -24,6 +24,13 @@ const (
 	TokenEqualEqual
 	TokenLessEqual
 	TokenGreaterEqual
+	TokenLessGreater
+	TokenPlus
+	TokenMinus
+	TokenSlash
+	TokenPercent
+	TokenConcat
+	TokenDot
 
 	TokenString
 	TokenNumber
@@ -35,6 +42,7 @@ const (
 	TokenCreate
 	TokenFrom
 	TokenDelete
+	TokenDrop
 	TokenIndex
 	TokenInsert
 	TokenInto
@@ -42,12 +50,50 @@ const (
 	TokenUpdate
 	TokenWhere
 	TokenOrderBy
+	TokenGroupBy
+	TokenHaving
+	TokenDistinct
+	TokenLimit
+	TokenOffset
+	TokenAsc
+	TokenDesc
+	TokenJoin
+	TokenInner
+	TokenLeft
+	TokenRight
+	TokenFull
+	TokenOuter
+	TokenIn
+	TokenExists
+	TokenUnion
+	TokenAll
+	TokenIntersect
+	TokenExcept
+	TokenWith
+	TokenRecursive
+	TokenAlter
+	TokenAdd
+	TokenColumn
+	TokenRename
+	TokenTo
+	TokenPrimary
+	TokenUnique
+	TokenDefault
+	TokenCheck
+	TokenForeign
+	TokenReferences
+	TokenCascade
+	TokenRestrict
 
 	TokenBegin
 	TokenCommit
 	TokenRollback
 
 	TokenAnd
+	TokenOr
+	TokenNot
+	TokenIs
+	TokenNull
 	TokenValues
 	TokenSet
 	TokenTable
@@ -136,6 +182,8 @@ func (t *tokenizer) nextToken() (Token, error) {
 		return t.makeToken(TokenSemicolon), nil
 	case ',':
 		return t.makeToken(TokenComma), nil
+	case '.':
+		return t.makeToken(TokenDot), nil
 	// two chars token
 	case '!':
 		if t.match('=') {
@@ -153,15 +201,30 @@ func (t *tokenizer) nextToken() (Token, error) {
 		if t.match('=') {
 			return t.makeToken(TokenLessEqual), nil
 		}
-		return t.makeToken(TokenGreater), nil
+		if t.match('>') {
+			return t.makeToken(TokenLessGreater), nil
+		}
+		return t.makeToken(TokenLess), nil
 
 	case '>':
 		if t.match('=') {
 			return t.makeToken(TokenGreaterEqual), nil
 		}
-		return t.makeToken(TokenLessEqual), nil
+		return t.makeToken(TokenGreater), nil
 	case '*':
 		return t.makeToken(TokenStar), nil
+	case '+':
+		return t.makeToken(TokenPlus), nil
+	case '-':
+		return t.makeToken(TokenMinus), nil
+	case '/':
+		return t.makeToken(TokenSlash), nil
+	case '%':
+		return t.makeToken(TokenPercent), nil
+	case '|':
+		if t.match('|') {
+			return t.makeToken(TokenConcat), nil
+		}
 	case '\'':
 		return t.string()
 	case '\n':
@@ -309,6 +372,18 @@ func (t *tokenizer) identifierType() tokenType {
 		if t.isKeyword(1, 2, "nd") {
 			return TokenAnd
 		}
+		if t.isKeyword(1, 2, "sc") {
+			return TokenAsc
+		}
+		if t.isKeyword(1, 2, "ll") {
+			return TokenAll
+		}
+		if t.isKeyword(1, 4, "lter") {
+			return TokenAlter
+		}
+		if t.isKeyword(1, 2, "dd") {
+			return TokenAdd
+		}
 	case 'b':
 		if t.isKeyword(1, 4, "egin") {
 			return TokenBegin
@@ -320,14 +395,59 @@ func (t *tokenizer) identifierType() tokenType {
 		if t.isKeyword(1, 5, "reate") {
 			return TokenCreate
 		}
+		if t.isKeyword(1, 5, "olumn") {
+			return TokenColumn
+		}
+		if t.isKeyword(1, 4, "heck") {
+			return TokenCheck
+		}
+		if t.isKeyword(1, 6, "ascade") {
+			return TokenCascade
+		}
 	case 'd':
 		if t.isKeyword(1, 5, "elete") {
 			return TokenDelete
 		}
+		if t.isKeyword(1, 7, "istinct") {
+			return TokenDistinct
+		}
+		if t.isKeyword(1, 3, "esc") {
+			return TokenDesc
+		}
+		if t.isKeyword(1, 3, "rop") {
+			return TokenDrop
+		}
+		if t.isKeyword(1, 6, "efault") {
+			return TokenDefault
+		}
+	case 'e':
+		if t.isKeyword(1, 5, "xists") {
+			return TokenExists
+		}
+		if t.isKeyword(1, 5, "xcept") {
+			return TokenExcept
+		}
 	case 'f':
 		if t.isKeyword(1, 3, "rom") {
 			return TokenFrom
 		}
+		if t.isKeyword(1, 3, "ull") {
+			return TokenFull
+		}
+		if t.isKeyword(1, 6, "oreign") {
+			return TokenForeign
+		}
+	case 'g':
+		if t.isKeyword(1, 4, "roup") && t.match(' ') {
+			t.toNextWhitespace()
+			if t.isKeyword(6, 2, "by") {
+				return TokenGroupBy
+			}
+		}
+	case 'h':
+		if t.isKeyword(1, 5, "aving") {
+			return TokenHaving
+		}
 	case 'i':
 		if t.isKeyword(1, 5, "nsert") {
 			return TokenInsert
@@ -341,20 +461,78 @@ func (t *tokenizer) identifierType() tokenType {
 		if t.isKeyword(1, 4, "ndex") {
 			return TokenIndex
 		}
+		if t.isKeyword(1, 1, "s") {
+			return TokenIs
+		}
+		if t.isKeyword(1, 4, "nner") {
+			return TokenInner
+		}
+		if t.isKeyword(1, 8, "ntersect") {
+			return TokenIntersect
+		}
+		if t.isKeyword(1, 1, "n") {
+			return TokenIn
+		}
+	case 'j':
+		if t.isKeyword(1, 3, "oin") {
+			return TokenJoin
+		}
+	case 'l':
+		if t.isKeyword(1, 4, "imit") {
+			return TokenLimit
+		}
+		if t.isKeyword(1, 3, "eft") {
+			return TokenLeft
+		}
+	case 'n':
+		if t.isKeyword(1, 2, "ot") {
+			return TokenNot
+		}
+		if t.isKeyword(1, 3, "ull") {
+			return TokenNull
+		}
 	case 'o':
 		if t.isKeyword(1, 1, "n") {
 			return TokenOn
 		}
+		if t.isKeyword(1, 1, "r") {
+			return TokenOr
+		}
+		if t.isKeyword(1, 5, "ffset") {
+			return TokenOffset
+		}
+		if t.isKeyword(1, 4, "uter") {
+			return TokenOuter
+		}
 		if t.isKeyword(1, 4, "rder") && t.match(' ') {
 			t.toNextWhitespace()
 			if t.isKeyword(6, 2, "by") {
 				return TokenOrderBy
 			}
 		}
+	case 'p':
+		if t.isKeyword(1, 6, "rimary") {
+			return TokenPrimary
+		}
 	case 'r':
 		if t.isKeyword(1, 7, "ollback") {
 			return TokenRollback
 		}
+		if t.isKeyword(1, 4, "ight") {
+			return TokenRight
+		}
+		if t.isKeyword(1, 8, "ecursive") {
+			return TokenRecursive
+		}
+		if t.isKeyword(1, 5, "ename") {
+			return TokenRename
+		}
+		if t.isKeyword(1, 9, "eferences") {
+			return TokenReferences
+		}
+		if t.isKeyword(1, 7, "estrict") {
+			return TokenRestrict
+		}
 	case 's':
 		if t.isKeyword(1, 2, "et") {
 			return TokenSet
@@ -369,14 +547,26 @@ func (t *tokenizer) identifierType() tokenType {
 		if t.isKeyword(1, 3, "ext") {
 			return TokenText
 		}
+		if t.isKeyword(1, 1, "o") {
+			return TokenTo
+		}
 	case 'u':
 		if t.isKeyword(1, 5, "pdate") {
 			return TokenUpdate
 		}
+		if t.isKeyword(1, 4, "nion") {
+			return TokenUnion
+		}
+		if t.isKeyword(1, 5, "nique") {
+			return TokenUnique
+		}
 	case 'w':
 		if t.isKeyword(1, 4, "here") {
 			return TokenWhere
 		}
+		if t.isKeyword(1, 3, "ith") {
+			return TokenWith
+		}
 	case 'v':
 		if t.isKeyword(1, 3, "iew") {
 			return TokenView
@@ -34,6 +34,10 @@ func TestKeywords(t *testing.T) {
 			src: "DELETE",
 			exp: TokenDelete,
 		},
+		{
+			src: "DROP",
+			exp: TokenDrop,
+		},
 		{
 			src: "FROM",
 			exp: TokenFrom,
@@ -66,6 +70,22 @@ func TestKeywords(t *testing.T) {
 			src: "AND",
 			exp: TokenAnd,
 		},
+		{
+			src: "OR",
+			exp: TokenOr,
+		},
+		{
+			src: "NOT",
+			exp: TokenNot,
+		},
+		{
+			src: "IS",
+			exp: TokenIs,
+		},
+		{
+			src: "NULL",
+			exp: TokenNull,
+		},
 		{
 			src: "VALUES",
 			exp: TokenValues,
@@ -102,6 +122,142 @@ func TestKeywords(t *testing.T) {
 			src: "ORDER BY",
 			exp: TokenOrderBy,
 		},
+		{
+			src: "GROUP BY",
+			exp: TokenGroupBy,
+		},
+		{
+			src: "HAVING",
+			exp: TokenHaving,
+		},
+		{
+			src: "DISTINCT",
+			exp: TokenDistinct,
+		},
+		{
+			src: "LIMIT",
+			exp: TokenLimit,
+		},
+		{
+			src: "OFFSET",
+			exp: TokenOffset,
+		},
+		{
+			src: "ASC",
+			exp: TokenAsc,
+		},
+		{
+			src: "DESC",
+			exp: TokenDesc,
+		},
+		{
+			src: "JOIN",
+			exp: TokenJoin,
+		},
+		{
+			src: "INNER",
+			exp: TokenInner,
+		},
+		{
+			src: "LEFT",
+			exp: TokenLeft,
+		},
+		{
+			src: "RIGHT",
+			exp: TokenRight,
+		},
+		{
+			src: "FULL",
+			exp: TokenFull,
+		},
+		{
+			src: "OUTER",
+			exp: TokenOuter,
+		},
+		{
+			src: "IN",
+			exp: TokenIn,
+		},
+		{
+			src: "EXISTS",
+			exp: TokenExists,
+		},
+		{
+			src: "UNION",
+			exp: TokenUnion,
+		},
+		{
+			src: "ALL",
+			exp: TokenAll,
+		},
+		{
+			src: "INTERSECT",
+			exp: TokenIntersect,
+		},
+		{
+			src: "EXCEPT",
+			exp: TokenExcept,
+		},
+		{
+			src: "WITH",
+			exp: TokenWith,
+		},
+		{
+			src: "RECURSIVE",
+			exp: TokenRecursive,
+		},
+		{
+			src: "ALTER",
+			exp: TokenAlter,
+		},
+		{
+			src: "ADD",
+			exp: TokenAdd,
+		},
+		{
+			src: "COLUMN",
+			exp: TokenColumn,
+		},
+		{
+			src: "RENAME",
+			exp: TokenRename,
+		},
+		{
+			src: "TO",
+			exp: TokenTo,
+		},
+		{
+			src: "PRIMARY",
+			exp: TokenPrimary,
+		},
+		{
+			src: "UNIQUE",
+			exp: TokenUnique,
+		},
+		{
+			src: "DEFAULT",
+			exp: TokenDefault,
+		},
+		{
+			src: "CHECK",
+			exp: TokenCheck,
+		},
+		{
+			src: "FOREIGN",
+			exp: TokenForeign,
+		},
+		{
+			src: "REFERENCES",
+			exp: TokenReferences,
+		},
+		{
+			src: "CASCADE",
+			exp: TokenCascade,
+		},
+		{
+			src: "RESTRICT",
+			exp: TokenRestrict,
+		},
 	} {
 
 		tc := tc
@@ -177,3 +333,44 @@ func TestTokenizer(t *testing.T) {
 		}
 	}
 }
+
+func TestComparisonOperators(t *testing.T) {
+	type test struct {
+		src string
+		exp tokenType
+	}
+
+	for _, tc := range []test{
+		{src: "=", exp: TokenEqual},
+		{src: "!=", exp: TokenBangEqual},
+		{src: "<>", exp: TokenLessGreater},
+		{src: "<", exp: TokenLess},
+		{src: "<=", exp: TokenLessEqual},
+		{src: ">", exp: TokenGreater},
+		{src: ">=", exp: TokenGreaterEqual},
+	} {
+		tokenizer := newTokenizer(tc.src)
+		tkn, err := tokenizer.nextToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tkn.TokenType != tc.exp {
+			t.Fatalf("expected token of type %+v for %q. Got %+v", tc.exp, tc.src, tkn.TokenType)
+		}
+	}
+}
+
+func TestQualifiedFieldTokens(t *testing.T) {
+	tokenizer := newTokenizer("u.id")
+	tkns, err := tokenizer.tokenise()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, exp := range []tokenType{TokenIdentifier, TokenDot, TokenIdentifier} {
+		if tkns[i].TokenType != exp {
+			t.Fatalf("expected token of type %+v at %d, got %+v", exp, i, tkns[i].TokenType)
+		}
+	}
+}
@@ -44,6 +44,11 @@ func (t FieldType) String() string {
 	return typeNames[t]
 }
 
+// IsInteger returns true if t is one of the integer types.
+func (t FieldType) IsInteger() bool {
+	return t >= TINYINT && t <= LONG
+}
+
 // Name is a fixed-length string of 64 bytes, only composed of ASCII characters.
 // The first byte is the length of the string.
 // If a name is longer than 63 bytes, it is truncated to 63 bytes.
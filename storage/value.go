@@ -3,11 +3,25 @@ package storage
 import (
 	"fmt"
 	"slices"
+	"strings"
 )
 
-// Value is a generic value
+// Value is a generic value.
+// NULL is represented by the empty Value,
+// since the encoding of any other value takes at least one byte.
 type Value []byte
 
+// Null is the NULL value.
+var Null Value
+
+func (v Value) IsNull() bool {
+	return len(v) == 0
+}
+
+// Copy returns a copy of the value.
+// A value read from a page points into the buffer the block is pinned to,
+// which the buffer manager can assign to another block as soon as a new one is pinned,
+// so a value that must outlive the next read or write of another block is copied first.
 func Copy(v Value) Value {
 	cpy := make([]byte, len(v))
 	copy(cpy, v)
@@ -52,6 +66,35 @@ func ValueAsInteger[V Integer](v Value) V {
 	return FixedLenToInteger[V](v.AsFixedLen())
 }
 
+// ValueAsLong returns the integer stored in v, of integer type t, widened to a Long.
+func ValueAsLong(t FieldType, v Value) Long {
+	switch t {
+	case TINYINT:
+		return Long(ValueAsInteger[TinyInt](v))
+	case SMALLINT:
+		return Long(ValueAsInteger[SmallInt](v))
+	case INT:
+		return Long(ValueAsInteger[Int](v))
+	}
+
+	return ValueAsInteger[Long](v)
+}
+
+// ValueFromLong returns l as a Value of the integer type t.
+// If t is narrower than a Long, the value is truncated.
+func ValueFromLong(t FieldType, l Long) Value {
+	switch t {
+	case TINYINT:
+		return ValueFromInteger[TinyInt](SizeOfTinyInt, TinyInt(l))
+	case SMALLINT:
+		return ValueFromInteger[SmallInt](SizeOfSmallInt, SmallInt(l))
+	case INT:
+		return ValueFromInteger[Int](SizeOfInt, Int(l))
+	}
+
+	return ValueFromInteger[Long](SizeOfLong, l)
+}
+
 func ValueAsGoString(v Value) string {
 	return VarlenToGoString(BytesToVarlen(v))
 }
@@ -60,11 +103,17 @@ func ValueAsVarlen(v Value) Varlen {
 	return BytesToVarlen(v)
 }
 
+// Size returns the space the value takes in a record.
+// A NULL takes the space of the zero value of its type.
 func (v Value) Size(t FieldType) Offset {
 	if size := t.Size(); size != SizeOfVarlen {
 		return Offset(size)
 	}
 
+	if v.IsNull() {
+		return SizeOfVarlenLen
+	}
+
 	// todo: implement sizes when toasts are implemented
 	return Offset(v.AsVarlen().Size())
 }
@@ -162,7 +211,14 @@ var minValFunc = [...]func() Value{
 	},
 }
 
+// String formats the value as a string of its type.
+// The string of a NAME or TEXT value is cloned,
+// since the value can point into a buffer that is reused once it is unpinned.
 func (c Value) String(t FieldType) string {
+	if c.IsNull() {
+		return "NULL"
+	}
+
 	return stringFunc[t](c)
 }
 
@@ -180,9 +236,9 @@ var stringFunc = [...]func(Value) string{
 		return fmt.Sprintf("%d", ValueAsInteger[Long](v))
 	},
 	NAME: func(v Value) string {
-		return v.AsName().AsGoString()
+		return strings.Clone(v.AsName().AsGoString())
 	},
 	TEXT: func(v Value) string {
-		return ValueAsGoString(v)
+		return strings.Clone(ValueAsGoString(v))
 	},
 }
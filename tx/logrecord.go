@@ -85,6 +85,7 @@ var txTypeToString = [...]string{
 	SETFIXEDLEN: "SETFIXED",
 	SETVARLEN:   "SETSTRING",
 	COPY:        "COPY",
+	REMOVEFILE:  "REMOVEFILE",
 }
 
 func txTypeFromFixedLen(f storage.FixedLen) txType {
@@ -103,6 +104,7 @@ const (
 	SETFIXEDLEN
 	SETVARLEN
 	COPY
+	REMOVEFILE
 )
 
 func createLogRecord(bytes []byte) logRecord {
@@ -124,6 +126,8 @@ func createLogRecord(bytes []byte) logRecord {
 		return newRollbackRecord(rbuf)
 	case COPY:
 		return newCopyRecord(rbuf)
+	case REMOVEFILE:
+		return newRemoveFileRecord(rbuf)
 	}
 
 	return nil
@@ -230,3 +230,29 @@ func TestLogCopy(t *testing.T) {
 
 	newCopyRecord(&recordBuffer{bytes: p})
 }
+
+func TestLogRemoveFile(t *testing.T) {
+	const txNum storage.TxID = 123
+	const fname = "testfile"
+
+	p := make([]byte, sizeOfRemoveFileRecord+int(storage.SizeOfStringAsVarlen(fname)))
+
+	writeRemoveFile(p, txNum, fname)
+
+	var offset storage.Offset
+
+	assertIntegerAtOffset(t, p, offset, storage.SizeOfTinyInt, storage.TinyInt(REMOVEFILE))
+	offset += storage.SizeOfTinyInt
+
+	// tx number
+	assertIntegerAtOffset(t, p, offset, storage.SizeOfTxID, txNum)
+	offset += storage.SizeOfTxID
+
+	// file name
+	assertVarlenAtPos(t, p, offset, fname)
+
+	rec := createLogRecord(p).(removeFileLogRecord)
+	if rec.txnum != txNum || rec.fname != fname {
+		t.Fatalf("expected <REMOVEFILE %d %s>, got %s", txNum, fname, rec)
+	}
+}
@@ -3,6 +3,8 @@ package tx
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/luigitni/simpledb/storage"
@@ -349,3 +351,124 @@ func TestRecoverCopy(t *testing.T) {
 		}
 	})
 }
+
+func TestRecoverRemoveFile(t *testing.T) {
+	dir := t.TempDir()
+	fm, lm, bm := test.MakeManagersWithDir(dir)
+
+	exists := func(fname string) bool {
+		_, err := os.Stat(filepath.Join(dir, fname))
+		return err == nil
+	}
+
+	write := func(fname string) {
+		block := storage.NewBlock(fname, 0)
+
+		x := NewTx(fm, lm, bm)
+		x.Pin(block)
+		if err := x.SetFixedlen(block, 0, storage.SizeOfInt, storage.IntegerToFixedLen(storage.SizeOfInt, storage.Int(1)), true); err != nil {
+			t.Fatal(err)
+		}
+
+		x.Commit()
+	}
+
+	// crash simulates a crash right after the commit record has been flushed,
+	// before the files are removed
+	crash := func(fname string) {
+		x := NewTx(fm, lm, bm).(transactionImpl)
+		if err := x.RemoveFile(fname); err != nil {
+			t.Fatal(err)
+		}
+
+		x.recoverMan.commit()
+		x.release()
+	}
+
+	write("committed")
+	write("rolledback")
+	write("rewritten")
+	write("removed")
+
+	x := NewTx(fm, lm, bm)
+	if err := x.RemoveFile("removed"); err != nil {
+		t.Fatal(err)
+	}
+
+	x.Commit()
+
+	if exists("removed") {
+		t.Fatal("expected the file to be removed on commit")
+	}
+
+	x = NewTx(fm, lm, bm)
+	if err := x.RemoveFile("rolledback"); err != nil {
+		t.Fatal(err)
+	}
+
+	x.Rollback()
+
+	crash("committed")
+	crash("rewritten")
+	write("rewritten")
+
+	for _, fname := range []string{"committed", "rolledback", "rewritten"} {
+		if !exists(fname) {
+			t.Fatalf("expected %q to exist before recovery", fname)
+		}
+	}
+
+	x = NewTx(fm, lm, bm)
+	x.Recover()
+	x.Commit()
+
+	if exists("committed") {
+		t.Fatal("expected recovery to remove the file of the committed transaction")
+	}
+
+	if !exists("rolledback") {
+		t.Fatal("expected recovery to keep the file of the rolled back transaction")
+	}
+
+	if !exists("rewritten") {
+		t.Fatal("expected recovery to keep the file written after its removal")
+	}
+
+	// a file that cannot be removed does not fail the commit,
+	// and is removed by the next recovery
+	blocker := filepath.Join(dir, "busy", "blocker")
+	if err := os.MkdirAll(blocker, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	x = NewTx(fm, lm, bm)
+	if err := x.RemoveFile("busy"); err != nil {
+		t.Fatal(err)
+	}
+
+	x.Commit()
+
+	if !exists("busy") {
+		t.Fatal("expected the file not to be removed while it cannot be")
+	}
+
+	// the commit released the lock on the file
+	x = NewTx(fm, lm, bm)
+	if err := x.RemoveFile("busy"); err != nil {
+		t.Fatal(err)
+	}
+
+	x.Rollback()
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+
+	x = NewTx(fm, lm, bm)
+	x.Recover()
+	x.Commit()
+
+	if exists("busy") {
+		t.Fatal("expected recovery to remove the file that could not be removed on commit")
+	}
+}
@@ -1,7 +1,10 @@
 package tx
 
 import (
+	"log"
+
 	"github.com/luigitni/simpledb/buffer"
+	"github.com/luigitni/simpledb/file"
 	"github.com/luigitni/simpledb/storage"
 	"github.com/luigitni/simpledb/wal"
 )
@@ -22,18 +25,24 @@ type logManager interface {
 // 3. to recover the database after a system crash
 type recoveryManager struct {
 	lm    logManager
+	fm    *file.FileManager
 	bm    *buffer.BufferManager
 	tx    Transaction
 	txnum storage.TxID
+	// records counts the log records written by the transaction since its START record,
+	// shared by the copies of the recovery manager.
+	records *int
 }
 
 // RecoveryManagerForTx returns a recovery manager for the given transaction and txnum
-func newRecoveryManagerForTx(tx Transaction, txnum storage.TxID, lm logManager, bm *buffer.BufferManager) recoveryManager {
+func newRecoveryManagerForTx(tx Transaction, txnum storage.TxID, lm logManager, fm *file.FileManager, bm *buffer.BufferManager) recoveryManager {
 	man := recoveryManager{
-		lm:    lm,
-		bm:    bm,
-		tx:    tx,
-		txnum: txnum,
+		lm:      lm,
+		fm:      fm,
+		bm:      bm,
+		tx:      tx,
+		txnum:   txnum,
+		records: new(int),
 	}
 	logStart(lm, txnum)
 	return man
@@ -47,6 +56,7 @@ func newRecoveryManagerForTx(tx Transaction, txnum storage.TxID, lm logManager,
 func (man recoveryManager) setFixedLen(buff *buffer.Buffer, offset storage.Offset, size storage.Offset, _ storage.FixedLen) int {
 	oldVal := buff.Contents().Slice(offset, offset + size)
 	block := buff.Block()
+	*man.records++
 
 	return logCopy(man.lm, man.txnum, block, offset, oldVal)
 }
@@ -60,6 +70,7 @@ func (man recoveryManager) setVarLen(buff *buffer.Buffer, offset storage.Offset,
 	size := storage.Offset(vlen.Size())
 	oldVal := buff.Contents().Slice(offset, offset + size)
 	block := buff.Block()
+	*man.records++
 
 	return logCopy(man.lm, man.txnum, block, offset, oldVal)
 }
@@ -67,10 +78,19 @@ func (man recoveryManager) setVarLen(buff *buffer.Buffer, offset storage.Offset,
 func (man recoveryManager) logCopy(buff *buffer.Buffer, _ storage.Offset, dst storage.Offset, size storage.Offset) int {
 	oldval := buff.Contents().Slice(dst, dst+size)
 	block := buff.Block()
+	*man.records++
 
 	return logCopy(man.lm, man.txnum, block, dst, oldval)
 }
 
+// removeFile writes a REMOVEFILE record to the log and returns its lsn.
+// The record is flushed together with the commit record.
+func (man recoveryManager) removeFile(fname string) int {
+	*man.records++
+
+	return logRemoveFile(man.lm, man.txnum, fname)
+}
+
 // Write a commit record to the log and flushes it to disk
 func (man recoveryManager) commit() {
 	man.bm.FlushAll(man.txnum)
@@ -113,6 +133,32 @@ func (man recoveryManager) doRollback() {
 	}
 }
 
+// written returns the number of log records written by the transaction since its START record.
+func (man recoveryManager) written() int {
+	return *man.records
+}
+
+// rollbackTo undoes the log records the transaction wrote after the first n,
+// iterating through the log from the most recent record as doRollback does.
+// The undone records stay in the log: a later rollback or recovery undoes them again,
+// which restores the same values, since records are undone from the most recent.
+// The records written afterwards are counted from n.
+func (man recoveryManager) rollbackTo(n int) {
+	reader := man.lm.Iterator()
+	defer reader.Close()
+
+	for *man.records > n && reader.HasNext() {
+		record := createLogRecord(reader.Next())
+
+		if record.TxNumber() != man.txnum {
+			continue
+		}
+
+		record.Undo(man.tx)
+		*man.records--
+	}
+}
+
 // recover recovers uncompleted transactions from the log
 // and then writes a quiescent checkpoint record to the log and flushes it
 func (man recoveryManager) recover() {
@@ -129,9 +175,15 @@ func (man recoveryManager) recover() {
 // The method iterates through the log records.
 // Whenever it finds a log record for an unfinished transaction,
 // it calls undo() on that record.
+// Whenever it finds a REMOVEFILE record of a committed transaction,
+// it removes the file again, since the system may have crashed before the file was removed,
+// unless a later log record writes to a file with the same name.
 // The method stops when it encounters a CHECKPOINT record or the end of the log file
 func (man recoveryManager) doRecover() storage.TxID {
 	finishedTxs := map[storage.TxID]struct{}{}
+	committedTxs := map[storage.TxID]struct{}{}
+	// written holds the files written by the records that follow the current one
+	written := map[string]struct{}{}
 	reader := man.lm.Iterator()
 	defer reader.Close()
 
@@ -157,6 +209,9 @@ func (man recoveryManager) doRecover() storage.TxID {
 
 			txNum := record.TxNumber()
 			finishedTxs[txNum] = struct{}{}
+			if record.Op() == COMMIT {
+				committedTxs[txNum] = struct{}{}
+			}
 
 			if txNum > maxTxNum {
 				maxTxNum = txNum
@@ -166,6 +221,17 @@ func (man recoveryManager) doRecover() storage.TxID {
 			// undo it.
 		} else if _, ok := finishedTxs[record.TxNumber()]; !ok {
 			record.Undo(man.tx)
+		} else if rf, ok := record.(removeFileLogRecord); ok {
+			_, committed := committedTxs[rf.txnum]
+			if _, ok := written[rf.fname]; committed && !ok {
+				if err := removeFile(man.fm, man.bm, rf.fname); err != nil {
+					log.Printf("recovery: removing file %q: %s", rf.fname, err)
+				}
+			}
+		}
+
+		if cr, ok := record.(copyRecord); ok {
+			written[cr.block.FileName()] = struct{}{}
 		}
 	}
 
@@ -0,0 +1,72 @@
+package tx
+
+import (
+	"fmt"
+
+	"github.com/luigitni/simpledb/storage"
+)
+
+// removeFileLogRecord records that a transaction removes a file.
+// Files are removed only once the transaction has committed,
+// so the record has nothing to undo: if the transaction rolls back, the file is kept.
+// Recovery removes the file again if the transaction committed,
+// since the system may have crashed before the file was removed.
+// The record can be represented as
+// <REMOVEFILE, txnum, filename>
+type removeFileLogRecord struct {
+	txnum storage.TxID
+	fname string
+}
+
+const sizeOfRemoveFileRecord = int(storage.SizeOfTxID) + int(storage.SizeOfTinyInt)
+
+func newRemoveFileRecord(record *recordBuffer) removeFileLogRecord {
+	f := record.readFixedLen(storage.SizeOfTinyInt)
+	if v := txTypeFromFixedLen(f); v != REMOVEFILE {
+		panic(fmt.Sprintf("bad %s record: %s", REMOVEFILE, v))
+	}
+
+	return removeFileLogRecord{
+		txnum: storage.FixedLenToInteger[storage.TxID](record.readFixedLen(storage.SizeOfTxID)),
+		fname: storage.VarlenToGoString(record.readVarlen()),
+	}
+}
+
+func (record removeFileLogRecord) Op() txType {
+	return REMOVEFILE
+}
+
+func (record removeFileLogRecord) TxNumber() storage.TxID {
+	return record.txnum
+}
+
+func (record removeFileLogRecord) Undo(tx Transaction) {
+	// do nothing: the file has not been removed yet
+}
+
+func (record removeFileLogRecord) String() string {
+	return fmt.Sprintf("<REMOVEFILE %d %s>", record.txnum, record.fname)
+}
+
+// logRemoveFile appends a REMOVEFILE record to the log.
+// A remove file entry has the following layout:
+// | log type | tx number | filename |
+func logRemoveFile(lm logManager, txnum storage.TxID, fname string) int {
+	buf := make([]byte, sizeOfRemoveFileRecord+int(storage.SizeOfStringAsVarlen(fname)))
+	writeRemoveFile(buf, txnum, fname)
+
+	return lm.Append(buf)
+}
+
+func writeRemoveFile(dst []byte, txnum storage.TxID, fname string) {
+	rbuf := recordBuffer{bytes: dst}
+	rbuf.writeFixedLen(
+		storage.SizeOfTinyInt,
+		storage.IntegerToFixedLen[storage.TinyInt](storage.SizeOfTinyInt, storage.TinyInt(REMOVEFILE)),
+	)
+	rbuf.writeFixedLen(
+		storage.SizeOfTxID,
+		storage.IntegerToFixedLen[storage.TxID](storage.SizeOfTxID, txnum),
+	)
+	rbuf.writeString(fname)
+}
@@ -1,6 +1,7 @@
 package tx
 
 import (
+	"log"
 	"sync/atomic"
 
 	"github.com/luigitni/simpledb/buffer"
@@ -27,6 +28,16 @@ type Transaction interface {
 	// and finally releases all locks and unpins any pinned buffers
 	Rollback()
 
+	// Savepoint returns the point the transaction has reached,
+	// to which its changes can later be undone with RollbackTo.
+	Savepoint() Savepoint
+
+	// RollbackTo undoes the changes the transaction made after the savepoint,
+	// from the most recent one, and leaves the transaction open.
+	// Locks and pinned buffers are kept, and the files to be removed at commit are still removed,
+	// so that a savepoint is meant to undo a statement that writes records, rather than one that changes the catalog.
+	RollbackTo(sp Savepoint)
+
 	// Recover flushes all the modified buffers then goes through the log
 	// rolling back all uncommitted transactions.
 	// Finally, it writes a quiescent checkpoint record to the log.
@@ -77,10 +88,33 @@ type Transaction interface {
 	// Returns ErrLockAcquisitionTimeout if the X lock can't be acquired
 	Append(fname string) (storage.Block, error)
 
+	// RemoveFile removes the specified file when the transaction commits.
+	// It first obtains an X lock on the "end of file" block and writes a REMOVEFILE record to the log,
+	// so that the removal can be completed by recovery if the system crashes after the commit.
+	// The file is kept if the transaction rolls back.
+	// Returns ErrLockAcquisitionTimeout if the X lock can't be acquired
+	RemoveFile(fname string) error
+
 	// BlockSize returns the size of a block
 	BlockSize() storage.Offset
+
+	// AvailableBuffers returns the number of unpinned buffers of the buffer manager.
+	AvailableBuffers() int
+
+	// Autonomous starts a new transaction, which shares the file, log and buffer managers
+	// of the transaction but commits or rolls back on its own.
+	// Short updates that must outlive a rollback, such as drawing the value of a sequence,
+	// can be committed through it, so that their locks are released as soon as they are done
+	// rather than being held until the end of the transaction.
+	// Locks are not shared between the two transactions, so the blocks an autonomous transaction modifies
+	// must be kept out of reach of the transaction that starts it, such as the blocks of the values of the sequences.
+	Autonomous() Transaction
 }
 
+// Savepoint is a point reached by a transaction, to which its changes can be undone.
+// It holds the number of log records the transaction had written.
+type Savepoint int
+
 // nextTxNum generates transaction ids
 func nextTxNum() storage.TxID {
 	return storage.TxID(atomic.AddUint32(&lastTxNum, 1))
@@ -95,24 +129,29 @@ var _ Transaction = transactionImpl{}
 type transactionImpl struct {
 	bufMan     *buffer.BufferManager
 	fileMan    *file.FileManager
+	logMan     logManager
 	recoverMan recoveryManager
 	concMan    ConcurrencyManager
 	buffers    bufferList
 	num        storage.TxID
+	// removed holds the files to be removed when the transaction commits
+	removed *[]string
 }
 
 func NewTx(fm *file.FileManager, lm logManager, bm *buffer.BufferManager) Transaction {
 	tx := transactionImpl{
 		bufMan:  bm,
 		fileMan: fm,
+		logMan:  lm,
 		num:     nextTxNum(),
 		concMan: NewConcurrencyManager(),
 		buffers: makeBufferList(bm),
+		removed: &[]string{},
 	}
 
 	// assign the recovery manager to the tx
 	// todo: this is ugly in Go, will refactor at a later stage.
-	tx.recoverMan = newRecoveryManagerForTx(tx, tx.num, lm, bm)
+	tx.recoverMan = newRecoveryManagerForTx(tx, tx.num, lm, fm, bm)
 
 	return tx
 }
@@ -123,7 +162,19 @@ func (tx transactionImpl) Id() storage.TxID {
 
 func (tx transactionImpl) Commit() {
 	tx.recoverMan.commit()
-	tx.release()
+	tx.buffers.unpinAll()
+
+	// files are removed before the locks are released,
+	// so that no other transaction can access them in the meantime.
+	// The transaction is committed even if a file cannot be removed:
+	// its REMOVEFILE record is in the log, and recovery removes the file at the next start.
+	for _, fname := range *tx.removed {
+		if err := removeFile(tx.fileMan, tx.bufMan, fname); err != nil {
+			log.Printf("tx %d: removing file %q: %s", tx.num, fname, err)
+		}
+	}
+
+	tx.concMan.Release()
 }
 
 func (tx transactionImpl) Rollback() {
@@ -131,6 +182,14 @@ func (tx transactionImpl) Rollback() {
 	tx.release()
 }
 
+func (tx transactionImpl) Savepoint() Savepoint {
+	return Savepoint(tx.recoverMan.written())
+}
+
+func (tx transactionImpl) RollbackTo(sp Savepoint) {
+	tx.recoverMan.rollbackTo(int(sp))
+}
+
 func (tx transactionImpl) Recover() {
 	tx.bufMan.FlushAll(tx.num)
 	tx.recoverMan.recover()
@@ -250,11 +309,27 @@ func (tx transactionImpl) Append(fname string) (storage.Block, error) {
 	return tx.fileMan.Append(fname), nil
 }
 
-// availableBuffers returns the number of unpinned buffers
-func (tx transactionImpl) availableBuffers() int {
+func (tx transactionImpl) RemoveFile(fname string) error {
+	dummy := storage.NewBlock(fname, storage.EOF)
+	if err := tx.concMan.XLock(dummy); err != nil {
+		return err
+	}
+
+	tx.recoverMan.removeFile(fname)
+	*tx.removed = append(*tx.removed, fname)
+
+	return nil
+}
+
+// AvailableBuffers returns the number of unpinned buffers
+func (tx transactionImpl) AvailableBuffers() int {
 	return tx.bufMan.Available()
 }
 
+func (tx transactionImpl) Autonomous() Transaction {
+	return NewTx(tx.fileMan, tx.logMan, tx.bufMan)
+}
+
 func (tx transactionImpl) BlockSize() storage.Offset {
 	return tx.fileMan.BlockSize()
 }
@@ -263,3 +338,10 @@ func (tx transactionImpl) release() {
 	tx.concMan.Release()
 	tx.buffers.unpinAll()
 }
+
+// removeFile discards the buffers assigned to the blocks of the file, and removes it from disk.
+func removeFile(fm *file.FileManager, bm *buffer.BufferManager, fname string) error {
+	bm.Discard(fname)
+
+	return fm.Remove(fname)
+}
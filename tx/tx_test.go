@@ -133,3 +133,72 @@ func TestSerialTx(t *testing.T) {
 
 	tx4.Commit()
 }
+
+func TestRollbackToSavepoint(t *testing.T) {
+	fm, lm, bm := test.MakeManagers(t)
+
+	block := storage.NewBlock("thisisablock", 1)
+
+	setInt := func(x tx.Transaction, v storage.Int) {
+		t.Helper()
+
+		if err := x.SetFixedlen(block, 80, storage.SizeOfInt, storage.IntegerToFixedLen[storage.Int](storage.SizeOfInt, v), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectInt := func(x tx.Transaction, exp storage.Int) {
+		t.Helper()
+
+		val, err := x.Fixedlen(block, 80, storage.SizeOfInt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v := storage.FixedLenToInteger[storage.Int](val); v != exp {
+			t.Fatalf("expected intval to be %d, got %d", exp, v)
+		}
+	}
+
+	tx1 := tx.NewTx(fm, lm, bm)
+	tx1.Pin(block)
+	setInt(tx1, 1)
+	tx1.Commit()
+
+	tx2 := tx.NewTx(fm, lm, bm)
+	tx2.Pin(block)
+	setInt(tx2, 2)
+
+	sp := tx2.Savepoint()
+	setInt(tx2, 3)
+	setInt(tx2, 4)
+
+	// the changes after the savepoint are undone, those before it are kept
+	tx2.RollbackTo(sp)
+	expectInt(tx2, 2)
+
+	// the transaction goes on, and can roll back to the same savepoint again
+	setInt(tx2, 5)
+	tx2.RollbackTo(sp)
+	expectInt(tx2, 2)
+
+	setInt(tx2, 6)
+	tx2.Commit()
+
+	tx3 := tx.NewTx(fm, lm, bm)
+	tx3.Pin(block)
+	expectInt(tx3, 6)
+
+	// a rollback undoes the changes made before and after a partial rollback
+	setInt(tx3, 7)
+	sp = tx3.Savepoint()
+	setInt(tx3, 8)
+	tx3.RollbackTo(sp)
+	setInt(tx3, 9)
+	tx3.Rollback()
+
+	tx4 := tx.NewTx(fm, lm, bm)
+	tx4.Pin(block)
+	expectInt(tx4, 6)
+	tx4.Commit()
+}